@@ -0,0 +1,54 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// Ways of handling writes to the files opened with O_APPEND (see FileSystem.AppendMode)
+const (
+	AppendEmulate         = "emulate"           // content is rewritten to HDFS as a new file (read-modify-write)
+	AppendNative          = "native"            // HDFS append is used, fails with EOPNOTSUPP if the cluster doesn't support it
+	AppendNativeOrEmulate = "native-or-emulate" // HDFS append is used, emulated if the cluster doesn't support it
+)
+
+// Returns true if the error indicates that append is disabled on the cluster (e.g. dfs.support.append=false)
+func IsAppendUnsupportedError(err error) bool {
+	if err == nil {
+		return false
+	}
+	message := err.Error()
+	return strings.Contains(message, "UnsupportedOperationException") ||
+		strings.Contains(message, "dfs.support.append") ||
+		strings.Contains(strings.ToLower(message), "append is not supported")
+}
+
+// Opens the file for native HDFS append according to AppendMode (with lease recovery, see OpenWithLeaseRecovery).
+// Returns nil writer if append has to be emulated. Once the cluster reports that append isn't supported,
+// it isn't attempted again
+func (this *FileSystem) OpenForAppend(path string) (HdfsWriter, error) {
+	if this.AppendMode == "" || this.AppendMode == AppendEmulate {
+		return nil, nil
+	}
+	if atomic.LoadInt32(&this.appendUnsupported) == 0 {
+		var w HdfsWriter
+		err := this.OpenWithLeaseRecovery(path, func() error {
+			var err error
+			w, err = this.HdfsAccessor.Append(path)
+			return err
+		})
+		if !IsAppendUnsupportedError(err) {
+			return w, err
+		}
+		Warning.Println("[", path, "] HDFS append isn't supported by the cluster:", err)
+		atomic.StoreInt32(&this.appendUnsupported, 1)
+	}
+	if this.AppendMode == AppendNative {
+		return nil, fuse.Errno(syscall.EOPNOTSUPP)
+	}
+	return nil, nil
+}
@@ -0,0 +1,96 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Default time for which attributes fetched from HDFS are cached (see FileSystem.MetadataCacheTTL)
+var DefaultMetadataCacheTTL time.Duration = 5 * time.Second
+
+// Bounds number of cached file nodes. Nodes of the least recently used files are dropped from the entries
+// of their directories (so the next lookup re-stats them), unless the file has active handles
+type AttrCache struct {
+	MaxEntries int                     // Maximum number of cached file nodes
+	lru        *list.List              // cached file nodes, most recently used first
+	elements   map[*File]*list.Element // position of the file in lru list
+	mutex      sync.Mutex              // mutex for lru and elements
+}
+
+// Creates attribute cache holding at most maxEntries file nodes
+func NewAttrCache(maxEntries int) *AttrCache {
+	return &AttrCache{
+		MaxEntries: maxEntries,
+		lru:        list.New(),
+		elements:   make(map[*File]*list.Element)}
+}
+
+// Records that the file node has been cached (e.g. by directory listing) without evicting other nodes,
+// so listing a large directory doesn't evict the entries it has just cached (nil-safe).
+// Node is tracked as the least recently used one until its attributes are used
+func (this *AttrCache) Add(file *File) {
+	if this == nil {
+		return
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if _, ok := this.elements[file]; !ok {
+		this.elements[file] = this.lru.PushBack(file)
+	}
+}
+
+// Records that attributes of the file have been used, evicting the least recently used nodes
+// if the limit is exceeded (nil-safe, does nothing if the cache isn't bounded)
+func (this *AttrCache) Touch(file *File) {
+	if this == nil {
+		return
+	}
+	this.mutex.Lock()
+	if element, ok := this.elements[file]; ok {
+		this.lru.MoveToFront(element)
+	} else {
+		this.elements[file] = this.lru.PushFront(file)
+	}
+	var victims []*File
+	element := this.lru.Back()
+	for this.lru.Len() > this.MaxEntries && element != nil {
+		previous := element.Prev()
+		victim := element.Value.(*File)
+		if victim != file && !victim.HasActiveHandles() {
+			this.lru.Remove(element)
+			delete(this.elements, victim)
+			victims = append(victims, victim)
+		}
+		element = previous
+	}
+	this.mutex.Unlock()
+	// Evicted nodes are dropped from their directories under the directory locks (not nested in the cache mutex)
+	for _, victim := range victims {
+		if parent, _ := NodeParentAndName(victim); parent != nil {
+			parent.EntriesForget(victim)
+		}
+	}
+}
+
+// Stops tracking the file which is dropped from the node cache (nil-safe)
+func (this *AttrCache) Remove(file *File) {
+	if this == nil {
+		return
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if element, ok := this.elements[file]; ok {
+		this.lru.Remove(element)
+		delete(this.elements, file)
+	}
+}
+
+// Returns number of cached file nodes
+func (this *AttrCache) Len() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.lru.Len()
+}
@@ -0,0 +1,98 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// Nodes of least recently used files are dropped from directory entries once the limit is exceeded,
+// unless the file has active handles
+func TestAttrCacheEviction(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.AttrCache = NewAttrCache(2)
+	root, _ := fs.Root()
+	dir := root.(*Dir)
+	var attr fuse.Attr
+	// Kernel asks for attributes of the node right after lookup
+	lookup := func(name string, size uint64) *File {
+		hdfsAccessor.EXPECT().Stat("/"+name).Return(Attrs{Name: name, Mode: 0644, Size: size}, nil)
+		node, err := dir.Lookup(nil, name)
+		assert.Nil(t, err)
+		assert.Nil(t, node.Attr(nil, &attr))
+		assert.Equal(t, size, attr.Size)
+		return node.(*File)
+	}
+	cached := func(name string) bool {
+		dir.EntriesMutex.Lock()
+		defer dir.EntriesMutex.Unlock()
+		_, ok := dir.Entries[name]
+		return ok
+	}
+	opened := lookup("opened", 10)
+	opened.AddHandle(&FileHandle{File: opened})
+	lookup("a", 10)
+	lookup("b", 10)
+
+	// 'opened' is the least recently used one, but it has active handle, so 'a' was evicted instead
+	assert.Equal(t, 2, fs.AttrCache.Len())
+	assert.True(t, cached("opened"))
+	assert.False(t, cached("a"))
+	assert.True(t, cached("b"))
+
+	// Evicted node is re-statted on next lookup, which evicts 'b', least recently used one without active handles
+	lookup("a", 20)
+	assert.True(t, cached("a"))
+	assert.False(t, cached("b"))
+	assert.Equal(t, 2, fs.AttrCache.Len())
+
+	// Listing caches the entries without evicting any of them
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{
+		{Name: "c", Mode: 0644}, {Name: "d", Mode: 0644}, {Name: "e", Mode: 0644}}, nil)
+	_, err := dir.ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, fs.AttrCache.Len())
+	for _, name := range []string{"opened", "a", "c", "d", "e"} {
+		assert.True(t, cached(name), name)
+	}
+	mockCtrl.Finish()
+}
+
+// Attributes are re-statted once MetadataCacheTTL elapses
+func TestMetadataCacheTTL(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	assert.Equal(t, DefaultMetadataCacheTTL, fileSystem.MetadataCacheTTL)
+	fileSystem.MetadataCacheTTL = 2 * time.Minute
+	root, _ := fileSystem.Root()
+
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: 0644, Size: 10}, nil)
+	file, err := root.(*Dir).Lookup(nil, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, mockClock.Now().Add(2*time.Minute), file.(*File).Attrs.Expires)
+
+	// Cached attributes are served within the TTL
+	mockClock.NotifyTimeElapsed(time.Minute)
+	var attr fuse.Attr
+	assert.Nil(t, file.Attr(nil, &attr))
+	assert.Equal(t, uint64(10), attr.Size)
+
+	// File is re-statted once the TTL has elapsed
+	mockClock.NotifyTimeElapsed(90 * time.Second)
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: 0644, Size: 20}, nil)
+	assert.Nil(t, file.Attr(nil, &attr))
+	assert.Equal(t, uint64(20), attr.Size)
+	mockCtrl.Finish()
+}
@@ -0,0 +1,139 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Overrides attributes reported for the paths matching glob patterns (first matching rule wins).
+// This is presentation only: attributes stored in HDFS aren't changed
+type AttrOverrides struct {
+	Rules []AttrOverrideRule
+}
+
+// Single rule: files and directories with paths matching the pattern are reported with given mode, owner and group
+type AttrOverrideRule struct {
+	Pattern string // Glob pattern matched against absolute path, "**" component matches any number of path components
+	Mode    int64  // Permission bits reported for the files (-1 to keep), directories keep their mode to stay traversable
+	Uid     int64  // Owner reported (-1 to keep)
+	Gid     int64  // Group reported (-1 to keep)
+}
+
+// Creates AttrOverrides from comma-separated list of "pattern=mode:owner:group" rules,
+// empty mode, owner or group are kept as stored in HDFS (e.g. "/public/**=0644:svc:svc,/logs/*=0640::")
+func NewAttrOverrides(spec string) (*AttrOverrides, error) {
+	this := &AttrOverrides{}
+	for _, entry := range strings.Split(spec, ",") {
+		if entry == "" {
+			continue
+		}
+		patternAndAttrs := strings.SplitN(entry, "=", 2)
+		if len(patternAndAttrs) != 2 || !strings.HasPrefix(patternAndAttrs[0], "/") {
+			return nil, errors.New(fmt.Sprintf("Invalid attribute override rule: %s", entry))
+		}
+		attrs := strings.Split(patternAndAttrs[1], ":")
+		if len(attrs) != 3 {
+			return nil, errors.New(fmt.Sprintf("Invalid attribute override rule (mode:owner:group expected): %s", entry))
+		}
+		if _, err := path.Match(patternAndAttrs[0], ""); err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid pattern in attribute override rule: %s", entry))
+		}
+		rule := AttrOverrideRule{Pattern: patternAndAttrs[0], Mode: -1, Uid: -1, Gid: -1}
+		if attrs[0] != "" {
+			mode, err := strconv.ParseUint(attrs[0], 8, 32)
+			if err != nil || mode&^uint64(os.ModePerm) != 0 {
+				return nil, errors.New(fmt.Sprintf("Invalid mode in attribute override rule: %s", entry))
+			}
+			rule.Mode = int64(mode)
+		}
+		var err error
+		if attrs[1] != "" {
+			if rule.Uid, err = lookupOverrideUid(attrs[1]); err != nil {
+				return nil, errors.New(fmt.Sprintf("Unknown owner in attribute override rule: %s", entry))
+			}
+		}
+		if attrs[2] != "" {
+			if rule.Gid, err = lookupOverrideGid(attrs[2]); err != nil {
+				return nil, errors.New(fmt.Sprintf("Unknown group in attribute override rule: %s", entry))
+			}
+		}
+		Info.Println("Attribute override rule: [", rule.Pattern, "] ->", patternAndAttrs[1])
+		this.Rules = append(this.Rules, rule)
+	}
+	return this, nil
+}
+
+// Maps user name (or numeric uid) to uid
+func lookupOverrideUid(owner string) (int64, error) {
+	if u, err := user.Lookup(owner); err == nil {
+		owner = u.Uid
+	}
+	uid, err := strconv.ParseUint(owner, 10, 32)
+	return int64(uid), err
+}
+
+// Maps group name (or numeric gid) to gid
+func lookupOverrideGid(group string) (int64, error) {
+	if g, err := user.LookupGroup(group); err == nil {
+		group = g.Gid
+	}
+	gid, err := strconv.ParseUint(group, 10, 32)
+	return int64(gid), err
+}
+
+// Returns true if the absolute path matches the glob pattern, "**" component matches any number of path components
+func MatchPathGlob(pattern string, p string) bool {
+	return matchPathComponents(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(strings.Trim(p, "/"), "/"))
+}
+
+// Matches path components against pattern components
+func matchPathComponents(pattern []string, components []string) bool {
+	if len(pattern) == 0 {
+		return len(components) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(components); i++ {
+			if matchPathComponents(pattern[1:], components[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(components) == 0 {
+		return false
+	}
+	if matched, _ := path.Match(pattern[0], components[0]); !matched {
+		return false
+	}
+	return matchPathComponents(pattern[1:], components[1:])
+}
+
+// Applies the first rule matching the path to the reported attributes (nil-safe, does nothing if no overrides are configured)
+func (this *AttrOverrides) Apply(absolutePath string, a *fuse.Attr) {
+	if this == nil {
+		return
+	}
+	for _, rule := range this.Rules {
+		if !MatchPathGlob(rule.Pattern, absolutePath) {
+			continue
+		}
+		if rule.Mode >= 0 && !a.Mode.IsDir() {
+			a.Mode = a.Mode&^os.ModePerm | os.FileMode(rule.Mode)
+		}
+		if rule.Uid >= 0 {
+			a.Uid = uint32(rule.Uid)
+		}
+		if rule.Gid >= 0 {
+			a.Gid = uint32(rule.Gid)
+		}
+		return
+	}
+}
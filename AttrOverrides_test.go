@@ -0,0 +1,57 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// Attributes reported for the matching paths are overridden, while HDFS attributes stay unchanged
+func TestAttrOverrides(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	var err error
+	fs.AttrOverrides, err = NewAttrOverrides("/public/**=0644:1001:1002")
+	assert.Nil(t, err)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/public").Return(Attrs{Name: "public", Mode: os.ModeDir | 0700, Uid: 5, Gid: 5}, nil)
+	hdfsAccessor.EXPECT().Stat("/public/data.csv").Return(Attrs{Name: "data.csv", Mode: 0600, Uid: 5, Gid: 5}, nil)
+	hdfsAccessor.EXPECT().Stat("/private.csv").Return(Attrs{Name: "private.csv", Mode: 0600, Uid: 5, Gid: 5}, nil)
+	public, _ := root.(*Dir).Lookup(nil, "public")
+	data, _ := public.(*Dir).Lookup(nil, "data.csv")
+	private, _ := root.(*Dir).Lookup(nil, "private.csv")
+
+	var attr fuse.Attr
+	assert.Nil(t, data.(*File).Attr(nil, &attr))
+	assert.Equal(t, os.FileMode(0644), attr.Mode)
+	assert.Equal(t, uint32(1001), attr.Uid)
+	assert.Equal(t, uint32(1002), attr.Gid)
+	assert.Equal(t, os.FileMode(0600), data.(*File).Attrs.Mode)
+
+	// Directory keeps its mode, only owner and group are overridden
+	assert.Nil(t, public.(*Dir).Attr(nil, &attr))
+	assert.Equal(t, os.ModeDir|0700, attr.Mode)
+	assert.Equal(t, uint32(1001), attr.Uid)
+
+	assert.Nil(t, private.(*File).Attr(nil, &attr))
+	assert.Equal(t, os.FileMode(0600), attr.Mode)
+	assert.Equal(t, uint32(5), attr.Uid)
+}
+
+// "**" matches any number of path components
+func TestMatchPathGlob(t *testing.T) {
+	assert.True(t, MatchPathGlob("/public/**", "/public/a/b/c.txt"))
+	assert.True(t, MatchPathGlob("/public/**/*.csv", "/public/x.csv"))
+	assert.True(t, MatchPathGlob("/public/**/*.csv", "/public/a/b/x.csv"))
+	assert.False(t, MatchPathGlob("/public/**/*.csv", "/public/a/x.txt"))
+	assert.True(t, MatchPathGlob("/logs/*", "/logs/app.log"))
+	assert.False(t, MatchPathGlob("/logs/*", "/logs/2020/app.log"))
+	assert.False(t, MatchPathGlob("/public/**", "/private/a"))
+}
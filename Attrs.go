@@ -17,9 +17,31 @@ type Attrs struct {
 	Uid     uint32
 	Gid     uint32
 	Mtime   time.Time
-	Ctime   time.Time
-	Crtime  time.Time
-	Expires time.Time // indicates when cached attribute information expires
+	Atime   time.Time
+	Ctime   time.Time  // metadata change time (HDFS doesn't track it, so local changes are tracked on top of mtime)
+	Crtime  time.Time  // creation (birth) time, zero if unknown
+	Expires time.Time  // indicates when cached attribute information expires
+	Group   string     // owning group in HDFS ("" if unknown)
+	HasAcl  bool       // true if the file has ACL (its entries are retrieved on demand, see FileSystem.LoadAcl)
+	Acl     []AclEntry // ACL entries extending the mode bits (nil if the file has no ACL or they haven't been retrieved yet)
+	Target  string     // target of the symbolic link ("" if the node isn't a symlink)
+}
+
+// Location of HDFS block of the file
+type BlockLocation struct {
+	Offset            uint64   // Offset of the block within the file
+	Length            uint64   // Length of the block
+	Hosts             []string // Data nodes hosting replicas of the block
+	States            []string // Admin states of the data nodes, in the same order as Hosts (nil if unknown)
+	Checksum          []byte   // Checksum of the block content (nil if unknown)
+	UnderConstruction bool     // true for the last block of a file which is open for write (the block might still grow)
+}
+
+// Change of the snapshottable directory between two snapshots
+type DiffEntry struct {
+	Type       string // Type of the change: "+" (created), "M" (modified), "-" (deleted), "R" (renamed)
+	Path       string // Path of the changed entry, relative to the snapshottable directory
+	TargetPath string // New path of the renamed entry ("" for other types of changes)
 }
 
 // FsInfo provides information about HDFS
@@ -29,6 +51,23 @@ type FsInfo struct {
 	remaining             uint64
 }
 
+// ContentSummary provides quota and usage information of HDFS directory
+type ContentSummary struct {
+	Size           uint64 // Total size of the files in the directory tree
+	SpaceConsumed  uint64 // Disk space consumed by the directory tree (including replicas)
+	SpaceQuota     int64  // Space quota in bytes (negative if not set)
+	NameQuota      int64  // Namespace (number of files and directories) quota (negative if not set)
+	FileCount      uint64 // Number of files in the directory tree
+	DirectoryCount uint64 // Number of directories in the directory tree
+}
+
+// Records metadata change (chmod, chown, rename) which happened at the given time
+func (this *Attrs) MetadataChanged(now time.Time) {
+	if now.After(this.Ctime) {
+		this.Ctime = now
+	}
+}
+
 // Converts Attrs datastructure into FUSE represnetation
 func (this *Attrs) Attr(a *fuse.Attr) error {
 	a.Inode = this.Inode
@@ -39,15 +78,21 @@ func (this *Attrs) Attr(a *fuse.Attr) error {
 	a.Uid = this.Uid
 	a.Gid = this.Gid
 	a.Mtime = this.Mtime
+	a.Atime = this.Atime
 	a.Ctime = this.Ctime
-	a.Crtime = this.Crtime
+	if !this.Crtime.IsZero() {
+		// birth time is reported only if known, otherwise it is omitted
+		a.Crtime = this.Crtime
+	}
 	return nil
 }
 
-// returns fuse.DirentType for this attributes (DT_Dir or DT_File)
+// returns fuse.DirentType for this attributes (DT_Dir, DT_Link or DT_File)
 func (this *Attrs) FuseNodeType() fuse.DirentType {
 	if (this.Mode & os.ModeDir) == os.ModeDir {
 		return fuse.DT_Dir
+	} else if (this.Mode & os.ModeSymlink) == os.ModeSymlink {
+		return fuse.DT_Link
 	} else {
 		return fuse.DT_File
 	}
@@ -0,0 +1,123 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Single record of the audit log
+type AuditRecord struct {
+	Time    time.Time // Time of the operation
+	Op      string    // Operation: create, mkdir, delete, rename, chmod, chown
+	Path    string    // HDFS path the operation was applied to
+	Details string    // Operation-specific details (e.g. new path for rename, mode for chmod)
+	Uid     uint32    // Identity of the caller
+	Gid     uint32
+}
+
+// Append-only log of the namespace-modifying operations performed via the mount.
+// Records are written asynchronously, so the audit doesn't slow down FUSE operations
+// (unless the destination can't keep up: then operations wait for the queue rather than losing records)
+type AuditLog struct {
+	Writer  io.Writer         // Destination of the audit records
+	records chan AuditRecord  // queue of records to be written
+	done    chan struct{}     // closed once all the records are written
+	users   map[uint32]string // cache of usernames by uid (accessed only by the writer goroutine)
+	closed  bool              // true once the log is closed (records are ignored)
+	lock    sync.RWMutex      // protects closed flag against concurrent Close
+}
+
+// Capacity of the audit record queue
+const AuditLogQueueSize = 4096
+
+// Creates audit log writing to the given destination: "syslog" or path to a local file
+func OpenAuditLog(destination string) (*AuditLog, error) {
+	if destination == "syslog" {
+		writer, err := syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, "hdfs-mount")
+		if err != nil {
+			return nil, err
+		}
+		return NewAuditLog(writer), nil
+	}
+	file, err := os.OpenFile(destination, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewAuditLog(file), nil
+}
+
+// Creates audit log and starts background goroutine writing the records
+func NewAuditLog(writer io.Writer) *AuditLog {
+	this := &AuditLog{
+		Writer:  writer,
+		records: make(chan AuditRecord, AuditLogQueueSize),
+		done:    make(chan struct{}),
+		users:   make(map[uint32]string)}
+	go this.run()
+	return this
+}
+
+// Queues audit record (nil-safe, does nothing if audit isn't enabled).
+// Records are never dropped: if the queue is full, the caller waits until the writer catches up
+func (this *AuditLog) Record(record AuditRecord) {
+	if this == nil {
+		return
+	}
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	if this.closed {
+		return
+	}
+	select {
+	case this.records <- record:
+	default:
+		Warning.Println("Audit log queue is full, waiting for the writer:", record.Op, record.Path)
+		this.records <- record
+	}
+}
+
+// Writes queued records
+func (this *AuditLog) run() {
+	defer close(this.done)
+	for record := range this.records {
+		_, err := fmt.Fprintf(this.Writer, "%s op=%s path=%q details=%q uid=%d gid=%d user=%s\n",
+			record.Time.UTC().Format(time.RFC3339Nano), record.Op, record.Path, record.Details, record.Uid, record.Gid, this.userName(record.Uid))
+		if err != nil {
+			Error.Println("Can't write audit record:", err)
+		}
+	}
+}
+
+// Maps uid to username (uid itself is used if it can't be mapped)
+func (this *AuditLog) userName(uid uint32) string {
+	if name, ok := this.users[uid]; ok {
+		return name
+	}
+	name := strconv.FormatUint(uint64(uid), 10)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	this.users[uid] = name
+	return name
+}
+
+// Writes all the queued records and closes the destination
+func (this *AuditLog) Close() error {
+	this.lock.Lock()
+	this.closed = true
+	close(this.records)
+	this.lock.Unlock()
+	<-this.done
+	if closer, ok := this.Writer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bytes"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Creating a file emits audit record with the timestamp, path and identity of the caller
+func TestAuditLogCreate(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	mockClock.NotifyTimeElapsed(1000 * time.Hour)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	var auditRecords bytes.Buffer
+	fs.AuditLog = NewAuditLog(&auditRecords)
+	fs.StagingInMemory = true
+	root, _ := fs.Root()
+
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Remove("/foo.txt").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/foo.txt", os.FileMode(0644)).Return(hdfsWriter, nil)
+	hdfsWriter.EXPECT().Close().Return(nil)
+	_, _, err := root.(*Dir).Create(nil, &fuse.CreateRequest{Header: fuse.Header{Uid: 4242, Gid: 4343}, Name: "foo.txt", Mode: 0644}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+
+	// Closing the log writes all the queued records
+	assert.Nil(t, fs.AuditLog.Close())
+	records := strings.Split(strings.TrimSpace(auditRecords.String()), "\n")
+	assert.Equal(t, 1, len(records))
+	expectedPrefix := mockClock.Now().UTC().Format(time.RFC3339Nano) + " op=create path=\"/foo.txt\" details=\"-rw-r--r--\" uid=4242 gid=4343 user="
+	assert.True(t, strings.HasPrefix(records[0], expectedPrefix), records[0])
+
+	// Records are ignored once the log is closed
+	fs.AuditLog.Record(AuditRecord{Op: "delete", Path: "/foo.txt"})
+}
+
+// Writer blocking until the gate is opened
+type gatedWriter struct {
+	gate   chan struct{}
+	buffer bytes.Buffer
+}
+
+func (this *gatedWriter) Write(p []byte) (int, error) {
+	<-this.gate
+	return this.buffer.Write(p)
+}
+
+// Records aren't dropped when the queue is full, the caller waits for the writer instead
+func TestAuditLogFullQueue(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	writer := &gatedWriter{gate: make(chan struct{})}
+	auditLog := NewAuditLog(writer)
+	count := AuditLogQueueSize + 10
+	recorded := make(chan struct{})
+	go func() {
+		for i := 0; i < count; i++ {
+			auditLog.Record(AuditRecord{Op: "delete", Path: "/foo.txt"})
+		}
+		close(recorded)
+	}()
+	select {
+	case <-recorded:
+		t.Fatal("Recording didn't wait for the writer")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(writer.gate)
+	<-recorded
+	assert.Nil(t, auditLog.Close())
+	assert.Equal(t, count, strings.Count(writer.buffer.String(), "\n"))
+}
@@ -0,0 +1,284 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// Serves reads from a backup (DR) cluster when they fail on the primary cluster, including read-only
+// metadata operations needed to reach the files (stat, listing). All other operations (including writes)
+// go only to the primary cluster
+type BackupReadHdfsAccessor struct {
+	Primary            HdfsAccessor  // Accessor to the primary cluster
+	Backup             HdfsAccessor  // Accessor to the backup cluster
+	BackupPathRewriter *PathRewriter // Maps paths on the primary cluster to paths on the backup cluster (nil if paths are the same)
+}
+
+var _ HdfsAccessor = (*BackupReadHdfsAccessor)(nil) // ensure BackupReadHdfsAccessor implements HdfsAccessor
+
+// Creates an instance of BackupReadHdfsAccessor
+func NewBackupReadHdfsAccessor(primary HdfsAccessor, backup HdfsAccessor, backupPathRewriter *PathRewriter) *BackupReadHdfsAccessor {
+	return &BackupReadHdfsAccessor{
+		Primary:            primary,
+		Backup:             backup,
+		BackupPathRewriter: backupPathRewriter}
+}
+
+// Opens HDFS file for reading (on the backup cluster if it can't be opened on the primary one)
+func (this *BackupReadHdfsAccessor) OpenRead(path string) (ReadSeekCloser, error) {
+	reader, err := this.Primary.OpenRead(path)
+	if err == nil {
+		return &BackupFallbackReader{Path: path, Impl: reader, Accessor: this}, nil
+	}
+	if IsSuccessOrBenignError(err) {
+		return nil, err
+	}
+	Warning.Println("[", path, "] OpenRead failed on primary cluster:", err, ", falling back to backup cluster")
+	backupReader, backupErr := this.Backup.OpenRead(this.BackupPathRewriter.Rewrite(path))
+	if backupErr != nil {
+		Error.Println("[", path, "] OpenRead failed on backup cluster:", backupErr)
+		return nil, err
+	}
+	return &BackupFallbackReader{Path: path, Impl: backupReader, Accessor: this, UsingBackup: true}, nil
+}
+
+// Opens HDFS file for writing
+func (this *BackupReadHdfsAccessor) CreateFile(path string, mode os.FileMode) (HdfsWriter, error) {
+	return this.Primary.CreateFile(path, mode)
+}
+
+// Opens HDFS file for writing, with given number of replicas in the write pipeline
+func (this *BackupReadHdfsAccessor) CreateFileWithReplication(path string, mode os.FileMode, replication int) (HdfsWriter, error) {
+	return this.Primary.CreateFileWithReplication(path, mode, replication)
+}
+
+// Opens existing HDFS file for appending (on primary cluster only)
+func (this *BackupReadHdfsAccessor) Append(path string) (HdfsWriter, error) {
+	return this.Primary.Append(path)
+}
+
+// Enumerates HDFS directory (on the backup cluster if it can't be enumerated on the primary one)
+func (this *BackupReadHdfsAccessor) ReadDir(path string) ([]Attrs, error) {
+	attrs, err := this.Primary.ReadDir(path)
+	if this.fallBackToBackup("ReadDir", path, err) {
+		backupAttrs, backupErr := this.Backup.ReadDir(this.BackupPathRewriter.Rewrite(path))
+		if backupErr == nil {
+			return backupAttrs, nil
+		}
+		Error.Println("[", path, "] ReadDir failed on backup cluster:", backupErr)
+	}
+	return attrs, err
+}
+
+// Opens HDFS directory for enumerating it page by page (on the backup cluster if it can't be opened on the primary one)
+func (this *BackupReadHdfsAccessor) OpenDir(path string) (DirReader, error) {
+	reader, err := this.Primary.OpenDir(path)
+	if this.fallBackToBackup("OpenDir", path, err) {
+		backupReader, backupErr := this.Backup.OpenDir(this.BackupPathRewriter.Rewrite(path))
+		if backupErr == nil {
+			return backupReader, nil
+		}
+		Error.Println("[", path, "] OpenDir failed on backup cluster:", backupErr)
+	}
+	return reader, err
+}
+
+// Retrieves file/directory attributes (from the backup cluster if they can't be retrieved from the primary one)
+func (this *BackupReadHdfsAccessor) Stat(path string) (Attrs, error) {
+	attrs, err := this.Primary.Stat(path)
+	if this.fallBackToBackup("Stat", path, err) {
+		backupAttrs, backupErr := this.Backup.Stat(this.BackupPathRewriter.Rewrite(path))
+		if backupErr == nil {
+			return backupAttrs, nil
+		}
+		Error.Println("[", path, "] Stat failed on backup cluster:", backupErr)
+	}
+	return attrs, err
+}
+
+// Returns true if read-only operation has failed on the primary cluster (not due to a benign error,
+// e.g. the path doesn't exist), so it should be retried on the backup cluster
+func (this *BackupReadHdfsAccessor) fallBackToBackup(op string, path string, err error) bool {
+	if IsSuccessOrBenignError(err) {
+		return false
+	}
+	Warning.Println("[", path, "]", op, "failed on primary cluster:", err, ", falling back to backup cluster")
+	return true
+}
+
+// Retrieves HDFS usage
+func (this *BackupReadHdfsAccessor) StatFs() (FsInfo, error) {
+	return this.Primary.StatFs()
+}
+
+// Retrieves quota and usage of the directory (from the backup cluster if they can't be retrieved from the primary one)
+func (this *BackupReadHdfsAccessor) GetContentSummary(path string) (ContentSummary, error) {
+	summary, err := this.Primary.GetContentSummary(path)
+	if this.fallBackToBackup("GetContentSummary", path, err) {
+		backupSummary, backupErr := this.Backup.GetContentSummary(this.BackupPathRewriter.Rewrite(path))
+		if backupErr == nil {
+			return backupSummary, nil
+		}
+		Error.Println("[", path, "] GetContentSummary failed on backup cluster:", backupErr)
+	}
+	return summary, err
+}
+
+// Creates a directory
+func (this *BackupReadHdfsAccessor) Mkdir(path string, mode os.FileMode) error {
+	return this.Primary.Mkdir(path, mode)
+}
+
+// Removes a file or directory
+func (this *BackupReadHdfsAccessor) Remove(path string) error {
+	return this.Primary.Remove(path)
+}
+
+// Renames a file or directory
+func (this *BackupReadHdfsAccessor) Rename(oldPath string, newPath string) error {
+	return this.Primary.Rename(oldPath, newPath)
+}
+
+// Ensures HDFS accessor is connected to the HDFS name node of the primary cluster
+func (this *BackupReadHdfsAccessor) EnsureConnected() error {
+	return this.Primary.EnsureConnected()
+}
+
+// Changes the owner and group of the file
+func (this *BackupReadHdfsAccessor) Chown(path string, owner, group string) error {
+	return this.Primary.Chown(path, owner, group)
+}
+
+// Changes the access and modification times of the file
+func (this *BackupReadHdfsAccessor) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	return this.Primary.Chtimes(path, atime, mtime)
+}
+
+// Changes the mode of the file
+func (this *BackupReadHdfsAccessor) Chmod(path string, mode os.FileMode) error {
+	return this.Primary.Chmod(path, mode)
+}
+
+// Changes the mode of the directory tree
+func (this *BackupReadHdfsAccessor) ChmodRecursive(path string, mode os.FileMode) error {
+	return this.Primary.ChmodRecursive(path, mode)
+}
+
+// Changes the owner and group of the directory tree
+func (this *BackupReadHdfsAccessor) ChownRecursive(path string, owner, group string) error {
+	return this.Primary.ChownRecursive(path, owner, group)
+}
+
+// Retrieves checksum of the file (from the backup cluster if it can't be retrieved from the primary one)
+func (this *BackupReadHdfsAccessor) GetChecksum(path string) ([]byte, error) {
+	checksum, err := this.Primary.GetChecksum(path)
+	if this.fallBackToBackup("GetChecksum", path, err) {
+		backupChecksum, backupErr := this.Backup.GetChecksum(this.BackupPathRewriter.Rewrite(path))
+		if backupErr == nil {
+			return backupChecksum, nil
+		}
+		Error.Println("[", path, "] GetChecksum failed on backup cluster:", backupErr)
+	}
+	return checksum, err
+}
+
+// Retrieves ACL entries of the file/directory (falls back to backup cluster)
+func (this *BackupReadHdfsAccessor) GetAcl(path string) ([]AclEntry, error) {
+	acl, err := this.Primary.GetAcl(path)
+	if this.fallBackToBackup("GetAcl", path, err) {
+		backupAcl, backupErr := this.Backup.GetAcl(this.BackupPathRewriter.Rewrite(path))
+		if backupErr == nil {
+			return backupAcl, nil
+		}
+		Error.Println("[", path, "] GetAcl failed on backup cluster:", backupErr)
+	}
+	return acl, err
+}
+
+// Triggers lease recovery of the file (on primary cluster only)
+func (this *BackupReadHdfsAccessor) RecoverLease(path string) (bool, error) {
+	return this.Primary.RecoverLease(path)
+}
+
+// Retrieves layout of the file blocks (on primary cluster only)
+func (this *BackupReadHdfsAccessor) GetBlockLocations(path string) ([]BlockLocation, error) {
+	return this.Primary.GetBlockLocations(path)
+}
+
+// Creates a symbolic link (on primary cluster only)
+func (this *BackupReadHdfsAccessor) CreateSymlink(target string, path string) error {
+	return this.Primary.CreateSymlink(target, path)
+}
+
+// Lists changes of the snapshottable directory between two snapshots (on primary cluster only)
+func (this *BackupReadHdfsAccessor) SnapshotDiff(path, from, to string) ([]DiffEntry, error) {
+	return this.Primary.SnapshotDiff(path, from, to)
+}
+
+// Closes connections to both clusters
+func (this *BackupReadHdfsAccessor) Close() error {
+	this.Backup.Close()
+	return this.Primary.Close()
+}
+
+// Reader which switches to the backup cluster (at the same offset) if reading from primary cluster fails
+type BackupFallbackReader struct {
+	Path        string                  // Path of the file on the primary cluster
+	Impl        ReadSeekCloser          // Current backend reader
+	Accessor    *BackupReadHdfsAccessor // Accessor used to open the file on the backup cluster
+	Offset      int64                   // Current reading position
+	UsingBackup bool                    // true if reading from the backup cluster
+}
+
+var _ ReadSeekCloser = (*BackupFallbackReader)(nil) // ensure BackupFallbackReader implements ReadSeekCloser
+
+// Read a chunk of data
+func (this *BackupFallbackReader) Read(buffer []byte) (int, error) {
+	nr, err := this.Impl.Read(buffer)
+	if !IsSuccessOrBenignError(err) && !this.UsingBackup {
+		Warning.Println("[", this.Path, "] Read @", this.Offset, "failed on primary cluster:", err, ", falling back to backup cluster")
+		backupReader, backupErr := this.Accessor.Backup.OpenRead(this.Accessor.BackupPathRewriter.Rewrite(this.Path))
+		if backupErr != nil {
+			Error.Println("[", this.Path, "] OpenRead failed on backup cluster:", backupErr)
+			return nr, err
+		}
+		if backupErr = backupReader.Seek(this.Offset + int64(nr)); backupErr != nil {
+			Error.Println("[", this.Path, "] Seek failed on backup cluster:", backupErr)
+			backupReader.Close()
+			return nr, err
+		}
+		this.Impl.Close()
+		this.Impl = backupReader
+		this.UsingBackup = true
+		if nr == 0 {
+			nr, err = this.Impl.Read(buffer)
+		} else {
+			err = nil
+		}
+	}
+	if err == nil {
+		this.Offset += int64(nr)
+	}
+	return nr, err
+}
+
+// Seeks to a given position
+func (this *BackupFallbackReader) Seek(pos int64) error {
+	err := this.Impl.Seek(pos)
+	if err == nil {
+		this.Offset = pos
+	}
+	return err
+}
+
+// Returns current position
+func (this *BackupFallbackReader) Position() (int64, error) {
+	return this.Offset, nil
+}
+
+// Closes the stream
+func (this *BackupFallbackReader) Close() error {
+	return this.Impl.Close()
+}
@@ -0,0 +1,102 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// Testing that file which can't be opened on primary cluster is read from the backup cluster
+func TestBackupReadWhenPrimaryOpenFails(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	primary := NewMockHdfsAccessor(mockCtrl)
+	backup := NewMockHdfsAccessor(mockCtrl)
+	backupReader := NewMockReadSeekCloser(mockCtrl)
+	rewriter, _ := NewPathRewriter("/data=/dr/data")
+	accessor := NewBackupReadHdfsAccessor(primary, backup, rewriter)
+
+	primary.EXPECT().OpenRead("/data/file").Return(nil, errors.New("Injected failure"))
+	backup.EXPECT().OpenRead("/dr/data/file").Return(backupReader, nil)
+	reader, err := accessor.OpenRead("/data/file")
+	assert.Nil(t, err)
+	backupReader.whenReadReturn([]byte("Hello"), nil)
+	buffer := make([]byte, 5)
+	nr, err := reader.Read(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello", string(buffer[:nr]))
+}
+
+// Testing that read failing on primary cluster in the middle of the file continues on the backup cluster
+func TestBackupReadWhenPrimaryReadFails(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	primary := NewMockHdfsAccessor(mockCtrl)
+	backup := NewMockHdfsAccessor(mockCtrl)
+	primaryReader := NewMockReadSeekCloser(mockCtrl)
+	backupReader := NewMockReadSeekCloser(mockCtrl)
+	accessor := NewBackupReadHdfsAccessor(primary, backup, nil)
+
+	primary.EXPECT().OpenRead("/data/file").Return(primaryReader, nil)
+	reader, err := accessor.OpenRead("/data/file")
+	assert.Nil(t, err)
+	buffer := make([]byte, 5)
+	primaryReader.whenReadReturn([]byte("Hello"), nil)
+	nr, err := reader.Read(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello", string(buffer[:nr]))
+
+	// Primary cluster fails, backup reader is opened at the same offset
+	primaryReader.EXPECT().Read(gomock.Any()).Return(0, errors.New("Injected failure"))
+	primaryReader.EXPECT().Close().Return(nil)
+	backup.EXPECT().OpenRead("/data/file").Return(backupReader, nil)
+	backupReader.expectSeek(5)
+	backupReader.whenReadReturn([]byte("World"), nil)
+	nr, err = reader.Read(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, "World", string(buffer[:nr]))
+	pos, _ := reader.Position()
+	assert.Equal(t, int64(10), pos)
+
+	// Writes still go to the primary cluster only
+	primary.EXPECT().Remove("/data/file").Return(nil)
+	assert.Nil(t, accessor.Remove("/data/file"))
+}
+
+// Testing that lookups and listings failing on primary cluster are served by the backup cluster,
+// while benign errors (e.g. missing file) are reported without falling back
+func TestBackupMetadataWhenPrimaryFails(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	primary := NewMockHdfsAccessor(mockCtrl)
+	backup := NewMockHdfsAccessor(mockCtrl)
+	rewriter, _ := NewPathRewriter("/data=/dr/data")
+	accessor := NewBackupReadHdfsAccessor(primary, backup, rewriter)
+	failure := errors.New("Injected failure")
+
+	primary.EXPECT().Stat("/data/file").Return(Attrs{}, failure)
+	backup.EXPECT().Stat("/dr/data/file").Return(Attrs{Name: "file", Size: 5}, nil)
+	attrs, err := accessor.Stat("/data/file")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5), attrs.Size)
+
+	primary.EXPECT().ReadDir("/data").Return(nil, failure)
+	backup.EXPECT().ReadDir("/dr/data").Return([]Attrs{{Name: "file"}}, nil)
+	entries, err := accessor.ReadDir("/data")
+	assert.Nil(t, err)
+	assert.Equal(t, []Attrs{{Name: "file"}}, entries)
+
+	// Error of the primary cluster is reported if backup cluster fails too
+	primary.EXPECT().Stat("/data/other").Return(Attrs{}, failure)
+	backup.EXPECT().Stat("/dr/data/other").Return(Attrs{}, errors.New("Backup failure"))
+	_, err = accessor.Stat("/data/other")
+	assert.Equal(t, failure, err)
+
+	notFound := &os.PathError{Op: "stat", Path: "/data/missing", Err: os.ErrNotExist}
+	primary.EXPECT().Stat("/data/missing").Return(Attrs{}, notFound)
+	_, err = accessor.Stat("/data/missing")
+	assert.Equal(t, notFound, err)
+}
@@ -0,0 +1,43 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Extended attribute exposing HDFS block layout of the file (with ExposeBlockLayout), one line per block:
+// offset, length and comma-separated data nodes hosting its replicas, letting locality-aware tools
+// schedule work next to the data (e.g. getfattr --only-values -n user.hdfs.blocks file)
+const XattrBlocks = "user.hdfs.blocks"
+
+// Formats block layout of the file as the value of XattrBlocks
+func FormatBlockLayout(blocks []BlockLocation) []byte {
+	var buf bytes.Buffer
+	for _, block := range blocks {
+		fmt.Fprintf(&buf, "%d %d %s\n", block.Offset, block.Length, strings.Join(block.Hosts, ","))
+	}
+	return buf.Bytes()
+}
+
+// Retrieves block layout of the file from the name node, formatted as the value of XattrBlocks
+func (this *File) BlockLayout() ([]byte, error) {
+	if this.IsCreateDeferred() {
+		// File doesn't exist in HDFS yet, so it has no blocks
+		return []byte{}, nil
+	}
+	absolutePath := this.AbsolutePath()
+	blocks, err := this.FileSystem.HdfsAccessor.GetBlockLocations(absolutePath)
+	if err != nil {
+		Warning.Println("[", absolutePath, "] GetBlockLocations:", err)
+		if os.IsNotExist(err) {
+			return nil, fuse.ENOENT
+		}
+		return nil, err
+	}
+	return FormatBlockLayout(blocks), nil
+}
@@ -0,0 +1,43 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// Block layout of the file is exposed as extended attribute, one line per block
+func TestBlockLayoutXattr(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fileSystem.Root()
+	hdfsAccessor.EXPECT().Stat("/big.dat").Return(Attrs{Name: "big.dat", Mode: 0644, Size: 200}, nil)
+	file, _ := root.(*Dir).Lookup(nil, "big.dat")
+
+	// Not exposed unless enabled
+	err := file.(*File).Getxattr(nil, &fuse.GetxattrRequest{Name: XattrBlocks}, &fuse.GetxattrResponse{})
+	assert.Equal(t, fuse.ErrNoXattr, err)
+
+	fileSystem.ExposeBlockLayout = true
+	list := &fuse.ListxattrResponse{}
+	assert.Nil(t, file.(*File).Listxattr(nil, &fuse.ListxattrRequest{}, list))
+	assert.Equal(t, XattrBlocks+"\x00", string(list.Xattr))
+	hdfsAccessor.EXPECT().GetBlockLocations("/big.dat").Return([]BlockLocation{
+		{Offset: 0, Length: 128, Hosts: []string{"dn1", "dn2"}},
+		{Offset: 128, Length: 72, Hosts: []string{"dn3"}}}, nil)
+	resp := &fuse.GetxattrResponse{}
+	assert.Nil(t, file.(*File).Getxattr(nil, &fuse.GetxattrRequest{Name: XattrBlocks}, resp))
+	assert.Equal(t, "0 128 dn1,dn2\n128 72 dn3\n", string(resp.Xattr))
+
+	// File removed by another client
+	hdfsAccessor.EXPECT().GetBlockLocations("/big.dat").Return(nil, &os.PathError{Op: "getBlockLocations", Path: "/big.dat", Err: os.ErrNotExist})
+	err = file.(*File).Getxattr(nil, &fuse.GetxattrRequest{Name: XattrBlocks}, &fuse.GetxattrResponse{})
+	assert.Equal(t, fuse.ENOENT, err)
+}
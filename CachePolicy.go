@@ -0,0 +1,77 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+// Name of the marker file setting caching policy of the files in its directory and subdirectories
+// (with CachePolicyMarkers), the content of the marker is the name of the policy (e.g. 'aggressive')
+const CachePolicyMarkerName = ".hdfs-mount-cache"
+
+// Caching policies set by the markers
+const (
+	CachePolicyAggressive = "aggressive" // hot reference data: content is kept in the shared content cache (see DedupCache)
+	CachePolicyNone       = "none"       // one-time-read data: neither the content cache nor recently read ranges are kept
+)
+
+// Maximum size of the marker content which is read
+var CachePolicyMarkerMaxSize int = 64
+
+// Returns caching policy of the files in the directory: set by the marker in the directory, or inherited
+// from the parent ("" if no directory up the tree is marked). Marker is read once per directory
+func (this *Dir) CachePolicy() string {
+	this.cachePolicyOnce.Do(func() {
+		this.cachePolicy = this.readCachePolicyMarker()
+	})
+	if this.cachePolicy == "" && this.Parent != nil {
+		return this.Parent.CachePolicy()
+	}
+	return this.cachePolicy
+}
+
+// Reads caching policy from the marker in the directory ("" if there is no valid marker)
+func (this *Dir) readCachePolicyMarker() string {
+	path := this.AbsolutePathForChild(CachePolicyMarkerName)
+	reader, err := this.FileSystem.HdfsAccessor.OpenRead(path)
+	if err != nil {
+		if pathError, ok := err.(*os.PathError); !ok || pathError.Err != os.ErrNotExist {
+			Warning.Println("[", path, "] Reading caching policy marker:", err)
+		}
+		return ""
+	}
+	defer reader.Close()
+	buf := make([]byte, CachePolicyMarkerMaxSize)
+	n, err := io.ReadFull(reader, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		Warning.Println("[", path, "] Reading caching policy marker:", err)
+		return ""
+	}
+	policy := strings.TrimSpace(string(buf[:n]))
+	if policy != CachePolicyAggressive && policy != CachePolicyNone {
+		Warning.Println("[", path, "] Unknown caching policy:", policy)
+		return ""
+	}
+	Info.Println("[", this.AbsolutePath(), "] Caching policy:", policy)
+	return policy
+}
+
+// Returns caching policy of the file set by the directory markers ("" if markers are disabled or not set)
+func (this *File) CachePolicy() string {
+	if !this.FileSystem.CachePolicyMarkers {
+		return ""
+	}
+	return this.Parent.CachePolicy()
+}
+
+// Returns true if content of the file can be served from the shared content cache.
+// With CachePolicyMarkers, only the files in directories marked as 'aggressive' use it
+func (this *File) UsesContentCache() bool {
+	if !this.FileSystem.CachePolicyMarkers {
+		return true
+	}
+	return this.CachePolicy() == CachePolicyAggressive
+}
@@ -0,0 +1,108 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"fmt"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Ways of handling entries whose names differ only in case (see FileSystem.CaseCollisions),
+// which collide when the mount is re-exported to case-insensitive clients (e.g. via SMB)
+const (
+	CaseCollisionsAllow  = "allow"  // all entries are listed as they are
+	CaseCollisionsHide   = "hide"   // only the first entry (in byte order of names) is listed, the others are hidden
+	CaseCollisionsSuffix = "suffix" // entries after the first are listed with disambiguating suffix (e.g. 'a~1.txt')
+	CaseCollisionsFail   = "fail"   // listing of the directory fails with EIO
+)
+
+// Finds entries of the listing colliding case-insensitively with other entries.
+// Returns names presented for the entries after the first one of each collision by their HDFS names
+// (empty for hidden ones), fails with EIO if collisions aren't allowed
+func (this *Dir) ResolveCaseCollisions(allAttrs []Attrs) (map[string]string, error) {
+	policy := this.FileSystem.CaseCollisions
+	if policy == "" || policy == CaseCollisionsAllow {
+		return nil, nil
+	}
+	names := make([]string, 0, len(allAttrs))
+	for _, a := range allAttrs {
+		names = append(names, a.Name)
+	}
+	sort.Strings(names)
+	taken := make(map[string]string, len(names))
+	for _, name := range names {
+		folded := strings.ToLower(name)
+		if _, ok := taken[folded]; !ok {
+			taken[folded] = name
+		}
+	}
+	var collisions map[string]string
+	for _, name := range names {
+		first := taken[strings.ToLower(name)]
+		if first == name {
+			continue
+		}
+		if policy == CaseCollisionsFail {
+			Error.Println("ls [", this.AbsolutePath(), "]: names [", first, "] and [", name, "] differ only in case")
+			return nil, fuse.Errno(syscall.EIO)
+		}
+		if collisions == nil {
+			collisions = make(map[string]string)
+		}
+		if policy == CaseCollisionsHide {
+			Warning.Println("ls [", this.AbsolutePath(), "]: hiding [", name, "] colliding with [", first, "]")
+			collisions[name] = ""
+			continue
+		}
+		presented := this.FileSystem.PresentedName(name)
+		for n := 1; ; n++ {
+			alias := caseCollisionAlias(presented, n)
+			if _, ok := taken[strings.ToLower(alias)]; !ok {
+				taken[strings.ToLower(alias)] = name
+				collisions[name] = alias
+				break
+			}
+		}
+		Warning.Println("ls [", this.AbsolutePath(), "]: listing [", name, "] colliding with [", first, "] as [", collisions[name], "]")
+	}
+	return collisions, nil
+}
+
+// Inserts disambiguating suffix before the extension of the name (e.g. 'a.txt' -> 'a~1.txt')
+func caseCollisionAlias(name string, n int) string {
+	dot := strings.LastIndex(name, ".")
+	if dot <= 0 {
+		return fmt.Sprintf("%s~%d", name, n)
+	}
+	return fmt.Sprintf("%s~%d%s", name[:dot], n, name[dot:])
+}
+
+// Records names presented for colliding entries by the last listing
+func (this *Dir) setCaseCollisions(collisions map[string]string) {
+	this.EntriesMutex.Lock()
+	defer this.EntriesMutex.Unlock()
+	this.caseCollisions = collisions
+}
+
+// Maps the looked up name to HDFS name according to the last listing (disambiguating suffix is removed).
+// Returns false if the entry is hidden due to case collision
+func (this *Dir) ResolveCaseCollision(name string) (string, bool) {
+	this.EntriesMutex.Lock()
+	defer this.EntriesMutex.Unlock()
+	if this.caseCollisions == nil {
+		return name, true
+	}
+	if _, ok := this.caseCollisions[name]; ok {
+		// Colliding entry is only visible under its alias (if any)
+		return name, false
+	}
+	for hdfsName, alias := range this.caseCollisions {
+		if alias == name {
+			return hdfsName, true
+		}
+	}
+	return name, true
+}
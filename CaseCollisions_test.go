@@ -0,0 +1,65 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// Entries differing only in case are listed according to the configured collision policy
+func TestCaseCollisions(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	listing := []Attrs{
+		{Name: "report.txt", Mode: 0644, Size: 1},
+		{Name: "other.txt", Mode: 0644, Size: 2},
+		{Name: "Report.txt", Mode: 0644, Size: 3},
+	}
+	list := func(policy string) (*Dir, []string, error) {
+		fileSystem.CaseCollisions = policy
+		root := &Dir{FileSystem: fileSystem, Attrs: Attrs{Inode: 1, Name: "", Mode: 0755 | os.ModeDir}}
+		hdfsAccessor.EXPECT().ReadDir("/").Return(append([]Attrs(nil), listing...), nil)
+		dirents, err := root.ReadDirAll(nil)
+		var names []string
+		for _, dirent := range dirents {
+			names = append(names, dirent.Name)
+		}
+		return root, names, err
+	}
+
+	root, names, err := list(CaseCollisionsAllow)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"report.txt", "other.txt", "Report.txt"}, names)
+
+	// First entry in byte order ('R' < 'r') is the one kept
+	root, names, err = list(CaseCollisionsHide)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"other.txt", "Report.txt"}, names)
+	_, err = root.Lookup(nil, "report.txt")
+	assert.Equal(t, fuse.ENOENT, err)
+	node, err := root.Lookup(nil, "Report.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), node.(*File).Attrs.Size)
+
+	root, names, err = list(CaseCollisionsSuffix)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"report~1.txt", "other.txt", "Report.txt"}, names)
+	node, err = root.Lookup(nil, "report~1.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), node.(*File).Attrs.Size)
+	assert.Equal(t, "/report.txt", node.(*File).AbsolutePath())
+	_, err = root.Lookup(nil, "report.txt")
+	assert.Equal(t, fuse.ENOENT, err)
+
+	_, names, err = list(CaseCollisionsFail)
+	assert.Equal(t, fuse.Errno(syscall.EIO), err)
+	assert.Nil(t, names)
+}
@@ -0,0 +1,277 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"math/rand"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Injects I/O errors into reads, writes and stats with configured probability (for chaos testing
+// of the applications using the mount). Injected errors look the same as the errors of the backend,
+// so they are reported to the clients the same way
+type ChaosHdfsAccessor struct {
+	Impl        HdfsAccessor
+	Probability float64    // Probability of injecting an error into each operation (0..1)
+	Rand        *rand.Rand // Source of randomness
+	randMutex   sync.Mutex // Rand isn't thread safe
+}
+
+var _ HdfsAccessor = (*ChaosHdfsAccessor)(nil) // ensure ChaosHdfsAccessor implements HdfsAccessor
+
+// Errors which are injected
+var ChaosErrors = []syscall.Errno{syscall.EIO, syscall.ETIMEDOUT}
+
+// Creates an instance of ChaosHdfsAccessor
+func NewChaosHdfsAccessor(impl HdfsAccessor, probability float64) *ChaosHdfsAccessor {
+	return &ChaosHdfsAccessor{
+		Impl:        impl,
+		Probability: probability,
+		Rand:        rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Returns an error to be injected into the operation, or nil
+func (this *ChaosHdfsAccessor) inject(op string, path string) error {
+	this.randMutex.Lock()
+	defer this.randMutex.Unlock()
+	if this.Rand.Float64() >= this.Probability {
+		return nil
+	}
+	errno := ChaosErrors[this.Rand.Intn(len(ChaosErrors))]
+	Warning.Println("[", path, "] Chaos: injecting", errno, "into", op)
+	return &os.PathError{Op: op, Path: path, Err: errno}
+}
+
+// Opens HDFS file for reading
+func (this *ChaosHdfsAccessor) OpenRead(path string) (ReadSeekCloser, error) {
+	if err := this.inject("open", path); err != nil {
+		return nil, err
+	}
+	reader, err := this.Impl.OpenRead(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ChaosReader{Impl: reader, Path: path, Accessor: this}, nil
+}
+
+// Opens HDFS file for writing
+func (this *ChaosHdfsAccessor) CreateFile(path string, mode os.FileMode) (HdfsWriter, error) {
+	if err := this.inject("create", path); err != nil {
+		return nil, err
+	}
+	writer, err := this.Impl.CreateFile(path, mode)
+	if err != nil {
+		return nil, err
+	}
+	return &ChaosWriter{Impl: writer, Path: path, Accessor: this}, nil
+}
+
+// Opens HDFS file for writing, with given number of replicas in the write pipeline
+func (this *ChaosHdfsAccessor) CreateFileWithReplication(path string, mode os.FileMode, replication int) (HdfsWriter, error) {
+	if err := this.inject("create", path); err != nil {
+		return nil, err
+	}
+	writer, err := this.Impl.CreateFileWithReplication(path, mode, replication)
+	if err != nil {
+		return nil, err
+	}
+	return &ChaosWriter{Impl: writer, Path: path, Accessor: this}, nil
+}
+
+// Opens existing HDFS file for appending
+func (this *ChaosHdfsAccessor) Append(path string) (HdfsWriter, error) {
+	if err := this.inject("append", path); err != nil {
+		return nil, err
+	}
+	writer, err := this.Impl.Append(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ChaosWriter{Impl: writer, Path: path, Accessor: this}, nil
+}
+
+// Enumerates HDFS directory
+func (this *ChaosHdfsAccessor) ReadDir(path string) ([]Attrs, error) {
+	if err := this.inject("readdir", path); err != nil {
+		return nil, err
+	}
+	return this.Impl.ReadDir(path)
+}
+
+// Opens HDFS directory for enumerating it page by page
+func (this *ChaosHdfsAccessor) OpenDir(path string) (DirReader, error) {
+	if err := this.inject("readdir", path); err != nil {
+		return nil, err
+	}
+	return this.Impl.OpenDir(path)
+}
+
+// Retrieves file/directory attributes
+func (this *ChaosHdfsAccessor) Stat(path string) (Attrs, error) {
+	if err := this.inject("stat", path); err != nil {
+		return Attrs{}, err
+	}
+	return this.Impl.Stat(path)
+}
+
+// Retrieves HDFS usage
+func (this *ChaosHdfsAccessor) StatFs() (FsInfo, error) {
+	return this.Impl.StatFs()
+}
+
+// Retrieves quota and usage of the directory
+func (this *ChaosHdfsAccessor) GetContentSummary(path string) (ContentSummary, error) {
+	return this.Impl.GetContentSummary(path)
+}
+
+// Creates a directory
+func (this *ChaosHdfsAccessor) Mkdir(path string, mode os.FileMode) error {
+	return this.Impl.Mkdir(path, mode)
+}
+
+// Removes a file or directory
+func (this *ChaosHdfsAccessor) Remove(path string) error {
+	return this.Impl.Remove(path)
+}
+
+// Renames a file or directory
+func (this *ChaosHdfsAccessor) Rename(oldPath string, newPath string) error {
+	return this.Impl.Rename(oldPath, newPath)
+}
+
+// Ensures HDFS accessor is connected to the HDFS name node
+func (this *ChaosHdfsAccessor) EnsureConnected() error {
+	return this.Impl.EnsureConnected()
+}
+
+// Changes the owner and group of the file
+func (this *ChaosHdfsAccessor) Chown(path string, owner, group string) error {
+	return this.Impl.Chown(path, owner, group)
+}
+
+// Changes the access and modification times of the file
+func (this *ChaosHdfsAccessor) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	return this.Impl.Chtimes(path, atime, mtime)
+}
+
+// Changes the mode of the file
+func (this *ChaosHdfsAccessor) Chmod(path string, mode os.FileMode) error {
+	return this.Impl.Chmod(path, mode)
+}
+
+// Changes the mode of the directory tree
+func (this *ChaosHdfsAccessor) ChmodRecursive(path string, mode os.FileMode) error {
+	return this.Impl.ChmodRecursive(path, mode)
+}
+
+// Changes the owner and group of the directory tree
+func (this *ChaosHdfsAccessor) ChownRecursive(path string, owner, group string) error {
+	return this.Impl.ChownRecursive(path, owner, group)
+}
+
+// Retrieves checksum of the file
+func (this *ChaosHdfsAccessor) GetChecksum(path string) ([]byte, error) {
+	return this.Impl.GetChecksum(path)
+}
+
+// Retrieves ACL entries of the file/directory
+func (this *ChaosHdfsAccessor) GetAcl(path string) ([]AclEntry, error) {
+	return this.Impl.GetAcl(path)
+}
+
+// Triggers lease recovery of the file
+func (this *ChaosHdfsAccessor) RecoverLease(path string) (bool, error) {
+	return this.Impl.RecoverLease(path)
+}
+
+// Retrieves layout of the file blocks
+func (this *ChaosHdfsAccessor) GetBlockLocations(path string) ([]BlockLocation, error) {
+	return this.Impl.GetBlockLocations(path)
+}
+
+// Creates a symbolic link
+func (this *ChaosHdfsAccessor) CreateSymlink(target string, path string) error {
+	return this.Impl.CreateSymlink(target, path)
+}
+
+// Lists changes of the snapshottable directory between two snapshots
+func (this *ChaosHdfsAccessor) SnapshotDiff(path, from, to string) ([]DiffEntry, error) {
+	return this.Impl.SnapshotDiff(path, from, to)
+}
+
+// Closes current meta connection if needed
+func (this *ChaosHdfsAccessor) Close() error {
+	return this.Impl.Close()
+}
+
+// Reader injecting errors into reads
+type ChaosReader struct {
+	Impl     ReadSeekCloser
+	Path     string
+	Accessor *ChaosHdfsAccessor
+}
+
+var _ ReadSeekCloser = (*ChaosReader)(nil) // ensure ChaosReader implements ReadSeekCloser
+
+// Reads a chunk of data
+func (this *ChaosReader) Read(buffer []byte) (int, error) {
+	if err := this.Accessor.inject("read", this.Path); err != nil {
+		return 0, err
+	}
+	return this.Impl.Read(buffer)
+}
+
+// Seeks to a given position
+func (this *ChaosReader) Seek(pos int64) error {
+	return this.Impl.Seek(pos)
+}
+
+// Returns current position
+func (this *ChaosReader) Position() (int64, error) {
+	return this.Impl.Position()
+}
+
+// Closes the stream
+func (this *ChaosReader) Close() error {
+	return this.Impl.Close()
+}
+
+// Writer injecting errors into writes
+type ChaosWriter struct {
+	Impl     HdfsWriter
+	Path     string
+	Accessor *ChaosHdfsAccessor
+}
+
+var _ HdfsWriter = (*ChaosWriter)(nil) // ensure ChaosWriter implements HdfsWriter
+
+// Writes chunk of data
+func (this *ChaosWriter) Write(buffer []byte) (int, error) {
+	if err := this.Accessor.inject("write", this.Path); err != nil {
+		return 0, err
+	}
+	return this.Impl.Write(buffer)
+}
+
+// Seeks to a given position
+func (this *ChaosWriter) Seek(pos int64) error {
+	return this.Impl.Seek(pos)
+}
+
+// Flushes all the data
+func (this *ChaosWriter) Flush() error {
+	return this.Impl.Flush()
+}
+
+// Closes the stream
+func (this *ChaosWriter) Close() error {
+	return this.Impl.Close()
+}
+
+// Truncates the HDFS file
+func (this *ChaosWriter) Truncate() error {
+	return this.Impl.Truncate()
+}
@@ -0,0 +1,77 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// Asserts that the error is one of the injected errors
+func assertInjectedError(t *testing.T, err error) {
+	pathError, ok := err.(*os.PathError)
+	if assert.True(t, ok) {
+		assert.Contains(t, ChaosErrors, pathError.Err)
+	}
+}
+
+// With 100% probability all the reads, writes and stats fail with injected errors
+func TestChaosInjectsErrors(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	chaosAccessor := NewChaosHdfsAccessor(hdfsAccessor, 1)
+	fs, _ := NewFileSystem(chaosAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+
+	// No calls are expected on the backend
+	_, err := root.(*Dir).Lookup(nil, "foo")
+	assertInjectedError(t, err)
+	_, err = root.(*Dir).ReadDirAll(nil)
+	assertInjectedError(t, err)
+	_, err = chaosAccessor.OpenRead("/foo")
+	assertInjectedError(t, err)
+	_, err = chaosAccessor.CreateFile("/foo", 0644)
+	assertInjectedError(t, err)
+
+	// Errors are injected into the streams opened before
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	chaosAccessor.Probability = 0
+	hdfsAccessor.EXPECT().OpenRead("/foo").Return(hdfsReader, nil)
+	hdfsAccessor.EXPECT().CreateFile("/bar", os.FileMode(0644)).Return(hdfsWriter, nil)
+	reader, err := chaosAccessor.OpenRead("/foo")
+	assert.Nil(t, err)
+	writer, err := chaosAccessor.CreateFile("/bar", 0644)
+	assert.Nil(t, err)
+	chaosAccessor.Probability = 1
+	_, err = reader.Read(make([]byte, 10))
+	assertInjectedError(t, err)
+	_, err = writer.Write([]byte("data"))
+	assertInjectedError(t, err)
+}
+
+// With 0% probability operations behave normally
+func TestChaosDisabled(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	chaosAccessor := NewChaosHdfsAccessor(hdfsAccessor, 0)
+	fs, _ := NewFileSystem(chaosAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: 0644}, nil)
+	_, err := root.(*Dir).Lookup(nil, "foo")
+	assert.Nil(t, err)
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/foo").Return(hdfsReader, nil)
+	reader, err := chaosAccessor.OpenRead("/foo")
+	assert.Nil(t, err)
+	hdfsReader.EXPECT().Read(gomock.Any()).Return(10, nil)
+	nr, err := reader.Read(make([]byte, 10))
+	assert.Nil(t, err)
+	assert.Equal(t, 10, nr)
+}
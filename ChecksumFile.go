@@ -0,0 +1,92 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+	"strings"
+	"syscall"
+)
+
+// Styles of the checksum sidecar names (see FileSystem.ChecksumSidecar)
+const (
+	ChecksumSidecarVisible = "visible" // checksum of 'foo' is exposed as 'foo.crc'
+	ChecksumSidecarHidden  = "hidden"  // checksum of 'foo' is exposed as '.foo.crc' (Hadoop style)
+)
+
+// Virtual read-only file whose content is HDFS checksum of the file next to it: raw bytes of the MD5 of the MD5s
+// of the block CRCs, as returned by HDFS getFileChecksum. Only the name follows Hadoop's convention,
+// the content isn't in the format of the .crc files of Hadoop's local (ChecksumFileSystem) files
+type ChecksumFile struct {
+	File *File // File, checksum of which is reported
+}
+
+// Verify that *ChecksumFile implements necesary FUSE interfaces
+var _ fs.Node = (*ChecksumFile)(nil)
+var _ fs.NodeOpener = (*ChecksumFile)(nil)
+var _ fs.HandleReadAller = (*ChecksumFile)(nil)
+
+// Returns name of the checksum sidecar for a given file name
+// ("" if sidecars aren't exposed, or the file itself is named as a sidecar)
+func (this *FileSystem) ChecksumSidecarName(name string) string {
+	if _, isSidecar := this.ChecksumSidecarTarget(name); isSidecar {
+		return ""
+	}
+	switch this.ChecksumSidecar {
+	case ChecksumSidecarVisible:
+		return name + ".crc"
+	case ChecksumSidecarHidden:
+		return "." + name + ".crc"
+	}
+	return ""
+}
+
+// Returns name of the file, checksum of which is exposed by the sidecar with a given name.
+// Returns false if the name isn't a name of the sidecar
+func (this *FileSystem) ChecksumSidecarTarget(name string) (string, bool) {
+	if !strings.HasSuffix(name, ".crc") {
+		return "", false
+	}
+	target := strings.TrimSuffix(name, ".crc")
+	if this.ChecksumSidecar == ChecksumSidecarHidden {
+		if !strings.HasPrefix(target, ".") {
+			return "", false
+		}
+		target = target[1:]
+	} else if this.ChecksumSidecar != ChecksumSidecarVisible {
+		return "", false
+	}
+	return target, target != ""
+}
+
+// Responds on FUSE Attr request to retrieve file attributes.
+// Size is reported as zero since the content is retrieved on open (as in procfs)
+func (this *ChecksumFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = 0444
+	a.Uid = this.File.Attrs.Uid
+	a.Gid = this.File.Attrs.Gid
+	a.Mtime = this.File.Attrs.Mtime
+	return nil
+}
+
+// Responds on FUSE Open request, content is served bypassing the page cache
+func (this *ChecksumFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if !req.Flags.IsReadOnly() {
+		return nil, fuse.Errno(syscall.EACCES)
+	}
+	resp.Flags |= fuse.OpenDirectIO
+	return this, nil
+}
+
+// Responds on FUSE Read request by returning checksum bytes of the file
+func (this *ChecksumFile) ReadAll(ctx context.Context) ([]byte, error) {
+	absolutePath := this.File.AbsolutePath()
+	checksum, err := this.File.FileSystem.HdfsAccessor.GetChecksum(absolutePath)
+	if err != nil {
+		Warning.Println("[", absolutePath, "] GetChecksum:", err)
+		return nil, err
+	}
+	return checksum, nil
+}
@@ -0,0 +1,77 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Measures skew between the local clock and the clock of HDFS name node.
+// This is done by creating an empty probe file and comparing its HDFS modification time to the local time.
+// Name of the probe file is made unique (so probes of concurrent mounts or the ones left behind don't collide),
+// the probe is removed once it has been created, even if measurement fails.
+// Returned value is positive if HDFS clock is ahead of the local clock
+func MeasureClockSkew(hdfsAccessor HdfsAccessor, clock Clock, probePath string) (time.Duration, error) {
+	probePath = fmt.Sprintf("%s.%d.%d", probePath, os.Getpid(), clock.Now().UnixNano())
+	before := clock.Now()
+	w, err := hdfsAccessor.CreateFile(probePath, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer hdfsAccessor.Remove(probePath)
+	err = w.Close()
+	if err != nil {
+		return 0, err
+	}
+	after := clock.Now()
+	attrs, err := hdfsAccessor.Stat(probePath)
+	if err != nil {
+		return 0, err
+	}
+	// Local time at which the file was created is estimated as a midpoint of the create operation.
+	// HDFS timestamps have millisecond precision, so skew is rounded to the millisecond
+	localTime := before.Add(after.Sub(before) / 2)
+	return attrs.Mtime.Sub(localTime).Round(time.Millisecond), nil
+}
+
+// Measures clock skew of the clusters mounted as subdirectories (see MultiClusterHdfsAccessor). Each of them is probed
+// in its own namespace, by prefixing the probe path with the cluster name. Single skew is applied to all the clusters,
+// so the one of the first cluster is returned, while differing skews of the other clusters are only reported
+func MeasureClustersClockSkew(hdfsAccessor HdfsAccessor, clock Clock, probePath string, clusterNames []string) (time.Duration, error) {
+	var skew time.Duration
+	for i, name := range clusterNames {
+		clusterSkew, err := MeasureClockSkew(hdfsAccessor, clock, "/"+name+"/"+strings.TrimPrefix(probePath, "/"))
+		if err != nil {
+			return 0, err
+		}
+		if i == 0 {
+			skew = clusterSkew
+		} else if difference := clusterSkew - skew; difference > time.Second || difference < -time.Second {
+			Warning.Println("Clock skew of cluster", name, "(", clusterSkew, ") differs from the one applied to all clusters (", skew, ")")
+		}
+	}
+	return skew, nil
+}
+
+// Converts timestamps reported by HDFS into local time frame using measured clock skew
+func (this *FileSystem) ApplyClockSkew(attrs *Attrs) {
+	if this.ClockSkew == 0 {
+		return
+	}
+	for _, t := range []*time.Time{&attrs.Mtime, &attrs.Atime, &attrs.Ctime, &attrs.Crtime} {
+		if !t.IsZero() {
+			*t = t.Add(-this.ClockSkew)
+		}
+	}
+}
+
+// Converts local timestamp into HDFS time frame using measured clock skew (e.g. for explicitly set modification time)
+func (this *FileSystem) HdfsTime(t time.Time) time.Time {
+	if this.ClockSkew == 0 || t.IsZero() {
+		return t
+	}
+	return t.Add(this.ClockSkew)
+}
@@ -0,0 +1,90 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"errors"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// Testing that clock skew is measured at startup and applied to HDFS timestamps, while TTL is computed using local time
+func TestClockSkew(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	mockClock.NotifyTimeElapsed(1000 * time.Hour)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+
+	// HDFS clock is one hour ahead of local clock
+	hdfsNow := mockClock.Now().Add(time.Hour)
+	probe := fmt.Sprintf("/tmp/probe.%d.%d", os.Getpid(), mockClock.Now().UnixNano())
+	hdfsAccessor.EXPECT().CreateFile(probe, os.FileMode(0600)).Return(hdfsWriter, nil)
+	hdfsWriter.EXPECT().Close().Return(nil)
+	hdfsAccessor.EXPECT().Stat(probe).Return(Attrs{Name: "probe", Mtime: hdfsNow}, nil)
+	hdfsAccessor.EXPECT().Remove(probe).Return(nil)
+	skew, err := MeasureClockSkew(hdfsAccessor, mockClock, "/tmp/probe")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Hour, skew)
+
+	// Probe file is removed even if closing it fails
+	failure := errors.New("close failed")
+	hdfsAccessor.EXPECT().CreateFile(probe, os.FileMode(0600)).Return(hdfsWriter, nil)
+	hdfsWriter.EXPECT().Close().Return(failure)
+	hdfsAccessor.EXPECT().Remove(probe).Return(nil)
+	_, err = MeasureClockSkew(hdfsAccessor, mockClock, "/tmp/probe")
+	assert.Equal(t, failure, err)
+
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.ClockSkew = skew
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: 0644, Mtime: hdfsNow}, nil)
+	file, err := root.(*Dir).Lookup(nil, "foo")
+	assert.Nil(t, err)
+	var attr fuse.Attr
+	assert.Nil(t, file.Attr(nil, &attr))
+	// Modification time is reported in the local time frame
+	assert.Equal(t, mockClock.Now(), attr.Mtime)
+	// Cached attributes expire according to local clock
+	assert.Equal(t, mockClock.Now().Add(5*time.Second), file.(*File).Attrs.Expires)
+	mockClock.NotifyTimeElapsed(6 * time.Second)
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: 0644, Mtime: hdfsNow}, nil)
+	assert.Nil(t, file.Attr(nil, &attr))
+
+	// Explicitly set modification time is converted into HDFS time frame
+	mtime := mockClock.Now().Add(-24 * time.Hour)
+	hdfsAccessor.EXPECT().Chtimes("/foo", time.Time{}, mtime.Add(time.Hour)).Return(nil)
+	assert.Nil(t, file.(*File).Setattr(nil, &fuse.SetattrRequest{Valid: fuse.SetattrMtime, Mtime: mtime}, &fuse.SetattrResponse{}))
+	assert.Equal(t, mtime, file.(*File).Attrs.Mtime)
+}
+
+// Testing that with multiple clusters mounted, each of them is probed within its own namespace
+func TestClockSkewOfClusters(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	mockClock.NotifyTimeElapsed(1000 * time.Hour)
+	clusterA := NewMockHdfsAccessor(mockCtrl)
+	clusterB := NewMockHdfsAccessor(mockCtrl)
+	hdfsAccessor := NewMultiClusterHdfsAccessor(map[string]HdfsAccessor{"a": clusterA, "b": clusterB}, mockClock)
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+
+	// Skew is measured with millisecond precision
+	hdfsNow := mockClock.Now().Add(1500 * time.Millisecond)
+	probe := fmt.Sprintf("/tmp/probe.%d.%d", os.Getpid(), mockClock.Now().UnixNano())
+	for _, cluster := range []*MockHdfsAccessor{clusterA, clusterB} {
+		cluster.EXPECT().CreateFile(probe, os.FileMode(0600)).Return(hdfsWriter, nil)
+		cluster.EXPECT().Stat(probe).Return(Attrs{Name: "probe", Mtime: hdfsNow}, nil)
+		cluster.EXPECT().Remove(probe).Return(nil)
+	}
+	hdfsWriter.EXPECT().Close().Return(nil).Times(2)
+	skew, err := MeasureClustersClockSkew(hdfsAccessor, mockClock, "/tmp/probe", hdfsAccessor.clusterNames())
+	assert.Nil(t, err)
+	assert.Equal(t, 1500*time.Millisecond, skew)
+}
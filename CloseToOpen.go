@@ -0,0 +1,23 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+// Revalidates attributes of the file with HDFS on open (with CloseToOpen), so the handle sees complete content
+// of the file as of the last close by any writer. Content kept for the previous version of the file
+// (prefetched beginning, idle backend reader) is discarded, buffers of other handles are invalidated
+func (this *File) RevalidateOnOpen() error {
+	if !this.FileSystem.CloseToOpen || this.IsCreateDeferred() {
+		return nil
+	}
+	version := this.Attrs.ContentVersion()
+	if err := this.Parent.LookupAttrs(this.Attrs.Name, &this.Attrs); err != nil {
+		return err
+	}
+	if version != this.Attrs.ContentVersion() {
+		Info.Println("[", this.AbsolutePath(), "] Content has changed since it was cached, discarding cached content")
+		this.DiscardPrefetched()
+		this.DropIdleReader()
+		this.CheckContentVersion(version)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Notified when a file has been successfully written and closed via the mount (see FileSystem.CommitHook),
+// letting downstream systems start processing the file as soon as it lands
+type CommitHook interface {
+	FileClosed(path string, size uint64) error
+}
+
+// Maximum time a single commit hook invocation may take
+var CommitHookTimeout time.Duration = 30 * time.Second
+
+// Commit hook running an executable with path and size of the file as arguments
+type ExecCommitHook struct {
+	Command string // Path to the executable
+}
+
+// Commit hook posting JSON {"path": ..., "size": ...} to an HTTP endpoint
+type HttpCommitHook struct {
+	Url    string       // URL of the endpoint
+	Client *http.Client // HTTP client used for the callbacks
+}
+
+// Verify that both hooks implement CommitHook interface
+var _ CommitHook = (*ExecCommitHook)(nil)
+var _ CommitHook = (*HttpCommitHook)(nil)
+
+// Creates commit hook from its spec: http(s) URL of the callback, or path to the executable
+func NewCommitHook(spec string) CommitHook {
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		return &HttpCommitHook{Url: spec, Client: &http.Client{Timeout: CommitHookTimeout}}
+	}
+	return &ExecCommitHook{Command: spec}
+}
+
+// Runs the executable, failing if it exits with non-zero status
+func (this *ExecCommitHook) FileClosed(path string, size uint64) error {
+	// Executable is killed together with its children once the timeout elapses
+	ctx, cancel := context.WithTimeout(context.Background(), CommitHookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, this.Command, path, strconv.FormatUint(size, 10))
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// Not waiting for the output of orphaned descendants which have left their process group
+	cmd.WaitDelay = time.Second
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s (output: %q)", this.Command, err, output)
+	}
+	return nil
+}
+
+// Posts the notification, failing unless the endpoint responds with 2xx status
+func (this *HttpCommitHook) FileClosed(path string, size uint64) error {
+	body, err := json.Marshal(struct {
+		Path string `json:"path"`
+		Size uint64 `json:"size"`
+	}{path, size})
+	if err != nil {
+		return err
+	}
+	resp, err := this.Client.Post(this.Url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s responded with %s", this.Url, resp.Status)
+	}
+	return nil
+}
+
+// Invokes commit hook for the file which has been written and closed (if the hook is configured).
+// The hook runs in the background, so it doesn't block release of the handle, and its failures are only logged
+func (this *FileSystem) NotifyFileClosed(path string, size uint64) {
+	hook := this.CommitHook
+	if hook == nil {
+		return
+	}
+	go func() {
+		if err := hook.FileClosed(path, size); err != nil {
+			Error.Println("[", path, "] Commit hook failed:", err)
+		} else {
+			Info.Println("[", path, "] Commit hook notified, size", size)
+		}
+	}()
+}
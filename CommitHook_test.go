@@ -0,0 +1,97 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// Commit hook recording the notifications
+type recordingCommitHook struct {
+	notifications chan string
+}
+
+func (this *recordingCommitHook) FileClosed(path string, size uint64) error {
+	this.notifications <- fmt.Sprintf("%s:%d", path, size)
+	return nil
+}
+
+// Commit hook fires with path and size of the file once its write handle is released
+func TestCommitHookFiresOnRelease(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.StreamingWriteBuffer = 1024
+	hook := &recordingCommitHook{notifications: make(chan string, 1)}
+	fs.CommitHook = hook
+	root, _ := fs.Root()
+
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Remove("/new.txt").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/new.txt", os.FileMode(0644)).Return(hdfsWriter, nil)
+	_, h, err := root.(*Dir).Create(nil, &fuse.CreateRequest{Name: "new.txt", Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: 100, remaining: 100}, nil)
+	hdfsWriter.EXPECT().Write([]byte("hello")).Return(5, nil)
+	assert.Nil(t, h.(*FileHandle).Write(nil, &fuse.WriteRequest{Data: []byte("hello")}, &fuse.WriteResponse{}))
+	select {
+	case path := <-hook.notifications:
+		t.Fatal("Commit hook fired before release:", path)
+	default:
+	}
+
+	hdfsWriter.EXPECT().Close().Return(nil)
+	assert.Nil(t, h.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+	select {
+	case notification := <-hook.notifications:
+		assert.Equal(t, "/new.txt:5", notification)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Commit hook hasn't fired")
+	}
+}
+
+// HTTP commit hook posts path and size as JSON
+func TestHttpCommitHook(t *testing.T) {
+	var received struct {
+		Path string `json:"path"`
+		Size uint64 `json:"size"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+	hook := NewCommitHook(server.URL)
+	assert.Nil(t, hook.FileClosed("/foo/bar.csv", 42))
+	assert.Equal(t, "/foo/bar.csv", received.Path)
+	assert.Equal(t, uint64(42), received.Size)
+}
+
+// Executable commit hook is killed once it exceeds CommitHookTimeout
+func TestExecCommitHookTimeout(t *testing.T) {
+	script, err := ioutil.TempFile("", "hook")
+	assert.Nil(t, err)
+	defer os.Remove(script.Name())
+	script.WriteString("#!/bin/sh\nsleep 10\n")
+	script.Close()
+	os.Chmod(script.Name(), 0700)
+
+	CommitHookTimeout = 100 * time.Millisecond
+	defer func() { CommitHookTimeout = 30 * time.Second }()
+	hook := NewCommitHook(script.Name())
+	start := time.Now()
+	assert.NotNil(t, hook.FileClosed("/foo/bar.csv", 42))
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
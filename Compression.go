@@ -0,0 +1,91 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+)
+
+// Extension of the files which are gzip-compressed on write (with CompressOnWrite enabled)
+const GzipExtension = ".gz"
+
+// Returns true if the content written to the file is compressed before going to HDFS
+func (this *File) IsCompressedOnWrite() bool {
+	return this.FileSystem.CompressOnWrite && strings.HasSuffix(this.Attrs.Name, GzipExtension)
+}
+
+// Records sizes of the content compressed on write (as stored in HDFS, and as written by the application)
+func (this *File) RecordCompressedSize(compressedSize uint64, uncompressedSize uint64) {
+	this.activeHandlesMutex.Lock()
+	defer this.activeHandlesMutex.Unlock()
+	this.compressedSize = compressedSize
+	this.uncompressedSize = uncompressedSize
+}
+
+// Returns sizes of the content last compressed on write (zeros if nothing has been written)
+func (this *File) CompressedSize() (uint64, uint64) {
+	this.activeHandlesMutex.Lock()
+	defer this.activeHandlesMutex.Unlock()
+	return this.compressedSize, this.uncompressedSize
+}
+
+// Reports size of the uncompressed content written by the application (with UncompressedSize enabled),
+// as long as HDFS holds the content compressed through this mount
+func (this *File) ApplyUncompressedSize(a *fuse.Attr) {
+	if !this.FileSystem.UncompressedSize {
+		return
+	}
+	compressedSize, uncompressedSize := this.CompressedSize()
+	if compressedSize > 0 && a.Size == compressedSize {
+		a.Size = uncompressedSize
+	}
+}
+
+// Returns reader decompressing gzip content (empty content is treated as empty stream)
+func NewGunzipReader(reader io.Reader) (io.Reader, error) {
+	gzipReader, err := gzip.NewReader(reader)
+	if err == io.EOF {
+		return bytes.NewReader(nil), nil
+	}
+	return gzipReader, err
+}
+
+// Writer counting the bytes passing through it
+type countingWriter struct {
+	Impl  io.Writer // Underlying writer
+	Count uint64    // Number of bytes written so far
+}
+
+// Writes data to the underlying writer
+func (this *countingWriter) Write(p []byte) (int, error) {
+	n, err := this.Impl.Write(p)
+	this.Count += uint64(n)
+	return n, err
+}
+
+// Compresses content of the staging file into the HDFS writer and closes it
+func (this *FileHandleWriter) FlushCompressed(w HdfsWriter) error {
+	path := this.Handle.File.AbsolutePath()
+	compressed := &countingWriter{Impl: w}
+	gzipWriter := gzip.NewWriter(compressed)
+	uncompressedSize, err := io.Copy(gzipWriter, this.stagingFile)
+	if err == nil {
+		err = gzipWriter.Close()
+	}
+	if err != nil {
+		Error.Println("Writing", path, ":", err)
+		w.Close()
+		return err
+	}
+	if err = w.Close(); err != nil {
+		Error.Println("Closing", path, ":", err)
+		return err
+	}
+	Info.Println("[", path, "] Compressed", uncompressedSize, "bytes to", compressed.Count)
+	this.Handle.File.RecordCompressedSize(compressed.Count, uint64(uncompressedSize))
+	return nil
+}
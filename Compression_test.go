@@ -0,0 +1,61 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bytes"
+	"compress/gzip"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Plain data written to *.gz file is stored gzip-compressed in HDFS
+func TestCompressOnWrite(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.CompressOnWrite = true
+	root, _ := fs.Root()
+	// Pre-closed channel lets slowHdfsWriter accept all the writes immediately
+	proceed := make(chan struct{})
+	close(proceed)
+
+	hdfsAccessor.EXPECT().Remove("/data.gz").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/data.gz", os.FileMode(0644)).Return(&slowHdfsWriter{proceed: proceed}, nil)
+	_, h, err := root.(*Dir).Create(nil, &fuse.CreateRequest{Name: "data.gz", Mode: 0644}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	handle := h.(*FileHandle)
+
+	plainText := []byte(strings.Repeat("Hello compressed world! ", 1000))
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: 1 << 30, remaining: 1 << 30}, nil)
+	assert.Nil(t, handle.Writer.Write(handle, nil, &fuse.WriteRequest{Data: plainText}, &fuse.WriteResponse{}))
+
+	stored := &slowHdfsWriter{proceed: proceed}
+	hdfsAccessor.EXPECT().Remove("/data.gz").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/data.gz", os.FileMode(0644)).Return(stored, nil)
+	assert.Nil(t, handle.Writer.Flush())
+
+	// HDFS holds gzip stream, which decompresses to the written data
+	assert.True(t, len(stored.written) < len(plainText))
+	gzipReader, err := gzip.NewReader(bytes.NewReader(stored.written))
+	assert.Nil(t, err)
+	decompressed, err := ioutil.ReadAll(gzipReader)
+	assert.Nil(t, err)
+	assert.Equal(t, plainText, decompressed)
+
+	// Size is reported as stored in HDFS, or as written by the application
+	var attr fuse.Attr
+	hdfsAccessor.EXPECT().Stat("/data.gz").Return(Attrs{Name: "data.gz", Mode: 0644, Size: uint64(len(stored.written))}, nil)
+	assert.Nil(t, handle.File.Attr(nil, &attr))
+	assert.Equal(t, uint64(len(stored.written)), attr.Size)
+	fs.UncompressedSize = true
+	assert.Nil(t, handle.File.Attr(nil, &attr))
+	assert.Equal(t, uint64(len(plainText)), attr.Size)
+}
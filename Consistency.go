@@ -0,0 +1,59 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"golang.org/x/net/context"
+	"strings"
+	"syscall"
+)
+
+// Read consistency levels of the file handles
+const (
+	ConsistencyCached = "cached" // metadata and content are served from caches (default)
+	ConsistencyFresh  = "fresh"  // all caching is bypassed, every request hits the backend
+)
+
+// Extended attribute setting consistency level of the handles subsequently opened on the file
+// (e.g. setfattr -n user.hdfs-mount.consistency -v fresh file)
+const XattrConsistency = "user.hdfs-mount.consistency"
+
+// Responds on FUSE Setxattr request
+func (this *File) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	if req.Name != XattrConsistency {
+		return fuse.Errno(syscall.ENOTSUP)
+	}
+	value := strings.TrimRight(string(req.Xattr), "\x00\n")
+	if value != ConsistencyCached && value != ConsistencyFresh {
+		Error.Println("[", this.AbsolutePath(), "] invalid consistency level", value)
+		return fuse.Errno(syscall.EINVAL)
+	}
+	Info.Println("[", this.AbsolutePath(), "] consistency level:", value)
+	this.activeHandlesMutex.Lock()
+	defer this.activeHandlesMutex.Unlock()
+	this.consistency = value
+	return nil
+}
+
+// Returns true if the handle opened with given flags uses fresh consistency level
+func (this *File) IsFreshOpen(flags fuse.OpenFlags) bool {
+	this.activeHandlesMutex.Lock()
+	defer this.activeHandlesMutex.Unlock()
+	if this.consistency != "" {
+		return this.consistency == ConsistencyFresh
+	}
+	return this.FileSystem.OpenFlagModes.Has(flags, OpenModeFresh)
+}
+
+// Returns true if the file has opened handles with fresh consistency level
+func (this *File) HasFreshHandles() bool {
+	this.activeHandlesMutex.Lock()
+	defer this.activeHandlesMutex.Unlock()
+	for _, handle := range this.activeHandles {
+		if handle.Fresh {
+			return true
+		}
+	}
+	return false
+}
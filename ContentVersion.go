@@ -0,0 +1,36 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"sync/atomic"
+)
+
+// Identifies version of the file content. Unlike TTL-based caching, comparing versions detects
+// overwrites which keep size and modification time (new file gets new inode).
+// Versions are compared with ==, so modification time is kept as Unix nanoseconds (time.Time values
+// denoting the same instant might differ in location or monotonic clock reading)
+type ContentVersion struct {
+	Inode uint64
+	Mtime int64
+	Size  uint64
+}
+
+// Returns version of the file content described by the attributes
+func (this *Attrs) ContentVersion() ContentVersion {
+	return ContentVersion{Inode: this.Inode, Mtime: this.Mtime.UnixNano(), Size: this.Size}
+}
+
+// Invalidates buffered content of the opened handles if the file content has changed
+// since the given version. Handles re-open backend reader on their next read
+// (fresh handles are skipped, they bypass content caches anyway)
+func (this *File) CheckContentVersion(previous ContentVersion) {
+	if previous == this.Attrs.ContentVersion() {
+		return
+	}
+	for _, handle := range this.GetActiveHandles() {
+		if !handle.Fresh && atomic.CompareAndSwapInt32(&handle.contentChanged, 0, 1) {
+			Info.Println("[", this.AbsolutePath(), "] Content version has changed, invalidating buffers of the handle")
+		}
+	}
+}
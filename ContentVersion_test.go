@@ -0,0 +1,53 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// Overwriting a file with the same size and modification time is detected on metadata refresh
+// and buffered content of the opened handle is discarded
+func TestOverwriteWithSameSizeAndMtimeInvalidatesBuffers(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fileSystem.Root()
+	mtime := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+	hdfsAccessor.EXPECT().Stat("/data.txt").Return(Attrs{Name: "data.txt", Mode: 0644, Size: 5, Inode: 1, Mtime: mtime}, nil)
+	node, _ := root.(*Dir).Lookup(nil, "data.txt")
+	file := node.(*File)
+
+	oldReader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/data.txt").Return(oldReader, nil)
+	h, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+	assert.Nil(t, err)
+	handle := h.(*FileHandle)
+	oldReader.whenReadReturn([]byte("Hello"), io.EOF)
+	handle.readAndVerify(t, 0, 5, []byte("Hello"))
+
+	// Same content is served from buffers while version is unchanged
+	handle.readAndVerify(t, 0, 5, []byte("Hello"))
+
+	// File is overwritten by another client: same size and mtime (reported in another location), but new inode
+	mockClock.NotifyTimeElapsed(6 * time.Second)
+	hdfsAccessor.EXPECT().Stat("/data.txt").Return(Attrs{Name: "data.txt", Mode: 0644, Size: 5, Inode: 2, Mtime: mtime.In(time.Local)}, nil)
+	var attr fuse.Attr
+	assert.Nil(t, file.Attr(nil, &attr))
+	assert.Equal(t, uint64(5), attr.Size)
+
+	// Next read discards buffered content and re-opens backend reader
+	newReader := NewMockReadSeekCloser(mockCtrl)
+	oldReader.EXPECT().Close().Return(nil)
+	hdfsAccessor.EXPECT().OpenRead("/data.txt").Return(newReader, nil)
+	newReader.whenReadReturn([]byte("World"), io.EOF)
+	handle.readAndVerify(t, 0, 5, []byte("World"))
+}
@@ -0,0 +1,57 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+)
+
+// Returns HDFS owner and group names for the local user (uid, gid).
+// Numeric ids are used if the user isn't known locally
+func OwnerNames(uid uint32, gid uint32) (string, string) {
+	u, err := user.LookupId(fmt.Sprint(uid))
+	if err != nil {
+		Error.Println("Chown: username for uid", uid, "not found, use uid/gid instead")
+		return fmt.Sprint(uid), fmt.Sprint(gid)
+	}
+	return u.Username, u.Username // hardcoded the group same as owner until LookupGroupId available
+}
+
+// Creates the file in HDFS owned by the user (uid, gid) who creates it via the mount (with CreateAsCaller).
+// HDFS client can't impersonate the user, so the file is created under a temporary name hidden from listings
+// (see MountTempPrefix), chowned and renamed into place, so it never appears with the mount's owner
+func (this *FileSystem) CreateFileAs(p string, mode os.FileMode, uid uint32, gid uint32) (HdfsWriter, error) {
+	if !this.CreateAsCaller {
+		return this.CreateFile(p, mode)
+	}
+	owner, group := OwnerNames(uid, gid)
+	tempPath := path.Join(path.Dir(p), MountTempPrefix+path.Base(p))
+	w, err := this.CreateFile(tempPath, mode)
+	if err != nil {
+		return nil, err
+	}
+	err = this.RunMutating("Chown", tempPath, func() error {
+		return this.HdfsAccessor.Chown(tempPath, owner, group)
+	})
+	if err == nil {
+		err = this.RunMutating("Rename", tempPath, func() error {
+			return this.HdfsAccessor.Rename(tempPath, p)
+		})
+	}
+	if err != nil {
+		Error.Println("[", p, "] Creating file owned by", owner, ":", group, ":", err)
+		w.Close()
+		this.HdfsAccessor.Remove(tempPath)
+		return nil, err
+	}
+	Info.Println("[", p, "] Created file owned by", owner, ":", group)
+	return w, nil
+}
+
+// Creates the file in HDFS on behalf of the user who has opened the handle
+func (this *FileHandleWriter) createFile(path string) (HdfsWriter, error) {
+	return this.Handle.File.FileSystem.CreateFileAs(path, this.Handle.File.Attrs.Mode, this.Handle.Uid, this.Handle.Gid)
+}
@@ -0,0 +1,49 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+// Admin states of HDFS data nodes (see DatanodeInfoProto.AdminState)
+const (
+	DatanodeNormal                 = "NORMAL"
+	DatanodeDecommissionInProgress = "DECOMMISSION_INPROGRESS"
+	DatanodeDecommissioned         = "DECOMMISSIONED"
+	DatanodeEnteringMaintenance    = "ENTERING_MAINTENANCE"
+	DatanodeInMaintenance          = "IN_MAINTENANCE"
+)
+
+// Returns true if the data node in the given admin state is expected to serve reads reliably
+// (nodes being decommissioned or in maintenance might go away at any time)
+func IsDatanodeInService(state string) bool {
+	return state == "" || state == DatanodeNormal
+}
+
+// Returns hosts of the block replicas in preferred order: in-service data nodes first
+// (in the order reported by the name node), then the ones in maintenance or being decommissioned
+func (this BlockLocation) PreferredHosts() []string {
+	preferred := make([]string, 0, len(this.Hosts))
+	var fallback []string
+	for i, host := range this.Hosts {
+		if i < len(this.States) && !IsDatanodeInService(this.States[i]) {
+			fallback = append(fallback, host)
+		} else {
+			preferred = append(preferred, host)
+		}
+	}
+	return append(preferred, fallback...)
+}
+
+// Selects data node to read the block covering the given offset from, avoiding nodes in maintenance
+// or being decommissioned as long as there is a healthy replica. Returns false if the block isn't known.
+// TODO: HDFS client picks data nodes on its own, selected replica has to be passed to it once it allows that
+func (this *FileHandleReader) SelectReplica(offset int64) (string, bool) {
+	for _, block := range this.Handle.BlockLocations() {
+		if offset < int64(block.Offset) || offset >= int64(block.Offset+block.Length) {
+			continue
+		}
+		if hosts := block.PreferredHosts(); len(hosts) > 0 {
+			return hosts[0], true
+		}
+		return "", false
+	}
+	return "", false
+}
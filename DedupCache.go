@@ -0,0 +1,131 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"container/list"
+	"io"
+	"sync"
+)
+
+// Content-addressed cache of HDFS blocks keyed by block checksum, so identical blocks
+// (e.g. zero-filled regions or templated data) are read from the backend and kept in memory once
+// across all the files
+type DedupCache struct {
+	MaxSize  uint64                   // Maximum total size of the cached blocks (in bytes)
+	size     uint64                   // Current total size of the cached blocks
+	lru      *list.List               // cached blocks, most recently used first
+	elements map[string]*list.Element // position of the block in lru list, keyed by checksum
+	mutex    sync.Mutex               // mutex for size, lru and elements
+}
+
+// Block of the content-addressed cache
+type dedupBlock struct {
+	Checksum string // Checksum of the block
+	Data     []byte // Content of the block
+}
+
+// Creates content-addressed cache holding at most maxSize bytes of block contents
+func NewDedupCache(maxSize uint64) *DedupCache {
+	return &DedupCache{
+		MaxSize:  maxSize,
+		lru:      list.New(),
+		elements: make(map[string]*list.Element)}
+}
+
+// Returns content of the block with a given checksum, if cached
+func (this *DedupCache) Get(checksum []byte) ([]byte, bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	element, ok := this.elements[string(checksum)]
+	if !ok {
+		return nil, false
+	}
+	this.lru.MoveToFront(element)
+	return element.Value.(*dedupBlock).Data, true
+}
+
+// Adds content of the block with a given checksum, evicting least recently used blocks if needed
+func (this *DedupCache) Add(checksum []byte, data []byte) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if uint64(len(data)) > this.MaxSize {
+		return
+	}
+	if _, ok := this.elements[string(checksum)]; ok {
+		return
+	}
+	this.elements[string(checksum)] = this.lru.PushFront(&dedupBlock{Checksum: string(checksum), Data: data})
+	this.size += uint64(len(data))
+	for this.size > this.MaxSize {
+		victim := this.lru.Remove(this.lru.Back()).(*dedupBlock)
+		delete(this.elements, victim.Checksum)
+		this.size -= uint64(len(victim.Data))
+	}
+}
+
+// Attempts to satisfy read request from the content-addressed cache (with DedupCache enabled),
+// reading the whole block from the backend on miss. Returns false if the request
+// can't be served this way (e.g. the block checksum is unknown or the request spans blocks)
+func (this *FileHandle) ReadDeduplicated(req *fuse.ReadRequest, resp *fuse.ReadResponse) bool {
+	cache := this.File.FileSystem.DedupCache
+	if cache == nil || this.Fresh || req.Offset < 0 || !this.File.UsesContentCache() {
+		return false
+	}
+	path := this.File.AbsolutePath()
+	offset := uint64(req.Offset)
+	for _, block := range this.BlockLocations() {
+		if offset < block.Offset || offset >= block.Offset+block.Length {
+			continue
+		}
+		if len(block.Checksum) == 0 || offset+uint64(req.Size) > block.Offset+block.Length || block.Length > cache.MaxSize {
+			return false
+		}
+		data, ok := cache.Get(block.Checksum)
+		if !ok {
+			var err error
+			if data, err = this.readBlock(path, block); err != nil {
+				Warning.Println("[", path, "] Reading block @", block.Offset, ":", err)
+				return false
+			}
+			cache.Add(block.Checksum, data)
+		}
+		resp.Data = resp.Data[:req.Size]
+		copy(resp.Data, data[offset-block.Offset:])
+		return true
+	}
+	return false
+}
+
+// Returns blocks of the file, retrieving them on first use (nil if they can't be retrieved)
+func (this *FileHandle) BlockLocations() []BlockLocation {
+	if !this.blocksFetched {
+		this.blocksFetched = true
+		path := this.File.AbsolutePath()
+		blocks, err := this.File.FileSystem.HdfsAccessor.GetBlockLocations(path)
+		if err != nil {
+			Warning.Println("[", path, "] GetBlockLocations:", err)
+		}
+		this.blocks = blocks
+	}
+	return this.blocks
+}
+
+// Reads whole block of the file from the backend
+func (this *FileHandle) readBlock(path string, block BlockLocation) ([]byte, error) {
+	reader, err := this.File.FileSystem.OpenReadForHandle(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	reader = this.File.LimitReads(reader)
+	if err = reader.Seek(int64(block.Offset)); err != nil {
+		return nil, err
+	}
+	data := make([]byte, block.Length)
+	if _, err = io.ReadFull(reader, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
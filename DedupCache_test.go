@@ -0,0 +1,109 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"testing"
+)
+
+// Identical block of the second file is served from the content-addressed cache populated by reading the first file
+func TestIdenticalBlocksReadOnce(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.DedupCache = NewDedupCache(1024)
+	root, _ := fileSystem.Root()
+	open := func(name string, blocks []BlockLocation) *FileHandle {
+		hdfsAccessor.EXPECT().Stat("/"+name).Return(Attrs{Name: name, Mode: 0644, Size: 8}, nil)
+		file, _ := root.(*Dir).Lookup(nil, name)
+		hdfsAccessor.EXPECT().OpenRead("/"+name).Return(NewMockReadSeekCloser(mockCtrl), nil)
+		hdfsAccessor.EXPECT().GetBlockLocations("/"+name).Return(blocks, nil)
+		h, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+		assert.Nil(t, err)
+		return h.(*FileHandle)
+	}
+	zeros := make([]byte, 4)
+
+	// First file: zero-filled block is read from the backend as a whole
+	handleA := open("a.dat", []BlockLocation{
+		{Offset: 0, Length: 4, Checksum: []byte("zeros")},
+		{Offset: 4, Length: 4, Checksum: []byte("a-tail")}})
+	blockReader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/a.dat").Return(blockReader, nil)
+	blockReader.expectSeek(0)
+	blockReader.whenReadReturn(zeros, nil)
+	blockReader.EXPECT().Close().Return(nil)
+	handleA.readAndVerify(t, 0, 4, zeros)
+
+	// Second file: identical block is served from the cache, without touching the backend
+	handleB := open("b.dat", []BlockLocation{
+		{Offset: 0, Length: 4, Checksum: []byte("b-head")},
+		{Offset: 4, Length: 4, Checksum: []byte("zeros")}})
+	handleB.readAndVerify(t, 4, 4, zeros)
+	handleB.readAndVerify(t, 6, 2, zeros[:2])
+}
+
+// With caching policy markers, only files in the directory marked as 'aggressive' use the content-addressed cache
+func TestCachePolicyMarkers(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.DedupCache = NewDedupCache(1024)
+	fileSystem.CachePolicyMarkers = true
+	root, _ := fileSystem.Root()
+	notExist := func(path string) error {
+		return &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	hdfsAccessor.EXPECT().OpenRead("/"+CachePolicyMarkerName).Return(nil, notExist("/"+CachePolicyMarkerName))
+	open := func(dir string, marker ReadSeekCloser) *FileHandle {
+		hdfsAccessor.EXPECT().Stat("/"+dir).Return(Attrs{Name: dir, Mode: os.ModeDir | 0755}, nil)
+		dirNode, _ := root.(*Dir).Lookup(nil, dir)
+		markerPath := "/" + dir + "/" + CachePolicyMarkerName
+		if marker != nil {
+			hdfsAccessor.EXPECT().OpenRead(markerPath).Return(marker, nil)
+		} else {
+			hdfsAccessor.EXPECT().OpenRead(markerPath).Return(nil, notExist(markerPath))
+		}
+		hdfsAccessor.EXPECT().Stat("/"+dir+"/data.dat").Return(Attrs{Name: "data.dat", Mode: 0644, Size: 4}, nil)
+		file, err := dirNode.(*Dir).Lookup(nil, "data.dat")
+		assert.Nil(t, err)
+		hdfsReader := NewMockReadSeekCloser(mockCtrl)
+		hdfsAccessor.EXPECT().OpenRead("/"+dir+"/data.dat").Return(hdfsReader, nil)
+		h, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+		assert.Nil(t, err)
+		return h.(*FileHandle)
+	}
+	data := []byte("ref!")
+
+	// Marked directory: block is read as a whole into the content cache
+	marker := NewMockReadSeekCloser(mockCtrl)
+	marker.whenReadReturn([]byte("aggressive\n"), nil)
+	marker.whenReadReturn(nil, io.EOF)
+	marker.EXPECT().Close().Return(nil)
+	hot := open("hot", marker)
+	assert.Equal(t, CachePolicyAggressive, hot.File.CachePolicy())
+	hdfsAccessor.EXPECT().GetBlockLocations("/hot/data.dat").Return([]BlockLocation{{Offset: 0, Length: 4, Checksum: []byte("ref")}}, nil)
+	blockReader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/hot/data.dat").Return(blockReader, nil)
+	blockReader.expectSeek(0)
+	blockReader.whenReadReturn(data, nil)
+	blockReader.EXPECT().Close().Return(nil)
+	hot.readAndVerify(t, 0, 4, data)
+	_, cached := fileSystem.DedupCache.Get([]byte("ref"))
+	assert.True(t, cached)
+
+	// Unmarked directory: the file is read through its own reader, even though its block is in the cache
+	cold := open("cold", nil)
+	assert.Equal(t, "", cold.File.CachePolicy())
+	cold.Reader.HdfsReader.(*MockReadSeekCloser).whenReadReturn(data, nil)
+	cold.readAndVerify(t, 0, 4, data)
+}
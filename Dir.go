@@ -5,13 +5,15 @@ package main
 import (
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
-	"fmt"
+	"bytes"
 	"golang.org/x/net/context"
+	"io"
 	"os"
-	"os/user"
 	"path"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -22,6 +24,16 @@ type Dir struct {
 	Parent       *Dir               // Pointer to the parent directory (allows computing fully-qualified paths on demand)
 	Entries      map[string]fs.Node // Cahed directory entries
 	EntriesMutex sync.Mutex         // Used to protect Entries
+
+	SubdirCount      uint32 // Number of subdirectories as observed by the last listing (used to report nlink), protected by EntriesMutex
+	SubdirCountKnown bool   // true if SubdirCount is known (directory has been listed), protected by EntriesMutex
+
+	listing         []string          // names of the entries in the order of the last listing (with SequentialDirPrefetch only), protected by EntriesMutex
+	prefetchedFile  *File             // file which has been prefetched as the next one in the listing, protected by EntriesMutex
+	caseCollisions  map[string]string // names presented for entries colliding case-insensitively by the last listing (see ResolveCaseCollisions), protected by EntriesMutex
+	cachePolicy     string            // caching policy set by the marker in the directory (see CachePolicy)
+	cachePolicyOnce sync.Once         // guards reading of the caching policy marker
+	namespaceLock   sync.RWMutex      // held for reading by backend lookups and for writing by renames in this directory (with RenameLocking)
 }
 
 // Verify that *Dir implements necesary FUSE interfaces
@@ -31,19 +43,37 @@ var _ fs.NodeStringLookuper = (*Dir)(nil)
 var _ fs.NodeMkdirer = (*Dir)(nil)
 var _ fs.NodeRemover = (*Dir)(nil)
 var _ fs.NodeRenamer = (*Dir)(nil)
+var _ fs.NodeSetxattrer = (*Dir)(nil)
+var _ fs.NodeOpener = (*Dir)(nil)
+var _ fs.NodeAccesser = (*Dir)(nil)
 
-// Returns absolute path of the dir in HDFS namespace
-func (this *Dir) AbsolutePath() string {
+// Extended attributes which trigger recursive permission changes when set on a directory
+// (e.g. setfattr -n user.hdfs-mount.chmod-recursive -v 0755 dir)
+const XattrChmodRecursive = "user.hdfs-mount.chmod-recursive"
+const XattrChownRecursive = "user.hdfs-mount.chown-recursive"
+
+// Returns path of the dir as presented to the clients of the mount point
+func (this *Dir) VirtualPath() string {
 	if this.Parent == nil {
 		return "/"
 	} else {
-		return path.Join(this.Parent.AbsolutePath(), this.Attrs.Name)
+		return path.Join(this.Parent.VirtualPath(), this.Attrs.Name)
 	}
 }
 
+// Returns absolute path of the dir in HDFS namespace
+func (this *Dir) AbsolutePath() string {
+	return this.FileSystem.PathRewriter.Rewrite(this.VirtualPath())
+}
+
 // Returns absolute path of the child item of this directory
 func (this *Dir) AbsolutePathForChild(name string) string {
-	path := this.AbsolutePath()
+	return this.FileSystem.PathRewriter.Rewrite(this.VirtualPathForChild(name))
+}
+
+// Returns path of the child item of this directory as presented to the clients of the mount point
+func (this *Dir) VirtualPathForChild(name string) string {
+	path := this.VirtualPath()
 	if path != "/" {
 		path = path + "/"
 	}
@@ -59,7 +89,19 @@ func (this *Dir) Attr(ctx context.Context, a *fuse.Attr) error {
 		}
 
 	}
-	return this.Attrs.Attr(a)
+	err := this.Attrs.Attr(a)
+	this.FileSystem.AttrOverrides.Apply(this.AbsolutePath(), a)
+	this.EntriesMutex.Lock()
+	subdirCount, subdirCountKnown := this.SubdirCount, this.SubdirCountKnown
+	this.EntriesMutex.Unlock()
+	if subdirCountKnown {
+		// Reporting 2 + number of subdirectories, which allows 'find' to optimize leaf directories traversal
+		a.Nlink = 2 + subdirCount
+	} else {
+		// nlink=1 tells the tools that subdirectory count is unknown
+		a.Nlink = 1
+	}
+	return err
 }
 
 func (this *Dir) EntriesGet(name string) fs.Node {
@@ -83,6 +125,21 @@ func (this *Dir) EntriesSet(name string, node fs.Node) {
 	this.Entries[name] = node
 }
 
+// Caches the node unless another node has been cached for the name meanwhile. Returns the cached node
+func (this *Dir) EntriesSetIfAbsent(name string, node fs.Node) fs.Node {
+	this.EntriesMutex.Lock()
+	defer this.EntriesMutex.Unlock()
+
+	if existing, ok := this.Entries[name]; ok {
+		return existing
+	}
+	if this.Entries == nil {
+		this.Entries = make(map[string]fs.Node)
+	}
+	this.Entries[name] = node
+	return node
+}
+
 func (this *Dir) EntriesRemove(name string) {
 	this.EntriesMutex.Lock()
 	defer this.EntriesMutex.Unlock()
@@ -91,20 +148,73 @@ func (this *Dir) EntriesRemove(name string) {
 	}
 }
 
+// Removes given node from the entries (entry which has been replaced by another node is kept)
+func (this *Dir) EntriesForget(node fs.Node) {
+	this.EntriesMutex.Lock()
+	defer this.EntriesMutex.Unlock()
+	if _, name := NodeParentAndName(node); this.Entries[name] == node {
+		delete(this.Entries, name)
+	}
+	if this.prefetchedFile != nil && this.prefetchedFile == node {
+		this.prefetchedFile.DiscardPrefetched()
+		this.prefetchedFile = nil
+	}
+}
+
 // Responds on FUSE request to lookup the directory
 func (this *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	name = this.FileSystem.HdfsName(name)
+	if tracer := this.FileSystem.Tracer; tracer != nil {
+		defer tracer.StartOperation("Lookup", this.AbsolutePathForChild(name), 0, 0).End()
+	}
+	if this.Parent == nil && this.FileSystem.ExposeConfig && name == MountInfoDirName {
+		// Synthesized, doesn't exist in HDFS
+		return &MountInfoDir{FileSystem: this.FileSystem}, nil
+	}
 	if !this.FileSystem.IsPathAllowed(this.AbsolutePathForChild(name)) {
 		return nil, fuse.ENOENT
 	}
+	name, visible := this.ResolveCaseCollision(name)
+	if !visible {
+		return nil, fuse.ENOENT
+	}
 
 	if node := this.EntriesGet(name); node != nil {
 		return node, nil
 	}
 
+	if this.FileSystem.ExposeQuotaFile && name == QuotaFileName {
+		return &QuotaFile{Dir: this}, nil
+	}
+
+	if this.FileSystem.ExposeSnapshotDiff && name == SnapshotDiffName {
+		return &SnapshotDiffDir{Dir: this}, nil
+	}
+
+	if target, ok := this.FileSystem.ChecksumSidecarTarget(name); ok {
+		// Real file with the same name (if any) takes precedence over the checksum sidecar
+		var attrs Attrs
+		err := this.LookupAttrs(name, &attrs)
+		if err == nil {
+			return this.NodeFromAttrs(attrs), nil
+		} else if err != fuse.ENOENT {
+			return nil, err
+		}
+		targetNode, err := this.Lookup(nil, this.FileSystem.PresentedName(target))
+		if err != nil {
+			return nil, err
+		}
+		targetFile, ok := targetNode.(*File)
+		if !ok {
+			return nil, fuse.ENOENT
+		}
+		return &ChecksumFile{File: targetFile}, nil
+	}
+
 	if this.FileSystem.ExpandZips && strings.HasSuffix(name, ".zip@") {
 		// looking up original zip file
 		zipFileName := name[:len(name)-1]
-		zipFileNode, err := this.Lookup(nil, zipFileName)
+		zipFileNode, err := this.Lookup(nil, this.FileSystem.PresentedName(zipFileName))
 		if err != nil {
 			return nil, err
 		}
@@ -119,67 +229,274 @@ func (this *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 		return NewZipRootDir(zipFile, attrs), nil
 	}
 
+	if absolutePath := this.AbsolutePathForChild(name); absolutePath != this.VirtualPathForChild(name) {
+		Info.Println("Lookup [", this.VirtualPathForChild(name), "] is aliased to [", absolutePath, "]")
+	}
+
+	return this.lookupBackend(name)
+}
+
+// Looks up the entry in HDFS and caches its node. With RenameLocking it doesn't overlap renames in this directory,
+// so it observes the entry either before or after the rename, and never caches a name which has been renamed away
+func (this *Dir) lookupBackend(name string) (fs.Node, error) {
+	if this.FileSystem.RenameLocking {
+		this.namespaceLock.RLock()
+		defer this.namespaceLock.RUnlock()
+		if node := this.EntriesGet(name); node != nil {
+			// Entry has been renamed into this directory while waiting for the lock
+			return node, nil
+		}
+	}
 	var attrs Attrs
 	err := this.LookupAttrs(name, &attrs)
 	if err != nil {
 		return nil, err
 	}
-	return this.NodeFromAttrs(attrs), nil
+	if this.FileSystem.CachePolicyMarkers && !attrs.Mode.IsDir() {
+		// Caching policy of the file is determined by the markers at lookup time
+		this.CachePolicy()
+	}
+	if !this.FileSystem.RenameLocking {
+		return this.NodeFromAttrs(attrs), nil
+	}
+	// Concurrent lookups of the same entry share the node cached by the first one
+	node := this.EntriesSetIfAbsent(attrs.Name, this.newNode(attrs))
+	if file, ok := node.(*File); ok {
+		this.FileSystem.AttrCache.Add(file)
+	}
+	return node, nil
+}
+
+// Responds on FUSE Access request (checks effective permission of the caller)
+func (this *Dir) Access(ctx context.Context, req *fuse.AccessRequest) error {
+	// Refreshing expired attributes
+	if err := this.Attr(ctx, &fuse.Attr{}); err != nil {
+		return err
+	}
+	return this.FileSystem.CheckAccessRequest(this.AbsolutePath(), &this.Attrs, req)
+}
+
+// Responds on FUSE Open request. Opening directory as a file (without O_DIRECTORY) fails with EISDIR,
+// unless DirListingOnRead is enabled, in which case reading it returns names of the entries
+func (this *Dir) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if req.Dir {
+		return this, nil
+	}
+	if !this.FileSystem.DirListingOnRead || !req.Flags.IsReadOnly() {
+		return nil, fuse.Errno(syscall.EISDIR)
+	}
+	entries, err := this.ReadDirAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var listing bytes.Buffer
+	for _, entry := range entries {
+		listing.WriteString(entry.Name)
+		listing.WriteString("\n")
+	}
+	resp.Flags |= fuse.OpenDirectIO
+	return &DirListingHandle{Listing: listing.Bytes()}, nil
+}
+
+// Handle of the directory opened as a file, reading it returns names of the directory entries
+type DirListingHandle struct {
+	Listing []byte // Snapshot of the entry names taken on open, one per line
+}
+
+var _ fs.HandleReadAller = (*DirListingHandle)(nil)
+
+// Responds on FUSE Read request
+func (this *DirListingHandle) ReadAll(ctx context.Context) ([]byte, error) {
+	return this.Listing, nil
+}
+
+// Lists directory page by page (see FileSystem.ReadDirPageSize). Each failed page is retried
+// according to the retry policy, if it still fails, entries listed so far are returned with a warning.
+// Directory deleted while being listed fails the listing with ESTALE (with StaleVanishedDirs enabled)
+func (this *Dir) ReadDirPaged(absolutePath string) ([]Attrs, error) {
+	reader, err := this.FileSystem.HdfsAccessor.OpenDir(absolutePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	var allAttrs []Attrs
+	for {
+		var page []Attrs
+		vanished := false
+		op := this.FileSystem.RetryPolicy.StartOperation()
+		for {
+			page, err = reader.ReadDirPage(this.FileSystem.ReadDirPageSize)
+			pathError, ok := err.(*os.PathError)
+			vanished = ok && pathError.Err == os.ErrNotExist
+			if err == nil || err == io.EOF || vanished || !op.ShouldRetry("[%s] ReadDirPage: %s", absolutePath, err) {
+				break
+			}
+		}
+		if vanished && this.FileSystem.StaleVanishedDirs {
+			Warning.Println("ls [", absolutePath, "]: directory has disappeared after", len(allAttrs), "entries were listed")
+			this.Attrs.Expires = this.FileSystem.Clock.Now().Add(-1 * time.Second)
+			return nil, fuse.Errno(syscall.ESTALE)
+		}
+		if err != nil && err != io.EOF {
+			if len(allAttrs) == 0 {
+				return nil, err
+			}
+			Warning.Println("ls [", absolutePath, "]: listing failed after", len(allAttrs), "entries, returning partial listing:", err)
+			return allAttrs, nil
+		}
+		allAttrs = append(allAttrs, page...)
+		if err == io.EOF {
+			return allAttrs, nil
+		}
+	}
 }
 
 // Responds on FUSE request to read directory
 func (this *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	absolutePath := this.AbsolutePath()
 	Info.Println("[", absolutePath, "]ReadDirAll")
+	if tracer := this.FileSystem.Tracer; tracer != nil {
+		defer tracer.StartOperation("ReadDirAll", absolutePath, 0, 0).End()
+	}
 
-	allAttrs, err := this.FileSystem.HdfsAccessor.ReadDir(absolutePath)
+	var allAttrs []Attrs
+	var err error
+	if this.FileSystem.ReadDirPageSize > 0 {
+		allAttrs, err = this.ReadDirPaged(absolutePath)
+	} else {
+		allAttrs, err = this.FileSystem.HdfsAccessor.ReadDir(absolutePath)
+	}
 	if err != nil {
 		Warning.Println("ls [", absolutePath, "]: ", err)
 		return nil, err
 	}
+	this.FileSystem.SortListing(allAttrs)
+	collisions, err := this.ResolveCaseCollisions(allAttrs)
+	if err != nil {
+		return nil, err
+	}
+	this.setCaseCollisions(collisions)
 	entries := make([]fuse.Dirent, 0, len(allAttrs))
+	subdirCount := uint32(0)
+	maxEntries := this.FileSystem.MaxListingEntries
+	truncated := false
+	var listing []string
+	var sidecars []string
 	for _, a := range allAttrs {
+		this.FileSystem.ApplyClockSkew(&a)
+		if a.Mode.IsDir() {
+			subdirCount++
+		}
+		presentedName := this.FileSystem.PresentedName(a.Name)
+		if alias, ok := collisions[a.Name]; ok {
+			if alias == "" {
+				// Hidden, colliding with another entry case-insensitively
+				continue
+			}
+			presentedName = alias
+		}
 		if this.FileSystem.IsPathAllowed(this.AbsolutePathForChild(a.Name)) {
+			if maxEntries > 0 && len(entries) >= maxEntries {
+				// Not returning (nor caching) entries beyond the cap, but still counting subdirectories
+				truncated = true
+				continue
+			}
 			// Creating Dirent structure as required by FUSE
 			entries = append(entries, fuse.Dirent{
 				Inode: a.Inode,
-				Name:  a.Name,
+				Name:  presentedName,
 				Type:  a.FuseNodeType()})
 			// Speculatively pre-creating child Dir or File node with cached attributes,
 			// since it's highly likely that we will have Lookup() call for this name
 			// This is the key trick which dramatically speeds up 'ls'
 			this.NodeFromAttrs(a)
+			if this.FileSystem.SequentialDirPrefetch {
+				listing = append(listing, a.Name)
+			}
+			if sidecar := this.FileSystem.ChecksumSidecarName(a.Name); sidecar != "" && !a.Mode.IsDir() {
+				sidecars = append(sidecars, sidecar)
+			}
 
 			if this.FileSystem.ExpandZips {
 				// Creating a virtual directory next to each zip file
 				// (appending '@' to the zip file name)
 				if !a.Mode.IsDir() && strings.HasSuffix(a.Name, ".zip") {
 					entries = append(entries, fuse.Dirent{
-						Name: a.Name + "@",
+						Name: presentedName + "@",
 						Type: fuse.DT_Dir})
 				}
 			}
 		}
 	}
+	if truncated {
+		Warning.Println("ls [", absolutePath, "]: listing truncated to", maxEntries, "entries out of", len(allAttrs))
+		if this.FileSystem.MarkTruncatedListing {
+			entries = append(entries, fuse.Dirent{
+				Name: ListingTruncatedName,
+				Type: fuse.DT_File})
+		}
+	}
+	if len(sidecars) > 0 {
+		// Checksum sidecars don't shadow real files with the same names
+		names := make(map[string]bool, len(allAttrs))
+		for _, a := range allAttrs {
+			names[a.Name] = true
+		}
+		for _, sidecar := range sidecars {
+			if !names[sidecar] {
+				entries = append(entries, fuse.Dirent{
+					Name: this.FileSystem.PresentedName(sidecar),
+					Type: fuse.DT_File})
+			}
+		}
+	}
+	if this.FileSystem.ExposeQuotaFile {
+		entries = append(entries, fuse.Dirent{
+			Name: QuotaFileName,
+			Type: fuse.DT_File})
+	}
+	if trashEntry, ok := this.TrashEntry(); ok {
+		entries = append(entries, trashEntry)
+	}
+	if mountInfoEntry, ok := this.MountInfoEntry(); ok {
+		entries = append(entries, mountInfoEntry)
+	}
+	this.EntriesMutex.Lock()
+	this.SubdirCount = subdirCount
+	this.SubdirCountKnown = true
+	this.listing = listing
+	this.EntriesMutex.Unlock()
 	return entries, nil
 }
 
 // Creates typed node (Dir or File) from the attributes
 func (this *Dir) NodeFromAttrs(attrs Attrs) fs.Node {
-	var node fs.Node
-	if (attrs.Mode & os.ModeDir) == 0 {
-		node = &File{FileSystem: this.FileSystem, Parent: this, Attrs: attrs}
-	} else {
-		node = &Dir{FileSystem: this.FileSystem, Parent: this, Attrs: attrs}
+	node := this.newNode(attrs)
+	if file, ok := node.(*File); ok {
+		this.FileSystem.AttrCache.Add(file)
 	}
 	this.EntriesSet(attrs.Name, node)
 	return node
 }
 
+// Creates node for the entry, without caching it
+func (this *Dir) newNode(attrs Attrs) fs.Node {
+	if (attrs.Mode & os.ModeDir) == 0 {
+		return &File{FileSystem: this.FileSystem, Parent: this, Attrs: attrs}
+	}
+	return &Dir{FileSystem: this.FileSystem, Parent: this, Attrs: attrs}
+}
+
 // Performs Stat() query on the backend
 func (this *Dir) LookupAttrs(name string, attrs *Attrs) error {
-	var err error
-	*attrs, err = this.FileSystem.HdfsAccessor.Stat(path.Join(this.AbsolutePath(), name))
+	absolutePath := this.FileSystem.PathRewriter.Rewrite(path.Join(this.VirtualPath(), name))
+	// Metadata changes made through the mount are tracked locally, preserving them across refreshes
+	knownCtime := attrs.Ctime
+	err := this.FileSystem.RunIdempotent(nil, "Stat", func() error {
+		var statErr error
+		*attrs, statErr = this.FileSystem.HdfsAccessor.Stat(absolutePath)
+		return statErr
+	})
 	if err != nil {
 		// It is a warning as each time new file write tries to stat if the file exists
 		Warning.Print("stat [", name, "]: ", err.Error(), err)
@@ -188,40 +505,127 @@ func (this *Dir) LookupAttrs(name string, attrs *Attrs) error {
 		}
 		return err
 	}
-	// expiration time := now + 5 secs // TODO: make configurable
-	attrs.Expires = this.FileSystem.Clock.Now().Add(5 * time.Second)
+	this.FileSystem.ApplyClockSkew(attrs)
+	if knownCtime.After(attrs.Ctime) {
+		attrs.Ctime = knownCtime
+	}
+	attrs.Expires = this.FileSystem.Clock.Now().Add(this.FileSystem.MetadataCacheTTL)
 	return nil
 }
 
 // Responds on FUSE Mkdir request
 func (this *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error) {
-	err := this.FileSystem.HdfsAccessor.Mkdir(this.AbsolutePathForChild(req.Name), req.Mode)
+	req.Name = this.FileSystem.HdfsName(req.Name)
+	if tracer := this.FileSystem.Tracer; tracer != nil {
+		defer tracer.StartOperation("Mkdir", this.AbsolutePathForChild(req.Name), 0, 0).End()
+	}
+	if err := this.FileSystem.CheckOpEnabled("mkdir", this.AbsolutePathForChild(req.Name)); err != nil {
+		return nil, err
+	}
+	if err := this.CheckNameLength(req.Name); err != nil {
+		return nil, err
+	}
+	if this.FileSystem.CheckNameQuota {
+		if err := this.FileSystem.CheckNameQuotaAvailable(this.AbsolutePath()); err != nil {
+			return nil, err
+		}
+	}
+	err := this.FileSystem.RunMutating("Mkdir", this.AbsolutePathForChild(req.Name), func() error {
+		return this.FileSystem.HdfsAccessor.Mkdir(this.AbsolutePathForChild(req.Name), req.Mode)
+	})
 	if err != nil {
+		if IsQuotaError(err) {
+			return nil, fuse.Errno(syscall.EDQUOT)
+		}
 		return nil, err
 	}
+	this.FileSystem.Audit(req.Header, "mkdir", this.AbsolutePathForChild(req.Name), req.Mode.String())
+	this.FileSystem.AdjustNameQuotaUsage(this.AbsolutePath(), 1)
+	this.AdjustSubdirCount(1)
 	return this.NodeFromAttrs(Attrs{Name: req.Name, Mode: req.Mode | os.ModeDir}), nil
 }
 
 // Responds on FUSE Create request
 func (this *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
+	req.Name = this.FileSystem.HdfsName(req.Name)
 	Info.Println("[", this.AbsolutePathForChild(req.Name), "] Create ", req.Mode)
-	file := this.NodeFromAttrs(Attrs{Name: req.Name, Mode: req.Mode}).(*File)
+	if tracer := this.FileSystem.Tracer; tracer != nil {
+		defer tracer.StartOperation("Create", this.AbsolutePathForChild(req.Name), 0, 0).End()
+	}
+	if err := this.FileSystem.CheckOpEnabled("create", this.AbsolutePathForChild(req.Name)); err != nil {
+		return nil, nil, err
+	}
+	if err := this.CheckNameLength(req.Name); err != nil {
+		return nil, nil, err
+	}
+	if this.FileSystem.CheckNameQuota {
+		if err := this.FileSystem.CheckNameQuotaAvailable(this.AbsolutePath()); err != nil {
+			Warning.Println("Can't create file: ", this.AbsolutePathForChild(req.Name), err)
+			return nil, nil, err
+		}
+	}
+	if this.FileSystem.ExclusiveCreate && req.Flags&fuse.OpenExclusive != 0 {
+		// Concurrent exclusive creates of the same file are serialized, so exactly one of them succeeds
+		unlock := this.FileSystem.createLocks.Lock(this.AbsolutePathForChild(req.Name))
+		defer unlock()
+		if err := this.CheckNotExists(req.Name); err != nil {
+			return nil, nil, err
+		}
+	}
+	attrs := Attrs{Name: req.Name, Mode: req.Mode}
+	if this.FileSystem.InheritDefaultAcl {
+		this.FileSystem.LoadAcl(this.AbsolutePath(), &this.Attrs)
+	}
+	if this.FileSystem.InheritDefaultAcl && this.Attrs.HasDefaultAcl() {
+		// Kernel has applied umask to the requested mode, but it doesn't apply if the directory has default ACL
+		attrs.Mode, attrs.Acl = this.Attrs.ApplyDefaultAcl(req.Mode | req.Umask)
+		attrs.HasAcl = attrs.Acl != nil
+		Info.Println("[", this.AbsolutePathForChild(req.Name), "] Inherited default ACL of the directory, mode", attrs.Mode)
+	}
+	if this.FileSystem.CreateAsCaller {
+		attrs.Uid, attrs.Gid = req.Header.Uid, req.Header.Gid
+	}
+	file := this.NodeFromAttrs(attrs).(*File)
 	handle := NewFileHandle(file)
+	handle.Uid, handle.Gid = req.Header.Uid, req.Header.Gid
+	handle.ApplyOpenFlagModes(req.Flags)
 	err := handle.EnableWrite(true)
 	if err != nil {
 		Error.Println("Can't create file: ", this.AbsolutePathForChild(req.Name), err)
+		if IsQuotaError(err) {
+			return nil, nil, fuse.Errno(syscall.EDQUOT)
+		}
 		return nil, nil, err
 	}
+	this.FileSystem.Audit(req.Header, "create", this.AbsolutePathForChild(req.Name), attrs.Mode.String())
+	this.FileSystem.AdjustNameQuotaUsage(this.AbsolutePath(), 1)
 	file.AddHandle(handle)
 	return file, handle, nil
 }
 
 // Responds on FUSE Remove request
 func (this *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	req.Name, _ = this.ResolveCaseCollision(this.FileSystem.HdfsName(req.Name))
 	path := this.AbsolutePathForChild(req.Name)
 	Info.Println("Remove", path)
-	err := this.FileSystem.HdfsAccessor.Remove(path)
+	if err := this.FileSystem.CheckOpEnabled("remove", path); err != nil {
+		return err
+	}
+	if tracer := this.FileSystem.Tracer; tracer != nil {
+		defer tracer.StartOperation("Remove", path, 0, 0).End()
+	}
+	err := this.FileSystem.RunMutating("Remove", path, func() error {
+		return this.FileSystem.HdfsAccessor.Remove(path)
+	})
 	if err == nil {
+		this.FileSystem.Audit(req.Header, "delete", path, "")
+		this.FileSystem.AdjustNameQuotaUsage(this.AbsolutePath(), -1)
+		if req.Dir {
+			this.AdjustSubdirCount(-1)
+		}
+		if file, ok := this.EntriesGet(req.Name).(*File); ok && file.MarkDeletedIfOpen() {
+			Info.Println("[", path, "] Removed while opened")
+		}
 		this.EntriesRemove(req.Name)
 	}
 	return err
@@ -229,23 +633,110 @@ func (this *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
 
 // Responds on FUSE Rename request
 func (this *Dir) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Node) error {
+	req.OldName, req.NewName = this.FileSystem.HdfsName(req.OldName), this.FileSystem.HdfsName(req.NewName)
+	req.OldName, _ = this.ResolveCaseCollision(req.OldName)
+	targetDir, ok := newDir.(*Dir)
+	if !ok {
+		// Renaming into a virtual directory (e.g. expanded zip archive) isn't supported
+		return fuse.Errno(syscall.EXDEV)
+	}
+	if err := this.FileSystem.CheckOpEnabled("rename", this.AbsolutePathForChild(req.OldName)); err != nil {
+		return err
+	}
+	if err := targetDir.CheckNameLength(req.NewName); err != nil {
+		return err
+	}
+	// Both paths are computed from the live parent chain, so they reflect preceding renames of the parent directories
 	oldPath := this.AbsolutePathForChild(req.OldName)
-	newPath := newDir.(*Dir).AbsolutePathForChild(req.NewName)
+	newPath := targetDir.AbsolutePathForChild(req.NewName)
 	Info.Println("Rename [", oldPath, "] to ", newPath)
-	err := this.FileSystem.HdfsAccessor.Rename(oldPath, newPath)
-	if err == nil {
-		// Upon successful rename, updating in-memory representation of the file entry
-		if node := this.EntriesGet(req.OldName); node != nil {
-			if fnode, ok := node.(*File); ok {
-				fnode.Attrs.Name = req.NewName
-			} else if dnode, ok := node.(*Dir); ok {
-				dnode.Attrs.Name = req.NewName
-			}
-			this.EntriesRemove(req.OldName)
-			newDir.(*Dir).EntriesSet(req.NewName, node)
+	if tracer := this.FileSystem.Tracer; tracer != nil {
+		defer tracer.StartOperation("Rename", oldPath, 0, 0).End()
+	}
+	defer this.lockForRename(targetDir)()
+	err := this.FileSystem.RunMutating("Rename", oldPath, func() error {
+		return this.FileSystem.HdfsAccessor.Rename(oldPath, newPath)
+	})
+	if err != nil {
+		return err
+	}
+	if this.FileSystem.IsTrashPath(oldPath) && !this.FileSystem.IsTrashPath(newPath) {
+		Info.Println("Restored [", newPath, "] from trash")
+		this.FileSystem.Audit(req.Header, "restore", oldPath, newPath)
+	} else {
+		this.FileSystem.Audit(req.Header, "rename", oldPath, newPath)
+	}
+	// Upon successful rename, updating in-memory representation of the file entry
+	node := this.EntriesGet(req.OldName)
+	this.EntriesRemove(req.OldName)
+	if node == nil {
+		// Source node isn't cached, dropping possibly stale node for the target name.
+		// It isn't known whether a subdirectory has been moved, so subdirectory counts are re-computed by next listing
+		targetDir.EntriesRemove(req.NewName)
+		if targetDir != this {
+			this.ForgetSubdirCount()
+			targetDir.ForgetSubdirCount()
 		}
+		return nil
+	}
+	if fnode, ok := node.(*File); ok {
+		fnode.Attrs.Name = req.NewName
+		fnode.Attrs.MetadataChanged(this.FileSystem.Clock.Now())
+		fnode.Parent = targetDir
+	} else if dnode, ok := node.(*Dir); ok {
+		dnode.Attrs.Name = req.NewName
+		dnode.Attrs.MetadataChanged(this.FileSystem.Clock.Now())
+		dnode.Parent = targetDir
+		if targetDir != this {
+			this.AdjustSubdirCount(-1)
+			targetDir.AdjustSubdirCount(1)
+		}
+	}
+	targetDir.EntriesSet(req.NewName, node)
+	return nil
+}
+
+// Adjusts number of subdirectories after a subdirectory has been created or moved in (delta > 0),
+// removed or moved out (delta < 0)
+func (this *Dir) AdjustSubdirCount(delta int) {
+	this.EntriesMutex.Lock()
+	defer this.EntriesMutex.Unlock()
+	if delta < 0 && int(this.SubdirCount) < -delta {
+		this.SubdirCount = 0
+	} else {
+		this.SubdirCount = uint32(int(this.SubdirCount) + delta)
+	}
+}
+
+// Marks number of subdirectories unknown until the directory is listed again
+func (this *Dir) ForgetSubdirCount() {
+	this.EntriesMutex.Lock()
+	defer this.EntriesMutex.Unlock()
+	this.SubdirCountKnown = false
+}
+
+// Locks source and target directories of the rename (with RenameLocking), so concurrent lookups in them
+// wait for the rename to complete. Directories are locked in the order of their paths (ancestors first),
+// so concurrent renames locking the same two directories can't deadlock, while renames in unrelated directories
+// proceed in parallel. Returns function unlocking the directories
+func (this *Dir) lockForRename(targetDir *Dir) func() {
+	if !this.FileSystem.RenameLocking {
+		return func() {}
+	}
+	if targetDir == this {
+		this.namespaceLock.Lock()
+		return this.namespaceLock.Unlock
+	}
+	first, second := this, targetDir
+	if targetDir.AbsolutePath() < this.AbsolutePath() {
+		first, second = targetDir, this
+	}
+	first.namespaceLock.Lock()
+	second.namespaceLock.Lock()
+	return func() {
+		second.namespaceLock.Unlock()
+		first.namespaceLock.Unlock()
 	}
-	return err
 }
 
 // Responds on FUSE Chmod request
@@ -253,11 +744,16 @@ func (this *Dir) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fu
 	// Get the filepath, so chmod in hdfs can work
 	path := this.AbsolutePath()
 	var err error
+	if err = this.FileSystem.CheckSetattrEnabled(req, path); err != nil {
+		return err
+	}
 
 	if req.Valid.Mode() {
 		Info.Println("Chmod [", path, "] to [", req.Mode, "]")
 		(func() {
-			err = this.FileSystem.HdfsAccessor.Chmod(path, req.Mode)
+			err = this.FileSystem.RunMutating("Chmod", path, func() error {
+				return this.FileSystem.HdfsAccessor.Chmod(path, req.Mode)
+			})
 			if err != nil {
 				return
 			}
@@ -267,23 +763,19 @@ func (this *Dir) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fu
 			Error.Println("Chmod [", path, "] failed with error: ", err)
 		} else {
 			this.Attrs.Mode = req.Mode
+			this.Attrs.MetadataChanged(this.FileSystem.Clock.Now())
+			this.FileSystem.Audit(req.Header, "chmod", path, req.Mode.String())
 		}
 	}
 
 	if req.Valid.Uid() {
-		u, err := user.LookupId(fmt.Sprint(req.Uid))
-		owner := fmt.Sprint(req.Uid)
-		group := fmt.Sprint(req.Gid)
-		if err != nil {
-			Error.Println("Chown: username for uid", req.Uid, "not found, use uid/gid instead")
-		} else {
-			owner = u.Username
-			group = owner // hardcoded the group same as owner until LookupGroupId available
-		}
+		owner, group := OwnerNames(req.Uid, req.Gid)
 
 		Info.Println("Chown [", path, "] to [", owner, ":", group, "]")
 		(func() {
-			err = this.FileSystem.HdfsAccessor.Chown(path, owner, group)
+			err = this.FileSystem.RunMutating("Chown", path, func() error {
+				return this.FileSystem.HdfsAccessor.Chown(path, owner, group)
+			})
 			if err != nil {
 				return
 			}
@@ -294,8 +786,95 @@ func (this *Dir) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fu
 		} else {
 			this.Attrs.Uid = req.Uid
 			this.Attrs.Gid = req.Gid
+			this.Attrs.MetadataChanged(this.FileSystem.Clock.Now())
+			this.FileSystem.Audit(req.Header, "chown", path, owner+":"+group)
 		}
 	}
 
 	return err
 }
+
+// Responds on FUSE Setxattr request. Only the triggers of recursive chmod/chown are supported,
+// which are applied by a single accessor call instead of one kernel request per entry.
+// The change isn't atomic: if it fails for some of the entries, the rest of the tree is still changed
+func (this *Dir) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	if req.Name != XattrChmodRecursive && req.Name != XattrChownRecursive {
+		return fuse.Errno(syscall.ENOTSUP)
+	}
+	if this.FileSystem.ReadOnly {
+		return fuse.Errno(syscall.EROFS)
+	}
+	path := this.AbsolutePath()
+	op := "chmod"
+	if req.Name == XattrChownRecursive {
+		op = "chown"
+	}
+	if err := this.FileSystem.CheckOpEnabled(op, path); err != nil {
+		return err
+	}
+	value := strings.TrimRight(string(req.Xattr), "\x00\n")
+	var err error
+	if req.Name == XattrChmodRecursive {
+		mode, parseErr := strconv.ParseUint(value, 8, 32)
+		if parseErr != nil || mode&^uint64(os.ModePerm) != 0 {
+			Error.Println("ChmodRecursive [", path, "]: invalid mode", value)
+			return fuse.Errno(syscall.EINVAL)
+		}
+		Info.Println("ChmodRecursive [", path, "] to [", os.FileMode(mode), "]")
+		err = this.FileSystem.RunMutating("ChmodRecursive", path, func() error {
+			return this.FileSystem.HdfsAccessor.ChmodRecursive(path, os.FileMode(mode))
+		})
+	} else {
+		ownerAndGroup := strings.SplitN(value, ":", 2)
+		owner := ownerAndGroup[0]
+		group := owner
+		if len(ownerAndGroup) == 2 {
+			group = ownerAndGroup[1]
+		}
+		if owner == "" || group == "" {
+			Error.Println("ChownRecursive [", path, "]: invalid owner", value)
+			return fuse.Errno(syscall.EINVAL)
+		}
+		Info.Println("ChownRecursive [", path, "] to [", owner, ":", group, "]")
+		err = this.FileSystem.RunMutating("ChownRecursive", path, func() error {
+			return this.FileSystem.HdfsAccessor.ChownRecursive(path, owner, group)
+		})
+	}
+	details := value
+	if err != nil {
+		Error.Println(req.Name, "[", path, "] failed with error:", err)
+		if _, partial := err.(*RecursiveOpError); !partial {
+			return err
+		}
+		// Rest of the directory tree has been changed
+		details = value + " (partial)"
+	}
+	if req.Name == XattrChmodRecursive {
+		this.FileSystem.Audit(req.Header, "chmod-recursive", path, details)
+	} else {
+		this.FileSystem.Audit(req.Header, "chown-recursive", path, details)
+	}
+	// Cached attributes of the directory and its descendants are stale now
+	this.InvalidateTreeMetadataCache()
+	return err
+}
+
+// Expires cached attributes of the directory and of all its cached descendants, keeping the nodes themselves,
+// so next ls or stat gives up-to-date attributes, while open handles and kernel references stay valid
+func (this *Dir) InvalidateTreeMetadataCache() {
+	this.Attrs.Expires = this.FileSystem.Clock.Now().Add(-1 * time.Second)
+	this.EntriesMutex.Lock()
+	entries := make([]fs.Node, 0, len(this.Entries))
+	for _, node := range this.Entries {
+		entries = append(entries, node)
+	}
+	this.EntriesMutex.Unlock()
+	for _, node := range entries {
+		switch n := node.(type) {
+		case *File:
+			n.InvalidateMetadataCache()
+		case *Dir:
+			n.InvalidateTreeMetadataCache()
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/colinmarc/hdfs"
+	"io"
+)
+
+// Enumerates HDFS directory page by page
+// Concurrency: not thread safe: at most on request at a time
+type DirReader interface {
+	ReadDirPage(count int) ([]Attrs, error) // Returns up to count next entries (io.EOF once all entries are returned). Failed page can be retried
+	Close() error                           // Closes the reader
+}
+
+// DirReader for HDFS directory
+type hdfsDirReaderImpl struct {
+	Accessor      *hdfsAccessorImpl // Accessor used to convert HDFS file statuses into attributes
+	BackendReader *hdfs.FileReader  // HDFS directory opened for reading
+}
+
+var _ DirReader = (*hdfsDirReaderImpl)(nil) // ensure hdfsDirReaderImpl implements DirReader
+
+// Returns next page of the directory entries
+func (this *hdfsDirReaderImpl) ReadDirPage(count int) ([]Attrs, error) {
+	// Backend reader keeps position of the last returned entry, so failed page is re-requested on the next call
+	files, err := this.BackendReader.Readdir(count)
+	this.Accessor.MetadataClientMutex.Lock()
+	defer this.Accessor.MetadataClientMutex.Unlock()
+	allAttrs := make([]Attrs, len(files))
+	for i, fileInfo := range files {
+		allAttrs[i] = this.Accessor.AttrsFromFileInfo(fileInfo)
+	}
+	return allAttrs, err
+}
+
+// Closes the reader
+func (this *hdfsDirReaderImpl) Close() error {
+	return this.BackendReader.Close()
+}
+
+// DirReader returning pre-fetched list of entries
+type staticDirReader struct {
+	Entries []Attrs // Entries which haven't been returned yet
+}
+
+var _ DirReader = (*staticDirReader)(nil) // ensure staticDirReader implements DirReader
+
+// Creates DirReader returning given entries
+func NewStaticDirReader(entries []Attrs) DirReader {
+	return &staticDirReader{Entries: entries}
+}
+
+// Returns next page of the directory entries
+func (this *staticDirReader) ReadDirPage(count int) ([]Attrs, error) {
+	if len(this.Entries) == 0 {
+		return nil, io.EOF
+	}
+	if count <= 0 || count > len(this.Entries) {
+		count = len(this.Entries)
+	}
+	page := this.Entries[:count]
+	this.Entries = this.Entries[count:]
+	return page, nil
+}
+
+// Closes the reader
+func (this *staticDirReader) Close() error {
+	return nil
+}
@@ -4,10 +4,17 @@ package main
 
 import (
 	"bazil.org/fuse"
+	"bytes"
+	"errors"
+	"fmt"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 
+	"io"
 	"os"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -130,6 +137,26 @@ func TestLookupWithFiltering(t *testing.T) {
 	assert.Equal(t, fuse.ENOENT, err) // Not found error, since it is not in the allowed prefixes
 }
 
+// Testing that mount's temporary files in HDFS are hidden from the listing and lookups
+func TestTempFilesHidden(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.HideTempFiles = true
+	root, _ := fileSystem.Root()
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{
+		{Name: "data.csv", Mode: 0644},
+		{Name: MountTempPrefix + "data.csv-1234", Mode: 0644}}, nil)
+	dirents, err := root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(dirents))
+	assert.Equal(t, "data.csv", dirents[0].Name)
+	_, err = root.(*Dir).Lookup(nil, MountTempPrefix+"data.csv-1234")
+	assert.Equal(t, fuse.ENOENT, err)
+}
+
 // Testing Mkdir
 func TestMkdir(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
@@ -143,6 +170,33 @@ func TestMkdir(t *testing.T) {
 	assert.Equal(t, "foo", node.(*Dir).Attrs.Name)
 }
 
+// Mkdir rejected by the name node in safe mode fails with EROFS, or succeeds once safe mode is left (with WaitSafeMode)
+func TestMkdirInSafeMode(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"foo", "bar"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	safeModeErr := errors.New("org.apache.hadoop.hdfs.server.namenode.SafeModeException: Cannot create directory /foo. Name node is in safe mode.")
+	hdfsAccessor.EXPECT().Mkdir("/foo", os.ModeDir|0755).Return(safeModeErr)
+	_, err := root.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Name: "foo", Mode: os.ModeDir | 0755})
+	assert.Equal(t, fuse.Errno(syscall.EROFS), err)
+
+	fs.WaitSafeMode = 10 * SafeModePollInterval
+	gomock.InOrder(
+		hdfsAccessor.EXPECT().Mkdir("/foo", os.ModeDir|0755).Return(safeModeErr).Times(2),
+		hdfsAccessor.EXPECT().Mkdir("/foo", os.ModeDir|0755).Return(nil))
+	node, err := root.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Name: "foo", Mode: os.ModeDir | 0755})
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", node.(*Dir).Attrs.Name)
+
+	// Waiting is bounded
+	hdfsAccessor.EXPECT().Mkdir("/bar", os.ModeDir|0755).Return(safeModeErr).Times(11)
+	_, err = root.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Name: "bar", Mode: os.ModeDir | 0755})
+	assert.Equal(t, fuse.Errno(syscall.EROFS), err)
+}
+
 // Testing Chmod and Chown
 func TestSetattr(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
@@ -162,3 +216,835 @@ func TestSetattr(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, uint32(0), node.(*Dir).Attrs.Uid)
 }
+
+// Changing only the group of a file leaves its owner as it is
+func TestFileSetattrGroupOnly(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: 0644, Uid: 1001, Gid: 1001}, nil)
+	node, err := root.(*Dir).Lookup(nil, "foo")
+	assert.Nil(t, err)
+	_, group := OwnerNames(1001, 0)
+	hdfsAccessor.EXPECT().Chown("/foo", "", group).Return(nil)
+	err = node.(*File).Setattr(nil, &fuse.SetattrRequest{Gid: 0, Valid: fuse.SetattrGid}, &fuse.SetattrResponse{})
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1001), node.(*File).Attrs.Uid)
+	assert.Equal(t, uint32(0), node.(*File).Attrs.Gid)
+}
+
+// Single setattr changing size, mtime and mode applies all of them (size first) and responds with the final attributes
+func TestSetattrSizeTimesAndMode(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	mockClock.NotifyTimeElapsed(1000 * time.Hour)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.StagingInMemory = true
+	root, _ := fileSystem.Root()
+	hdfsAttrs := Attrs{Name: "foo", Mode: 0644, Size: 10, Mtime: mockClock.Now().Add(-time.Hour)}
+	hdfsAccessor.EXPECT().Stat("/foo").Return(hdfsAttrs, nil).Times(2)
+	file, _ := root.(*Dir).Lookup(nil, "foo")
+
+	// Existing content is cut to the new size and rewritten
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/foo").Return(hdfsReader, nil)
+	hdfsReader.whenReadReturn([]byte("0123456789"), nil)
+	hdfsReader.EXPECT().Read(gomock.Any()).Return(0, io.EOF)
+	hdfsReader.EXPECT().Close().Return(nil)
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	var written []byte
+	truncate := hdfsAccessor.EXPECT().Remove("/foo").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/foo", os.FileMode(0644)).Return(hdfsWriter, nil).After(truncate)
+	hdfsWriter.EXPECT().Write(gomock.Any()).DoAndReturn(func(data []byte) (int, error) {
+		written = append(written, data...)
+		return len(data), nil
+	}).AnyTimes()
+	closed := hdfsWriter.EXPECT().Close().Return(nil)
+
+	// Times are applied after the content is rewritten, mode afterwards
+	mtime := mockClock.Now().Add(-24 * time.Hour)
+	chtimes := hdfsAccessor.EXPECT().Chtimes("/foo", hdfsAttrs.Atime, mtime).Return(nil).After(closed)
+	hdfsAccessor.EXPECT().Chmod("/foo", os.FileMode(0600)).Return(nil).After(chtimes)
+	resp := &fuse.SetattrResponse{}
+	err := file.(*File).Setattr(nil, &fuse.SetattrRequest{
+		Valid: fuse.SetattrSize | fuse.SetattrMtime | fuse.SetattrMode,
+		Size:  4,
+		Mtime: mtime,
+		Mode:  0600}, resp)
+	assert.Nil(t, err)
+	assert.Equal(t, "0123", string(written))
+	assert.Equal(t, uint64(4), resp.Attr.Size)
+	assert.Equal(t, mtime, resp.Attr.Mtime)
+	assert.Equal(t, os.FileMode(0600), resp.Attr.Mode)
+	assert.Equal(t, mockClock.Now(), resp.Attr.Ctime)
+
+	// Failure stops processing of the remaining changes
+	hdfsAccessor.EXPECT().Chtimes("/foo", hdfsAttrs.Atime, hdfsAttrs.Mtime).Return(&os.PathError{Op: "chtimes", Path: "/foo", Err: os.ErrPermission})
+	err = file.(*File).Setattr(nil, &fuse.SetattrRequest{Valid: fuse.SetattrMtime | fuse.SetattrMode, Mtime: hdfsAttrs.Mtime, Mode: 0644}, &fuse.SetattrResponse{})
+	assert.NotNil(t, err)
+	assert.Equal(t, os.FileMode(0600), file.(*File).Attrs.Mode)
+	assert.Equal(t, mtime, file.(*File).Attrs.Mtime)
+}
+
+// Testing that birth time is populated from HDFS attributes and omitted when unknown
+func TestBirthTime(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	crtime := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	mtime := crtime.Add(time.Hour)
+	atime := crtime.Add(2 * time.Hour)
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mtime: mtime, Atime: atime, Ctime: mtime, Crtime: crtime}, nil)
+	hdfsAccessor.EXPECT().Stat("/bar").Return(Attrs{Name: "bar", Mtime: mtime}, nil)
+
+	foo, err := root.(*Dir).Lookup(nil, "foo")
+	assert.Nil(t, err)
+	var attr fuse.Attr
+	assert.Nil(t, foo.Attr(nil, &attr))
+	assert.Equal(t, crtime, attr.Crtime)
+	assert.Equal(t, mtime, attr.Mtime)
+	assert.Equal(t, atime, attr.Atime)
+
+	bar, err := root.(*Dir).Lookup(nil, "bar")
+	assert.Nil(t, err)
+	attr = fuse.Attr{}
+	assert.Nil(t, bar.Attr(nil, &attr))
+	assert.True(t, attr.Crtime.IsZero())
+}
+
+// Testing that directory reports nlink as 2 + number of subdirectories
+func TestDirNlink(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: os.ModeDir | 0755}, nil)
+	dir, _ := root.(*Dir).Lookup(nil, "foo")
+
+	// Not listed yet, subdirectory count is unknown
+	var attr fuse.Attr
+	assert.Nil(t, dir.Attr(nil, &attr))
+	assert.Equal(t, uint32(1), attr.Nlink)
+
+	hdfsAccessor.EXPECT().ReadDir("/foo").Return([]Attrs{
+		{Name: "a", Mode: os.ModeDir},
+		{Name: "b", Mode: os.ModeDir},
+		{Name: "c"},
+		{Name: "d", Mode: os.ModeDir},
+	}, nil)
+	_, err := dir.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, dir.Attr(nil, &attr))
+	assert.Equal(t, uint32(3+2), attr.Nlink)
+
+	// Creating one more subdirectory
+	hdfsAccessor.EXPECT().Mkdir("/foo/e", os.ModeDir|0755).Return(nil)
+	_, err = dir.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Name: "e", Mode: os.ModeDir | 0755})
+	assert.Nil(t, err)
+	assert.Nil(t, dir.Attr(nil, &attr))
+	assert.Equal(t, uint32(4+2), attr.Nlink)
+
+	// Moving subdirectory to another listed directory updates counts of both
+	hdfsAccessor.EXPECT().Stat("/bar").Return(Attrs{Name: "bar", Mode: os.ModeDir | 0755}, nil)
+	bar, _ := root.(*Dir).Lookup(nil, "bar")
+	hdfsAccessor.EXPECT().ReadDir("/bar").Return([]Attrs{}, nil)
+	_, err = bar.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().Rename("/foo/e", "/bar/e").Return(nil)
+	assert.Nil(t, dir.(*Dir).Rename(nil, &fuse.RenameRequest{OldName: "e", NewName: "e"}, bar))
+	assert.Nil(t, dir.Attr(nil, &attr))
+	assert.Equal(t, uint32(3+2), attr.Nlink)
+	assert.Nil(t, bar.Attr(nil, &attr))
+	assert.Equal(t, uint32(1+2), attr.Nlink)
+
+	// Moving an entry which isn't cached (so it might be a subdirectory) makes counts unknown
+	hdfsAccessor.EXPECT().Rename("/foo/z", "/bar/z").Return(nil)
+	assert.Nil(t, dir.(*Dir).Rename(nil, &fuse.RenameRequest{OldName: "z", NewName: "z"}, bar))
+	assert.Nil(t, dir.Attr(nil, &attr))
+	assert.Equal(t, uint32(1), attr.Nlink)
+	assert.Nil(t, bar.Attr(nil, &attr))
+	assert.Equal(t, uint32(1), attr.Nlink)
+}
+
+// Testing rename into a newly created subdirectory
+func TestRenameIntoNewSubdirectory(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Mkdir("/foo", os.ModeDir|0755).Return(nil)
+	hdfsAccessor.EXPECT().Mkdir("/foo/bar", os.ModeDir|0755).Return(nil)
+	foo, _ := root.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Name: "foo", Mode: os.ModeDir | 0755})
+	bar, _ := foo.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Name: "bar", Mode: os.ModeDir | 0755})
+	hdfsAccessor.EXPECT().Stat("/a.txt").Return(Attrs{Name: "a.txt", Mode: 0644}, nil)
+	file, _ := root.(*Dir).Lookup(nil, "a.txt")
+
+	// Moving file into newly created subdirectory
+	hdfsAccessor.EXPECT().Rename("/a.txt", "/foo/bar/b.txt").Return(nil)
+	err := root.(*Dir).Rename(nil, &fuse.RenameRequest{OldName: "a.txt", NewName: "b.txt"}, bar)
+	assert.Nil(t, err)
+	assert.Equal(t, "/foo/bar/b.txt", file.(*File).AbsolutePath())
+	assert.Nil(t, root.(*Dir).EntriesGet("a.txt"))
+	assert.Equal(t, file, bar.(*Dir).EntriesGet("b.txt"))
+
+	// Renaming parent directory, path of the moved file is computed from the live parent chain
+	hdfsAccessor.EXPECT().Rename("/foo", "/qux").Return(nil)
+	err = root.(*Dir).Rename(nil, &fuse.RenameRequest{OldName: "foo", NewName: "qux"}, root)
+	assert.Nil(t, err)
+	assert.Equal(t, "/qux/bar/b.txt", file.(*File).AbsolutePath())
+	hdfsAccessor.EXPECT().Rename("/qux/bar/b.txt", "/qux/c.txt").Return(nil)
+	err = bar.(*Dir).Rename(nil, &fuse.RenameRequest{OldName: "b.txt", NewName: "c.txt"}, foo)
+	assert.Nil(t, err)
+	assert.Equal(t, "/qux/c.txt", file.(*File).AbsolutePath())
+}
+
+// Testing virtual quota file reporting quota and usage of the directory
+func TestQuotaFile(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.ExposeQuotaFile = true
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: os.ModeDir | 0755}, nil)
+	foo, _ := root.(*Dir).Lookup(nil, "foo")
+
+	// Quota file is listed along with the real directory entries
+	hdfsAccessor.EXPECT().ReadDir("/foo").Return([]Attrs{Attrs{Name: "bar", Mode: 0644}}, nil)
+	dirents, err := foo.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(dirents))
+	assert.Equal(t, QuotaFileName, dirents[1].Name)
+
+	hdfsAccessor.EXPECT().GetContentSummary("/foo").Return(ContentSummary{
+		Size:           1000,
+		SpaceConsumed:  3000,
+		SpaceQuota:     1024 * 1024,
+		NameQuota:      -1,
+		FileCount:      5,
+		DirectoryCount: 2}, nil)
+	quotaFile, err := foo.(*Dir).Lookup(nil, QuotaFileName)
+	assert.Nil(t, err)
+	h, err := quotaFile.(*QuotaFile).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	content, err := h.(*QuotaFile).ReadAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "space_quota: 1048576\nspace_consumed: 3000\nname_quota: none\nfile_count: 5\ndirectory_count: 2\ncontent_size: 1000\n", string(content))
+
+	// Quota file can't be written
+	_, err = quotaFile.(*QuotaFile).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly}, &fuse.OpenResponse{})
+	assert.NotNil(t, err)
+
+	// Quota file isn't exposed unless enabled
+	fs.ExposeQuotaFile = false
+	hdfsAccessor.EXPECT().Stat("/foo/"+QuotaFileName).Return(Attrs{}, &os.PathError{Op: "stat", Path: "/foo/" + QuotaFileName, Err: os.ErrNotExist})
+	_, err = foo.(*Dir).Lookup(nil, QuotaFileName)
+	assert.Equal(t, fuse.ENOENT, err)
+}
+
+// Testing recursive chmod/chown triggered by setting extended attribute on a directory
+func TestRecursivePermissionChangeXattr(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: os.ModeDir | 0700}, nil)
+	foo, _ := root.(*Dir).Lookup(nil, "foo")
+
+	hdfsAccessor.EXPECT().ChmodRecursive("/foo", os.FileMode(0755)).Return(nil).Times(1)
+	err := foo.(*Dir).Setxattr(nil, &fuse.SetxattrRequest{Name: XattrChmodRecursive, Xattr: []byte("0755")})
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().ChownRecursive("/foo", "alice", "staff").Return(nil).Times(1)
+	err = foo.(*Dir).Setxattr(nil, &fuse.SetxattrRequest{Name: XattrChownRecursive, Xattr: []byte("alice:staff")})
+	assert.Nil(t, err)
+
+	// Partial failure is reported, while cached entries are still invalidated since the rest of the tree has changed
+	hdfsAccessor.EXPECT().Stat("/foo/bar").Return(Attrs{Name: "bar", Mode: 0644}, nil)
+	bar, _ := foo.(*Dir).Lookup(nil, "bar")
+	partialErr := &RecursiveOpError{Failed: []string{"/foo/bar"}, Err: &os.PathError{Op: "chmod", Path: "/foo/bar", Err: os.ErrPermission}}
+	hdfsAccessor.EXPECT().ChmodRecursive("/foo", os.FileMode(0700)).Return(partialErr).Times(1)
+	err = foo.(*Dir).Setxattr(nil, &fuse.SetxattrRequest{Name: XattrChmodRecursive, Xattr: []byte("0700")})
+	assert.Equal(t, partialErr, err)
+	// Cached node is kept, only its attributes are re-fetched
+	assert.Equal(t, bar, foo.(*Dir).Entries["bar"])
+	hdfsAccessor.EXPECT().Stat("/foo/bar").Return(Attrs{Name: "bar", Mode: 0700}, nil)
+	var a fuse.Attr
+	assert.Nil(t, bar.(*File).Attr(nil, &a))
+	assert.Equal(t, os.FileMode(0700), a.Mode)
+	assert.Contains(t, partialErr.Error(), "failed for 1 path(s) [/foo/bar]")
+	assert.True(t, IsBenignRecursiveOpError(partialErr))
+
+	// Invalid values and unknown attributes are rejected without touching HDFS
+	err = foo.(*Dir).Setxattr(nil, &fuse.SetxattrRequest{Name: XattrChmodRecursive, Xattr: []byte("rwx")})
+	assert.Equal(t, fuse.Errno(syscall.EINVAL), err)
+	err = foo.(*Dir).Setxattr(nil, &fuse.SetxattrRequest{Name: "user.foo", Xattr: []byte("bar")})
+	assert.Equal(t, fuse.Errno(syscall.ENOTSUP), err)
+}
+
+// Testing that chmod advances ctime of the file, but not its mtime
+func TestCtimeTrackedSeparatelyFromMtime(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	mockClock.NotifyTimeElapsed(1000 * time.Hour)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	modificationTime := mockClock.Now().Add(-time.Hour)
+	hdfsAttrs := Attrs{Name: "foo", Mode: 0644, Mtime: modificationTime, Ctime: modificationTime}
+	hdfsAccessor.EXPECT().Stat("/foo").Return(hdfsAttrs, nil)
+	file, _ := root.(*Dir).Lookup(nil, "foo")
+	var attr fuse.Attr
+	assert.Nil(t, file.Attr(nil, &attr))
+	assert.Equal(t, modificationTime, attr.Ctime)
+
+	mockClock.NotifyTimeElapsed(time.Second)
+	hdfsAccessor.EXPECT().Chmod("/foo", os.FileMode(0600)).Return(nil)
+	err := file.(*File).Setattr(nil, &fuse.SetattrRequest{Valid: fuse.SetattrMode, Mode: 0600}, &fuse.SetattrResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, file.Attr(nil, &attr))
+	assert.Equal(t, mockClock.Now(), attr.Ctime)
+	assert.Equal(t, modificationTime, attr.Mtime)
+
+	// Locally tracked ctime survives refresh of the attributes from HDFS
+	mockClock.NotifyTimeElapsed(10 * time.Second)
+	hdfsAttrs.Mode = 0600
+	hdfsAccessor.EXPECT().Stat("/foo").Return(hdfsAttrs, nil)
+	assert.Nil(t, file.Attr(nil, &attr))
+	assert.Equal(t, mockClock.Now().Add(-10*time.Second), attr.Ctime)
+	assert.Equal(t, modificationTime, attr.Mtime)
+}
+
+// Opening directory as a file fails with EISDIR by default
+func TestOpenDirAsFile(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	_, err := root.(*Dir).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EISDIR), err)
+
+	// Opening as a directory still works
+	h, err := root.(*Dir).Open(nil, &fuse.OpenRequest{Dir: true, Flags: fuse.OpenReadOnly | fuse.OpenDirectory}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	assert.Equal(t, root, h)
+}
+
+// Reading directory opened as a file returns names of the entries (with DirListingOnRead enabled)
+func TestOpenDirAsFileWithListing(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.DirListingOnRead = true
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{
+		Attrs{Name: "foo", Mode: os.ModeDir | 0755},
+		Attrs{Name: "bar.txt", Mode: 0644}}, nil)
+	h, err := root.(*Dir).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	listing, err := h.(*DirListingHandle).ReadAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo\nbar.txt\n", string(listing))
+
+	// Directory can't be opened as a file for writing
+	_, err = root.(*Dir).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly}, &fuse.OpenResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EISDIR), err)
+}
+
+// Listing of a directory larger than the cap is truncated to the first N entries with a warning
+func TestReadDirTruncatedToMaxListingEntries(t *testing.T) {
+	var warnings bytes.Buffer
+	InitLogger(os.Stdout, &warnings, os.Stdout, os.Stderr)
+	defer InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.MaxListingEntries = 3
+	root, _ := fs.Root()
+	var allAttrs []Attrs
+	for i := 0; i < 10; i++ {
+		allAttrs = append(allAttrs, Attrs{Name: fmt.Sprint("file", i), Mode: 0644})
+	}
+	hdfsAccessor.EXPECT().ReadDir("/").Return(allAttrs, nil).Times(2)
+	dirents, err := root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(dirents))
+	for i, dirent := range dirents {
+		assert.Equal(t, fmt.Sprint("file", i), dirent.Name)
+	}
+	assert.True(t, strings.Contains(warnings.String(), "listing truncated to 3 entries"))
+
+	// Optional sentinel entry indicates truncation
+	fs.MarkTruncatedListing = true
+	dirents, err = root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(dirents))
+	assert.Equal(t, ListingTruncatedName, dirents[3].Name)
+}
+
+// Invalid UTF-8 bytes in names are percent-encoded in listing, escaped names resolve back to HDFS names on lookup
+func TestEscapeInvalidUtf8Names(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.EscapeNames = true
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{
+		{Name: "data\xff\xfe.csv", Mode: 0644},
+		{Name: "50%.txt", Mode: 0644},
+		{Name: "naïve.txt", Mode: 0644},
+	}, nil)
+	dirents, err := root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(dirents))
+	assert.Equal(t, "data%FF%FE.csv", dirents[0].Name)
+	assert.Equal(t, "50%25.txt", dirents[1].Name)
+	assert.Equal(t, "naïve.txt", dirents[2].Name)
+
+	node, err := root.(*Dir).Lookup(nil, "data%FF%FE.csv")
+	assert.Nil(t, err)
+	assert.Equal(t, "data\xff\xfe.csv", node.(*File).Attrs.Name)
+	assert.Equal(t, "/data\xff\xfe.csv", node.(*File).AbsolutePath())
+
+	// Name which hasn't been listed is resolved by HDFS name as well
+	hdfsAccessor.EXPECT().Stat("/log\xc3.txt").Return(Attrs{Name: "log\xc3.txt", Mode: 0644}, nil)
+	node, err = root.(*Dir).Lookup(nil, "log%C3.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "log\xc3.txt", node.(*File).Attrs.Name)
+	assert.Equal(t, "log\xc3.txt", UnescapeName(EscapeName("log\xc3.txt")))
+}
+
+// Each file is accompanied by virtual checksum sidecar, which doesn't shadow real files with the same name
+func TestChecksumSidecar(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.ChecksumSidecar = ChecksumSidecarVisible
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{
+		{Name: "data.csv", Mode: 0644},
+		{Name: "real.csv", Mode: 0644},
+		{Name: "real.csv.crc", Mode: 0644},
+		{Name: "subdir", Mode: os.ModeDir | 0755},
+	}, nil)
+	dirents, err := root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	var names []string
+	for _, dirent := range dirents {
+		names = append(names, dirent.Name)
+	}
+	assert.Equal(t, []string{"data.csv", "real.csv", "real.csv.crc", "subdir", "data.csv.crc"}, names)
+
+	hdfsAccessor.EXPECT().Stat("/data.csv.crc").Return(Attrs{}, &os.PathError{Op: "stat", Path: "/data.csv.crc", Err: os.ErrNotExist})
+	node, err := root.(*Dir).Lookup(nil, "data.csv.crc")
+	assert.Nil(t, err)
+	sidecar := node.(*ChecksumFile)
+	resp := &fuse.OpenResponse{}
+	_, err = sidecar.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, resp)
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().GetChecksum("/data.csv").Return([]byte{0xde, 0xad, 0xbe, 0xef}, nil)
+	content, err := sidecar.ReadAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0xde, 0xad, 0xbe, 0xef}, content)
+
+	// Real file isn't shadowed by the sidecar
+	node, err = root.(*Dir).Lookup(nil, "real.csv.crc")
+	assert.Nil(t, err)
+	assert.Equal(t, "real.csv.crc", node.(*File).Attrs.Name)
+
+	// Hidden (Hadoop-style) sidecar names
+	fs.ChecksumSidecar = ChecksumSidecarHidden
+	assert.Equal(t, ".data.csv.crc", fs.ChecksumSidecarName("data.csv"))
+	target, ok := fs.ChecksumSidecarTarget(".data.csv.crc")
+	assert.True(t, ok)
+	assert.Equal(t, "data.csv", target)
+	_, ok = fs.ChecksumSidecarTarget("data.csv.crc")
+	assert.False(t, ok)
+
+	// No sidecars if the option is off
+	fs.ChecksumSidecar = ""
+	hdfsAccessor.EXPECT().Stat("/other.csv.crc").Return(Attrs{}, &os.PathError{Op: "stat", Path: "/other.csv.crc", Err: os.ErrNotExist})
+	_, err = root.(*Dir).Lookup(nil, "other.csv.crc")
+	assert.Equal(t, fuse.ENOENT, err)
+}
+
+// Failed page of the directory listing is retried, if it keeps failing entries listed so far are returned
+func TestReadDirPartialFailureRetried(t *testing.T) {
+	var warnings bytes.Buffer
+	InitLogger(os.Stdout, &warnings, os.Stdout, os.Stderr)
+	defer InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	dirReader := NewMockDirReader(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.ReadDirPageSize = 2
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().OpenDir("/").Return(dirReader, nil)
+	gomock.InOrder(
+		dirReader.EXPECT().ReadDirPage(2).Return([]Attrs{{Name: "a"}, {Name: "b"}}, nil),
+		dirReader.EXPECT().ReadDirPage(2).Return(nil, errors.New("Injected failure")),
+		dirReader.EXPECT().ReadDirPage(2).Return([]Attrs{{Name: "c"}, {Name: "d"}}, nil),
+		dirReader.EXPECT().ReadDirPage(2).Return([]Attrs{{Name: "e"}}, io.EOF),
+		dirReader.EXPECT().Close().Return(nil))
+	dirents, err := root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(dirents))
+	for i, dirent := range dirents {
+		assert.Equal(t, string(rune('a'+i)), dirent.Name)
+	}
+
+	// Page which keeps failing results in partial listing with a warning
+	fs.RetryPolicy = NewNoRetryPolicy()
+	hdfsAccessor.EXPECT().OpenDir("/").Return(dirReader, nil)
+	gomock.InOrder(
+		dirReader.EXPECT().ReadDirPage(2).Return([]Attrs{{Name: "a"}, {Name: "b"}}, nil),
+		dirReader.EXPECT().ReadDirPage(2).Return(nil, errors.New("Injected failure")),
+		dirReader.EXPECT().Close().Return(nil))
+	dirents, err = root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(dirents))
+	assert.True(t, strings.Contains(warnings.String(), "returning partial listing"))
+}
+
+// Directory deleted by another client while being listed page by page fails the listing with ESTALE
+func TestReadDirOfVanishedDirectory(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	dirReader := NewMockDirReader(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.ReadDirPageSize = 2
+	fs.StaleVanishedDirs = true
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: os.ModeDir | 0755}, nil)
+	dir, err := root.(*Dir).Lookup(nil, "foo")
+	assert.Nil(t, err)
+
+	hdfsAccessor.EXPECT().OpenDir("/foo").Return(dirReader, nil)
+	gomock.InOrder(
+		dirReader.EXPECT().ReadDirPage(2).Return([]Attrs{{Name: "a"}, {Name: "b"}}, nil),
+		dirReader.EXPECT().ReadDirPage(2).Return(nil, &os.PathError{Op: "readdir", Path: "/foo", Err: os.ErrNotExist}),
+		dirReader.EXPECT().Close().Return(nil))
+	_, err = dir.(*Dir).ReadDirAll(nil)
+	assert.Equal(t, fuse.Errno(syscall.ESTALE), err)
+}
+
+// Creating a file in a directory which is at its namespace quota fails with EDQUOT
+func TestCreateInQuotaFullDirectory(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+
+	// Quota exceeded exception of HDFS is reported as EDQUOT
+	hdfsAccessor.EXPECT().Remove("/foo").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/foo", os.FileMode(0644)).Return(nil,
+		errors.New("org.apache.hadoop.hdfs.protocol.NSQuotaExceededException: The NameSpace quota (directories and files) of directory / is exceeded: quota=3 file count=4"))
+	_, _, err := root.(*Dir).Create(nil, &fuse.CreateRequest{Name: "foo", Mode: 0644}, &fuse.CreateResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EDQUOT), err)
+
+	// With CheckNameQuota, file isn't attempted to be created in the directory at its quota
+	fs.CheckNameQuota = true
+	hdfsAccessor.EXPECT().GetContentSummary("/").Return(ContentSummary{NameQuota: 3, FileCount: 2, DirectoryCount: 1}, nil)
+	_, _, err = root.(*Dir).Create(nil, &fuse.CreateRequest{Name: "bar", Mode: 0644}, &fuse.CreateResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EDQUOT), err)
+}
+
+// Creating entries with names or paths exceeding HDFS limits fails with ENAMETOOLONG without reaching the backend
+func TestCreateWithTooLongName(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	longName := strings.Repeat("x", DefaultMaxNameLength+1)
+	_, _, err := root.(*Dir).Create(nil, &fuse.CreateRequest{Name: longName, Mode: 0644}, &fuse.CreateResponse{})
+	assert.Equal(t, fuse.Errno(syscall.ENAMETOOLONG), err)
+	_, err = root.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Name: longName, Mode: 0755})
+	assert.Equal(t, fuse.Errno(syscall.ENAMETOOLONG), err)
+	err = root.(*Dir).Rename(nil, &fuse.RenameRequest{OldName: "short", NewName: longName}, root)
+	assert.Equal(t, fuse.Errno(syscall.ENAMETOOLONG), err)
+
+	// Limit of the total path length is configurable
+	fs.MaxPathLength = 10
+	_, err = root.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Name: "0123456789", Mode: 0755})
+	assert.Equal(t, fuse.Errno(syscall.ENAMETOOLONG), err)
+	hdfsAccessor.EXPECT().Mkdir("/012345678", os.FileMode(0755)).Return(nil)
+	_, err = root.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Name: "012345678", Mode: 0755})
+	assert.Nil(t, err)
+}
+
+// Directory listing is returned in the configured sort order
+func TestReadDirSorted(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	listing := []Attrs{
+		{Name: "c", Mode: 0644, Size: 10, Mtime: time.Unix(300, 0)},
+		{Name: "a", Mode: 0644, Size: 30, Mtime: time.Unix(200, 0)},
+		{Name: "d", Mode: os.ModeDir | 0755, Size: 0, Mtime: time.Unix(200, 0)},
+		{Name: "b", Mode: 0644, Size: 10, Mtime: time.Unix(100, 0)},
+	}
+	names := func(order string) []string {
+		fs.SortListings = order
+		hdfsAccessor.EXPECT().ReadDir("/").Return(append([]Attrs(nil), listing...), nil)
+		dirents, err := root.(*Dir).ReadDirAll(nil)
+		assert.Nil(t, err)
+		var result []string
+		for _, dirent := range dirents {
+			result = append(result, dirent.Name)
+		}
+		return result
+	}
+	assert.Equal(t, []string{"c", "a", "d", "b"}, names(""))
+	assert.Equal(t, []string{"a", "b", "c", "d"}, names(ListingSortName))
+	assert.Equal(t, []string{"b", "a", "d", "c"}, names(ListingSortMtime))
+	assert.Equal(t, []string{"d", "b", "c", "a"}, names(ListingSortSize))
+}
+
+// Of concurrent exclusive creates of the same file exactly one succeeds, the rest fail with EEXIST
+func TestConcurrentExclusiveCreate(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.ExclusiveCreate = true
+	root, _ := fileSystem.Root()
+
+	var createdMutex sync.Mutex
+	created := false
+	hdfsAccessor.EXPECT().Stat("/excl.txt").DoAndReturn(func(path string) (Attrs, error) {
+		createdMutex.Lock()
+		defer createdMutex.Unlock()
+		if created {
+			return Attrs{Name: "excl.txt", Mode: 0644}, nil
+		}
+		return Attrs{}, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+	}).AnyTimes()
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Remove("/excl.txt").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/excl.txt", os.FileMode(0644)).DoAndReturn(func(path string, mode os.FileMode) (HdfsWriter, error) {
+		createdMutex.Lock()
+		defer createdMutex.Unlock()
+		created = true
+		return hdfsWriter, nil
+	})
+	hdfsWriter.EXPECT().Close().Return(nil)
+
+	const clients = 8
+	results := make(chan error, clients)
+	handles := make(chan *FileHandle, clients)
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, h, err := root.(*Dir).Create(nil, &fuse.CreateRequest{
+				Name:  "excl.txt",
+				Flags: fuse.OpenWriteOnly | fuse.OpenCreate | fuse.OpenExclusive,
+				Mode:  os.FileMode(0644)}, &fuse.CreateResponse{})
+			if err == nil {
+				handles <- h.(*FileHandle)
+			}
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+	succeeded := 0
+	for err := range results {
+		if err == nil {
+			succeeded++
+		} else {
+			assert.Equal(t, fuse.EEXIST, err)
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+	(<-handles).Release(nil, &fuse.ReleaseRequest{})
+}
+
+// Testing that lookups racing with rename of the same entry observe either pre-rename or post-rename state
+func TestConcurrentRenameAndLookup(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	for iteration := 0; iteration < 20; iteration++ {
+		mockCtrl := gomock.NewController(t)
+		mockClock := &MockClock{}
+		hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+		fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+		fileSystem.RenameLocking = true
+		root, _ := fileSystem.Root()
+
+		var renamedMutex sync.Mutex
+		renamed := false
+		stat := func(name string, existsAfterRename bool) func(path string) (Attrs, error) {
+			return func(path string) (Attrs, error) {
+				renamedMutex.Lock()
+				exists := renamed == existsAfterRename
+				renamedMutex.Unlock()
+				// Slow name node, so the rename can happen while the lookup is in progress
+				time.Sleep(time.Duration(iteration%3) * time.Millisecond)
+				if exists {
+					return Attrs{Name: name, Mode: 0644}, nil
+				}
+				return Attrs{}, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+			}
+		}
+		hdfsAccessor.EXPECT().Stat("/a.txt").DoAndReturn(stat("a.txt", false)).AnyTimes()
+		hdfsAccessor.EXPECT().Stat("/b.txt").DoAndReturn(stat("b.txt", true)).AnyTimes()
+		hdfsAccessor.EXPECT().Rename("/a.txt", "/b.txt").DoAndReturn(func(oldPath string, newPath string) error {
+			renamedMutex.Lock()
+			defer renamedMutex.Unlock()
+			renamed = true
+			return nil
+		})
+
+		const lookups = 4
+		nodes := make(chan *File, 2*lookups)
+		var wg sync.WaitGroup
+		for i := 0; i < lookups; i++ {
+			for _, name := range []string{"a.txt", "b.txt"} {
+				wg.Add(1)
+				go func(name string) {
+					defer wg.Done()
+					node, err := root.(*Dir).Lookup(nil, name)
+					if err != nil {
+						assert.Equal(t, fuse.ENOENT, err)
+						return
+					}
+					nodes <- node.(*File)
+				}(name)
+			}
+		}
+		err := root.(*Dir).Rename(nil, &fuse.RenameRequest{OldName: "a.txt", NewName: "b.txt"}, root)
+		assert.Nil(t, err)
+		wg.Wait()
+		close(nodes)
+
+		// Renamed name is never resurrected, and every node returned by the lookups is the one now cached as the target
+		assert.Nil(t, root.(*Dir).EntriesGet("a.txt"))
+		for node := range nodes {
+			assert.Equal(t, "b.txt", node.Attrs.Name)
+			assert.Equal(t, "/b.txt", node.AbsolutePath())
+			assert.True(t, root.(*Dir).EntriesGet("b.txt") == node)
+		}
+		mockCtrl.Finish()
+	}
+}
+
+// With RenameLocking, renames in unrelated directories run in parallel,
+// and opposite renames between the same two directories don't deadlock
+func TestRenameLockingDirectories(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.RenameLocking = true
+	root, _ := fileSystem.Root()
+	dirs := make(map[string]*Dir)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		hdfsAccessor.EXPECT().Mkdir("/"+name, os.FileMode(0755)|os.ModeDir).Return(nil)
+		node, err := root.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Name: name, Mode: os.FileMode(0755) | os.ModeDir})
+		assert.Nil(t, err)
+		dirs[name] = node.(*Dir)
+	}
+	rename := func(source string, target string, name string) chan error {
+		done := make(chan error, 1)
+		go func() {
+			done <- dirs[source].Rename(nil, &fuse.RenameRequest{OldName: name, NewName: name}, dirs[target])
+		}()
+		return done
+	}
+	wait := func(done chan error) {
+		select {
+		case err := <-done:
+			assert.Nil(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Rename hasn't completed")
+		}
+	}
+
+	// Each rename completes only once the other one has reached the name node
+	started := make(chan struct{}, 2)
+	inParallel := func(oldPath string, newPath string) error {
+		started <- struct{}{}
+		for len(started) < 2 {
+			time.Sleep(time.Millisecond)
+		}
+		return nil
+	}
+	hdfsAccessor.EXPECT().Rename("/c/x", "/c/x").DoAndReturn(inParallel)
+	hdfsAccessor.EXPECT().Rename("/d/x", "/d/x").DoAndReturn(inParallel)
+	first, second := rename("c", "c", "x"), rename("d", "d", "x")
+	wait(first)
+	wait(second)
+
+	hdfsAccessor.EXPECT().Rename("/a/x", "/b/x").Return(nil)
+	hdfsAccessor.EXPECT().Rename("/b/y", "/a/y").Return(nil)
+	first, second = rename("a", "b", "x"), rename("b", "a", "y")
+	wait(first)
+	wait(second)
+}
+
+// Getattr on a handle of a file removed while opened returns last known attributes of the file
+func TestGetattrDeletedOpenFile(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.DeletedOpenAttrs = true
+	root, _ := fileSystem.Root()
+
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: 0640, Size: 42}, nil)
+	file, err := root.(*Dir).Lookup(nil, "foo")
+	assert.Nil(t, err)
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/foo").Return(hdfsReader, nil)
+	h, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+	assert.Nil(t, err)
+
+	hdfsAccessor.EXPECT().Remove("/foo").Return(nil)
+	assert.Nil(t, root.(*Dir).Remove(nil, &fuse.RemoveRequest{Name: "foo"}))
+
+	// Cached attributes have expired, but the file isn't re-statted
+	mockClock.NotifyTimeElapsed(time.Hour)
+	var attr fuse.Attr
+	assert.Nil(t, h.(*FileHandle).Attr(nil, &attr))
+	assert.Equal(t, uint64(42), attr.Size)
+	assert.Equal(t, os.FileMode(0640), attr.Mode)
+
+	// Without DeletedOpenAttrs, the file is looked up and is gone
+	fileSystem.DeletedOpenAttrs = false
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{}, &os.PathError{Op: "stat", Path: "/foo", Err: os.ErrNotExist})
+	assert.NotNil(t, h.(*FileHandle).Attr(nil, &attr))
+
+	hdfsReader.EXPECT().Close().Return(nil)
+	assert.Nil(t, h.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+	mockCtrl.Finish()
+}
@@ -0,0 +1,69 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// Names of the operations which can be disabled (see FileSystem.DisabledOps)
+var DisableableOps = []string{"create", "mkdir", "remove", "rename", "chmod", "chown", "symlink", "write"}
+
+// Parses comma-separated list of disabled operation names
+func ParseDisabledOps(spec string) (map[string]bool, error) {
+	disabledOps := make(map[string]bool)
+	for _, op := range strings.Split(spec, ",") {
+		if op == "" {
+			continue
+		}
+		known := false
+		for _, name := range DisableableOps {
+			known = known || name == op
+		}
+		if !known {
+			return nil, errors.New(fmt.Sprintf("Unknown operation: %s (supported: %s)", op, strings.Join(DisableableOps, ", ")))
+		}
+		disabledOps[op] = true
+	}
+	return disabledOps, nil
+}
+
+// Returns EPERM if the operation is disabled by configuration, regardless of the backend permissions,
+// or EROFS if it is attempted during a read-only window
+func (this *FileSystem) CheckOpEnabled(op string, path string) error {
+	if this.DisabledOps[op] {
+		Warning.Println("[", path, "]", op, "is disabled")
+		return fuse.Errno(syscall.EPERM)
+	}
+	if this.InReadOnlyWindow() {
+		Warning.Println("[", path, "]", op, "isn't allowed during read-only window")
+		return fuse.Errno(syscall.EROFS)
+	}
+	if this.ReservedPaths == ReservedPathsRaw && IsRawPath(path) {
+		Warning.Println("[", path, "]", op, "in raw reserved path isn't allowed")
+		return fuse.Errno(syscall.EROFS)
+	}
+	return nil
+}
+
+// Returns EPERM if any of the attribute changes requested is disabled by configuration
+func (this *FileSystem) CheckSetattrEnabled(req *fuse.SetattrRequest, path string) error {
+	if req.Valid.Mode() {
+		if err := this.CheckOpEnabled("chmod", path); err != nil {
+			return err
+		}
+	}
+	if req.Valid.Uid() || req.Valid.Gid() {
+		if err := this.CheckOpEnabled("chown", path); err != nil {
+			return err
+		}
+	}
+	if req.Valid.Size() {
+		return this.CheckOpEnabled("write", path)
+	}
+	return nil
+}
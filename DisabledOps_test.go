@@ -0,0 +1,124 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Disabled rename fails with EPERM without reaching the backend, while other operations still work
+func TestDisabledRename(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	var err error
+	fs.DisabledOps, err = ParseDisabledOps("rename,chmod")
+	assert.Nil(t, err)
+	root, _ := fs.Root()
+
+	err = root.(*Dir).Rename(nil, &fuse.RenameRequest{OldName: "a.txt", NewName: "b.txt"}, root)
+	assert.Equal(t, fuse.Errno(syscall.EPERM), err)
+	err = root.(*Dir).Setattr(nil, &fuse.SetattrRequest{Valid: fuse.SetattrMode, Mode: 0700}, &fuse.SetattrResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EPERM), err)
+
+	hdfsAccessor.EXPECT().Mkdir("/dir", os.FileMode(0755)).Return(nil)
+	_, err = root.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Name: "dir", Mode: 0755})
+	assert.Nil(t, err)
+
+	_, err = ParseDisabledOps("rename,format")
+	assert.NotNil(t, err)
+}
+
+// Modifying operations fail with EROFS during read-only windows, and succeed outside of them
+func TestReadOnlyWindows(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	var err error
+	fileSystem.ReadOnlyWindows, err = ParseTimeWindows("01:00-03:00,23:30-00:15")
+	assert.Nil(t, err)
+	root, _ := fileSystem.Root()
+
+	// 00:30 is outside of the windows
+	mockClock.NotifyTimeElapsed(30 * time.Minute)
+	hdfsAccessor.EXPECT().Mkdir("/before", os.FileMode(0755)).Return(nil)
+	_, err = root.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Name: "before", Mode: 0755})
+	assert.Nil(t, err)
+
+	// 01:30 is inside the maintenance window
+	mockClock.NotifyTimeElapsed(time.Hour)
+	_, err = root.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Name: "during", Mode: 0755})
+	assert.Equal(t, fuse.Errno(syscall.EROFS), err)
+	_, _, err = root.(*Dir).Create(nil, &fuse.CreateRequest{Name: "during.txt", Mode: 0644}, &fuse.CreateResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EROFS), err)
+	err = root.(*Dir).Remove(nil, &fuse.RemoveRequest{Name: "before", Dir: true})
+	assert.Equal(t, fuse.Errno(syscall.EROFS), err)
+
+	// 03:00 is the end of the window
+	mockClock.NotifyTimeElapsed(90 * time.Minute)
+	hdfsAccessor.EXPECT().Mkdir("/after", os.FileMode(0755)).Return(nil)
+	_, err = root.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Name: "after", Mode: 0755})
+	assert.Nil(t, err)
+
+	// Window wrapping around midnight
+	mockClock.NotifyTimeElapsed(20*time.Hour + 45*time.Minute)
+	assert.True(t, fileSystem.InReadOnlyWindow())
+	mockClock.NotifyTimeElapsed(20 * time.Minute)
+	assert.True(t, fileSystem.InReadOnlyWindow())
+	mockClock.NotifyTimeElapsed(10 * time.Minute)
+	assert.False(t, fileSystem.InReadOnlyWindow())
+
+	_, err = ParseTimeWindows("01:00")
+	assert.NotNil(t, err)
+	_, err = ParseTimeWindows("25:00-26:00")
+	assert.NotNil(t, err)
+}
+
+// Content staged before a read-only window has started isn't uploaded until the window ends
+func TestReadOnlyWindowFlush(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	var err error
+	fileSystem.ReadOnlyWindows, err = ParseTimeWindows("01:00-03:00")
+	assert.Nil(t, err)
+	root, _ := fileSystem.Root()
+
+	// File is created and written at 00:30
+	mockClock.NotifyTimeElapsed(30 * time.Minute)
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Remove("/foo.txt").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/foo.txt", os.FileMode(0644)).Return(hdfsWriter, nil)
+	hdfsWriter.EXPECT().Close().Return(nil)
+	_, h, err := root.(*Dir).Create(nil, &fuse.CreateRequest{Name: "foo.txt", Mode: 0644}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: 100, used: 20, remaining: 80}, nil).AnyTimes()
+	err = h.(*FileHandle).Write(nil, &fuse.WriteRequest{Data: []byte("hello")}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+
+	// Flush at 01:30 doesn't upload anything
+	mockClock.NotifyTimeElapsed(time.Hour)
+	err = h.(*FileHandle).Flush(nil, &fuse.FlushRequest{})
+	assert.Equal(t, fuse.Errno(syscall.EROFS), err)
+
+	// Staged content is kept, and uploaded by the flush at 03:30
+	mockClock.NotifyTimeElapsed(2 * time.Hour)
+	hdfsAccessor.EXPECT().Remove("/foo.txt").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/foo.txt", os.FileMode(0644)).Return(hdfsWriter, nil)
+	hdfsWriter.EXPECT().Write([]byte("hello")).Return(5, nil)
+	hdfsWriter.EXPECT().Close().Return(nil)
+	err = h.(*FileHandle).Flush(nil, &fuse.FlushRequest{})
+	assert.Nil(t, err)
+}
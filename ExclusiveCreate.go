@@ -0,0 +1,63 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"sync"
+)
+
+// Serializes operations on the same path, while operations on different paths proceed concurrently.
+// Zero value is ready to use
+type PathLocks struct {
+	mutex sync.Mutex           // mutex for locks
+	locks map[string]*pathLock // locks of the paths which are held or awaited
+}
+
+// Lock of a single path, dropped once nobody holds or awaits it
+type pathLock struct {
+	mutex sync.Mutex
+	refs  int // number of goroutines holding or awaiting the lock
+}
+
+// Locks the path, returns function releasing the lock
+func (this *PathLocks) Lock(path string) func() {
+	this.mutex.Lock()
+	if this.locks == nil {
+		this.locks = make(map[string]*pathLock)
+	}
+	lock, ok := this.locks[path]
+	if !ok {
+		lock = &pathLock{}
+		this.locks[path] = lock
+	}
+	lock.refs++
+	this.mutex.Unlock()
+
+	lock.mutex.Lock()
+	return func() {
+		lock.mutex.Unlock()
+		this.mutex.Lock()
+		defer this.mutex.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(this.locks, path)
+		}
+	}
+}
+
+// Fails with EEXIST if the entry exists in HDFS, or it is being created through this mount
+// and isn't in HDFS yet (see FileSystem.DeferCreate)
+func (this *Dir) CheckNotExists(name string) error {
+	if file, ok := this.EntriesGet(name).(*File); ok && file.IsCreateDeferred() {
+		return fuse.EEXIST
+	}
+	var attrs Attrs
+	err := this.LookupAttrs(name, &attrs)
+	if err == nil {
+		return fuse.EEXIST
+	} else if err == fuse.ENOENT {
+		return nil
+	}
+	return err
+}
@@ -4,12 +4,14 @@ package main
 
 import (
 	"os"
+	"time"
 )
 
 // Adds automatic retry capability to HdfsAccessor with respect to RetryPolicy
 type FaultTolerantHdfsAccessor struct {
-	Impl        HdfsAccessor
-	RetryPolicy *RetryPolicy
+	Impl             HdfsAccessor
+	RetryPolicy      *RetryPolicy
+	BlockReadTimeout time.Duration // Timeout of a single read of the opened files (0 to wait indefinitely)
 }
 
 var _ HdfsAccessor = (*FaultTolerantHdfsAccessor)(nil) // ensure FaultTolerantHdfsAccessor implements HdfsAccessor
@@ -39,7 +41,9 @@ func (this *FaultTolerantHdfsAccessor) OpenRead(path string) (ReadSeekCloser, er
 		result, err := this.Impl.OpenRead(path)
 		if err == nil {
 			// wrapping returned HdfsReader with FaultTolerantHdfsReader
-			return NewFaultTolerantHdfsReader(path, result, this.Impl, this.RetryPolicy), nil
+			reader := NewFaultTolerantHdfsReader(path, result, this.Impl, this.RetryPolicy)
+			reader.ReadTimeout = this.BlockReadTimeout
+			return reader, nil
 		}
 		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] OpenRead: %s", path, err) {
 			return nil, err
@@ -52,8 +56,48 @@ func (this *FaultTolerantHdfsAccessor) OpenRead(path string) (ReadSeekCloser, er
 
 // Opens HDFS file for writing
 func (this *FaultTolerantHdfsAccessor) CreateFile(path string, mode os.FileMode) (HdfsWriter, error) {
-	// TODO: implement fault-tolerance. For now re-try-loop is implemented inside FileHandleWriter
-	return this.Impl.CreateFile(path, mode)
+	// TODO: implement fault-tolerance. For now re-try-loop is implemented inside FileHandleWriter,
+	// only name node failover is handled here (standby name node doesn't create the file, so retry is safe)
+	op := this.RetryPolicy.StartOperation()
+	for {
+		result, err := this.Impl.CreateFile(path, mode)
+		if !IsFailoverError(err) || !op.ShouldRetry("[%s] CreateFile: %s", path, err) {
+			return result, err
+		} else {
+			// Clean up the bad connection, to reconnect to the active name node
+			this.Impl.Close()
+		}
+	}
+}
+
+// Opens HDFS file for writing, with given number of replicas in the write pipeline
+func (this *FaultTolerantHdfsAccessor) CreateFileWithReplication(path string, mode os.FileMode, replication int) (HdfsWriter, error) {
+	// Same as for CreateFile, only name node failover is handled
+	op := this.RetryPolicy.StartOperation()
+	for {
+		result, err := this.Impl.CreateFileWithReplication(path, mode, replication)
+		if !IsFailoverError(err) || !op.ShouldRetry("[%s] CreateFileWithReplication: %s", path, err) {
+			return result, err
+		} else {
+			// Clean up the bad connection, to reconnect to the active name node
+			this.Impl.Close()
+		}
+	}
+}
+
+// Opens existing HDFS file for appending
+func (this *FaultTolerantHdfsAccessor) Append(path string) (HdfsWriter, error) {
+	// As for CreateFile, only name node failover is handled (standby name node doesn't open the file, so retry is safe)
+	op := this.RetryPolicy.StartOperation()
+	for {
+		result, err := this.Impl.Append(path)
+		if !IsFailoverError(err) || !op.ShouldRetry("[%s] Append: %s", path, err) {
+			return result, err
+		} else {
+			// Clean up the bad connection, to reconnect to the active name node
+			this.Impl.Close()
+		}
+	}
 }
 
 // Enumerates HDFS directory
@@ -70,6 +114,20 @@ func (this *FaultTolerantHdfsAccessor) ReadDir(path string) ([]Attrs, error) {
 	}
 }
 
+// Opens HDFS directory for enumerating it page by page
+func (this *FaultTolerantHdfsAccessor) OpenDir(path string) (DirReader, error) {
+	op := this.RetryPolicy.StartOperation()
+	for {
+		result, err := this.Impl.OpenDir(path)
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] OpenDir: %s", path, err) {
+			return result, err
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
 // Retrieves file/directory attributes
 func (this *FaultTolerantHdfsAccessor) Stat(path string) (Attrs, error) {
 	op := this.RetryPolicy.StartOperation()
@@ -98,6 +156,20 @@ func (this *FaultTolerantHdfsAccessor) StatFs() (FsInfo, error) {
 	}
 }
 
+// Retrieves quota and usage of the directory
+func (this *FaultTolerantHdfsAccessor) GetContentSummary(path string) (ContentSummary, error) {
+	op := this.RetryPolicy.StartOperation()
+	for {
+		result, err := this.Impl.GetContentSummary(path)
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] GetContentSummary: %s", path, err) {
+			return result, err
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
 // Creates a directory
 func (this *FaultTolerantHdfsAccessor) Mkdir(path string, mode os.FileMode) error {
 	op := this.RetryPolicy.StartOperation()
@@ -168,6 +240,139 @@ func (this *FaultTolerantHdfsAccessor) Chown(path string, user, group string) er
 	}
 }
 
+// Changes the access and modification times of the file
+func (this *FaultTolerantHdfsAccessor) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	op := this.RetryPolicy.StartOperation()
+	for {
+		err := this.Impl.Chtimes(path, atime, mtime)
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("Chtimes [%s]: %s", path, err) {
+			return err
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Returns true if the recursive operation has failed for some of the entries with benign error (e.g. permission denied),
+// so retrying it entirely won't help
+func IsBenignRecursiveOpError(err error) bool {
+	recursiveErr, ok := err.(*RecursiveOpError)
+	return ok && IsSuccessOrBenignError(recursiveErr.Err)
+}
+
+// Chmod directory tree (operation is idempotent, so it's safe to retry it entirely)
+func (this *FaultTolerantHdfsAccessor) ChmodRecursive(path string, mode os.FileMode) error {
+	op := this.RetryPolicy.StartOperation()
+	for {
+		err := this.Impl.ChmodRecursive(path, mode)
+		if IsSuccessOrBenignError(err) || IsBenignRecursiveOpError(err) || !op.ShouldRetry("ChmodRecursive [%s] to [%d]: %s", path, mode, err) {
+			return err
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Chown directory tree (operation is idempotent, so it's safe to retry it entirely)
+func (this *FaultTolerantHdfsAccessor) ChownRecursive(path string, user, group string) error {
+	op := this.RetryPolicy.StartOperation()
+	for {
+		err := this.Impl.ChownRecursive(path, user, group)
+		if IsSuccessOrBenignError(err) || IsBenignRecursiveOpError(err) || !op.ShouldRetry("ChownRecursive [%s] to [%s:%s]: %s", path, user, group, err) {
+			return err
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Retrieves checksum of the file
+func (this *FaultTolerantHdfsAccessor) GetChecksum(path string) ([]byte, error) {
+	op := this.RetryPolicy.StartOperation()
+	for {
+		result, err := this.Impl.GetChecksum(path)
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] GetChecksum: %s", path, err) {
+			return result, err
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Retrieves ACL entries of the file/directory
+func (this *FaultTolerantHdfsAccessor) GetAcl(path string) ([]AclEntry, error) {
+	op := this.RetryPolicy.StartOperation()
+	for {
+		result, err := this.Impl.GetAcl(path)
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] GetAcl: %s", path, err) {
+			return result, err
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Triggers lease recovery of the file (operation is idempotent, so it's safe to retry it)
+func (this *FaultTolerantHdfsAccessor) RecoverLease(path string) (bool, error) {
+	op := this.RetryPolicy.StartOperation()
+	for {
+		recovered, err := this.Impl.RecoverLease(path)
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("RecoverLease [%s]: %s", path, err) {
+			return recovered, err
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Retrieves layout of the file blocks
+func (this *FaultTolerantHdfsAccessor) GetBlockLocations(path string) ([]BlockLocation, error) {
+	op := this.RetryPolicy.StartOperation()
+	for {
+		result, err := this.Impl.GetBlockLocations(path)
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] GetBlockLocations: %s", path, err) {
+			return result, err
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Creates a symbolic link
+func (this *FaultTolerantHdfsAccessor) CreateSymlink(target string, path string) error {
+	op := this.RetryPolicy.StartOperation()
+	for {
+		err := this.Impl.CreateSymlink(target, path)
+		if IsSuccessOrBenignError(err) || IsUnsupportedOperationError(err) || !op.ShouldRetry("[%s] CreateSymlink %s: %s", path, target, err) {
+			return err
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Lists changes of the snapshottable directory between two snapshots
+func (this *FaultTolerantHdfsAccessor) SnapshotDiff(path, from, to string) ([]DiffEntry, error) {
+	op := this.RetryPolicy.StartOperation()
+	for {
+		result, err := this.Impl.SnapshotDiff(path, from, to)
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] SnapshotDiff %s..%s: %s", path, from, to, err) {
+			return result, err
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
 // Close underline connection if needed
 func (this *FaultTolerantHdfsAccessor) Close() error {
 	return this.Impl.Close()
@@ -86,3 +86,80 @@ func atMost2Attempts() *RetryPolicy {
 	rp.TimeLimit = time.Hour
 	return rp
 }
+
+// Testing that Stat() failed on standby name node is retried after reconnect
+func TestStatRetriedOnNameNodeFailover(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, atMost2Attempts())
+	standbyErr := errors.New("org.apache.hadoop.ipc.StandbyException: Operation category READ is not supported in state standby")
+	assert.True(t, IsFailoverError(standbyErr))
+	hdfsAccessor.EXPECT().Stat("/test/file").Return(Attrs{}, standbyErr)
+	hdfsAccessor.EXPECT().Close().Return(nil)
+	hdfsAccessor.EXPECT().Stat("/test/file").Return(Attrs{Name: "file"}, nil)
+	attrs, err := ftHdfsAccessor.Stat("/test/file")
+	assert.Nil(t, err)
+	assert.Equal(t, "file", attrs.Name)
+}
+
+// Testing that CreateFile() is retried on name node failover, but not on other errors
+func TestCreateFileRetriedOnNameNodeFailover(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, atMost2Attempts())
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().CreateFile("/test/file", os.FileMode(0644)).Return(nil, errors.New("Operation category WRITE is not supported in state standby"))
+	hdfsAccessor.EXPECT().Close().Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/test/file", os.FileMode(0644)).Return(hdfsWriter, nil)
+	w, err := ftHdfsAccessor.CreateFile("/test/file", os.FileMode(0644))
+	assert.Nil(t, err)
+	assert.Equal(t, hdfsWriter, w)
+
+	hdfsAccessor.EXPECT().CreateFile("/test/file", os.FileMode(0644)).Return(nil, errors.New("Injected failure"))
+	_, err = ftHdfsAccessor.CreateFile("/test/file", os.FileMode(0644))
+	assert.NotNil(t, err)
+}
+
+// Testing that operations rejected by overloaded name node are retried with extended backoff
+func TestCongestionBackoff(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	clock := &MockClock{}
+	retryPolicy := NewDefaultRetryPolicy(clock)
+	retryPolicy.RandomizeDelays = false
+	retryPolicy.CongestionMinDelay = 10 * time.Second
+	retryPolicy.CongestionMaxDelay = 20 * time.Second
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, retryPolicy)
+	hdfsAccessor.EXPECT().Close().Return(nil).AnyTimes()
+	congested := errors.New("org.apache.hadoop.ipc.RetriableException: Server too busy")
+	var sleeps []time.Duration
+	recordSleep := func(path string) {
+		sleeps = append(sleeps, clock.LastSleepDuration)
+	}
+
+	// Generic failure is retried immediately, congestion isn't
+	gomock.InOrder(
+		hdfsAccessor.EXPECT().Stat("/test/file").Do(recordSleep).Return(Attrs{}, errors.New("Injected failure")),
+		hdfsAccessor.EXPECT().Stat("/test/file").Do(recordSleep).Return(Attrs{}, congested),
+		hdfsAccessor.EXPECT().Stat("/test/file").Do(recordSleep).Return(Attrs{}, congested),
+		hdfsAccessor.EXPECT().Stat("/test/file").Do(recordSleep).Return(Attrs{}, congested),
+		hdfsAccessor.EXPECT().Stat("/test/file").Do(recordSleep).Return(Attrs{Name: "file"}, nil))
+	attrs, err := ftHdfsAccessor.Stat("/test/file")
+	assert.Nil(t, err)
+	assert.Equal(t, "file", attrs.Name)
+	assert.Equal(t, []time.Duration{0, 0, 10 * time.Second, 16180 * time.Millisecond, 20 * time.Second}, sleeps)
+
+	// With congestion backoff disabled, default schedule is used
+	retryPolicy.CongestionMinDelay = 0
+	clock.LastSleepDuration = 0
+	sleeps = nil
+	gomock.InOrder(
+		hdfsAccessor.EXPECT().Stat("/test/file").Do(recordSleep).Return(Attrs{}, congested),
+		hdfsAccessor.EXPECT().Stat("/test/file").Do(recordSleep).Return(Attrs{}, congested),
+		hdfsAccessor.EXPECT().Stat("/test/file").Do(recordSleep).Return(Attrs{Name: "file"}, nil))
+	_, err = ftHdfsAccessor.Stat("/test/file")
+	assert.Nil(t, err)
+	assert.Equal(t, []time.Duration{0, 0, time.Second}, sleeps)
+}
@@ -2,6 +2,11 @@
 // Licensed under the MIT license. See LICENSE file in the project root for details.
 package main
 
+import (
+	"errors"
+	"time"
+)
+
 // Implements ReadSeekCloser interface with automatic retries (acts as a proxy to HdfsReader)
 type FaultTolerantHdfsReader struct {
 	Path         string
@@ -9,8 +14,13 @@ type FaultTolerantHdfsReader struct {
 	HdfsAccessor HdfsAccessor
 	RetryPolicy  *RetryPolicy
 	Offset       int64
+	ReadTimeout  time.Duration // single read which doesn't complete in time is abandoned and retried with a new reader (0 to wait indefinitely)
+	readBuffer   []byte        // buffer of the reads with ReadTimeout, reused unless the read is abandoned
 }
 
+// Error reported for the read abandoned after ReadTimeout
+var ErrReadTimeout = errors.New("block read timed out")
+
 var _ ReadSeekCloser = (*FaultTolerantHdfsReader)(nil) // ensure FaultTolerantHdfsReaderImpl implements ReadSeekCloser
 // Creates new instance of FaultTolerantHdfsReader
 func NewFaultTolerantHdfsReader(path string, impl ReadSeekCloser, hdfsAccessor HdfsAccessor, retryPolicy *RetryPolicy) *FaultTolerantHdfsReader {
@@ -41,7 +51,7 @@ func (this *FaultTolerantHdfsReader) Read(buffer []byte) (int, error) {
 		}
 		// Performing the read
 		var nr int
-		nr, err = this.Impl.Read(buffer)
+		nr, err = this.readWithTimeout(buffer)
 		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] Read @%d: %s", this.Path, this.Offset, err.Error()) {
 			if err == nil {
 				// On successful read, adjusting offset to the actual number of bytes read
@@ -54,11 +64,55 @@ func (this *FaultTolerantHdfsReader) Read(buffer []byte) (int, error) {
 	}
 }
 
+// Performs single read of the underlying reader. If it doesn't complete within ReadTimeout (e.g. data node serving
+// the block is stalled), it is abandoned and the read is retried with a new reader. Note that HDFS client picks
+// data nodes on its own, so the new reader might end up reading from the same data node
+func (this *FaultTolerantHdfsReader) readWithTimeout(buffer []byte) (int, error) {
+	if this.ReadTimeout <= 0 {
+		return this.Impl.Read(buffer)
+	}
+	type readResult struct {
+		nr  int
+		err error
+	}
+	impl := this.Impl
+	// Abandoned read might still complete later, so it reads into the buffer of this reader rather than the caller's
+	if cap(this.readBuffer) < len(buffer) {
+		this.readBuffer = make([]byte, len(buffer))
+	}
+	data := this.readBuffer[:len(buffer)]
+	done := make(chan readResult, 1)
+	go func() {
+		nr, err := impl.Read(data)
+		done <- readResult{nr, err}
+	}()
+	select {
+	case result := <-done:
+		copy(buffer, data[:result.nr])
+		return result.nr, result.err
+	case <-this.RetryPolicy.Clock.After(this.ReadTimeout):
+		Warning.Println("[", this.Path, "] Read @", this.Offset, "hasn't completed in", this.ReadTimeout)
+		// Abandoned read keeps using the reader and the buffer, the reader is closed once the read finishes
+		this.Impl = nil
+		this.readBuffer = nil
+		go func() {
+			<-done
+			impl.Close()
+		}()
+		return 0, ErrReadTimeout
+	}
+}
+
 // Seeks to a given position
 func (this *FaultTolerantHdfsReader) Seek(pos int64) error {
 	// Seek is implemented as virtual operation on which doesn't involve communication,
 	// passing that through without retires and promptly propagate errors
 	// (which will be non-recoverable in this case)
+	if this.Impl == nil {
+		// Reader has been abandoned, the next read re-opens the file at this position
+		this.Offset = pos
+		return nil
+	}
 	err := this.Impl.Seek(pos)
 	if err == nil {
 		// On success, updating current readng position
@@ -76,6 +130,9 @@ func (this *FaultTolerantHdfsReader) Position() (int64, error) {
 
 // Closes the stream
 func (this *FaultTolerantHdfsReader) Close() error {
+	if this.Impl == nil {
+		return nil
+	}
 	err := this.Impl.Close()
 	this.Impl = nil
 	return err
@@ -7,7 +7,9 @@ import (
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"io"
+	"os"
 	"testing"
+	"time"
 )
 
 // Testing retry logic for Read()
@@ -58,3 +60,86 @@ func TestNoRetryOnEOF(t *testing.T) {
 	assert.Equal(t, io.EOF, err)
 	assert.Equal(t, 0, nr)
 }
+
+// Clock whose non-zero timers fire only when triggered by the test
+type triggeredClock struct {
+	MockClock
+	timers chan chan time.Time
+}
+
+func (this *triggeredClock) After(d time.Duration) <-chan time.Time {
+	c := make(chan time.Time, 1)
+	if d == 0 {
+		c <- this.Now()
+	} else {
+		this.timers <- c
+	}
+	return c
+}
+
+// Stalled read times out, and is retried with a new reader which succeeds.
+// Stalled reader is closed once its abandoned read finishes
+func TestBlockReadTimeoutRetriesWithNewReader(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	clock := &triggeredClock{timers: make(chan chan time.Time, 2)}
+	retryPolicy := NewDefaultRetryPolicy(clock)
+	retryPolicy.MaxAttempts = 2
+	retryPolicy.TimeLimit = time.Hour
+	stalledReader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	ftHdfsReader := NewFaultTolerantHdfsReader("/path/to/file", stalledReader, hdfsAccessor, retryPolicy)
+	ftHdfsReader.ReadTimeout = 30 * time.Second
+
+	// Stalled read only completes (with error) once it is unblocked
+	started, unblocked, closed := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	stalledReader.EXPECT().Read(gomock.Any()).DoAndReturn(func(buffer []byte) (int, error) {
+		close(started)
+		<-unblocked
+		return 0, errors.New("connection reset")
+	})
+	stalledReader.EXPECT().Close().DoAndReturn(func() error {
+		close(closed)
+		return nil
+	})
+	healthyReader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/path/to/file").Return(healthyReader, nil)
+	healthyReader.EXPECT().Seek(int64(0)).Return(nil)
+	healthyReader.EXPECT().Read(gomock.Any()).DoAndReturn(func(buffer []byte) (int, error) {
+		return copy(buffer, "hello"), nil
+	})
+
+	type readResult struct {
+		data string
+		err  error
+	}
+	done := make(chan readResult, 1)
+	go func() {
+		buffer := make([]byte, 100)
+		nr, err := ftHdfsReader.Read(buffer)
+		done <- readResult{string(buffer[:nr]), err}
+	}()
+	// Firing the timer of the first read, the timer of the retried read never fires
+	<-started
+	(<-clock.timers) <- time.Time{}
+	select {
+	case result := <-done:
+		assert.Nil(t, result.err)
+		assert.Equal(t, "hello", result.data)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Read hasn't completed")
+	}
+	assert.Equal(t, int64(5), ftHdfsReader.Offset)
+	// Stalled reader is only closed once its read finishes
+	select {
+	case <-closed:
+		t.Fatal("Stalled reader closed while its read was in progress")
+	default:
+	}
+	close(unblocked)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stalled reader hasn't been closed")
+	}
+}
@@ -4,13 +4,15 @@ package main
 
 // Implements HdfsWriter interface with automatic retries (acts as a proxy to HdfsWriter)
 type FaultTolerantHdfsWriter struct {
-	Impl HdfsWriter
+	Impl        HdfsWriter
+	Path        string       // Path of the file being written (for diagnostics)
+	RetryPolicy *RetryPolicy // Policy of retrying failed Close
 }
 
 var _ HdfsWriter = (*FaultTolerantHdfsWriter)(nil) // ensure FaultTolerantHdfsWriterImpl implements HdfsWriter
 // Creates new instance of FaultTolerantHdfsWriter
-func NewFaultTolerantHdfsWriter(impl HdfsWriter) HdfsWriter {
-	return &FaultTolerantHdfsWriter{Impl: impl}
+func NewFaultTolerantHdfsWriter(impl HdfsWriter, path string, retryPolicy *RetryPolicy) HdfsWriter {
+	return &FaultTolerantHdfsWriter{Impl: impl, Path: path, RetryPolicy: retryPolicy}
 }
 
 // Seeks to a given position
@@ -37,8 +39,14 @@ func (this *FaultTolerantHdfsWriter) Truncate() error {
 	return this.Impl.Truncate()
 }
 
-// Truncate the HDFS file at a given position
+// Closes the stream. Failed close is retried, since it can be safely repeated
+// (e.g. it fails while the last block is still being replicated)
 func (this *FaultTolerantHdfsWriter) Close() error {
-	// TODO: implement fault tolerance
-	return this.Impl.Close()
+	op := this.RetryPolicy.StartOperation()
+	for {
+		err := this.Impl.Close()
+		if err == nil || !op.ShouldRetry("[%s] Close: %s", this.Path, err) {
+			return err
+		}
+	}
 }
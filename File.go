@@ -5,11 +5,11 @@ package main
 import (
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
-	"fmt"
 	"golang.org/x/net/context"
-	"os/user"
+	"os"
 	"path"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -19,42 +19,139 @@ type File struct {
 	Parent     *Dir        // Pointer to the parent directory (allows computing fully-qualified paths on demand)
 
 	activeHandles      []*FileHandle // list of opened file handles
-	activeHandlesMutex sync.Mutex    // mutex for activeHandles
+	activeHandlesMutex sync.Mutex    // mutex for activeHandles (and consistency, compressed and transcoded sizes, createDeferred)
+	consistency        string        // consistency level of the handles opened on the file set by XattrConsistency ("" if not set)
+	compressedSize     uint64        // size of the content compressed on write, as stored in HDFS (0 if not written)
+	uncompressedSize   uint64        // size of the content compressed on write, as written by the application
+	attrsMutex         sync.Mutex    // serializes updates of Attrs made through handles (which may be used concurrently)
+	createDeferred     bool          // true if the new file isn't created in HDFS until its first flush (see FileSystem.DeferCreate)
+	deleted            bool          // true if the file has been removed while opened (see FileSystem.DeletedOpenAttrs)
+
+	prefetched    *PrefetchedFile // beginning of the file fetched before it was opened (nil if none)
+	idleReader    *IdleReader     // backend reader of the recently released handle (nil if none, see ParkReader)
+	prefetchMutex sync.Mutex      // mutex for prefetched and idleReader
+
+	transcodedSize    uint64         // size of the content transcoded on read (see FileSystem.Transcoding)
+	transcodedVersion ContentVersion // version of the content transcodedSize has been computed for (guarded by activeHandlesMutex)
+
+	modSeq        uint64         // last modification sequence reported for the file (see ModSeq, guarded by activeHandlesMutex)
+	modSeqVersion ContentVersion // version of the content modSeq has been assigned to (guarded by activeHandlesMutex)
+
+	readSlots     chan struct{} // bounds number of concurrent backend reads of the file (see AcquireReadSlot)
+	readSlotsOnce sync.Once     // creates readSlots on first use
+
+	coalescer     *ReadCoalescer // coalesces small reads of the handles (see FileSystem.CoalesceReadSize)
+	coalescerOnce sync.Once      // creates coalescer on first use
 }
 
 // Verify that *File implements necesary FUSE interfaces
 var _ fs.Node = (*File)(nil)
 var _ fs.NodeOpener = (*File)(nil)
 var _ fs.NodeFsyncer = (*File)(nil)
+var _ fs.NodeAccesser = (*File)(nil)
+var _ fs.NodeSetxattrer = (*File)(nil)
+var _ fs.NodeGetxattrer = (*File)(nil)
+var _ fs.NodeListxattrer = (*File)(nil)
 
 // File is also a factory for ReadSeekCloser objects
 var _ ReadSeekCloserFactory = (*File)(nil)
 
+// O_DIRECT open flag (not defined by bazil.org/fuse)
+const OpenDirect = fuse.OpenFlags(syscall.O_DIRECT)
+
 // Retunds absolute path of the file in HDFS namespace
 func (this *File) AbsolutePath() string {
-	return path.Join(this.Parent.AbsolutePath(), this.Attrs.Name)
+	return this.FileSystem.PathRewriter.Rewrite(path.Join(this.Parent.VirtualPath(), this.Attrs.Name))
 }
 
 // Responds to the FUSE file attribute request
 func (this *File) Attr(ctx context.Context, a *fuse.Attr) error {
-	if this.FileSystem.Clock.Now().After(this.Attrs.Expires) {
+	if tracer := this.FileSystem.Tracer; tracer != nil {
+		defer tracer.StartOperation("Getattr", this.AbsolutePath(), 0, 0).End()
+	}
+	// Handles with fresh consistency level bypass metadata cache
+	// File with deferred creation doesn't exist in HDFS yet, locally known attributes are used
+	if (this.FileSystem.Clock.Now().After(this.Attrs.Expires) || this.HasFreshHandles()) && !this.IsCreateDeferred() {
+		version := this.Attrs.ContentVersion()
 		err := this.Parent.LookupAttrs(this.Attrs.Name, &this.Attrs)
 		if err != nil {
 			return err
 		}
+		this.CheckContentVersion(version)
 	}
-	return this.Attrs.Attr(a)
+	this.FileSystem.AttrCache.Touch(this)
+	return this.presentAttr(a)
+}
+
+// Converts locally known attributes into FUSE representation, as reported to the client
+func (this *File) presentAttr(a *fuse.Attr) error {
+	if err := this.Attrs.Attr(a); err != nil {
+		return err
+	}
+	this.ApplyUncompressedSize(a)
+	this.ApplyTranscodedSize(a)
+	this.FileSystem.AttrOverrides.Apply(this.AbsolutePath(), a)
+	return nil
+}
+
+// Responds to the FUSE access request (checks effective permission of the caller)
+func (this *File) Access(ctx context.Context, req *fuse.AccessRequest) error {
+	// Refreshing expired attributes
+	if err := this.Attr(ctx, &fuse.Attr{}); err != nil {
+		return err
+	}
+	return this.FileSystem.CheckAccessRequest(this.AbsolutePath(), &this.Attrs, req)
 }
 
 // Responds to the FUSE file open request (creates new file handle)
 func (this *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if tracer := this.FileSystem.Tracer; tracer != nil {
+		defer tracer.StartOperation("Open", this.AbsolutePath(), 0, 0).End()
+	}
 	Info.Println("Open: ", this.AbsolutePath(), req.Flags)
+	if this.FileSystem.EnforcePermissions {
+		access := AccessRead
+		if req.Flags.IsWriteOnly() {
+			access = AccessWrite
+		} else if req.Flags.IsReadWrite() {
+			access = AccessRead | AccessWrite
+		}
+		this.FileSystem.LoadAcl(this.AbsolutePath(), &this.Attrs)
+		if !this.Attrs.CheckAccess(req.Header.Uid, req.Header.Gid, access) {
+			Warning.Println("Open: ", this.AbsolutePath(), "access denied for uid", req.Header.Uid)
+			return nil, fuse.Errno(syscall.EACCES)
+		}
+	}
+	if req.Flags.IsWriteOnly() || req.Flags&fuse.OpenTruncate == fuse.OpenTruncate {
+		if err := this.FileSystem.CheckOpEnabled("write", this.AbsolutePath()); err != nil {
+			return nil, err
+		}
+	}
+	if err := this.RevalidateOnOpen(); err != nil {
+		return nil, err
+	}
 	handle := NewFileHandle(this)
+	handle.ReadOnly = req.Flags.IsReadOnly()
+	handle.Append = req.Flags&fuse.OpenAppend == fuse.OpenAppend
+	handle.Uid, handle.Gid = req.Header.Uid, req.Header.Gid
+	if err := this.ApplyOpenFlags(handle, req.Flags, resp); err != nil {
+		return nil, err
+	}
 	if req.Flags.IsReadOnly() || req.Flags.IsReadWrite() {
+		if err := this.CheckInProgressRead(); err != nil {
+			return nil, err
+		}
 		err := handle.EnableRead()
 		if err != nil {
 			return nil, err
 		}
+		if this.FileSystem.PrefetchBlocks {
+			handle.BlockLocations()
+		}
+		if this.FileSystem.SequentialDirPrefetch {
+			// Client is likely to read the next file of the directory afterwards
+			this.Parent.PrefetchNext(this.Attrs.Name)
+		}
 	}
 
 	if req.Flags.IsWriteOnly() {
@@ -87,6 +184,42 @@ func (this *File) AddHandle(handle *FileHandle) {
 	this.activeHandles = append(this.activeHandles, handle)
 }
 
+// Returns true if the file has opened handles
+func (this *File) HasActiveHandles() bool {
+	this.activeHandlesMutex.Lock()
+	defer this.activeHandlesMutex.Unlock()
+	return len(this.activeHandles) > 0
+}
+
+// Marks whether creation of the new file in HDFS is deferred until its first flush
+func (this *File) SetCreateDeferred(deferred bool) {
+	this.activeHandlesMutex.Lock()
+	defer this.activeHandlesMutex.Unlock()
+	this.createDeferred = deferred
+}
+
+// Returns true if the new file hasn't been created in HDFS yet
+func (this *File) IsCreateDeferred() bool {
+	this.activeHandlesMutex.Lock()
+	defer this.activeHandlesMutex.Unlock()
+	return this.createDeferred
+}
+
+// Marks the file as removed if it has opened handles, returns true if it has been marked
+func (this *File) MarkDeletedIfOpen() bool {
+	this.activeHandlesMutex.Lock()
+	defer this.activeHandlesMutex.Unlock()
+	this.deleted = len(this.activeHandles) > 0
+	return this.deleted
+}
+
+// Returns true if the file has been removed while opened
+func (this *File) IsDeleted() bool {
+	this.activeHandlesMutex.Lock()
+	defer this.activeHandlesMutex.Unlock()
+	return this.deleted
+}
+
 // Unregisters an opened file handle
 func (this *File) RemoveHandle(handle *FileHandle) {
 	this.activeHandlesMutex.Lock()
@@ -108,14 +241,34 @@ func (this *File) GetActiveHandles() []*FileHandle {
 	return snapshot
 }
 
-// Responds to the FUSE Fsync request
+// Maximum number of handles of the same file flushed concurrently on fsync
+var MaxConcurrentFsyncs int = 4
+
+// Responds to the FUSE Fsync request. Handles are flushed in parallel (with bounded concurrency),
+// all of them are attempted, the error of the first failed handle is returned
 func (this *File) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
-	Info.Println("Dispatching fsync request to open handles: ", len(this.GetActiveHandles()))
+	handles := this.GetActiveHandles()
+	Info.Println("Dispatching fsync request to open handles: ", len(handles))
+	errs := make([]error, len(handles))
+	slots := make(chan struct{}, MaxConcurrentFsyncs)
+	var wg sync.WaitGroup
+	for i, handle := range handles {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, handle *FileHandle) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			errs[i] = handle.Fsync(ctx, req)
+		}(i, handle)
+	}
+	wg.Wait()
 	var retErr error
-	for _, handle := range this.GetActiveHandles() {
-		err := handle.Fsync(ctx, req)
+	for _, err := range errs {
 		if err != nil {
-			retErr = err
+			Error.Println("[", this.AbsolutePath(), "] fsync failed:", err)
+			if retErr == nil {
+				retErr = err
+			}
 		}
 	}
 	return retErr
@@ -123,57 +276,209 @@ func (this *File) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
 
 // Invalidates metadata cache, so next ls or stat gives up-to-date file attributes
 func (this *File) InvalidateMetadataCache() {
+	this.attrsMutex.Lock()
+	defer this.attrsMutex.Unlock()
 	this.Attrs.Expires = this.FileSystem.Clock.Now().Add(-1 * time.Second)
 }
 
-// Responds on FUSE Chmod request
+// Re-fetches attributes of the file from HDFS, bypassing metadata cache
+func (this *File) RefreshAttrs() error {
+	this.attrsMutex.Lock()
+	defer this.attrsMutex.Unlock()
+	return this.Parent.LookupAttrs(this.Attrs.Name, &this.Attrs)
+}
+
+// Records access time of the file
+func (this *File) TouchAtime(atime time.Time) {
+	this.attrsMutex.Lock()
+	defer this.attrsMutex.Unlock()
+	this.Attrs.Atime = atime
+}
+
+// Moves or deletes file after it has been fully read (see FileSystem.ReadOnceAction). The action is skipped
+// while the file is still opened by other handles. Same as explicit rename and remove, it is subject
+// to disabled operations, read-only windows and safe mode handling, and it is recorded in the audit log
+func (this *File) ApplyReadOnceAction(header fuse.Header) error {
+	hdfsAccessor := this.FileSystem.HdfsAccessor
+	absolutePath := this.AbsolutePath()
+	action := this.FileSystem.ReadOnceAction
+	if action != "delete" && action != "move" {
+		return nil
+	}
+	if len(this.GetActiveHandles()) > 0 {
+		Info.Println("[", absolutePath, "] Fully read, but still opened, skipping read-once action")
+		return nil
+	}
+	var err error
+	if action == "delete" {
+		if err = this.FileSystem.CheckOpEnabled("remove", absolutePath); err != nil {
+			return err
+		}
+		Info.Println("[", absolutePath, "] Fully read, deleting")
+		err = this.FileSystem.RunMutating("Remove", absolutePath, func() error {
+			return hdfsAccessor.Remove(absolutePath)
+		})
+		if err == nil {
+			this.FileSystem.Audit(header, "delete", absolutePath, "read-once")
+		}
+	} else {
+		if err = this.FileSystem.CheckOpEnabled("rename", absolutePath); err != nil {
+			return err
+		}
+		processedDir := this.Parent.AbsolutePathForChild(ReadOnceProcessedDir)
+		newPath := path.Join(processedDir, this.Attrs.Name)
+		Info.Println("[", absolutePath, "] Fully read, moving to", processedDir)
+		err = this.FileSystem.RunMutating("Rename", absolutePath, func() error {
+			err := hdfsAccessor.Mkdir(processedDir, 0755|os.ModeDir)
+			if err == nil || err == fuse.EEXIST {
+				err = hdfsAccessor.Rename(absolutePath, newPath)
+			}
+			return err
+		})
+		if err == nil {
+			this.FileSystem.Audit(header, "rename", absolutePath, newPath)
+		}
+	}
+	if err != nil {
+		Error.Println("[", absolutePath, "] Read-once action failed:", err)
+		return err
+	}
+	this.Parent.EntriesRemove(this.Attrs.Name)
+	return nil
+}
+
+// Responds on FUSE Setattr request. All the requested changes are applied in one pass: size first
+// (rewriting content changes modification time), then access/modification times, then mode and ownership.
+// Processing stops at the first failure, the response reflects the final attributes
 func (this *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
-	// Get the filepath, so chmod in hdfs can work
 	path := this.AbsolutePath()
-	var err error
+	if err := this.FileSystem.CheckSetattrEnabled(req, path); err != nil {
+		return err
+	}
 
-	if req.Valid.Mode() {
-		Info.Println("Chmod [", path, "] to [", req.Mode, "]")
-		(func() {
-			err = this.FileSystem.HdfsAccessor.Chmod(path, req.Mode)
-			if err != nil {
-				return
-			}
-		})()
+	if req.Valid.Size() {
+		Info.Println("Truncate [", path, "] to", req.Size)
+		if err := this.Truncate(req.Header, req.Size); err != nil {
+			Error.Println("Truncate [", path, "] failed with error:", err)
+			return err
+		}
+	}
 
+	if req.Valid.Atime() || req.Valid.Mtime() {
+		now := this.FileSystem.Clock.Now()
+		atime, mtime := this.Attrs.Atime, this.Attrs.Mtime
+		if req.Valid.AtimeNow() {
+			atime = now
+		} else if req.Valid.Atime() {
+			atime = req.Atime
+		}
+		if req.Valid.MtimeNow() {
+			mtime = now
+		} else if req.Valid.Mtime() {
+			mtime = req.Mtime
+		}
+		Info.Println("Chtimes [", path, "] to [", atime, ",", mtime, "]")
+		err := this.FileSystem.RunMutating("Chtimes", path, func() error {
+			// Timestamps are kept in local time frame, HDFS expects them in its own
+			return this.FileSystem.HdfsAccessor.Chtimes(path, this.FileSystem.HdfsTime(atime), this.FileSystem.HdfsTime(mtime))
+		})
 		if err != nil {
-			Error.Println("Chmod failed with error: ", err)
-		} else {
-			this.Attrs.Mode = req.Mode
+			Error.Println("Chtimes failed with error:", err)
+			return err
 		}
+		this.Attrs.Atime, this.Attrs.Mtime = atime, mtime
+		this.Attrs.MetadataChanged(now)
 	}
 
-	if req.Valid.Uid() {
-		u, err := user.LookupId(fmt.Sprint(req.Uid))
-		owner := fmt.Sprint(req.Uid)
-		group := fmt.Sprint(req.Gid)
+	if req.Valid.Mode() {
+		Info.Println("Chmod [", path, "] to [", req.Mode, "]")
+		err := this.FileSystem.RunMutating("Chmod", path, func() error {
+			return this.FileSystem.HdfsAccessor.Chmod(path, req.Mode)
+		})
 		if err != nil {
-			Error.Println("Chown: username for uid", req.Uid, "not found, use uid/gid instead")
-		} else {
-			owner = u.Username
-			group = owner // hardcoded the group same as owner
+			Error.Println("Chmod failed with error: ", err)
+			return err
 		}
+		this.Attrs.Mode = req.Mode
+		this.Attrs.MetadataChanged(this.FileSystem.Clock.Now())
+		this.FileSystem.Audit(req.Header, "chmod", path, req.Mode.String())
+	}
 
+	if req.Valid.Uid() || req.Valid.Gid() {
+		uid, gid := this.Attrs.Uid, this.Attrs.Gid
+		if req.Valid.Uid() {
+			uid = req.Uid
+		}
+		if req.Valid.Gid() {
+			gid = req.Gid
+		}
+		// Only the names being changed are sent, so HDFS keeps the other one as it is
+		owner, group := OwnerNames(uid, gid)
+		if !req.Valid.Uid() {
+			owner = ""
+		}
+		if !req.Valid.Gid() {
+			group = ""
+		}
 		Info.Println("Chown [", path, "] to [", owner, ":", group, "]")
-		(func() {
-			err = this.FileSystem.HdfsAccessor.Chown(path, fmt.Sprint(req.Uid), fmt.Sprint(req.Gid))
-			if err != nil {
-				return
-			}
-		})()
-
+		err := this.FileSystem.RunMutating("Chown", path, func() error {
+			return this.FileSystem.HdfsAccessor.Chown(path, owner, group)
+		})
 		if err != nil {
 			Error.Println("Chown failed with error:", err)
-		} else {
-			this.Attrs.Uid = req.Uid
-			this.Attrs.Gid = req.Gid
+			return err
+		}
+		this.Attrs.Uid = uid
+		this.Attrs.Gid = gid
+		this.Attrs.MetadataChanged(this.FileSystem.Clock.Now())
+		this.FileSystem.Audit(req.Header, "chown", path, owner+":"+group)
+	}
+
+	return this.presentAttr(&resp.Attr)
+}
+
+// Changes size of the file, cutting its content or extending it with zeroes. If the file is being written
+// via one of its handles, the staged content is truncated (and written on flush), otherwise the file is rewritten
+func (this *File) Truncate(header fuse.Header, size uint64) error {
+	version := this.Attrs.ContentVersion()
+	truncated, err := this.truncateActiveWriter(size)
+	if !truncated && err == nil {
+		handle := NewFileHandle(this)
+		handle.Uid, handle.Gid = header.Uid, header.Gid
+		if err = handle.EnableWrite(size == 0); err != nil {
+			return err
+		}
+		if size > 0 {
+			if err = handle.Writer.Truncate(size); err == nil {
+				err = handle.Writer.Flush()
+			}
+		}
+		if closeErr := handle.Writer.Close(); err == nil {
+			err = closeErr
 		}
 	}
+	if err != nil {
+		return err
+	}
+	this.Attrs.Size = size
+	this.Attrs.Mtime = this.FileSystem.Clock.Now()
+	this.CheckContentVersion(version)
+	return nil
+}
 
-	return err
+// Truncates content staged by the handle which writes the file, returns false if there is no such handle
+func (this *File) truncateActiveWriter(size uint64) (bool, error) {
+	for _, handle := range this.GetActiveHandles() {
+		handle.Mutex.Lock()
+		writer := handle.Writer
+		var err error
+		if writer != nil {
+			err = writer.Truncate(size)
+		}
+		handle.Mutex.Unlock()
+		if writer != nil {
+			return true, err
+		}
+	}
+	return false, nil
 }
@@ -24,11 +24,12 @@ func (this *FileFragment) ReadFromBackend(hdfsReader ReadSeekCloser, offset *int
 	var nr int
 	for totalRead < minBytesToRead {
 		nr, err = hdfsReader.Read(this.Data[totalRead:maxBytesToRead])
+		// Bytes returned together with an error are kept, since backend reader has advanced past them
+		*offset += int64(nr)
+		totalRead += nr
 		if err != nil {
 			break
 		}
-		*offset += int64(nr)
-		totalRead += nr
 	}
 	this.Data = this.Data[0:totalRead]
 	return err
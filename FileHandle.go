@@ -7,6 +7,8 @@ import (
 	"bazil.org/fuse/fs"
 	"golang.org/x/net/context"
 	"sync"
+	"sync/atomic"
+	"syscall"
 )
 
 // Represends a handle to an open file
@@ -14,7 +16,22 @@ type FileHandle struct {
 	File   *File
 	Reader *FileHandleReader
 	Writer *FileHandleWriter
+	Direct bool       // true if opened with O_DIRECT: reads and writes bypass buffering of the handle
+	Fresh  bool       // true if the handle uses fresh consistency level: metadata and content caches are bypassed
 	Mutex  sync.Mutex // all operations on the handle are serialized to simplify invariants
+
+	WriteThrough bool // true if every write is flushed to HDFS pipeline before it is acknowledged, when the file is written sequentially (see OpenFlagModes)
+	ReadOnly     bool // true if opened read-only, writes are rejected with EBADF (with RejectReadOnlyWrites enabled)
+	Append       bool // true if opened with O_APPEND, writes might use HDFS append (see AppendMode)
+	NoAtime      bool // true if reads through the handle don't update access time of the file
+	atimeUpdated bool // true once access time has been updated by this handle
+
+	Uid uint32 // identity of the process which opened the handle (its staging files are isolated with PerUserStaging)
+	Gid uint32
+
+	contentChanged int32           // set to 1 (atomically) once file content version changes, buffered content is discarded on next read
+	blocks         []BlockLocation // blocks of the file, used to serve reads from the content-addressed cache and to select replicas
+	blocksFetched  bool            // true once blocks have been retrieved
 }
 
 // Verify that *FileHandle implements necesary FUSE interfaces
@@ -57,14 +74,31 @@ func (this *FileHandle) EnableWrite(newFile bool) error {
 
 // Returns attributes of the file associated with this handle
 func (this *FileHandle) Attr(ctx context.Context, a *fuse.Attr) error {
+	if this.File.FileSystem.DeletedOpenAttrs && this.File.IsDeleted() {
+		// File removed while opened still exists for the holder of the handle, last known attributes are served
+		return this.File.presentAttr(a)
+	}
 	return this.File.Attr(ctx, a)
 }
 
 // Responds to FUSE Read request
 func (this *FileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	if tracer := this.File.FileSystem.Tracer; tracer != nil {
+		defer tracer.StartOperation("Read", this.File.AbsolutePath(), req.Offset, req.Size).End()
+	}
 	this.Mutex.Lock()
 	defer this.Mutex.Unlock()
 
+	if atomic.CompareAndSwapInt32(&this.contentChanged, 1, 0) && this.Reader != nil {
+		// File has been overwritten, re-opening backend reader and discarding buffers
+		this.Reader.Close()
+		this.Reader = nil
+		this.blocks = nil
+		this.blocksFetched = false
+		if err := this.EnableRead(); err != nil {
+			return err
+		}
+	}
 	if this.Reader == nil {
 		Warning.Println("[", this.File.AbsolutePath(), "] reading file opened for write @", req.Offset)
 		err := this.EnableRead()
@@ -73,26 +107,55 @@ func (this *FileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *f
 		}
 	}
 
+	this.TouchAtime()
+	if this.ReadDeduplicated(req, resp) || this.ReadParallelBlocks(req, resp) || this.ReadCoalesced(req, resp) {
+		return nil
+	}
 	return this.Reader.Read(this, ctx, req, resp)
 }
 
 // Responds to FUSE Write request
 func (this *FileHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	if tracer := this.File.FileSystem.Tracer; tracer != nil {
+		defer tracer.StartOperation("Write", this.File.AbsolutePath(), req.Offset, len(req.Data)).End()
+	}
+	if err := this.File.FileSystem.CheckOpEnabled("write", this.File.AbsolutePath()); err != nil {
+		return err
+	}
 	this.Mutex.Lock()
 	defer this.Mutex.Unlock()
+	if this.Writer == nil && this.ReadOnly && this.File.FileSystem.RejectReadOnlyWrites {
+		Warning.Println("[", this.File.AbsolutePath(), "] Write to the handle opened read-only")
+		return fuse.Errno(syscall.EBADF)
+	}
 	if this.Writer == nil {
+		if err := this.ValidateFreshness(); err != nil {
+			return err
+		}
 		err := this.EnableWrite(false)
 		if err != nil {
 			return err
 		}
 	}
-	return this.Writer.Write(this, ctx, req, resp)
+	if err := this.Writer.Write(this, ctx, req, resp); err != nil || !this.WriteThrough || !this.Writer.IsStreamed() {
+		// Staged content of write-through handles is uploaded on fsync and close only, not re-uploaded on every write
+		return err
+	}
+	return this.Writer.Flush()
 }
 
-// Responds to the FUSE Flush request
+// Responds to the FUSE Flush request (sent on every close of a file descriptor).
+// With LazyCloseFlush, written data stays staged until fsync or release of the handle
 func (this *FileHandle) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	if tracer := this.File.FileSystem.Tracer; tracer != nil {
+		defer tracer.StartOperation("Flush", this.File.AbsolutePath(), 0, 0).End()
+	}
 	this.Mutex.Lock()
 	defer this.Mutex.Unlock()
+	if this.Writer != nil && this.File.FileSystem.LazyCloseFlush {
+		Info.Println("[", this.File.AbsolutePath(), "] flush on close deferred until fsync or release")
+		return nil
+	}
 	if this.Writer != nil {
 		return this.Writer.Flush()
 	}
@@ -101,6 +164,9 @@ func (this *FileHandle) Flush(ctx context.Context, req *fuse.FlushRequest) error
 
 // Responds to the FUSE Fsync request
 func (this *FileHandle) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
+	if tracer := this.File.FileSystem.Tracer; tracer != nil {
+		defer tracer.StartOperation("Fsync", this.File.AbsolutePath(), 0, 0).End()
+	}
 	this.Mutex.Lock()
 	defer this.Mutex.Unlock()
 	if this.Writer != nil {
@@ -111,17 +177,52 @@ func (this *FileHandle) Fsync(ctx context.Context, req *fuse.FsyncRequest) error
 
 // Closes the handle
 func (this *FileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	readOnceActionRequired := this.File.FileSystem.ReadOnceAction != "" && this.Writer == nil &&
+		this.Reader != nil && this.Reader.IsFullyRead()
 	if this.Reader != nil {
+		this.Reader.DiscardReadahead()
+		if this.Writer == nil && this.Reader.HdfsReader != nil && this.File.ParkReader(this.Reader.HdfsReader) {
+			// Backend reader is kept open for quick reopen of the file
+			this.Reader.HdfsReader = nil
+		}
 		err := this.Reader.Close()
 		Info.Println("[", this.File.AbsolutePath(), "] Close/Read: err=", err)
 		this.Reader = nil
 	}
+	var releaseErr error
 	if this.Writer != nil {
+		if writeConfirmation := this.File.FileSystem.WriteConfirmation; writeConfirmation != "" {
+			releaseErr = this.Writer.Flush()
+			if releaseErr == nil {
+				releaseErr = this.Writer.Confirm(writeConfirmation == "checksum")
+			}
+		}
+		size := this.Writer.Size()
 		err := this.Writer.Close()
 		Info.Println("[", this.File.AbsolutePath(), "] Close/Write: err=", err)
+		if err != nil && this.File.FileSystem.RetryClose && releaseErr == nil {
+			// Close has failed despite retries, reporting it instead of losing the data silently
+			releaseErr = err
+		}
+		if err == nil && releaseErr == nil {
+			this.File.FileSystem.NotifyFileClosed(this.File.AbsolutePath(), size)
+		}
+		// Backend reader kept open since the last read and prefetched beginning don't reflect the new content
+		this.File.DropIdleReader()
+		this.File.DiscardPrefetched()
 		this.Writer = nil
 	}
 	this.File.InvalidateMetadataCache()
 	this.File.RemoveHandle(this)
+	if releaseErr != nil {
+		return releaseErr
+	}
+	if readOnceActionRequired {
+		var header fuse.Header
+		if req != nil {
+			header = req.Header
+		}
+		return this.File.ApplyReadOnceAction(header)
+	}
 	return nil
 }
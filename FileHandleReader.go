@@ -7,6 +7,8 @@ import (
 	"errors"
 	"golang.org/x/net/context"
 	"io"
+	"io/ioutil"
+	"syscall"
 )
 
 // Encapsulates state and routines for reading data from the file handle
@@ -16,37 +18,171 @@ import (
 type FileHandleReader struct {
 	Handle     *FileHandle    // File handle
 	HdfsReader ReadSeekCloser // Backend reader
-	Offset     int64          // Current offset for backend reader
+	Offset     int64          // Current offset for backend reader (invariant: always equals position of HdfsReader, so forward reads never Seek)
 	Buffer1    *FileFragment  // Most recent fragment from the backend reader
 	Buffer2    *FileFragment  // Least recent fragment read from the backend
 	Holes      int64          // tracks number of encountered "holes" TODO: find better name
 	CacheHits  int64          // tracks number of cache hits (read requests from buffer)
 	Seeks      int64          // tracks number of seeks performed on the backend stream
+	WholeFile  bool           // true if entire file content is held in Buffer1 (backend reader is closed)
+	ReadEnd    int64          // end of the contiguous range of the file read by the client starting from offset 0
+	ReachedEOF bool           // true if the client has read the contiguous range up to the end of file
+	BlockSize  int            // granularity of reads from the backend (depends on the read strategy)
+	Direct     bool           // true if reads bypass buffering (O_DIRECT), each one is served by a backend read at the exact offset
+	Recent     RangeCache     // ranges recently returned to the client (served again without backend fetch)
+
+	LastReadEnd     int64           // end offset of the most recent read request
+	SequentialReads int             // number of consecutive read requests (including current one), each starting where the previous one ended
+	readaheadBytes  int64           // bytes of PrefetchBudget reserved by the read-ahead data of the most recent backend read
+	readahead       *readaheadFetch // background read-ahead of the data following the most recent backend read (nil if none)
 }
 
 // Opens the reader (creates backend reader)
 func NewFileHandleReader(handle *FileHandle) (*FileHandleReader, error) {
 	this := &FileHandleReader{Handle: handle}
-	var err error
-	this.HdfsReader, err = handle.File.FileSystem.HdfsAccessor.OpenRead(handle.File.AbsolutePath())
-	if err != nil {
-		Error.Println("[", handle.File.AbsolutePath(), "] Opening: ", err)
-		return nil, err
-	}
 	this.Buffer1 = &FileFragment{}
 	this.Buffer2 = &FileFragment{}
+	var err error
+	var prefetched *PrefetchedFile
+	if !handle.Fresh {
+		prefetched = handle.File.TakePrefetched()
+		if prefetched == nil {
+			// File might have been recently closed, reusing its backend reader (rewound to offset 0)
+			this.HdfsReader = handle.File.TakeIdleReader()
+		}
+	}
+	if prefetched != nil {
+		// Beginning of the file has been prefetched, continuing from there
+		this.HdfsReader = prefetched.Reader
+		this.Buffer1 = prefetched.Fragment
+		this.Offset = prefetched.Offset
+		if prefetched.Blocks != nil {
+			handle.blocks, handle.blocksFetched = prefetched.Blocks, true
+		}
+	} else if this.HdfsReader == nil {
+		this.HdfsReader, err = handle.File.FileSystem.HdfsAccessor.OpenRead(handle.File.AbsolutePath())
+		if err != nil {
+			Error.Println("[", handle.File.AbsolutePath(), "] Opening: ", err)
+			return nil, err
+		}
+		this.HdfsReader = handle.File.LimitReads(this.HdfsReader)
+	}
+	this.BlockSize = BLOCKSIZE
+	if handle.File.IsTranscoded() {
+		// Transcoding changes lengths, so entire file is transcoded in memory to serve reads at any offset
+		if err = this.ReadWholeFile(); err != nil {
+			Error.Println("[", handle.File.AbsolutePath(), "] Reading file to transcode: ", err)
+			this.Close()
+			if err == ErrWholeFileTooLarge {
+				// File has grown beyond the limit since it was stat'ed
+				return nil, fuse.Errno(syscall.EFBIG)
+			}
+			return nil, err
+		}
+		this.Buffer1.Data = handle.File.Transcode(this.Buffer1.Data)
+		this.Offset = int64(len(this.Buffer1.Data))
+		return this, nil
+	}
+	this.Direct = handle.Direct || handle.Fresh
+	if this.Direct {
+		return this, nil
+	}
+	strategy := handle.File.FileSystem.ReadStrategies.Match(handle.File.Attrs.Name)
+	switch strategy {
+	case ReadStrategySequential:
+		this.BlockSize = SequentialBlockSize
+	case ReadStrategyRandom:
+		this.BlockSize = RandomBlockSize
+	}
+	threshold := handle.File.FileSystem.SmallFileThreshold
+	if strategy == ReadStrategyWholeFile || (threshold > 0 && handle.File.Attrs.Size < threshold) {
+		if handle.File.Attrs.Size > WholeFileMaxSize {
+			Info.Println("[", handle.File.AbsolutePath(), "] File is too large to be read into memory:", handle.File.Attrs.Size, "bytes")
+			return this, nil
+		}
+		err = this.ReadWholeFile()
+		if err == ErrWholeFileTooLarge {
+			// File has grown since it was stat'ed, the rest is read through the buffers
+			Info.Println("[", handle.File.AbsolutePath(), "] File is too large to be read into memory, read", this.Offset, "bytes")
+			err = nil
+		}
+		if err != nil {
+			Error.Println("[", handle.File.AbsolutePath(), "] Reading small file: ", err)
+			this.Close()
+			return nil, err
+		}
+	}
 	return this, nil
 }
 
+// Maximum size of the file read entirely into memory (by whole-file read strategy or small file threshold),
+// larger files are read through the buffers
+var WholeFileMaxSize uint64 = 64 * 1024 * 1024
+
+// Returned by ReadWholeFile if the file is larger than WholeFileMaxSize
+var ErrWholeFileTooLarge = errors.New("file is too large to be read into memory")
+
+// Reads entire content of the file into Buffer1 and closes backend reader,
+// so all the subsequent reads are served from memory.
+// Buffer1 is expected to hold the data already read from the beginning of the file (if any).
+// If the file turns out to be larger than WholeFileMaxSize, ErrWholeFileTooLarge is returned:
+// backend reader is kept open and Buffer1 holds the data read so far
+func (this *FileHandleReader) ReadWholeFile() error {
+	limit := int64(WholeFileMaxSize) - int64(len(this.Buffer1.Data)) + 1
+	data, err := ioutil.ReadAll(io.LimitReader(this.HdfsReader, limit))
+	if err != nil {
+		return err
+	}
+	data = append(this.Buffer1.Data, data...)
+	if uint64(len(data)) > WholeFileMaxSize {
+		this.Buffer1 = &FileFragment{Offset: 0, Data: data}
+		this.Offset = int64(len(data))
+		return ErrWholeFileTooLarge
+	}
+	this.Buffer1 = &FileFragment{Offset: 0, Data: data}
+	this.Offset = int64(len(data))
+	this.WholeFile = true
+	Info.Println("[", this.Handle.File.AbsolutePath(), "] Read whole file into memory:", len(data), "bytes")
+	this.HdfsReader.Close()
+	this.HdfsReader = nil
+	return nil
+}
+
 // Responds on FUSE Read request. Note: If FUSE requested to read N bytes it expects exactly N, unless EOF
 func (this *FileHandleReader) Read(handle *FileHandle, ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
 	totalRead := 0
 	buf := resp.Data[0:req.Size]
 	fileOffset := req.Offset
+	if req.Offset == this.LastReadEnd {
+		this.SequentialReads++
+	} else {
+		this.SequentialReads = 1
+	}
 	var nr int
 	var err error
+	followedGrowth := false
+	cached := !this.Direct && this.Recent.Read(fileOffset, buf)
+	if cached {
+		this.CacheHits++
+		totalRead = len(buf)
+		buf = buf[len(buf):]
+	}
 	for len(buf) > 0 {
-		nr, err = this.ReadPartial(handle, fileOffset, buf)
+		err = handle.File.FileSystem.RunIdempotent(ctx, "Read", func() error {
+			var partialErr error
+			nr, partialErr = this.ReadPartial(handle, fileOffset, buf)
+			return partialErr
+		})
+		if IsMissingBlockError(err) {
+			nr, err = this.ReadMissingBlock(handle, fileOffset, buf, err)
+		}
+		if err == io.EOF && handle.File.FileSystem.FollowGrowth && !followedGrowth {
+			// Checking (once per request) whether the file has grown since the backend reader was opened
+			followedGrowth = true
+			if this.ReopenIfGrown(handle, fileOffset) {
+				continue
+			}
+		}
 		if err != nil {
 			break
 		}
@@ -55,6 +191,20 @@ func (this *FileHandleReader) Read(handle *FileHandle, ctx context.Context, req
 		buf = buf[nr:]
 	}
 	resp.Data = resp.Data[0:totalRead]
+	if !cached && !this.Direct && !this.WholeFile && err == nil && this.SequentialReads <= 1 && handle.File.CachePolicy() != CachePolicyNone {
+		// Sequential reads aren't cached, since they are unlikely to be repeated (nor are reads of one-time-read data)
+		this.Recent.Add(req.Offset, resp.Data)
+	}
+	this.LastReadEnd = req.Offset + int64(totalRead)
+	if req.Offset <= this.ReadEnd {
+		// Extending contiguous range which has been read by the client
+		if end := req.Offset + int64(totalRead); end > this.ReadEnd {
+			this.ReadEnd = end
+		}
+		if err == io.EOF {
+			this.ReachedEOF = true
+		}
+	}
 	if err == io.EOF {
 		// EOF isn't a error, reporting successful read to FUSE
 		return nil
@@ -63,10 +213,18 @@ func (this *FileHandleReader) Read(handle *FileHandle, ctx context.Context, req
 	}
 }
 
+// Default granularity of reads from the backend
 var BLOCKSIZE int = 65536
 
+// Size of the backend reads once aggressive read-ahead is triggered by sequential access pattern
+var READAHEADSIZE int = 1024 * 1024
+
 // Reads chunk of data (satisfies part of FUSE read request)
 func (this *FileHandleReader) ReadPartial(handle *FileHandle, fileOffset int64, buf []byte) (int, error) {
+	if this.Direct {
+		return this.ReadDirect(handle, fileOffset, buf)
+	}
+
 	// First checking whether we can satisfy request from buffered file fragments
 	var nr int
 	if this.Buffer1.ReadFromBuffer(fileOffset, buf, &nr) || this.Buffer2.ReadFromBuffer(fileOffset, buf, &nr) {
@@ -74,6 +232,20 @@ func (this *FileHandleReader) ReadPartial(handle *FileHandle, fileOffset int64,
 		return nr, nil
 	}
 
+	if this.WholeFile {
+		// Entire file is in memory, reading beyond it means EOF
+		return 0, io.EOF
+	}
+
+	// Sequential scan is served by the data read ahead in background (if any), which continues with the next window
+	if taken, err := this.takeReadahead(); taken && this.Buffer1.ReadFromBuffer(fileOffset, buf, &nr) {
+		this.CacheHits++
+		if err == nil {
+			this.startReadahead()
+		}
+		return nr, nil
+	}
+
 	// None of the buffers has the data to satisfy the request, we're going to read more data from backend into Buffer1
 
 	// Before doing that, swapping buffers to keep MRU/LRU invariant
@@ -85,7 +257,7 @@ func (this *FileHandleReader) ReadPartial(handle *FileHandle, fileOffset int64,
 	if fileOffset != this.Offset {
 		// We're reading not from the offset expected by the backend stream
 		// we need to decide whether we do Seek(), or read the skipped data (refered as "hole" below)
-		if fileOffset > this.Offset && fileOffset-this.Offset <= int64(BLOCKSIZE*2) {
+		if fileOffset > this.Offset && fileOffset-this.Offset <= int64(this.BlockSize*2) {
 			holeSize := int(fileOffset - this.Offset)
 			this.Holes++
 			maxBytesToRead += holeSize    // we're going to read the "hole"
@@ -94,7 +266,7 @@ func (this *FileHandleReader) ReadPartial(handle *FileHandle, fileOffset int64,
 			this.Seeks++
 			err := this.HdfsReader.Seek(fileOffset)
 			// If seek error happens, return err. Seek to the end of the file is not an error.
-			if err != nil && this.Offset > fileOffset{
+			if err != nil && this.Offset > fileOffset {
 				Error.Println("[seek", handle.File.AbsolutePath(), " @offset:", this.Offset, "] Seek error to", fileOffset, "(file offset):", err.Error())
 				return 0, err
 			}
@@ -102,27 +274,119 @@ func (this *FileHandleReader) ReadPartial(handle *FileHandle, fileOffset int64,
 		}
 	}
 
-	// Ceiling to the nearest BLOCKSIZE
-	maxBytesToRead = (maxBytesToRead + BLOCKSIZE - 1) / BLOCKSIZE * BLOCKSIZE
+	// Ceiling to the nearest block size
+	maxBytesToRead = (maxBytesToRead + this.BlockSize - 1) / this.BlockSize * this.BlockSize
+
+	// Reading ahead aggressively only after enough consecutive sequential reads,
+	// so short files accessed once don't waste bandwidth
+	// (read-ahead data of the previous backend read is being replaced, returning its share of the prefetch budget)
+	budget := this.Handle.File.FileSystem.PrefetchBudget
+	budget.Release(this.readaheadBytes)
+	this.readaheadBytes = 0
+	trigger := this.Handle.File.FileSystem.ReadaheadTriggerCount
+	if trigger > 0 && this.SequentialReads > trigger && maxBytesToRead < READAHEADSIZE && budget.TryAcquire(int64(READAHEADSIZE)) {
+		maxBytesToRead = READAHEADSIZE
+		this.readaheadBytes = int64(READAHEADSIZE)
+	}
+
+	// Bounding size of a single backend read (at least up to the requested offset),
+	// the rest of the request is served by subsequent backend reads
+	if limit := this.Handle.File.FileSystem.BackendReadSize; limit > 0 && maxBytesToRead > limit {
+		maxBytesToRead = limit
+		if maxBytesToRead < minBytesToRead {
+			maxBytesToRead = minBytesToRead
+		}
+	}
 
 	// Reading from backend into Buffer1
 	err := this.Buffer1.ReadFromBackend(this.HdfsReader, &this.Offset, minBytesToRead, maxBytesToRead)
-	if err != nil {
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	// Now Buffer1 has the data to satisfy request, unless EOF was reached before the requested offset
+	if !this.Buffer1.ReadFromBuffer(fileOffset, buf, &nr) {
 		if err == io.EOF {
 			Warning.Println("[", handle.File.AbsolutePath(), "] EOF @", this.Offset)
 			return 0, err
 		}
-		return 0, err
-	}
-	// Now Buffer1 has the data to satisfy request
-	if !this.Buffer1.ReadFromBuffer(fileOffset, buf, &nr) {
 		return 0, errors.New("INTERNAL ERROR: FileFragment invariant")
 	}
+	if err == nil {
+		this.startReadahead()
+	}
 	return nr, nil
 }
 
+// Reads chunk of data directly from the backend at the requested offset, bypassing buffers (O_DIRECT)
+func (this *FileHandleReader) ReadDirect(handle *FileHandle, fileOffset int64, buf []byte) (int, error) {
+	if fileOffset != this.Offset {
+		this.Seeks++
+		err := this.HdfsReader.Seek(fileOffset)
+		// Same as for buffered reads, seek to the end of the file is not an error
+		if err != nil && this.Offset > fileOffset {
+			Error.Println("[seek", handle.File.AbsolutePath(), " @offset:", this.Offset, "] Seek error to", fileOffset, "(file offset):", err.Error())
+			return 0, err
+		}
+		this.Offset = fileOffset
+	}
+	if limit := handle.File.FileSystem.BackendReadSize; limit > 0 && len(buf) > limit {
+		buf = buf[:limit]
+	}
+	nr, err := this.HdfsReader.Read(buf)
+	this.Offset += int64(nr)
+	if nr > 0 {
+		// Error (if any) is going to be reported by the next read
+		return nr, nil
+	}
+	return 0, err
+}
+
+// Re-stats the file after reaching EOF at a given offset, and if the file has grown beyond it,
+// reopens the backend reader at the current offset, so the new data can be read. Returns true if reopened
+func (this *FileHandleReader) ReopenIfGrown(handle *FileHandle, eofOffset int64) bool {
+	path := handle.File.AbsolutePath()
+	hdfsAccessor := handle.File.FileSystem.HdfsAccessor
+	attrs, err := hdfsAccessor.Stat(path)
+	if err != nil {
+		Warning.Println("[", path, "] Can't stat file to check for growth:", err)
+		return false
+	}
+	if int64(attrs.Size) <= eofOffset {
+		return false
+	}
+	Info.Println("[", path, "] File has grown from", eofOffset, "to", attrs.Size, "bytes, reopening @", this.Offset)
+	reader, err := hdfsAccessor.OpenRead(path)
+	if err != nil {
+		Warning.Println("[", path, "] Can't reopen grown file:", err)
+		return false
+	}
+	if this.Offset > 0 {
+		if err = reader.Seek(this.Offset); err != nil {
+			Warning.Println("[", path, "] Can't seek reopened file to", this.Offset, ":", err)
+			reader.Close()
+			return false
+		}
+	}
+	if this.HdfsReader != nil {
+		this.HdfsReader.Close()
+	}
+	this.HdfsReader = handle.File.LimitReads(reader)
+	this.WholeFile = false
+	this.ReachedEOF = false
+	handle.File.Attrs.Size = attrs.Size
+	return true
+}
+
+// Returns true if the client has read entire content of the file
+func (this *FileHandleReader) IsFullyRead() bool {
+	return this.ReachedEOF || (this.Handle.File.Attrs.Size > 0 && this.ReadEnd >= int64(this.Handle.File.Attrs.Size))
+}
+
 // Closes the reader
 func (this *FileHandleReader) Close() error {
+	this.DiscardReadahead()
+	this.Handle.File.FileSystem.PrefetchBudget.Release(this.readaheadBytes)
+	this.readaheadBytes = 0
 	if this.HdfsReader != nil {
 		Info.Println("[", this.Handle.File.AbsolutePath(), "] ReadStats: holes:", this.Holes, ", cache hits:", this.CacheHits, ", hard seeks:", this.Seeks)
 		this.HdfsReader.Close()
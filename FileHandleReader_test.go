@@ -4,11 +4,18 @@ package main
 
 import (
 	"bazil.org/fuse"
+	"bytes"
+	"fmt"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"io"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 // Testing reading of an empty file
@@ -42,6 +49,112 @@ func TestSmallFileSequentialRead(t *testing.T) {
 	handle.Release(nil, nil)
 }
 
+// Small file is fetched from backend entirely on open, all the reads are served from memory
+func TestSmallFileWholeRead(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(&MockClock{}), &MockClock{})
+	fs.SmallFileThreshold = 1024
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/small.dat").Return(Attrs{Name: "small.dat", Size: 11}, nil)
+	file, _ := root.(*Dir).Lookup(nil, "small.dat")
+
+	// Single backend fetch of the whole content, after which backend reader is closed
+	hdfsAccessor.EXPECT().OpenRead("/small.dat").Return(hdfsReader, nil).Times(1)
+	hdfsReader.whenReadReturn([]byte("HelloWorld!"), nil)
+	hdfsReader.whenReadReturn([]byte{}, io.EOF)
+	hdfsReader.EXPECT().Close().Return(nil).Times(1)
+	h, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+	assert.Nil(t, err)
+	handle := h.(*FileHandle)
+	assert.True(t, handle.Reader.WholeFile)
+
+	// No more backend calls are expected for the reads below
+	handle.readAndVerify(t, 5, 6, []byte("World!"))
+	handle.readAndVerify(t, 0, 5, []byte("Hello"))
+	handle.readAndVerify(t, 3, 1024, []byte("loWorld!"))
+	handle.readAndVerify(t, 11, 1024, []byte{})
+	handle.Release(nil, nil)
+}
+
+// Files larger than WholeFileMaxSize aren't read into memory on open, but through the buffers
+func TestWholeFileSizeCap(t *testing.T) {
+	defer func(size uint64) { WholeFileMaxSize = size }(WholeFileMaxSize)
+	WholeFileMaxSize = 8
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(&MockClock{}), &MockClock{})
+	fs.ReadStrategies, _ = NewReadStrategies("*.dat=whole-file")
+	root, _ := fs.Root()
+
+	// Size known to exceed the cap: nothing is read on open
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().Stat("/large.dat").Return(Attrs{Name: "large.dat", Size: 11}, nil)
+	hdfsAccessor.EXPECT().OpenRead("/large.dat").Return(hdfsReader, nil)
+	file, _ := root.(*Dir).Lookup(nil, "large.dat")
+	h, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+	assert.Nil(t, err)
+	handle := h.(*FileHandle)
+	assert.False(t, handle.Reader.WholeFile)
+	hdfsReader.whenReadReturn([]byte("HelloWorld!"), nil)
+	handle.readAndVerify(t, 0, 11, []byte("HelloWorld!"))
+	hdfsReader.EXPECT().Close().Return(nil)
+	handle.Release(nil, nil)
+
+	// File has grown past the cap since it was stat'ed: data read on open is kept, the rest is read through the buffers
+	hdfsReader = NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().Stat("/grown.dat").Return(Attrs{Name: "grown.dat", Size: 5}, nil)
+	hdfsAccessor.EXPECT().OpenRead("/grown.dat").Return(hdfsReader, nil)
+	file, _ = root.(*Dir).Lookup(nil, "grown.dat")
+	hdfsReader.whenReadReturn([]byte("Hello"), nil)
+	hdfsReader.whenReadReturn([]byte("Worl"), nil)
+	h, err = file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+	assert.Nil(t, err)
+	handle = h.(*FileHandle)
+	assert.False(t, handle.Reader.WholeFile)
+	handle.readAndVerify(t, 0, 9, []byte("HelloWorl"))
+	hdfsReader.whenReadReturn([]byte("d!"), nil)
+	hdfsReader.whenReadReturn([]byte{}, io.EOF)
+	handle.readAndVerify(t, 9, 1024, []byte("d!"))
+	hdfsReader.EXPECT().Close().Return(nil)
+	handle.Release(nil, nil)
+}
+
+// Read strategy is selected by the file name, determining size of the backend reads
+func TestReadStrategyByExtension(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(&MockClock{}), &MockClock{})
+	fs.ReadStrategies, _ = NewReadStrategies("*.parquet=random,*.log=sequential")
+	root, _ := fs.Root()
+	backendReadSizes := make(map[string]int)
+	for _, name := range []string{"data.parquet", "app.log"} {
+		hdfsReader := NewMockReadSeekCloser(mockCtrl)
+		hdfsAccessor.EXPECT().Stat("/"+name).Return(Attrs{Name: name, Size: 1 << 30}, nil)
+		hdfsAccessor.EXPECT().OpenRead("/"+name).Return(hdfsReader, nil)
+		file, _ := root.(*Dir).Lookup(nil, name)
+		h, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+		assert.Nil(t, err)
+		handle := h.(*FileHandle)
+		fileName := name
+		hdfsReader.EXPECT().Read(gomock.Any()).Do(func(buf []byte) {
+			backendReadSizes[fileName] = len(buf)
+		}).Return(10, nil)
+		handle.readAndVerify(t, 0, 10, make([]byte, 10))
+		hdfsReader.EXPECT().Close().Return(nil)
+		handle.Release(nil, nil)
+	}
+	assert.Equal(t, RandomBlockSize, backendReadSizes["data.parquet"])
+	assert.Equal(t, SequentialBlockSize, backendReadSizes["app.log"])
+	assert.True(t, backendReadSizes["data.parquet"] < backendReadSizes["app.log"])
+
+	// Files not matching any of the rules are read using default block size
+	assert.Equal(t, "", fs.ReadStrategies.Match("data.csv"))
+	_, err := NewReadStrategies("*.csv=unknown")
+	assert.NotNil(t, err)
+}
+
 // If reads are reordered but not far away from each other
 // this should not cause Seek() on the backend HDFS reader
 func TestReoderedReadsDontCauseSeek(t *testing.T) {
@@ -138,10 +251,137 @@ func RandomAccess(t *testing.T, fileSize int64, maxRead int) {
 	assert.True(t, hdfsReader.IsClosed)
 }
 
+// Long sequence of forward reads spanning many buffer refills never seeks the backend reader
+func TestForwardReadsDontSeek(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	r := rand.New(rand.NewSource(0))
+	fileSize := int64(20*BLOCKSIZE + 123)
+	readerStats := &ReaderStats{}
+	hdfsReader := &MockReadSeekCloserWithPseudoRandomContent{FileSize: fileSize, Rand: r, ReaderStats: readerStats, EofWithLastChunk: true}
+	handle := createTestHandle(t, mockCtrl, hdfsReader)
+
+	offset := int64(0)
+	for offset < fileSize {
+		// Forward reads of random sizes, occasionally skipping a few bytes
+		offset += int64(r.Intn(3))
+		size := r.Intn(BLOCKSIZE/4) + 1
+		resp := fuse.ReadResponse{Data: make([]byte, 0, size)}
+		err := handle.Read(nil, &fuse.ReadRequest{Offset: offset, Size: size}, &resp)
+		assert.Nil(t, err)
+		expectedSize := int64(size)
+		if expectedSize > fileSize-offset {
+			expectedSize = fileSize - offset
+		}
+		if expectedSize < 0 {
+			expectedSize = 0
+		}
+		assert.Equal(t, expectedSize, int64(len(resp.Data)))
+		for i := range resp.Data {
+			if resp.Data[i] != generateByteAtOffset(offset+int64(i)) {
+				t.Fatal("Invalid byte at offset ", offset+int64(i))
+			}
+		}
+		offset += int64(len(resp.Data))
+	}
+	assert.True(t, readerStats.ReadCount > 20)
+	assert.Equal(t, uint64(0), readerStats.SeekCount)
+	assert.Equal(t, uint64(0), uint64(handle.Reader.Seeks))
+	handle.Release(nil, nil)
+}
+
+// Repeated read of the same range is served from memory even after other reads have replaced the buffers
+func TestRepeatedRangeReadServedFromCache(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	fileSize := int64(100 * 1024 * 1024)
+	readerStats := &ReaderStats{}
+	hdfsReader := &MockReadSeekCloserWithPseudoRandomContent{FileSize: fileSize, ReaderStats: readerStats}
+	handle := createTestHandle(t, mockCtrl, hdfsReader)
+	readAt := func(offset int64, size int) {
+		resp := fuse.ReadResponse{Data: make([]byte, 0, size)}
+		assert.Nil(t, handle.Read(nil, &fuse.ReadRequest{Offset: offset, Size: size}, &resp))
+		assert.Equal(t, size, len(resp.Data))
+		for i := range resp.Data {
+			if resp.Data[i] != generateByteAtOffset(offset+int64(i)) {
+				t.Fatal("Invalid byte at offset ", offset+int64(i))
+			}
+		}
+	}
+
+	// Reading footer, then other distant ranges which replace both buffers
+	footerOffset := fileSize - 8192
+	readAt(footerOffset, 8192)
+	readAt(0, 4096)
+	readAt(fileSize/2, 4096)
+	readAt(fileSize/4, 4096)
+	readCount := readerStats.ReadCount
+
+	// Footer is read again without backend fetch
+	readAt(footerOffset, 8192)
+	readAt(footerOffset+100, 1000)
+	assert.Equal(t, readCount, readerStats.ReadCount)
+	handle.Release(nil, nil)
+}
+
+// Sequential scan is served from the windows read ahead in background, with far fewer backend reads
+func TestSequentialReadahead(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	fileSize := int64(4*DefaultReadaheadBytes + 123)
+	withoutReadahead := sequentialReadBackendReads(t, fileSize, 0)
+	withReadahead := sequentialReadBackendReads(t, fileSize, DefaultReadaheadBytes)
+	assert.True(t, withoutReadahead > 64, withoutReadahead)
+	assert.True(t, withReadahead <= 8, withReadahead)
+}
+
+// Random access doesn't read anything ahead
+func TestRandomAccessWithReadahead(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	randomReads := func(readaheadBytes int) uint64 {
+		mockCtrl := gomock.NewController(t)
+		r := rand.New(rand.NewSource(0))
+		fileSize := int64(1024 * 1024 / 2)
+		readerStats := &ReaderStats{}
+		handle := createTestHandle(t, mockCtrl, &MockReadSeekCloserWithPseudoRandomContent{FileSize: fileSize, ReaderStats: readerStats})
+		handle.File.FileSystem.ReadaheadBytes = readaheadBytes
+		for iter := 0; iter < 200; iter++ {
+			offset := r.Int63n(fileSize)
+			size := r.Intn(4096) + 1
+			resp := fuse.ReadResponse{Data: make([]byte, 0, size)}
+			assert.Nil(t, handle.Read(nil, &fuse.ReadRequest{Offset: offset, Size: size}, &resp))
+			for i := range resp.Data {
+				if resp.Data[i] != generateByteAtOffset(offset+int64(i)) {
+					t.Fatal("Invalid byte at offset ", offset+int64(i))
+				}
+			}
+			assert.Nil(t, handle.Reader.readahead)
+		}
+		handle.Release(nil, nil)
+		return readerStats.ReadCount
+	}
+	assert.Equal(t, randomReads(0), randomReads(DefaultReadaheadBytes))
+}
+
+// Compares number of backend reads of a sequential full-file read with and without read-ahead
+func BenchmarkSequentialRead(b *testing.B) {
+	InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr)
+	fileSize := int64(4 * DefaultReadaheadBytes)
+	for _, readaheadBytes := range []int{0, DefaultReadaheadBytes} {
+		b.Run(fmt.Sprint("readaheadBytes=", readaheadBytes), func(b *testing.B) {
+			b.SetBytes(fileSize)
+			var backendReads uint64
+			for i := 0; i < b.N; i++ {
+				backendReads += sequentialReadBackendReads(b, fileSize, readaheadBytes)
+			}
+			b.ReportMetric(float64(backendReads)/float64(b.N), "backend-reads/op")
+		})
+	}
+}
+
 ///////////////// Test Helpers /////////////////////
 
 // common setup for FileHandleReader testing
-func createTestHandle(t *testing.T, mockCtrl *gomock.Controller, hdfsReader ReadSeekCloser) *FileHandle {
+func createTestHandle(t testing.TB, mockCtrl *gomock.Controller, hdfsReader ReadSeekCloser) *FileHandle {
 	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
 	hdfsAccessor.EXPECT().Stat("/test.dat").Return(Attrs{Name: "test.dat"}, nil)
 	hdfsAccessor.EXPECT().OpenRead("/test.dat").Return(hdfsReader, nil)
@@ -152,6 +392,33 @@ func createTestHandle(t *testing.T, mockCtrl *gomock.Controller, hdfsReader Read
 	return h.(*FileHandle)
 }
 
+// reads the file sequentially in 64K requests, verifying its content,
+// returns number of backend reads
+func sequentialReadBackendReads(t testing.TB, fileSize int64, readaheadBytes int) uint64 {
+	mockCtrl := gomock.NewController(t)
+	readerStats := &ReaderStats{}
+	handle := createTestHandle(t, mockCtrl, &MockReadSeekCloserWithPseudoRandomContent{FileSize: fileSize, ReaderStats: readerStats})
+	handle.File.FileSystem.ReadaheadBytes = readaheadBytes
+	for offset := int64(0); offset < fileSize; {
+		resp := fuse.ReadResponse{Data: make([]byte, 0, 65536)}
+		if err := handle.Read(nil, &fuse.ReadRequest{Offset: offset, Size: 65536}, &resp); err != nil {
+			t.Fatal("Read @", offset, ":", err)
+		}
+		if len(resp.Data) == 0 {
+			t.Fatal("Unexpected EOF @", offset)
+		}
+		for i := range resp.Data {
+			if resp.Data[i] != generateByteAtOffset(offset+int64(i)) {
+				t.Fatal("Invalid byte at offset ", offset+int64(i))
+			}
+		}
+		offset += int64(len(resp.Data))
+	}
+	handle.Release(nil, nil)
+	mockCtrl.Finish()
+	return readerStats.ReadCount
+}
+
 // sets hdfsReader mock to respond on Read() request in a certain way
 func (hdfsReader *MockReadSeekCloser) whenReadReturn(data []byte, err error) {
 	hdfsReader.EXPECT().Read(gomock.Any()).Do(
@@ -174,3 +441,431 @@ func (handle *FileHandle) readAndVerify(t *testing.T, offset int64, size int, da
 	assert.Equal(t, len(data), len(resp.Data))
 	assert.Equal(t, data, resp.Data)
 }
+
+// Testing that read-once action is applied after the file is fully read, but not after a partial read
+func TestReadOnceAction(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(&MockClock{}), &MockClock{})
+	fs.ReadOnceAction = "move"
+	var auditRecords bytes.Buffer
+	fs.AuditLog = NewAuditLog(&auditRecords)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/queue").Return(Attrs{Name: "queue", Mode: os.ModeDir | 0755}, nil)
+	queue, _ := root.(*Dir).Lookup(nil, "queue")
+	hdfsAccessor.EXPECT().Stat("/queue/a.dat").Return(Attrs{Name: "a.dat", Size: 11}, nil)
+	hdfsAccessor.EXPECT().Stat("/queue/b.dat").Return(Attrs{Name: "b.dat", Size: 11}, nil)
+	fileA, _ := queue.(*Dir).Lookup(nil, "a.dat")
+	fileB, _ := queue.(*Dir).Lookup(nil, "b.dat")
+
+	// Fully reading a.dat, which should trigger moving it to 'processed' directory
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/queue/a.dat").Return(hdfsReader, nil)
+	h, _ := fileA.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+	hdfsReader.whenReadReturn([]byte("HelloWorld!"), nil)
+	h.(*FileHandle).readAndVerify(t, 0, 11, []byte("HelloWorld!"))
+	hdfsReader.EXPECT().Close().Return(nil)
+	hdfsAccessor.EXPECT().Mkdir("/queue/processed", os.ModeDir|0755).Return(fuse.EEXIST)
+	hdfsAccessor.EXPECT().Rename("/queue/a.dat", "/queue/processed/a.dat").Return(nil)
+	assert.Nil(t, h.(*FileHandle).Release(nil, nil))
+	assert.Nil(t, queue.(*Dir).EntriesGet("a.dat"))
+
+	// Partially reading b.dat, no action is expected
+	hdfsReader = NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/queue/b.dat").Return(hdfsReader, nil)
+	h, _ = fileB.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+	hdfsReader.whenReadReturn([]byte("Hello"), nil)
+	h.(*FileHandle).readAndVerify(t, 0, 5, []byte("Hello"))
+	hdfsReader.EXPECT().Close().Return(nil)
+	assert.Nil(t, h.(*FileHandle).Release(nil, nil))
+	assert.NotNil(t, queue.(*Dir).EntriesGet("b.dat"))
+
+	// Fully reading b.dat while it is opened by another handle, no action is expected
+	otherReader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/queue/b.dat").Return(otherReader, nil)
+	other, _ := fileB.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+	hdfsReader = NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/queue/b.dat").Return(hdfsReader, nil)
+	h, _ = fileB.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+	hdfsReader.whenReadReturn([]byte("HelloWorld!"), nil)
+	h.(*FileHandle).readAndVerify(t, 0, 11, []byte("HelloWorld!"))
+	hdfsReader.EXPECT().Close().Return(nil)
+	assert.Nil(t, h.(*FileHandle).Release(nil, nil))
+	otherReader.EXPECT().Close().Return(nil)
+	assert.Nil(t, other.(*FileHandle).Release(nil, nil))
+	assert.NotNil(t, queue.(*Dir).EntriesGet("b.dat"))
+
+	// Read-once action is subject to disabled operations
+	fs.DisabledOps = map[string]bool{"rename": true}
+	hdfsReader = NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/queue/b.dat").Return(hdfsReader, nil)
+	h, _ = fileB.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+	hdfsReader.whenReadReturn([]byte("HelloWorld!"), nil)
+	h.(*FileHandle).readAndVerify(t, 0, 11, []byte("HelloWorld!"))
+	hdfsReader.EXPECT().Close().Return(nil)
+	assert.Equal(t, fuse.Errno(syscall.EPERM), h.(*FileHandle).Release(nil, nil))
+	assert.NotNil(t, queue.(*Dir).EntriesGet("b.dat"))
+
+	// Only the applied action is audited
+	assert.Nil(t, fs.AuditLog.Close())
+	records := strings.Split(strings.TrimSpace(auditRecords.String()), "\n")
+	assert.Equal(t, 1, len(records))
+	assert.Contains(t, records[0], `op=rename path="/queue/a.dat" details="/queue/processed/a.dat"`)
+}
+
+// Interrupted read is retried once (with RetryInterrupted enabled) and returns correct data
+func TestInterruptedReadIsRetried(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	handle := createTestHandle(t, mockCtrl, hdfsReader)
+	handle.File.FileSystem.RetryInterrupted = true
+	hdfsReader.whenReadReturn([]byte{}, syscall.EINTR)
+	hdfsReader.whenReadReturn([]byte("Hello"), nil)
+	handle.readAndVerify(t, 0, 5, []byte("Hello"))
+
+	// Without automatic retry, interrupted read surfaces EINTR
+	handle.File.FileSystem.RetryInterrupted = false
+	hdfsReader.whenReadReturn([]byte{}, &os.PathError{Op: "read", Path: "/test.dat", Err: syscall.EINTR})
+	resp := fuse.ReadResponse{Data: make([]byte, 5)}
+	err := handle.Read(nil, &fuse.ReadRequest{Offset: 5, Size: 5}, &resp)
+	assert.Equal(t, fuse.Errno(syscall.EINTR), err)
+
+	hdfsReader.EXPECT().Close().Return(nil)
+	handle.Release(nil, nil)
+}
+
+// Aggressive read-ahead kicks in only after the configured number of consecutive sequential reads
+func TestReadaheadTriggerCount(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	handle := createTestHandle(t, mockCtrl, hdfsReader)
+	handle.File.FileSystem.ReadaheadTriggerCount = 3
+	var backendReadSizes []int
+	expectBackendRead := func(data string) {
+		hdfsReader.EXPECT().Read(gomock.Any()).Do(func(buf []byte) {
+			backendReadSizes = append(backendReadSizes, len(buf))
+			copy(buf, data)
+		}).Return(len(data), nil)
+	}
+
+	// Fewer sequential reads than the trigger count: regular block-sized backend reads
+	for i := 0; i < 3; i++ {
+		expectBackendRead("0123456789")
+		handle.readAndVerify(t, int64(i*10), 10, []byte("0123456789"))
+	}
+	assert.Equal(t, []int{BLOCKSIZE, BLOCKSIZE, BLOCKSIZE}, backendReadSizes)
+
+	// Sequential reads beyond the trigger count: read-ahead kicks in
+	backendReadSizes = nil
+	expectBackendRead("0123456789")
+	handle.readAndVerify(t, 30, 10, []byte("0123456789"))
+	assert.Equal(t, []int{READAHEADSIZE}, backendReadSizes)
+
+	// Non-sequential read resets the detection
+	backendReadSizes = nil
+	hdfsReader.expectSeek(1000000)
+	expectBackendRead("0123456789")
+	handle.readAndVerify(t, 1000000, 10, []byte("0123456789"))
+	assert.Equal(t, []int{BLOCKSIZE}, backendReadSizes)
+
+	hdfsReader.EXPECT().Close().Return(nil)
+	handle.Release(nil, nil)
+}
+
+// Handle opened with O_DIRECT doesn't buffer: each read is served by one backend read at the exact offset
+func TestODirectReadBypassesBuffering(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(&MockClock{}), &MockClock{})
+	fs.HonorODirect = true
+	fs.SmallFileThreshold = 1 << 20
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/direct.dat").Return(Attrs{Name: "direct.dat", Size: 1000}, nil)
+	hdfsAccessor.EXPECT().OpenRead("/direct.dat").Return(hdfsReader, nil)
+	file, _ := root.(*Dir).Lookup(nil, "direct.dat")
+	resp := &fuse.OpenResponse{}
+	h, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly | OpenDirect}, resp)
+	assert.Nil(t, err)
+	handle := h.(*FileHandle)
+	assert.True(t, handle.Reader.Direct)
+	assert.False(t, handle.Reader.WholeFile)
+	assert.Equal(t, fuse.OpenDirectIO, resp.Flags&fuse.OpenDirectIO)
+
+	type backendRead struct {
+		Offset int64
+		Size   int
+	}
+	var backendReads []backendRead
+	var position int64
+	hdfsReader.EXPECT().Seek(gomock.Any()).Do(func(pos int64) { position = pos }).Return(nil).AnyTimes()
+	hdfsReader.EXPECT().Read(gomock.Any()).Do(func(buf []byte) {
+		backendReads = append(backendReads, backendRead{position, len(buf)})
+		copy(buf, "0123456789")
+		position += int64(len(buf))
+	}).Return(10, nil).Times(3)
+
+	handle.readAndVerify(t, 0, 10, []byte("0123456789"))
+	// Overlapping with the previous read, still fetched from backend
+	handle.readAndVerify(t, 5, 10, []byte("0123456789"))
+	handle.readAndVerify(t, 500, 10, []byte("0123456789"))
+	assert.Equal(t, []backendRead{{0, 10}, {5, 10}, {500, 10}}, backendReads)
+
+	hdfsReader.EXPECT().Close().Return(nil)
+	handle.Release(nil, nil)
+}
+
+// With FollowGrowth enabled, reader reaching EOF picks up the data appended to the file after it was opened
+func TestReadFollowsFileGrowth(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	handle := createTestHandle(t, mockCtrl, hdfsReader)
+	handle.File.FileSystem.FollowGrowth = true
+	hdfsAccessor := handle.File.FileSystem.HdfsAccessor.(*MockHdfsAccessor)
+
+	// File hasn't grown yet: EOF is reported as usual
+	hdfsReader.whenReadReturn([]byte("Hello"), nil)
+	hdfsReader.whenReadReturn([]byte{}, io.EOF)
+	hdfsAccessor.EXPECT().Stat("/test.dat").Return(Attrs{Name: "test.dat", Size: 5}, nil)
+	handle.readAndVerify(t, 0, 1024, []byte("Hello"))
+
+	// File has grown: backend reader is reopened at the current offset
+	reopenedReader := NewMockReadSeekCloser(mockCtrl)
+	hdfsReader.whenReadReturn([]byte{}, io.EOF)
+	hdfsAccessor.EXPECT().Stat("/test.dat").Return(Attrs{Name: "test.dat", Size: 11}, nil)
+	hdfsAccessor.EXPECT().OpenRead("/test.dat").Return(reopenedReader, nil)
+	reopenedReader.expectSeek(5)
+	hdfsReader.EXPECT().Close().Return(nil)
+	reopenedReader.whenReadReturn([]byte("World!"), nil)
+	handle.readAndVerify(t, 5, 6, []byte("World!"))
+	assert.Equal(t, uint64(11), handle.File.Attrs.Size)
+
+	reopenedReader.EXPECT().Close().Return(nil)
+	handle.Release(nil, nil)
+}
+
+// With SequentialDirPrefetch enabled, opening a file prefetches the first block of the next file in the directory
+func TestSequentialDirPrefetch(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(&MockClock{}), &MockClock{})
+	fs.SequentialDirPrefetch = true
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{
+		{Name: "part-0", Mode: 0644, Size: 5},
+		{Name: "part-1", Mode: 0644, Size: 6},
+		{Name: "part-2", Mode: 0644, Size: 7},
+	}, nil)
+	_, err := root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+
+	readers := make(map[string]*MockReadSeekCloser)
+	opened := make(map[string]chan struct{})
+	for _, name := range []string{"part-0", "part-1", "part-2"} {
+		readers[name] = NewMockReadSeekCloser(mockCtrl)
+		opened[name] = make(chan struct{})
+		done := opened[name]
+		hdfsAccessor.EXPECT().OpenRead("/"+name).Do(func(path string) { close(done) }).Return(readers[name], nil).Times(1)
+	}
+	readers["part-1"].whenReadReturn([]byte("second"), nil)
+	readers["part-2"].whenReadReturn([]byte("thirdxx"), nil)
+
+	file0, _ := root.(*Dir).Lookup(nil, "part-0")
+	h0, err := file0.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	// Next file is opened and its first block is read before the client opens it
+	<-opened["part-1"]
+	readers["part-0"].whenReadReturn([]byte("first"), nil)
+	h0.(*FileHandle).readAndVerify(t, 0, 5, []byte("first"))
+	readers["part-0"].EXPECT().Close().Return(nil)
+	h0.(*FileHandle).Release(nil, nil)
+
+	// Opening the prefetched file doesn't reopen it, and the data is served without reading from backend
+	file1, _ := root.(*Dir).Lookup(nil, "part-1")
+	h1, err := file1.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	h1.(*FileHandle).readAndVerify(t, 0, 6, []byte("second"))
+	<-opened["part-2"]
+	readers["part-1"].EXPECT().Close().Return(nil)
+	h1.(*FileHandle).Release(nil, nil)
+
+	readers["part-2"].EXPECT().Close().Return(nil)
+	file2, _ := root.(*Dir).Lookup(nil, "part-2")
+	h2, err := file2.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	h2.(*FileHandle).readAndVerify(t, 0, 7, []byte("thirdxx"))
+	h2.(*FileHandle).Release(nil, nil)
+}
+
+// Handle with fresh consistency level hits the backend on every request, even within metadata TTL
+func TestFreshConsistencyBypassesCaches(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.OpenFlagModes, _ = NewOpenFlagModes("sync=fresh")
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/fresh.dat").Return(Attrs{Name: "fresh.dat", Size: 10}, nil)
+	file, _ := root.(*Dir).Lookup(nil, "fresh.dat")
+
+	// Metadata is refreshed on open and on each attribute request while fresh handle is open
+	hdfsAccessor.EXPECT().Stat("/fresh.dat").Return(Attrs{Name: "fresh.dat", Size: 10}, nil)
+	hdfsAccessor.EXPECT().OpenRead("/fresh.dat").Return(hdfsReader, nil)
+	resp := &fuse.OpenResponse{}
+	h, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly | fuse.OpenSync}, resp)
+	assert.Nil(t, err)
+	handle := h.(*FileHandle)
+	assert.True(t, handle.Fresh)
+	assert.Equal(t, fuse.OpenDirectIO, resp.Flags&fuse.OpenDirectIO)
+	hdfsAccessor.EXPECT().Stat("/fresh.dat").Return(Attrs{Name: "fresh.dat", Size: 10}, nil)
+	hdfsAccessor.EXPECT().Stat("/fresh.dat").Return(Attrs{Name: "fresh.dat", Size: 12}, nil)
+	attr := fuse.Attr{}
+	assert.Nil(t, file.(*File).Attr(nil, &attr))
+	assert.Nil(t, file.(*File).Attr(nil, &attr))
+	assert.Equal(t, uint64(12), attr.Size)
+
+	// Repeated reads of the same range are served by the backend each time
+	hdfsReader.whenReadReturn([]byte("Hello"), nil)
+	handle.readAndVerify(t, 0, 5, []byte("Hello"))
+	hdfsReader.expectSeek(0)
+	hdfsReader.whenReadReturn([]byte("Hallo"), nil)
+	handle.readAndVerify(t, 0, 5, []byte("Hallo"))
+	hdfsReader.EXPECT().Close().Return(nil)
+	handle.Release(nil, nil)
+
+	// Once the handle is closed, metadata cache is used again
+	hdfsAccessor.EXPECT().Stat("/fresh.dat").Return(Attrs{Name: "fresh.dat", Size: 12}, nil)
+	assert.Nil(t, file.(*File).Attr(nil, &attr))
+	assert.Nil(t, file.(*File).Attr(nil, &attr))
+
+	// Consistency level can be set per file with extended attribute
+	assert.Nil(t, file.(*File).Setxattr(nil, &fuse.SetxattrRequest{Name: XattrConsistency, Xattr: []byte(ConsistencyCached)}))
+	assert.False(t, file.(*File).IsFreshOpen(fuse.OpenReadOnly|fuse.OpenSync))
+	assert.Nil(t, file.(*File).Setxattr(nil, &fuse.SetxattrRequest{Name: XattrConsistency, Xattr: []byte(ConsistencyFresh)}))
+	assert.True(t, file.(*File).IsFreshOpen(fuse.OpenReadOnly))
+	assert.Equal(t, fuse.Errno(syscall.EINVAL), file.(*File).Setxattr(nil, &fuse.SetxattrRequest{Name: XattrConsistency, Xattr: []byte("eventual")}))
+}
+
+// Read request larger than the backend read size is served by multiple backend reads concatenated in order,
+// returning the data read so far if one of them hits EOF early
+func TestReadSplitIntoBackendReads(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	handle := createTestHandle(t, mockCtrl, hdfsReader)
+	handle.File.FileSystem.BackendReadSize = 10
+	var backendReadSizes []int
+	expectBackendRead := func(data string, err error) {
+		hdfsReader.EXPECT().Read(gomock.Any()).Do(func(buf []byte) {
+			backendReadSizes = append(backendReadSizes, len(buf))
+			copy(buf, data)
+		}).Return(len(data), err)
+	}
+
+	expectBackendRead("0123456789", nil)
+	expectBackendRead("abcdefghij", nil)
+	expectBackendRead("ABCDEFGHIJ", nil)
+	handle.readAndVerify(t, 0, 30, []byte("0123456789abcdefghijABCDEFGHIJ"))
+	assert.Equal(t, []int{10, 10, 10}, backendReadSizes)
+
+	// File ends in the middle of the second backend read
+	backendReadSizes = nil
+	expectBackendRead("klmnopqrst", nil)
+	expectBackendRead("uvw", io.EOF)
+	expectBackendRead("", io.EOF)
+	handle.readAndVerify(t, 30, 25, []byte("klmnopqrstuvw"))
+	assert.Equal(t, []int{10, 10, 10}, backendReadSizes)
+
+	hdfsReader.EXPECT().Close().Return(nil)
+	handle.Release(nil, nil)
+}
+
+// Reopening recently closed file reuses its backend reader (rewound to the beginning) within the TTL
+func TestReaderReusedOnQuickReopen(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	handle := createTestHandle(t, mockCtrl, hdfsReader)
+	fileSystem := handle.File.FileSystem
+	fileSystem.ReaderReuseTTL = 5 * time.Second
+	mockClock := fileSystem.Clock.(*MockClock)
+
+	hdfsReader.whenReadReturn([]byte("Hello"), nil)
+	handle.readAndVerify(t, 0, 5, []byte("Hello"))
+	handle.Release(nil, nil)
+
+	// Reopened within the TTL: backend reader is rewound instead of being reopened
+	mockClock.NotifyTimeElapsed(2 * time.Second)
+	hdfsReader.expectSeek(0)
+	h, err := handle.File.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+	assert.Nil(t, err)
+	handle = h.(*FileHandle)
+	hdfsReader.whenReadReturn([]byte("Hello"), nil)
+	handle.readAndVerify(t, 0, 5, []byte("Hello"))
+	handle.Release(nil, nil)
+
+	// Reopened after the TTL: stale backend reader is closed and new one is opened
+	mockClock.NotifyTimeElapsed(6 * time.Second)
+	hdfsReader.EXPECT().Close().Return(nil)
+	newHdfsReader := NewMockReadSeekCloser(mockCtrl)
+	fileSystem.HdfsAccessor.(*MockHdfsAccessor).EXPECT().OpenRead("/test.dat").Return(newHdfsReader, nil)
+	h, err = handle.File.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+	assert.Nil(t, err)
+	newHdfsReader.EXPECT().Close().Return(nil)
+	fileSystem.ReaderReuseTTL = 0
+	h.(*FileHandle).Release(nil, nil)
+}
+
+// Backend reader kept for reuse is closed once the kernel forgets the file, even if it is never reopened
+func TestParkedReaderClosedOnForget(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	handle := createTestHandle(t, mockCtrl, hdfsReader)
+	handle.File.FileSystem.ReaderReuseTTL = 5 * time.Second
+
+	hdfsReader.whenReadReturn([]byte("Hello"), nil)
+	handle.readAndVerify(t, 0, 5, []byte("Hello"))
+	handle.Release(nil, nil)
+
+	hdfsReader.EXPECT().Close().Return(nil)
+	handle.File.Forget()
+	mockCtrl.Finish()
+}
+
+// Replica on a data node in maintenance is avoided as long as there is a healthy one
+func TestSelectReplicaAvoidsMaintenanceNodes(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	handle := createTestHandle(t, mockCtrl, hdfsReader)
+	handle.File.FileSystem.HdfsAccessor.(*MockHdfsAccessor).EXPECT().GetBlockLocations("/test.dat").Return([]BlockLocation{
+		{Offset: 0, Length: 100, Hosts: []string{"dn1", "dn2", "dn3"}, States: []string{DatanodeInMaintenance, DatanodeNormal, DatanodeNormal}},
+		{Offset: 100, Length: 100, Hosts: []string{"dn1", "dn4"}, States: []string{DatanodeDecommissionInProgress, DatanodeEnteringMaintenance}},
+		{Offset: 200, Length: 100, Hosts: []string{"dn5", "dn1"}}}, nil)
+
+	host, ok := handle.Reader.SelectReplica(50)
+	assert.True(t, ok)
+	assert.Equal(t, "dn2", host)
+
+	// None of the replicas is healthy: falling back to the first one
+	host, ok = handle.Reader.SelectReplica(150)
+	assert.True(t, ok)
+	assert.Equal(t, "dn1", host)
+
+	// Admin states are unknown: the order reported by the name node is kept
+	host, ok = handle.Reader.SelectReplica(250)
+	assert.True(t, ok)
+	assert.Equal(t, "dn5", host)
+
+	_, ok = handle.Reader.SelectReplica(300)
+	assert.False(t, ok)
+
+	hdfsReader.EXPECT().Close().Return(nil)
+	handle.Release(nil, nil)
+}
@@ -4,19 +4,25 @@ package main
 
 import (
 	"bazil.org/fuse"
+	"bytes"
+	"crypto/md5"
 	"errors"
 	"golang.org/x/net/context"
 	"io"
-	"io/ioutil"
-	"os"
+	"syscall"
 	"time"
 )
 
 // Encapsulates state and routines for writing data from the file handle
 type FileHandleWriter struct {
 	Handle       *FileHandle
-	stagingFile  *os.File
+	stagingFile  StagingFile
 	BytesWritten uint64
+	stream       *StreamingWriter // streams data directly to HDFS (new files only, with StreamingWriteBuffer configured)
+	directWriter HdfsWriter       // writes data synchronously to HDFS, without buffering (new files opened with O_DIRECT)
+	streamOffset int64            // number of bytes passed to the stream (or direct writer)
+	truncated    bool             // true if staged content has been truncated since the last flush
+	acknowledged int64            // number of staged bytes accepted by HDFS during the last flush attempt
 }
 
 // Opens the file for writing
@@ -26,26 +32,58 @@ func NewFileHandleWriter(handle *FileHandle, newFile bool) (*FileHandleWriter, e
 	path := this.Handle.File.AbsolutePath()
 
 	hdfsAccessor := this.Handle.File.FileSystem.HdfsAccessor
-	if newFile {
+	if newFile && (handle.Direct || handle.WriteThrough) && !handle.File.IsCompressedOnWrite() {
+		// O_DIRECT or write-through: new file is written to HDFS as the data arrives, without staging
 		hdfsAccessor.Remove(path)
-		w, err := hdfsAccessor.CreateFile(path, this.Handle.File.Attrs.Mode)
+		w, err := this.createFile(path)
 		if err != nil {
 			Error.Println("Creating", path, ":", path, err)
 			return nil, err
 		}
-		w.Close()
+		this.directWriter = w
+		return this, nil
+	}
+	if streamingWriteBuffer := this.Handle.File.FileSystem.StreamingWriteBuffer; newFile && streamingWriteBuffer > 0 && !handle.File.IsCompressedOnWrite() {
+		// New file is streamed to HDFS without staging, buffering at most streamingWriteBuffer bytes
+		hdfsAccessor.Remove(path)
+		w, err := this.createFile(path)
+		if err != nil {
+			Error.Println("Creating", path, ":", path, err)
+			return nil, err
+		}
+		this.stream = NewStreamingWriter(w, streamingWriteBuffer)
+		return this, nil
+	}
+	if !newFile && handle.Append && !handle.File.IsCompressedOnWrite() {
+		// Appended data goes to HDFS as it arrives, unless append has to be emulated
+		w, err := this.Handle.File.FileSystem.OpenForAppend(path)
+		if err != nil {
+			Error.Println("Appending", path, ":", err)
+			return nil, err
+		}
+		if w != nil {
+			this.directWriter = w
+			this.streamOffset = int64(this.Handle.File.Attrs.Size)
+			return this, nil
+		}
 	}
-	stageDir := "/var/hdfs-mount" // TODO: make configurable
-	if ok := os.MkdirAll(stageDir, 0700); ok != nil {
-		Error.Println("Failed to create stageDir /var/hdfs-mount, Error:", ok)
-		return nil, ok
+	if newFile && this.Handle.File.FileSystem.DeferCreate {
+		// File is created in HDFS together with its content on the first flush
+		this.Handle.File.SetCreateDeferred(true)
+	} else if newFile {
+		hdfsAccessor.Remove(path)
+		w, err := this.createFile(path)
+		if err != nil {
+			Error.Println("Creating", path, ":", path, err)
+			return nil, err
+		}
+		w.Close()
 	}
 	var err error
-	this.stagingFile, err = ioutil.TempFile(stageDir, "stage")
+	this.stagingFile, err = this.Handle.File.FileSystem.CreateStagingFile(handle.Uid, handle.Gid)
 	if err != nil {
 		return nil, err
 	}
-	os.Remove(this.stagingFile.Name()) //TODO: handle error
 
 	if !newFile {
 		// Request to write to existing file
@@ -63,7 +101,15 @@ func NewFileHandleWriter(handle *FileHandle, newFile bool) (*FileHandleWriter, e
 			this.stagingFile = nil
 			return nil, err
 		}
-		nc, err := io.Copy(this.stagingFile, reader)
+		var source io.Reader = reader
+		if this.Handle.File.IsCompressedOnWrite() {
+			// Application appends to the uncompressed content
+			source, err = NewGunzipReader(reader)
+		}
+		var nc int64
+		if err == nil {
+			nc, err = io.Copy(this.stagingFile, source)
+		}
 		if err != nil {
 			Warning.Println("Copy failure:", err)
 			this.stagingFile.Close()
@@ -88,28 +134,80 @@ func (this *FileHandleWriter) Write(handle *FileHandle, ctx context.Context, req
 		return errors.New("Too large file")
 	}
 
+	if this.stream != nil || this.directWriter != nil {
+		if req.Offset != this.streamOffset {
+			Error.Println("[", this.Handle.File.AbsolutePath(), "] streamed file can only be written sequentially, expected offset", this.streamOffset, ", got", req.Offset)
+			return fuse.Errno(syscall.EINVAL)
+		}
+	}
+
+	if this.directWriter != nil {
+		nw, err := this.directWriter.Write(req.Data)
+		resp.Size = nw
+		this.streamOffset += int64(nw)
+		this.BytesWritten += uint64(nw)
+		return InterruptedAsEINTR(err)
+	}
+
+	if this.stream != nil {
+		if err := this.stream.Write(ctx, req.Data); err != nil {
+			return err
+		}
+		resp.Size = len(req.Data)
+		this.streamOffset += int64(len(req.Data))
+		this.BytesWritten += uint64(len(req.Data))
+		return nil
+	}
+
 	nw, err := this.stagingFile.WriteAt(req.Data, req.Offset)
 	resp.Size = nw
 	if err != nil {
-		return err
+		// Writes aren't retried, since partially completed write can't be safely repeated
+		return InterruptedAsEINTR(err)
 	}
 	this.BytesWritten += uint64(nw)
 	return nil
 }
 
+// Returns true if written data goes to HDFS as it arrives, so flushing it doesn't re-upload staged content
+func (this *FileHandleWriter) IsStreamed() bool {
+	return this.stream != nil || this.directWriter != nil
+}
+
 // Responds on FUSE Flush/Fsync request
 func (this *FileHandleWriter) Flush() error {
 	Info.Println("[", this.Handle.File.AbsolutePath(), "] flush (", this.BytesWritten, "new bytes written)")
-	if this.BytesWritten == 0 {
+	if this.BytesWritten == 0 && !this.truncated && !this.Handle.File.IsCreateDeferred() {
 		// Nothing to do
 		return nil
 	}
-	this.BytesWritten = 0
 	defer this.Handle.File.InvalidateMetadataCache()
+	if this.stream != nil || this.directWriter != nil {
+		this.BytesWritten = 0
+		this.truncated = false
+		if this.stream != nil {
+			// Streamed data can't be re-uploaded, waiting for it to reach HDFS pipeline instead
+			return this.stream.Drain()
+		}
+		return this.directWriter.Flush()
+	}
+	if this.Handle.File.FileSystem.InReadOnlyWindow() {
+		// Content staged before the window has started isn't uploaded during it either
+		Warning.Println("[", this.Handle.File.AbsolutePath(), "] upload of staged content isn't allowed during read-only window")
+		return fuse.Errno(syscall.EROFS)
+	}
 
 	op := this.Handle.File.FileSystem.RetryPolicy.StartOperation()
 	for {
 		err := this.FlushAttempt()
+		if err == nil {
+			// Staged content is reset as pending only once it has been fully accepted by HDFS,
+			// so the data of the failed flush is uploaded again on the next one
+			this.BytesWritten = 0
+			this.truncated = false
+		} else {
+			Warning.Println("[", this.Handle.File.AbsolutePath(), "] flush failed after", this.acknowledged, "bytes, staged content is kept for the next flush")
+		}
 		if err != io.EOF || IsSuccessOrBenignError(err) || !op.ShouldRetry("Flush()", err) {
 			return err
 		}
@@ -127,22 +225,40 @@ func (this *FileHandleWriter) Flush() error {
 func (this *FileHandleWriter) FlushAttempt() error {
 	hdfsAccessor := this.Handle.File.FileSystem.HdfsAccessor
 	hdfsAccessor.Remove(this.Handle.File.AbsolutePath())
-	w, err := hdfsAccessor.CreateFile(this.Handle.File.AbsolutePath(), this.Handle.File.Attrs.Mode)
+	w, err := this.createFile(this.Handle.File.AbsolutePath())
 	if err != nil {
 		Error.Println("ERROR creating", this.Handle.File.AbsolutePath(), ":", err)
 		return err
 	}
+	this.Handle.File.SetCreateDeferred(false)
 
 	this.stagingFile.Seek(0, 0)
+	this.acknowledged = 0
+	if this.Handle.File.IsCompressedOnWrite() {
+		return this.FlushCompressed(w)
+	}
 	b := make([]byte, 65536, 65536)
 	for {
 		nr, err := this.stagingFile.Read(b)
-		if err != nil {
+		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			Error.Println("Reading staged content of", this.Handle.File.AbsolutePath(), ":", err)
+			w.Close()
+			return err
+		}
 		b = b[:nr]
 
-		_, err = w.Write(b)
+		nw, err := w.Write(b)
+		this.acknowledged += int64(nw)
+		if err != nil && this.Handle.File.FileSystem.RecoverWritePipeline && IsBrokenPipeError(err) {
+			if w, err = this.RecoverPipeline(w, err); err != nil {
+				Error.Println("Writing", this.Handle.File.AbsolutePath(), ":", err)
+				return err
+			}
+			continue
+		}
 		if err != nil {
 			Error.Println("Writing", this.Handle.File.AbsolutePath(), ":", err)
 			w.Close()
@@ -159,7 +275,120 @@ func (this *FileHandleWriter) FlushAttempt() error {
 	return nil
 }
 
+// Verifies that the file stored on HDFS matches the content of the staging file
+// (length and, optionally, content checksum). Returns EIO on mismatch
+func (this *FileHandleWriter) Confirm(verifyChecksum bool) error {
+	path := this.Handle.File.AbsolutePath()
+	var stagingSize int64
+	var err error
+	if this.stream != nil || this.directWriter != nil {
+		// Stream needs to be closed for HDFS to report final size of the file
+		if err = this.Close(); err != nil {
+			return err
+		}
+		stagingSize = this.streamOffset
+		if verifyChecksum {
+			Warning.Println("[", path, "] Write confirmation: checksum isn't verified for streamed file")
+			verifyChecksum = false
+		}
+	} else if this.Handle.File.IsCompressedOnWrite() {
+		// HDFS holds compressed content
+		compressedSize, _ := this.Handle.File.CompressedSize()
+		stagingSize = int64(compressedSize)
+		if verifyChecksum {
+			Warning.Println("[", path, "] Write confirmation: checksum isn't verified for compressed file")
+			verifyChecksum = false
+		}
+	} else if stagingSize, err = this.stagingFile.Size(); err != nil {
+		return err
+	}
+	hdfsAccessor := this.Handle.File.FileSystem.HdfsAccessor
+	attrs, err := hdfsAccessor.Stat(path)
+	if err != nil {
+		Error.Println("[", path, "] Write confirmation: can't stat file:", err)
+		return err
+	}
+	if int64(attrs.Size) != stagingSize {
+		Error.Println("[", path, "] Write confirmation: size mismatch, written", stagingSize, "bytes, HDFS reports", attrs.Size, "bytes")
+		return fuse.EIO
+	}
+	if !verifyChecksum {
+		return nil
+	}
+	reader, err := hdfsAccessor.OpenRead(path)
+	if err != nil {
+		Error.Println("[", path, "] Write confirmation: can't open file:", err)
+		return err
+	}
+	defer reader.Close()
+	hdfsChecksum := md5.New()
+	if _, err = io.Copy(hdfsChecksum, reader); err != nil {
+		Error.Println("[", path, "] Write confirmation: can't read file:", err)
+		return err
+	}
+	stagingChecksum := md5.New()
+	if _, err = io.Copy(stagingChecksum, io.NewSectionReader(this.stagingFile, 0, stagingSize)); err != nil {
+		return err
+	}
+	if !bytes.Equal(hdfsChecksum.Sum(nil), stagingChecksum.Sum(nil)) {
+		Error.Println("[", path, "] Write confirmation: checksum mismatch")
+		return fuse.EIO
+	}
+	return nil
+}
+
+// Returns size of the content written via this handle, as seen by the application
+func (this *FileHandleWriter) Size() uint64 {
+	if this.stream != nil || this.directWriter != nil || this.stagingFile == nil {
+		return uint64(this.streamOffset)
+	}
+	size, err := this.stagingFile.Size()
+	if err != nil {
+		Warning.Println("[", this.Handle.File.AbsolutePath(), "] Can't get size of the staged content:", err)
+		return 0
+	}
+	return uint64(size)
+}
+
+// Changes size of the staged content (cutting it or extending it with zeroes), HDFS file is rewritten on the next flush.
+// Content which is streamed to HDFS can't be truncated (EOPNOTSUPP)
+func (this *FileHandleWriter) Truncate(size uint64) error {
+	if this.stream != nil || this.directWriter != nil || this.stagingFile == nil {
+		if size == uint64(this.streamOffset) {
+			return nil
+		}
+		Error.Println("[", this.Handle.File.AbsolutePath(), "] streamed file can't be truncated to", size)
+		return fuse.Errno(syscall.EOPNOTSUPP)
+	}
+	if err := this.stagingFile.Truncate(int64(size)); err != nil {
+		return err
+	}
+	this.truncated = true
+	return nil
+}
+
 // Closes the writer
 func (this *FileHandleWriter) Close() error {
+	if this.stream != nil {
+		err := this.stream.Close()
+		this.stream = nil
+		return err
+	}
+	if this.directWriter != nil {
+		err := this.directWriter.Close()
+		this.directWriter = nil
+		return err
+	}
+	if this.stagingFile == nil {
+		// Stream has been already closed (by Confirm)
+		return nil
+	}
+	if this.Handle.File.IsCreateDeferred() {
+		// File hasn't been flushed (e.g. flush has failed), creating it now
+		if err := this.Flush(); err != nil {
+			this.stagingFile.Close()
+			return err
+		}
+	}
 	return this.stagingFile.Close()
 }
@@ -6,8 +6,12 @@ import (
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"io"
+	"net"
 	"os"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestWriteFile(t *testing.T) {
@@ -156,3 +160,579 @@ func TestFlushFile(t *testing.T) {
 	err = writeHandle.Close()
 	assert.Nil(t, err)
 }
+
+// Testing that under write confirmation mode Release fails if HDFS reports unexpected file size
+func TestWriteConfirmationSizeMismatch(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileName := "/testWriteConfirmation"
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.WriteConfirmation = "length"
+
+	hdfswriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Remove(fileName).Return(nil)
+	hdfsAccessor.EXPECT().CreateFile(fileName, os.FileMode(0757)).Return(hdfswriter, nil)
+	hdfswriter.EXPECT().Close().Return(nil)
+	root, _ := fs.Root()
+	_, h, err := root.(*Dir).Create(nil, &fuse.CreateRequest{Name: "testWriteConfirmation", Mode: os.FileMode(0757)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil)
+	err = h.(*FileHandle).Write(nil, &fuse.WriteRequest{Data: []byte("hello world"), Offset: 0}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+
+	// Flushing on release
+	hdfsAccessor.EXPECT().Remove(fileName).Return(nil)
+	hdfsAccessor.EXPECT().CreateFile(fileName, os.FileMode(0757)).Return(hdfswriter, nil)
+	hdfswriter.EXPECT().Write([]byte("hello world")).Return(11, nil)
+	hdfswriter.EXPECT().Close().Return(nil)
+	// HDFS reports truncated file
+	hdfsAccessor.EXPECT().Stat(fileName).Return(Attrs{Name: "testWriteConfirmation", Size: 5}, nil)
+	err = h.(*FileHandle).Release(nil, &fuse.ReleaseRequest{})
+	assert.Equal(t, fuse.EIO, err)
+	assert.Nil(t, h.(*FileHandle).Writer)
+}
+
+// Testing that fsync flushes multiple dirty handles concurrently (with bounded concurrency),
+// attempting all of them even if some fail
+func TestParallelFsync(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/testFsync").Return(Attrs{Name: "testFsync", Mode: 0644}, nil)
+	node, _ := root.(*Dir).Lookup(nil, "testFsync")
+	file := node.(*File)
+	handleCount := 2*MaxConcurrentFsyncs + 1
+	for i := 0; i < handleCount; i++ {
+		handle := NewFileHandle(file)
+		handle.Writer = &FileHandleWriter{Handle: handle, stagingFile: &MemoryStagingFile{}, BytesWritten: 1}
+		file.AddHandle(handle)
+	}
+
+	var mutex sync.Mutex
+	active, maxActive := 0, 0
+	hdfsAccessor.EXPECT().Remove("/testFsync").Do(func(path string) {
+		mutex.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mutex.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mutex.Lock()
+		active--
+		mutex.Unlock()
+	}).Return(nil).Times(handleCount)
+	createErr := errors.New("create failed")
+	hdfsAccessor.EXPECT().CreateFile("/testFsync", os.FileMode(0644)).Return(nil, createErr).Times(handleCount)
+	err := file.Fsync(nil, &fuse.FsyncRequest{})
+	assert.Equal(t, createErr, err)
+	assert.True(t, maxActive > 1, "handles weren't flushed concurrently")
+	assert.True(t, maxActive <= MaxConcurrentFsyncs, "concurrency isn't bounded")
+}
+
+// Writing the file held by a lease of a crashed writer recovers the lease and retries
+func TestLeaseRecoveryOnFlush(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.RecoverLease = true
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/testLease").Return(Attrs{Name: "testLease", Mode: 0644}, nil)
+	node, _ := root.(*Dir).Lookup(nil, "testLease")
+	handle := NewFileHandle(node.(*File))
+	stagingFile := &MemoryStagingFile{}
+	stagingFile.Write([]byte("hello"))
+	handle.Writer = &FileHandleWriter{Handle: handle, stagingFile: stagingFile, BytesWritten: 5}
+
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	leaseErr := errors.New("org.apache.hadoop.hdfs.protocol.AlreadyBeingCreatedException: Failed to APPEND_FILE /testLease")
+	hdfsAccessor.EXPECT().Remove("/testLease").Return(nil)
+	gomock.InOrder(
+		hdfsAccessor.EXPECT().CreateFile("/testLease", os.FileMode(0644)).Return(nil, leaseErr),
+		hdfsAccessor.EXPECT().RecoverLease("/testLease").Return(false, nil),
+		hdfsAccessor.EXPECT().RecoverLease("/testLease").Return(true, nil),
+		hdfsAccessor.EXPECT().CreateFile("/testLease", os.FileMode(0644)).Return(hdfsWriter, nil))
+	hdfsWriter.EXPECT().Write([]byte("hello")).Return(5, nil)
+	hdfsWriter.EXPECT().Close().Return(nil)
+	assert.Nil(t, handle.Writer.Flush())
+	assert.Equal(t, LeaseRecoveryPollInterval, mockClock.LastSleepDuration)
+
+	// Without lease recovery enabled, the error is returned as is
+	fs.RecoverLease = false
+	handle.Writer.BytesWritten = 5
+	hdfsAccessor.EXPECT().Remove("/testLease").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/testLease", os.FileMode(0644)).Return(nil, leaseErr)
+	assert.Equal(t, leaseErr, handle.Writer.Flush())
+}
+
+// With deferred creation, the new file is created in HDFS once, together with the content written to it
+func TestDeferredCreateWritesContentOnce(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.DeferCreate = true
+	root, _ := fs.Root()
+
+	// Nothing reaches HDFS on create, attributes are served locally
+	node, h, err := root.(*Dir).Create(nil, &fuse.CreateRequest{Name: "new.txt", Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	var attr fuse.Attr
+	assert.Nil(t, node.(*File).Attr(nil, &attr))
+	assert.Equal(t, uint64(0), attr.Size)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: 100, remaining: 100}, nil)
+	err = h.(*FileHandle).Write(nil, &fuse.WriteRequest{Data: []byte("hello")}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+
+	// Flush creates the file with its content in a single create
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Remove("/new.txt").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/new.txt", os.FileMode(0644)).Return(hdfsWriter, nil).Times(1)
+	hdfsWriter.EXPECT().Write([]byte("hello")).Return(5, nil)
+	hdfsWriter.EXPECT().Close().Return(nil)
+	assert.Nil(t, h.(*FileHandle).Flush(nil, &fuse.FlushRequest{}))
+	assert.False(t, node.(*File).IsCreateDeferred())
+	assert.Nil(t, h.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+}
+
+// With RetryClose enabled, failed close of HDFS file on release is retried until it succeeds
+func TestCloseRetriedOnRelease(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.StreamingWriteBuffer = 1024
+	fs.RetryClose = true
+	root, _ := fs.Root()
+
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Remove("/new.txt").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/new.txt", os.FileMode(0644)).Return(hdfsWriter, nil)
+	_, h, err := root.(*Dir).Create(nil, &fuse.CreateRequest{Name: "new.txt", Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: 100, remaining: 100}, nil)
+	hdfsWriter.EXPECT().Write([]byte("hello")).Return(5, nil)
+	assert.Nil(t, h.(*FileHandle).Write(nil, &fuse.WriteRequest{Data: []byte("hello")}, &fuse.WriteResponse{}))
+
+	replicating := errors.New("replication in progress")
+	gomock.InOrder(
+		hdfsWriter.EXPECT().Close().Return(replicating),
+		hdfsWriter.EXPECT().Close().Return(replicating),
+		hdfsWriter.EXPECT().Close().Return(nil))
+	assert.Nil(t, h.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+}
+
+// Close which keeps failing is reported on release once retries are exhausted
+func TestCloseFailureReportedOnRelease(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.StreamingWriteBuffer = 1024
+	fs.RetryClose = true
+	fs.RetryPolicy.MaxAttempts = 3
+	root, _ := fs.Root()
+
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Remove("/new.txt").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/new.txt", os.FileMode(0644)).Return(hdfsWriter, nil)
+	_, h, err := root.(*Dir).Create(nil, &fuse.CreateRequest{Name: "new.txt", Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+
+	closeErr := errors.New("replication in progress")
+	hdfsWriter.EXPECT().Close().Return(closeErr).Times(3)
+	assert.Equal(t, closeErr, h.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+}
+
+// Write arriving on the handle opened read-only is rejected with EBADF, without enabling write
+func TestWriteToReadOnlyHandle(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	handle := createTestHandle(t, mockCtrl, hdfsReader)
+	handle.File.FileSystem.RejectReadOnlyWrites = true
+	err := handle.Write(nil, &fuse.WriteRequest{Data: []byte("hello")}, &fuse.WriteResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EBADF), err)
+	assert.Nil(t, handle.Writer)
+}
+
+// Append unsupported by the cluster fails with EOPNOTSUPP in native mode, or is emulated by rewriting the file
+func TestAppendUnsupported(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.StagingDir = os.TempDir()
+	fs.AppendMode = AppendNative
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/log.txt").Return(Attrs{Name: "log.txt", Mode: 0644, Size: 5}, nil)
+	file, _ := root.(*Dir).Lookup(nil, "log.txt")
+	appendFlags := fuse.OpenWriteOnly | fuse.OpenAppend
+
+	unsupported := errors.New("java.lang.UnsupportedOperationException: Append is not supported")
+	hdfsAccessor.EXPECT().Append("/log.txt").Return(nil, unsupported)
+	_, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: appendFlags}, &fuse.OpenResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EOPNOTSUPP), err)
+
+	// Emulated append buffers existing content, HDFS append isn't attempted again
+	fs.AppendMode = AppendNativeOrEmulate
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().Stat("/log.txt").Return(Attrs{Name: "log.txt", Mode: 0644, Size: 5}, nil)
+	hdfsAccessor.EXPECT().OpenRead("/log.txt").Return(hdfsReader, nil)
+	hdfsReader.whenReadReturn([]byte("hello"), nil)
+	hdfsReader.whenReadReturn(nil, io.EOF)
+	hdfsReader.EXPECT().Close().Return(nil)
+	h, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: appendFlags}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	writer := h.(*FileHandle).Writer
+	assert.Nil(t, writer.directWriter)
+	assert.Equal(t, uint64(5), writer.Size())
+	writer.stagingFile.Close()
+}
+
+// Append supported by the cluster sends appended data directly to HDFS
+func TestNativeAppend(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.AppendMode = AppendNative
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/log.txt").Return(Attrs{Name: "log.txt", Mode: 0644, Size: 5}, nil)
+	file, _ := root.(*Dir).Lookup(nil, "log.txt")
+
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Append("/log.txt").Return(hdfsWriter, nil)
+	h, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenAppend}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: 100, remaining: 100}, nil)
+	hdfsWriter.EXPECT().Write([]byte(" world")).Return(6, nil)
+	assert.Nil(t, h.(*FileHandle).Write(nil, &fuse.WriteRequest{Data: []byte(" world"), Offset: 5}, &fuse.WriteResponse{}))
+	hdfsWriter.EXPECT().Close().Return(nil)
+	assert.Nil(t, h.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+}
+
+// Appending to the file held by a lease of a crashed writer recovers the lease and retries
+func TestLeaseRecoveryOnAppend(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.AppendMode = AppendNative
+	fs.RecoverLease = true
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/log.txt").Return(Attrs{Name: "log.txt", Mode: 0644, Size: 5}, nil)
+	file, _ := root.(*Dir).Lookup(nil, "log.txt")
+
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	leaseErr := errors.New("org.apache.hadoop.hdfs.protocol.AlreadyBeingCreatedException: Failed to APPEND_FILE /log.txt")
+	gomock.InOrder(
+		hdfsAccessor.EXPECT().Append("/log.txt").Return(nil, leaseErr),
+		hdfsAccessor.EXPECT().RecoverLease("/log.txt").Return(true, nil),
+		hdfsAccessor.EXPECT().Append("/log.txt").Return(hdfsWriter, nil))
+	h, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenAppend}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	hdfsWriter.EXPECT().Close().Return(nil)
+	assert.Nil(t, h.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+
+	// Without lease recovery enabled, the error is returned as is
+	fs.RecoverLease = false
+	hdfsAccessor.EXPECT().Append("/log.txt").Return(nil, leaseErr)
+	_, err = file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenAppend}, &fuse.OpenResponse{})
+	assert.Equal(t, leaseErr, err)
+}
+
+// Write pipeline broken by a failed data node is re-established by append, resuming from the acknowledged length
+func TestWritePipelineRecovery(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.StagingInMemory = true
+	fs.DeferCreate = true
+	fs.RecoverWritePipeline = true
+	root, _ := fs.Root()
+	_, h, err := root.(*Dir).Create(nil, &fuse.CreateRequest{Name: "big.dat", Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: 100, remaining: 100}, nil)
+	assert.Nil(t, h.(*FileHandle).Write(nil, &fuse.WriteRequest{Data: []byte("hello world")}, &fuse.WriteResponse{}))
+
+	// Data node dies after acknowledging first 5 bytes, the rest goes via the new pipeline
+	brokenWriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Remove("/big.dat").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/big.dat", os.FileMode(0644)).Return(brokenWriter, nil)
+	brokenWriter.EXPECT().Write([]byte("hello world")).Return(0, &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)})
+	brokenWriter.EXPECT().Close().Return(errors.New("pipeline closed"))
+	hdfsAccessor.EXPECT().Stat("/big.dat").Return(Attrs{Name: "big.dat", Mode: 0644, Size: 5}, nil)
+	newWriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Append("/big.dat").Return(newWriter, nil)
+	newWriter.EXPECT().Write([]byte(" world")).Return(6, nil)
+	newWriter.EXPECT().Close().Return(nil)
+	assert.Nil(t, h.(*FileHandle).Flush(nil, &fuse.FlushRequest{}))
+	assert.Nil(t, h.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+
+	// Other failures aren't recovered this way
+	assert.False(t, IsBrokenPipeError(errors.New("disk quota exceeded")))
+	assert.True(t, IsBrokenPipeError(syscall.ECONNRESET))
+}
+
+// Files are created with as many data nodes in the write pipeline as the ack level requires,
+// and Flush returns only after the pipeline has acknowledged the data (on close of HDFS file)
+func TestWriteAckLevel(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.DeferCreate = true
+	root, _ := fileSystem.Root()
+	assert.Equal(t, DefaultReplication, fileSystem.WriteAckReplicas())
+	fileSystem.WriteAckLevel = WriteAckMajority
+	assert.Equal(t, 2, fileSystem.WriteAckReplicas())
+	fileSystem.WriteAckLevel = WriteAckOne
+
+	_, h, err := root.(*Dir).Create(nil, &fuse.CreateRequest{Name: "fast.txt", Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: 100, remaining: 100}, nil)
+	err = h.(*FileHandle).Write(nil, &fuse.WriteRequest{Data: []byte("hello")}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Remove("/fast.txt").Return(nil)
+	hdfsAccessor.EXPECT().CreateFileWithReplication("/fast.txt", os.FileMode(0644), 1).Return(hdfsWriter, nil)
+	hdfsWriter.EXPECT().Write([]byte("hello")).Return(5, nil)
+	acknowledged := false
+	hdfsWriter.EXPECT().Close().DoAndReturn(func() error {
+		// Close completes once the pipeline has acknowledged all the packets
+		time.Sleep(10 * time.Millisecond)
+		acknowledged = true
+		return nil
+	})
+	assert.Nil(t, h.(*FileHandle).Flush(nil, &fuse.FlushRequest{}))
+	assert.True(t, acknowledged)
+	assert.Nil(t, h.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+}
+
+// With LazyCloseFlush, flush on close keeps the data staged, while explicit fsync uploads it to HDFS
+func TestLazyCloseFlush(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.DeferCreate = true
+	fileSystem.LazyCloseFlush = true
+	root, _ := fileSystem.Root()
+
+	_, h, err := root.(*Dir).Create(nil, &fuse.CreateRequest{Name: "lazy.txt", Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: 100, remaining: 100}, nil)
+	err = h.(*FileHandle).Write(nil, &fuse.WriteRequest{Data: []byte("hello")}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+
+	// Close of a file descriptor doesn't reach HDFS
+	assert.Nil(t, h.(*FileHandle).Flush(nil, &fuse.FlushRequest{}))
+
+	// Fsync uploads the data
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Remove("/lazy.txt").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/lazy.txt", os.FileMode(0644)).Return(hdfsWriter, nil)
+	hdfsWriter.EXPECT().Write([]byte("hello")).Return(5, nil)
+	hdfsWriter.EXPECT().Close().Return(nil)
+	assert.Nil(t, h.(*FileHandle).Fsync(nil, &fuse.FsyncRequest{}))
+	assert.Nil(t, h.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+}
+
+// Staged data not accepted by HDFS on a failed flush is uploaded on the next flush
+func TestPartialFlushFailure(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.DeferCreate = true
+	root, _ := fileSystem.Root()
+
+	_, h, err := root.(*Dir).Create(nil, &fuse.CreateRequest{Name: "partial.txt", Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: 100, remaining: 100}, nil)
+	err = h.(*FileHandle).Write(nil, &fuse.WriteRequest{Data: []byte("hello world")}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+
+	// Only the beginning of the data is accepted before the pipeline fails
+	failure := errors.New("pipeline failed")
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Remove("/partial.txt").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/partial.txt", os.FileMode(0644)).Return(hdfsWriter, nil)
+	hdfsWriter.EXPECT().Write([]byte("hello world")).Return(5, failure)
+	hdfsWriter.EXPECT().Close().Return(nil)
+	assert.Equal(t, failure, h.(*FileHandle).Flush(nil, &fuse.FlushRequest{}))
+	assert.Equal(t, int64(5), h.(*FileHandle).Writer.acknowledged)
+
+	// Remainder is retained, the content is written in full on the next flush
+	hdfsWriter = NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Remove("/partial.txt").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/partial.txt", os.FileMode(0644)).Return(hdfsWriter, nil)
+	hdfsWriter.EXPECT().Write([]byte("hello world")).Return(11, nil)
+	hdfsWriter.EXPECT().Close().Return(nil)
+	assert.Nil(t, h.(*FileHandle).Flush(nil, &fuse.FlushRequest{}))
+	assert.Equal(t, int64(11), h.(*FileHandle).Writer.acknowledged)
+
+	// Nothing is left to upload
+	assert.Nil(t, h.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+	mockCtrl.Finish()
+}
+
+// First write to a file read with stale metadata is rejected if the file has changed in HDFS
+func TestWriteFreshness(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.WriteFreshness = time.Minute
+	root, _ := fileSystem.Root()
+
+	mtime := mockClock.Now().Add(-time.Hour)
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: 0644, Size: 5, Mtime: mtime}, nil)
+	file, err := root.(*Dir).Lookup(nil, "foo")
+	assert.Nil(t, err)
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/foo").Return(hdfsReader, nil)
+	h, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, nil)
+	assert.Nil(t, err)
+	handle := h.(*FileHandle)
+
+	// Metadata within the freshness bound isn't re-validated
+	mockClock.NotifyTimeElapsed(30 * time.Second)
+	assert.Nil(t, handle.ValidateFreshness())
+
+	// Unchanged file passes the validation
+	mockClock.NotifyTimeElapsed(time.Minute)
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: 0644, Size: 5, Mtime: mtime}, nil)
+	assert.Nil(t, handle.ValidateFreshness())
+
+	// File is appended by another client after it has been read
+	mockClock.NotifyTimeElapsed(2 * time.Minute)
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: 0644, Size: 7, Mtime: mtime.Add(time.Minute)}, nil)
+	err = handle.Write(nil, &fuse.WriteRequest{Data: []byte("world"), Offset: 5}, &fuse.WriteResponse{})
+	assert.Equal(t, fuse.Errno(syscall.ESTALE), err)
+	assert.Nil(t, handle.Writer)
+	assert.Equal(t, uint64(7), file.(*File).Attrs.Size)
+
+	hdfsReader.EXPECT().Close().Return(nil)
+	assert.Nil(t, handle.Release(nil, &fuse.ReleaseRequest{}))
+	mockCtrl.Finish()
+}
+
+// With CreateAsCaller, new file appears in HDFS already owned by the user creating it
+func TestCreateAsCaller(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.CreateAsCaller = true
+	root, _ := fileSystem.Root()
+	header := fuse.Header{Uid: 54321, Gid: 54322}
+	owner, group := OwnerNames(54321, 54322)
+
+	// File is chowned under a hidden name before it is renamed into place
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	gomock.InOrder(
+		hdfsAccessor.EXPECT().Remove("/owned.txt").Return(nil),
+		hdfsAccessor.EXPECT().CreateFile("/"+MountTempPrefix+"owned.txt", os.FileMode(0644)).Return(hdfsWriter, nil),
+		hdfsAccessor.EXPECT().Chown("/"+MountTempPrefix+"owned.txt", owner, group).Return(nil),
+		hdfsAccessor.EXPECT().Rename("/"+MountTempPrefix+"owned.txt", "/owned.txt").Return(nil),
+		hdfsWriter.EXPECT().Close().Return(nil))
+	node, h, err := root.(*Dir).Create(nil, &fuse.CreateRequest{Header: header, Name: "owned.txt", Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	var attr fuse.Attr
+	assert.Nil(t, node.(*File).presentAttr(&attr))
+	assert.Equal(t, uint32(54321), attr.Uid)
+	assert.Equal(t, uint32(54322), attr.Gid)
+	assert.Nil(t, h.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+
+	// Creation fails if the file can't be chowned, temporary file is removed
+	failure := errors.New("permission denied")
+	hdfsWriter = NewMockHdfsWriter(mockCtrl)
+	gomock.InOrder(
+		hdfsAccessor.EXPECT().Remove("/other.txt").Return(nil),
+		hdfsAccessor.EXPECT().CreateFile("/"+MountTempPrefix+"other.txt", os.FileMode(0644)).Return(hdfsWriter, nil),
+		hdfsAccessor.EXPECT().Chown("/"+MountTempPrefix+"other.txt", owner, group).Return(failure),
+		hdfsWriter.EXPECT().Close().Return(nil),
+		hdfsAccessor.EXPECT().Remove("/"+MountTempPrefix+"other.txt").Return(nil))
+	_, _, err = root.(*Dir).Create(nil, &fuse.CreateRequest{Header: header, Name: "other.txt", Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Equal(t, failure, err)
+	mockCtrl.Finish()
+}
+
+// With CloseToOpen, file opened after it has been written and closed is revalidated and read in full
+func TestCloseToOpen(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.CloseToOpen = true
+	fileSystem.ReaderReuseTTL = time.Minute
+	root, _ := fileSystem.Root()
+	mtime := mockClock.Now().Add(-time.Hour)
+
+	// File is read and closed, its backend reader is kept for reuse
+	hdfsAccessor.EXPECT().Stat("/c2o.txt").Return(Attrs{Name: "c2o.txt", Mode: 0644, Size: 5, Mtime: mtime}, nil).Times(2)
+	node, err := root.(*Dir).Lookup(nil, "c2o.txt")
+	assert.Nil(t, err)
+	file := node.(*File)
+	oldReader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/c2o.txt").Return(oldReader, nil)
+	h, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+	assert.Nil(t, err)
+	oldReader.whenReadReturn([]byte("Hello"), io.EOF)
+	h.(*FileHandle).readAndVerify(t, 0, 5, []byte("Hello"))
+	assert.Nil(t, h.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+
+	// File is overwritten and closed
+	hdfsAccessor.EXPECT().Stat("/c2o.txt").Return(Attrs{Name: "c2o.txt", Mode: 0644, Size: 5, Mtime: mtime}, nil)
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Remove("/c2o.txt").Return(nil).Times(2)
+	hdfsAccessor.EXPECT().CreateFile("/c2o.txt", os.FileMode(0644)).Return(hdfsWriter, nil).Times(2)
+	hdfsWriter.EXPECT().Close().Return(nil).Times(2)
+	oldReader.EXPECT().Close().Return(nil)
+	w, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly}, nil)
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: 100, remaining: 100}, nil)
+	assert.Nil(t, w.(*FileHandle).Write(nil, &fuse.WriteRequest{Data: []byte("Hello, world!")}, &fuse.WriteResponse{}))
+	hdfsWriter.EXPECT().Write([]byte("Hello, world!")).Return(13, nil)
+	assert.Nil(t, w.(*FileHandle).Flush(nil, &fuse.FlushRequest{}))
+	assert.Nil(t, w.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+
+	// Subsequent open sees the complete new content right away
+	mockClock.NotifyTimeElapsed(time.Second)
+	hdfsAccessor.EXPECT().Stat("/c2o.txt").Return(Attrs{Name: "c2o.txt", Mode: 0644, Size: 13, Mtime: mockClock.Now()}, nil)
+	newReader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/c2o.txt").Return(newReader, nil)
+	h, err = file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(13), file.Attrs.Size)
+	newReader.whenReadReturn([]byte("Hello, world!"), io.EOF)
+	h.(*FileHandle).readAndVerify(t, 0, 13, []byte("Hello, world!"))
+	fileSystem.ReaderReuseTTL = 0
+	newReader.EXPECT().Close().Return(nil)
+	assert.Nil(t, h.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+	mockCtrl.Finish()
+}
@@ -7,29 +7,140 @@ import (
 	"bazil.org/fuse/fs"
 	"golang.org/x/net/context"
 
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
+	"path"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
 type FileSystem struct {
-	MountPoint      string       // Path to the mount point on a local file system
-	HdfsAccessor    HdfsAccessor // Interface to access HDFS
-	AllowedPrefixes []string     // List of allowed path prefixes (only those prefixes are exposed via mountpoint)
-	ExpandZips      bool         // Indicates whether ZIP expansion feature is enabled
-	ReadOnly        bool         // Indicates whether mount filesystem with readonly
-	Mounted         bool         // True if filesystem is mounted
-	RetryPolicy     *RetryPolicy // Retry policy
-	Clock           Clock        // interface to get wall clock time
-	FsInfo          FsInfo       // Usage of HDFS, including capacity, remaining, used sizes.
-
-	closeOnUnmount     []io.Closer // list of opened files (zip archives) to be closed on unmount
-	closeOnUnmountLock sync.Mutex  // mutex to protet closeOnUnmount
+	MountPoint            string          // Path to the mount point on a local file system
+	HdfsAccessor          HdfsAccessor    // Interface to access HDFS
+	AllowedPrefixes       []string        // List of allowed path prefixes (only those prefixes are exposed via mountpoint)
+	ExpandZips            bool            // Indicates whether ZIP expansion feature is enabled
+	ReadOnly              bool            // Indicates whether mount filesystem with readonly
+	DisabledOps           map[string]bool // Operations which fail with EPERM regardless of backend permissions, by name (see DisableableOps)
+	ReadOnlyWindows       []TimeWindow    // Daily time windows during which modifying operations and uploads of staged content fail with EROFS
+	AllowNonEmpty         bool            // Indicates whether mounting over non-empty directory (or existing mount) is allowed
+	EnforcePermissions    bool            // Indicates whether mode bits and ACLs are checked against the identity of the caller on open
+	EffectiveAccess       bool            // Indicates whether access() is answered with effective permission of the caller (mode bits and ACLs)
+	InheritDefaultAcl     bool            // Indicates whether new files get permissions derived from default ACL of the directory (instead of umask)
+	DirListingOnRead      bool            // Indicates whether reading directory opened as a file returns names of its entries (EISDIR otherwise)
+	HonorODirect          bool            // Indicates whether handles opened with O_DIRECT bypass read/write buffering
+	RejectReadOnlyWrites  bool            // Indicates whether writes to the handles opened read-only fail with EBADF (instead of enabling write)
+	ExposeConfig          bool            // Indicates whether effective configuration is exposed as virtual file at the root of the mount
+	NameNode              string          // Name node address (or cluster spec) the file system is mounted from
+	Flags                 MountFlags      // Effective command-line flags, reported by the virtual config file
+	ExposeQuotaFile       bool            // Indicates whether each directory exposes virtual file with its quota and usage
+	ExposeSnapshotDiff    bool            // Indicates whether each directory exposes virtual directory with diffs between its snapshots
+	ExposeModSeq          bool            // Indicates whether files expose their modification sequence as 'user.hdfs.modseq' extended attribute
+	ExposeBlockLayout     bool            // Indicates whether files expose their HDFS block layout as 'user.hdfs.blocks' extended attribute
+	CreateAsCaller        bool            // Indicates whether new files are owned by the user creating them (instead of the mount's HDFS user) from the moment they appear
+	ExclusiveCreate       bool            // Indicates whether create with O_EXCL fails with EEXIST if the file exists (checked with HDFS)
+	HideTempFiles         bool            // Indicates whether mount's temporary files in HDFS ('.hdfs-mount-tmp-*') are hidden from listings and lookups
+	CaseCollisions        string          // Handling of entries whose names differ only in case: "allow" (default), "hide", "suffix" or "fail"
+	RenameLocking         bool            // Indicates whether lookups wait for renames in the same directory, so they never observe torn state
+	CheckNameQuota        bool            // Indicates whether create and mkdir check namespace quotas of the directory and its ancestors upfront
+	NameQuotaCacheTTL     time.Duration   // Time for which namespace quotas and usage checked with CheckNameQuota are cached
+	Mounted               bool            // True if filesystem is mounted
+	RetryPolicy           *RetryPolicy    // Retry policy
+	Clock                 Clock           // interface to get wall clock time
+	FsInfo                FsInfo          // Usage of HDFS, including capacity, remaining, used sizes.
+	ClockSkew             time.Duration   // Skew between HDFS and local clocks (positive if HDFS clock is ahead)
+	PathRewriter          *PathRewriter   // Maps virtual paths to HDFS paths (nil if no rewrites are configured)
+	TrashDir              string          // HDFS trash directory exposed as TrashName at the root of the mount ("" if not exposed)
+	ReadStrategies        *ReadStrategies // Maps file names to read strategies (nil if not configured)
+	AttrOverrides         *AttrOverrides  // Overrides mode, owner and group reported for paths matching glob patterns (nil if not configured)
+	Transcoding           *Transcoding    // Selects files transcoded to UTF-8 on read (nil if not configured)
+	OpenFlagModes         *OpenFlagModes  // Maps open flags to behaviors of the handles, e.g. O_SYNC to write-through (nil if not configured)
+	ReadOnceAction        string          // Action applied to a file once it has been fully read: "move", "delete" or "" (none)
+	DeletedOpenAttrs      bool            // Indicates whether handles of files removed while opened keep serving last known attributes (instead of ENOENT)
+	WriteFreshness        time.Duration   // Maximum age of metadata of a file on the first write of a handle, staler metadata is re-validated (0 to disable)
+	CloseToOpen           bool            // Indicates whether open revalidates attributes of the file, so it sees complete content as of the last close by any writer
+	LazyCloseFlush        bool            // Indicates whether FUSE flush on close keeps data staged, so it is only uploaded on fsync or release
+	WriteAckLevel         string          // Number of data nodes acknowledging writes before Flush returns: "one", "majority" or "all" (default)
+	WriteConfirmation     string          // Verification of written files on close: "length", "checksum" or "" (disabled)
+	ReadaheadTriggerCount int             // Number of consecutive sequential reads which triggers aggressive read-ahead (0 to disable)
+	ReadaheadBytes        int             // Size of the window read ahead in background once sequential reads are detected (0 to disable)
+	MaxListingEntries     int             // Maximum number of entries returned by directory listing, the rest are omitted (0 for unlimited)
+	MaxNameLength         int             // New names longer than this (in bytes) are rejected with ENAMETOOLONG (0 for unlimited)
+	MaxPathLength         int             // New HDFS paths longer than this (in bytes) are rejected with ENAMETOOLONG (0 for unlimited)
+	MarkTruncatedListing  bool            // Indicates whether truncated directory listing ends with ListingTruncatedName entry
+	StreamingWriteBuffer  int64           // New files are streamed to HDFS buffering at most this number of bytes (0 to use staging)
+	DeferCreate           bool            // Indicates whether new files are created in HDFS together with their content on the first flush
+	AppendMode            string          // Handling of writes to files opened with O_APPEND: "emulate", "native" or "native-or-emulate"
+	ExcludeReservedSpace  bool            // Indicates whether statfs excludes non-HDFS used space from capacity and ReservedSpace from available space
+	ReservedSpace         uint64          // Space reserved for non-HDFS use on all the data nodes together (total of dfs.datanode.du.reserved)
+	SmallFileThreshold    uint64          // Files smaller than this are read entirely into memory on first access (0 to disable)
+	FollowGrowth          bool            // Indicates whether reader hitting EOF re-stats the file and continues reading if it has grown
+	EscapeNames           bool            // Indicates whether invalid UTF-8 bytes (and '%') in names are percent-encoded (see EscapeName)
+	SequentialDirPrefetch bool            // Indicates whether opening a file for read prefetches beginning of the next file in the directory
+	ZeroFillMissingBlocks bool            // Indicates whether blocks without available replicas are read as zeros (EIO otherwise)
+	PrefetchBlocks        bool            // Indicates whether block locations are fetched on open (and together with the prefetched next file)
+	RecoverLease          bool            // Indicates whether lease of a stale writer is recovered if it prevents writing the file
+	RetryClose            bool            // Indicates whether failed close of HDFS file is retried, and error of the final attempt is reported on release
+	RecoverWritePipeline  bool            // Indicates whether write pipeline broken by a data node failure is re-established, resuming the upload
+	WaitSafeMode          time.Duration   // How long namespace-modifying operations wait for name node to leave safe mode before failing with EROFS
+	ChecksumSidecar       string          // Style of virtual checksum files exposed next to each file: "visible", "hidden" or "" (disabled)
+	ReadInProgress        string          // Behavior on opening for read a file being written elsewhere: "allow", "deny" or "wait"
+	CompressOnWrite       bool            // Indicates whether content written to *.gz files is gzip-compressed before going to HDFS
+	AllowSymlinks         string          // Allowed symlink operations: "create", "read" (default if empty) or "none"
+	MaxSymlinkHops        int             // Maximum number of symlinks traversed while resolving a path before failing with ELOOP (0 for unlimited)
+	ReservedPaths         string          // Access to HDFS reserved paths (/.reserved): "all" (default if empty), "raw" (read-only raw view) or "none"
+	MaxReadsPerFile       int             // Maximum number of concurrent backend reads of a single file, excess reads queue (0 for unlimited)
+	ReaderReuseTTL        time.Duration   // How long backend reader of the closed file is kept open for reuse on reopen (0 to disable)
+	ParallelBlockReads    int             // Max number of blocks fetched in parallel for a read spanning several blocks (0 to read them sequentially)
+	CoalesceReadSize      int             // Max size of reads coalesced with concurrent reads of nearby offsets by other handles of the file (0 to disable)
+	ReadMetadataWait      time.Duration   // Max wait for the name node when reading opened file through additional readers, before using the handle's reader (0 for unlimited)
+	BackendReadSize       int             // Maximum size of a single backend read, larger requests are served by multiple reads (0 for unlimited)
+	UncompressedSize      bool            // Indicates whether files compressed on write report size of the uncompressed content
+	SortListings          string          // Order of the directory listings: ListingSortName, ListingSortMtime, ListingSortSize ("" for HDFS order)
+	ReadDirPageSize       int             // Directories are listed in pages of this size, failed pages are retried and partial listing returned (0 to list at once)
+	StaleVanishedDirs     bool            // Indicates whether paged listing of a directory deleted while being listed fails with ESTALE (instead of partial listing)
+	MetadataCacheTTL      time.Duration   // Time for which attributes fetched from HDFS are cached before the file or directory is re-statted
+	StatfsCacheTTL        time.Duration   // Time for which HDFS capacity and usage reported by statfs are cached (0 to query name node on each statfs)
+	AttrCache             *AttrCache      // Bounds number of files with cached attributes (nil if unbounded)
+	CachePolicyMarkers    bool            // Indicates whether caching policy of the files is set by marker files in their directories (see CachePolicy)
+	DedupCache            *DedupCache     // Content-addressed cache of identical blocks across files (nil if disabled)
+	PrefetchBudget        *PrefetchBudget // Bounds memory held by prefetch and read-ahead buffers across all the files (nil if unbounded)
+	RetryInterrupted      bool            // Retry interrupted idempotent operations (reads, stats) once instead of returning EINTR
+	AuditLog              *AuditLog       // Log of namespace-modifying operations (nil if disabled)
+	CommitHook            CommitHook      // Notified when a file has been written and closed (nil if disabled)
+	Tracer                *Tracer         // Produces spans around FUSE operations and backend calls (nil if tracing is disabled)
+	StagingDir            string          // Local directory used to buffer contents of the files being written
+	PerUserStaging        bool            // Indicates whether staging files are isolated in per-user subdirectories of StagingDir
+	StagingMissing        string          // Behavior if staging directory is unavailable: "create", "fail" or "memory"
+	StagingInMemory       bool            // True if files being written are buffered in memory (staging directory is unavailable)
+
+	closeOnUnmount     []io.Closer    // list of opened files (zip archives) to be closed on unmount
+	closeOnUnmountLock sync.Mutex     // mutex to protet closeOnUnmount
+	createLocks        PathLocks      // serializes exclusive creates of the same path (see Dir.Create)
+	appendUnsupported  int32          // set to 1 (atomically) once the cluster reports that append isn't supported
+	statfsInfo         FsInfo         // HDFS capacity and usage cached by Statfs
+	statfsExpires      time.Time      // time when statfsInfo expires
+	statfsMutex        sync.Mutex     // mutex for statfsInfo and statfsExpires
+	nameQuotas         NameQuotaCache // namespace quotas of the directories (with CheckNameQuota)
 }
 
+// Default time for which HDFS capacity and usage reported by statfs are cached
+var DefaultStatfsCacheTTL time.Duration = 5 * time.Second
+
+// Default location of the staging directory
+const DefaultStagingDir = "/var/hdfs-mount"
+
+// Name of the entry appended to truncated directory listings (with MarkTruncatedListing enabled)
+const ListingTruncatedName = ".listing-truncated"
+
+// Name of the sibling directory where files are moved after being fully read (with ReadOnceAction=="move")
+const ReadOnceProcessedDir = "processed"
+
 // Verify that *FileSystem implements necesary FUSE interfaces
 var _ fs.FS = (*FileSystem)(nil)
 var _ fs.FSStatfser = (*FileSystem)(nil)
@@ -37,40 +148,42 @@ var _ fs.FSStatfser = (*FileSystem)(nil)
 // Creates an instance of mountable file system
 func NewFileSystem(hdfsAccessor HdfsAccessor, mountPoint string, allowedPrefixes []string, expandZips bool, readOnly bool, retryPolicy *RetryPolicy, clock Clock) (*FileSystem, error) {
 	return &FileSystem{
-		HdfsAccessor:    hdfsAccessor,
-		MountPoint:      mountPoint,
-		Mounted:         false,
-		AllowedPrefixes: allowedPrefixes,
-		ExpandZips:      expandZips,
-		ReadOnly:        readOnly,
-		RetryPolicy:     retryPolicy,
-		Clock:           clock}, nil
+		HdfsAccessor:      hdfsAccessor,
+		MountPoint:        mountPoint,
+		Mounted:           false,
+		AllowedPrefixes:   allowedPrefixes,
+		ExpandZips:        expandZips,
+		ReadOnly:          readOnly,
+		RetryPolicy:       retryPolicy,
+		Clock:             clock,
+		StagingDir:        DefaultStagingDir,
+		StagingMissing:    "create",
+		MaxNameLength:     DefaultMaxNameLength,
+		MaxPathLength:     DefaultMaxPathLength,
+		MetadataCacheTTL:  DefaultMetadataCacheTTL,
+		StatfsCacheTTL:    DefaultStatfsCacheTTL,
+		NameQuotaCacheTTL: DefaultNameQuotaCacheTTL}, nil
 }
 
 // Mounts the filesystem
 func (this *FileSystem) Mount() (*fuse.Conn, error) {
-	var conn *fuse.Conn
-	var err error
+	if err := this.CheckMountPoint(); err != nil {
+		return nil, err
+	}
+	options := []fuse.MountOption{
+		fuse.FSName("hdfs"),
+		fuse.Subtype("hdfs"),
+		fuse.VolumeName("HDFS filesystem"),
+		fuse.AllowOther(),
+		fuse.WritebackCache(),
+		fuse.MaxReadahead(1024 * 64)} //TODO: make configurable
 	if this.ReadOnly {
-		conn, err = fuse.Mount(
-			this.MountPoint,
-			fuse.FSName("hdfs"),
-			fuse.Subtype("hdfs"),
-			fuse.VolumeName("HDFS filesystem"),
-			fuse.AllowOther(),
-			fuse.WritebackCache(),
-			fuse.MaxReadahead(1024*64),  //TODO: make configurable
-			fuse.ReadOnly())
-	} else {
-		conn, err = fuse.Mount(
-			this.MountPoint,
-			fuse.FSName("hdfs"),
-			fuse.Subtype("hdfs"),
-			fuse.VolumeName("HDFS filesystem"),
-			fuse.AllowOther(),
-			fuse.WritebackCache(),
-			fuse.MaxReadahead(1024*64))  //TODO: make configurable
+		options = append(options, fuse.ReadOnly())
 	}
+	if this.AllowNonEmpty {
+		options = append(options, fuse.AllowNonEmptyMount())
+	}
+	conn, err := fuse.Mount(this.MountPoint, options...)
 	if err != nil {
 		return nil, err
 	}
@@ -107,9 +220,15 @@ func (this *FileSystem) Root() (fs.Node, error) {
 
 // Returns if given absoute path allowed by any of the prefixes
 func (this *FileSystem) IsPathAllowed(path string) bool {
-	if path == "/" {
+	if path == "/" || this.IsTrashPath(path) {
 		return true
 	}
+	if IsReservedPath(path) && !this.IsReservedPathAllowed(path) {
+		return false
+	}
+	if this.HideTempFiles && IsMountTempPath(path) {
+		return false
+	}
 	for _, prefix := range this.AllowedPrefixes {
 		if prefix == "*" {
 			return true
@@ -122,6 +241,11 @@ func (this *FileSystem) IsPathAllowed(path string) bool {
 	return false
 }
 
+// Records namespace-modifying operation in the audit log (if enabled)
+func (this *FileSystem) Audit(header fuse.Header, op string, path string, details string) {
+	this.AuditLog.Record(AuditRecord{Time: this.Clock.Now(), Op: op, Path: path, Details: details, Uid: header.Uid, Gid: header.Gid})
+}
+
 // Register a file to be closed on Unmount()
 func (this *FileSystem) CloseOnUnmount(file io.Closer) {
 	this.closeOnUnmountLock.Lock()
@@ -132,14 +256,125 @@ func (this *FileSystem) CloseOnUnmount(file io.Closer) {
 // Statfs is called to obtain file system metadata.
 // It should write that data to resp.
 func (this *FileSystem) Statfs(ctx context.Context, req *fuse.StatfsRequest, resp *fuse.StatfsResponse) error {
-	fsInfo, err := this.HdfsAccessor.StatFs()
+	fsInfo, err := this.CachedStatFs()
 	if err != nil {
 		Warning.Println("Failed to get HDFS info,", err)
 		return err
 	}
+	capacity, free, available := this.AvailableSpace(fsInfo)
 	resp.Bsize = 1024
-	resp.Bfree = fsInfo.remaining / uint64(resp.Bsize)
-	resp.Bavail = resp.Bfree
-	resp.Blocks = fsInfo.capacity / uint64(resp.Bsize)
+	resp.Frsize = resp.Bsize
+	resp.Bfree = free / uint64(resp.Bsize)
+	resp.Bavail = available / uint64(resp.Bsize)
+	resp.Blocks = capacity / uint64(resp.Bsize)
+	return nil
+}
+
+// Returns HDFS capacity and usage, querying the name node at most once per StatfsCacheTTL
+func (this *FileSystem) CachedStatFs() (FsInfo, error) {
+	this.statfsMutex.Lock()
+	defer this.statfsMutex.Unlock()
+	now := this.Clock.Now()
+	if now.Before(this.statfsExpires) {
+		return this.statfsInfo, nil
+	}
+	fsInfo, err := this.HdfsAccessor.StatFs()
+	if err != nil {
+		return FsInfo{}, err
+	}
+	this.statfsInfo = fsInfo
+	this.statfsExpires = now.Add(this.StatfsCacheTTL)
+	return fsInfo, nil
+}
+
+// Verifies that staging directory is available and writable, applying StagingMissing behavior otherwise:
+// "create" creates the directory, "fail" returns an error, "memory" switches to in-memory buffering
+func (this *FileSystem) PrepareStagingDir() error {
+	if this.StagingMissing == "create" {
+		if err := os.MkdirAll(this.StagingDir, 0700); err != nil {
+			Error.Println("Failed to create staging directory", this.StagingDir, ":", err)
+			return err
+		}
+	}
+	probe, err := NewDiskStagingFile(this.StagingDir)
+	if err != nil {
+		if this.StagingMissing == "memory" {
+			Warning.Println("Staging directory", this.StagingDir, "is unavailable, buffering writes in memory:", err)
+			this.StagingInMemory = true
+			return nil
+		}
+		Error.Println("Staging directory", this.StagingDir, "is unavailable:", err)
+		return err
+	}
+	this.StagingInMemory = false
+	return probe.Close()
+}
+
+// Creates staging file to buffer contents of the file being written by the user (uid, gid)
+func (this *FileSystem) CreateStagingFile(uid uint32, gid uint32) (StagingFile, error) {
+	if this.StagingInMemory {
+		return &MemoryStagingFile{}, nil
+	}
+	if this.StagingMissing == "create" {
+		if err := os.MkdirAll(this.StagingDir, 0700); err != nil {
+			Error.Println("Failed to create staging directory", this.StagingDir, ":", err)
+			return nil, err
+		}
+	}
+	stagingDir, err := this.UserStagingDir(uid, gid)
+	if err != nil {
+		return nil, err
+	}
+	return NewDiskStagingFile(stagingDir)
+}
+
+// Returns directory for the staging files of the user. With PerUserStaging enabled, it's subdirectory
+// of StagingDir named by uid, accessible only by the user, so buffered writes of the users are isolated
+func (this *FileSystem) UserStagingDir(uid uint32, gid uint32) (string, error) {
+	if !this.PerUserStaging {
+		return this.StagingDir, nil
+	}
+	stagingDir := path.Join(this.StagingDir, strconv.FormatUint(uint64(uid), 10))
+	if err := os.Mkdir(stagingDir, 0700); err != nil {
+		if os.IsExist(err) {
+			if err = checkUserStagingDir(stagingDir, uid); err != nil {
+				Error.Println("Staging directory", stagingDir, "can't be used:", err)
+				return "", err
+			}
+			return stagingDir, nil
+		}
+		Error.Println("Failed to create staging directory", stagingDir, ":", err)
+		return "", err
+	}
+	if os.Geteuid() == 0 {
+		if err := os.Chown(stagingDir, int(uid), int(gid)); err != nil {
+			Error.Println("Failed to change owner of staging directory", stagingDir, ":", err)
+			os.Remove(stagingDir)
+			return "", err
+		}
+	}
+	return stagingDir, nil
+}
+
+// Verifies that existing staging directory of the user is safe to use: it must be a real directory (not a symlink),
+// owned by the user (or by the mount process if it doesn't run as root) and inaccessible by anybody else
+func checkUserStagingDir(stagingDir string, uid uint32) error {
+	info, err := os.Lstat(stagingDir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory (mode %s)", stagingDir, info.Mode())
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%s is accessible by other users (mode %s)", stagingDir, info.Mode())
+	}
+	owner := uint32(os.Geteuid())
+	if owner == 0 {
+		owner = uid
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || stat.Uid != owner {
+		return fmt.Errorf("%s isn't owned by uid %d", stagingDir, owner)
+	}
 	return nil
 }
@@ -4,9 +4,15 @@ package main
 
 import (
 	"bazil.org/fuse"
+	"fmt"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestIsPathAllowedForStarPrefix(t *testing.T) {
@@ -41,3 +47,211 @@ func TestStatfs(t *testing.T) {
 	assert.Equal(t, uint64(10), fsInfo.Blocks)
 	assert.Equal(t, uint64(1), fsInfo.Bfree)
 }
+
+// Statfs converts capacity and usage of multi-terabyte file system into blocks, querying name node once per StatfsCacheTTL
+func TestStatfsMultiTerabyte(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.StatfsCacheTTL = 10 * time.Second
+
+	const TB = uint64(1) << 40
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: 500 * TB, used: 123 * TB, remaining: 377 * TB}, nil)
+	resp := &fuse.StatfsResponse{}
+	assert.Nil(t, fileSystem.Statfs(nil, &fuse.StatfsRequest{}, resp))
+	assert.Equal(t, uint32(1024), resp.Bsize)
+	assert.Equal(t, uint32(1024), resp.Frsize)
+	assert.Equal(t, 500*TB/1024, resp.Blocks)
+	assert.Equal(t, 377*TB/1024, resp.Bfree)
+	assert.Equal(t, 377*TB/1024, resp.Bavail)
+	assert.Equal(t, 500*TB, resp.Blocks*uint64(resp.Bsize))
+
+	// Cached capacity is reported until the TTL elapses
+	mockClock.NotifyTimeElapsed(5 * time.Second)
+	assert.Nil(t, fileSystem.Statfs(nil, &fuse.StatfsRequest{}, resp))
+	assert.Equal(t, 377*TB/1024, resp.Bfree)
+
+	mockClock.NotifyTimeElapsed(5 * time.Second)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: 500 * TB, used: 124 * TB, remaining: 376 * TB}, nil)
+	assert.Nil(t, fileSystem.Statfs(nil, &fuse.StatfsRequest{}, resp))
+	assert.Equal(t, 376*TB/1024, resp.Bfree)
+	mockCtrl.Finish()
+}
+
+// Statfs excludes non-HDFS used space and reserved space with ExcludeReservedSpace
+func TestStatfsExcludesReservedSpace(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.ExcludeReservedSpace = true
+	fileSystem.ReservedSpace = 2048
+
+	// 10240 bytes of capacity: 4096 used by HDFS, 1024 by non-HDFS data, 5120 remaining (2048 of it reserved)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: 10240, used: 4096, remaining: 5120}, nil)
+	resp := &fuse.StatfsResponse{}
+	assert.Nil(t, fileSystem.Statfs(nil, &fuse.StatfsRequest{}, resp))
+	assert.Equal(t, uint64(9), resp.Blocks)
+	assert.Equal(t, uint64(5), resp.Bfree)
+	assert.Equal(t, uint64(3), resp.Bavail)
+
+	// Reservation exceeding remaining space leaves nothing available
+	mockClock.NotifyTimeElapsed(fileSystem.StatfsCacheTTL)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: 10240, used: 8192, remaining: 1024}, nil)
+	assert.Nil(t, fileSystem.Statfs(nil, &fuse.StatfsRequest{}, resp))
+	assert.Equal(t, uint64(9), resp.Blocks)
+	assert.Equal(t, uint64(1), resp.Bfree)
+	assert.Equal(t, uint64(0), resp.Bavail)
+}
+
+// Testing configured behaviors for unavailable (unwritable) staging directory
+func TestStagingDirUnavailable(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	// Directory can't be created under a regular file, regardless of the permissions of the current user
+	regularFile, err := ioutil.TempFile("", "staging")
+	assert.Nil(t, err)
+	defer os.Remove(regularFile.Name())
+	regularFile.Close()
+	fs, _ := NewFileSystem(nil, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(WallClock{}), WallClock{})
+	fs.StagingDir = path.Join(regularFile.Name(), "staging")
+
+	fs.StagingMissing = "create"
+	assert.NotNil(t, fs.PrepareStagingDir())
+	fs.StagingMissing = "fail"
+	assert.NotNil(t, fs.PrepareStagingDir())
+	assert.False(t, fs.StagingInMemory)
+	_, err = fs.CreateStagingFile(0, 0)
+	assert.NotNil(t, err)
+
+	// Falling back to memory-only buffering
+	fs.StagingMissing = "memory"
+	assert.Nil(t, fs.PrepareStagingDir())
+	assert.True(t, fs.StagingInMemory)
+	stagingFile, err := fs.CreateStagingFile(0, 0)
+	assert.Nil(t, err)
+	_, err = stagingFile.WriteAt([]byte("world"), 6)
+	assert.Nil(t, err)
+	_, err = stagingFile.WriteAt([]byte("hello "), 0)
+	assert.Nil(t, err)
+	size, _ := stagingFile.Size()
+	assert.Equal(t, int64(11), size)
+	stagingFile.Seek(0, 0)
+	data, err := ioutil.ReadAll(stagingFile)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello world", string(data))
+	// Last chunk is returned without EOF, as for regular files
+	stagingFile.Seek(0, 0)
+	n, err := stagingFile.Read(make([]byte, 64))
+	assert.Equal(t, 11, n)
+	assert.Nil(t, err)
+	assert.Nil(t, stagingFile.Close())
+
+	// Missing staging directory is created
+	tempDir, err := ioutil.TempDir("", "staging")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	fs.StagingDir = path.Join(tempDir, "staging")
+	fs.StagingMissing = "create"
+	assert.Nil(t, fs.PrepareStagingDir())
+	assert.False(t, fs.StagingInMemory)
+	_, err = os.Stat(fs.StagingDir)
+	assert.Nil(t, err)
+}
+
+// Mounting over non-empty directory fails with descriptive error, unless AllowNonEmpty is set
+func TestCheckMountPoint(t *testing.T) {
+	mountPoint, err := ioutil.TempDir("", "mountpoint")
+	assert.Nil(t, err)
+	defer os.RemoveAll(mountPoint)
+	fs, _ := NewFileSystem(nil, mountPoint, []string{"*"}, false, false, NewDefaultRetryPolicy(WallClock{}), WallClock{})
+	assert.Nil(t, fs.CheckMountPoint())
+
+	assert.Nil(t, ioutil.WriteFile(path.Join(mountPoint, "busy.txt"), []byte("busy"), 0644))
+	err = fs.CheckMountPoint()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "is not empty")
+	assert.Contains(t, err.Error(), "-nonempty")
+
+	fs.AllowNonEmpty = true
+	assert.Nil(t, fs.CheckMountPoint())
+
+	fs.MountPoint = path.Join(mountPoint, "missing")
+	err = fs.CheckMountPoint()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "is unavailable")
+}
+
+// With PerUserStaging enabled, staging files of different users land in separate subdirectories accessible only by their owners
+func TestPerUserStaging(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	tempDir, err := ioutil.TempDir("", "staging")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	fs.StagingDir = tempDir
+	fs.PerUserStaging = true
+	root, _ := fs.Root()
+
+	stagingDirs := make(map[uint32]string)
+	for _, uid := range []uint32{1001, 1002} {
+		name := fmt.Sprintf("user%d.txt", uid)
+		hdfsWriter := NewMockHdfsWriter(mockCtrl)
+		hdfsAccessor.EXPECT().Remove("/" + name).Return(nil)
+		hdfsAccessor.EXPECT().CreateFile("/"+name, os.FileMode(0644)).Return(hdfsWriter, nil)
+		hdfsWriter.EXPECT().Close().Return(nil)
+		_, h, err := root.(*Dir).Create(nil, &fuse.CreateRequest{Header: fuse.Header{Uid: uid, Gid: uid}, Name: name, Mode: 0644}, &fuse.CreateResponse{})
+		assert.Nil(t, err)
+		stagingFile := h.(*FileHandle).Writer.stagingFile
+		stagingDirs[uid] = path.Dir(stagingFile.Name())
+		stagingFile.Close()
+	}
+	assert.Equal(t, path.Join(tempDir, "1001"), stagingDirs[1001])
+	assert.Equal(t, path.Join(tempDir, "1002"), stagingDirs[1002])
+	for uid, stagingDir := range stagingDirs {
+		info, err := os.Stat(stagingDir)
+		assert.Nil(t, err)
+		assert.Equal(t, os.ModeDir|0700, info.Mode())
+		if os.Geteuid() == 0 {
+			assert.Equal(t, uid, info.Sys().(*syscall.Stat_t).Uid)
+		}
+	}
+}
+
+// With PerUserStaging enabled, existing staging directory of the user is only used if it's private to the user
+func TestPerUserStagingRejectsUnsafeDir(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem(NewMockHdfsAccessor(mockCtrl), "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	tempDir, err := ioutil.TempDir("", "staging")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	fs.StagingDir = tempDir
+	fs.PerUserStaging = true
+	uid := uint32(os.Geteuid())
+	stagingDir := path.Join(tempDir, fmt.Sprint(uid))
+
+	// Directory accessible by others
+	assert.Nil(t, os.Mkdir(stagingDir, 0700))
+	assert.Nil(t, os.Chmod(stagingDir, 0777))
+	_, err = fs.UserStagingDir(uid, uid)
+	assert.NotNil(t, err)
+
+	// Private directory of the user
+	assert.Nil(t, os.Chmod(stagingDir, 0700))
+	dir, err := fs.UserStagingDir(uid, uid)
+	assert.Nil(t, err)
+	assert.Equal(t, stagingDir, dir)
+
+	// Symlink to a private directory
+	assert.Nil(t, os.Rename(stagingDir, stagingDir+".target"))
+	assert.Nil(t, os.Symlink(stagingDir+".target", stagingDir))
+	_, err = fs.UserStagingDir(uid, uid)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,40 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse/fs"
+)
+
+// Verify that nodes can be dropped from the node cache once the kernel forgets them
+var _ fs.NodeForgetter = (*File)(nil)
+var _ fs.NodeForgetter = (*Dir)(nil)
+
+// Responds on FUSE Forget request (kernel doesn't reference the file anymore), dropping the node from the node cache
+func (this *File) Forget() {
+	this.FileSystem.AttrCache.Remove(this)
+	// Releasing the connection of the file which won't be reopened through this node
+	this.DropIdleReader()
+	if this.Parent != nil {
+		this.Parent.EntriesForget(this)
+	}
+}
+
+// Responds on FUSE Forget request (kernel doesn't reference the directory anymore), dropping the node from the node cache
+func (this *Dir) Forget() {
+	if this.Parent != nil {
+		this.Parent.EntriesForget(this)
+	}
+}
+
+// Returns parent directory of the node and its name in the parent's entries
+// (nil parent for the root directory and virtual nodes, which aren't kept in the node cache)
+func NodeParentAndName(node fs.Node) (*Dir, string) {
+	switch n := node.(type) {
+	case *File:
+		return n.Parent, n.Attrs.Name
+	case *Dir:
+		return n.Parent, n.Attrs.Name
+	}
+	return nil, ""
+}
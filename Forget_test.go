@@ -0,0 +1,44 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// Forgotten nodes are dropped from the node cache and attribute cache, keeping entries which have been replaced
+func TestForget(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.AttrCache = NewAttrCache(100)
+	root, _ := fileSystem.Root()
+	rootDir := root.(*Dir)
+	subDir := rootDir.NodeFromAttrs(Attrs{Name: "sub", Mode: os.ModeDir | 0755}).(*Dir)
+	file := subDir.NodeFromAttrs(Attrs{Name: "file", Mode: 0644}).(*File)
+	// Node which has been replaced in the cache since (e.g. file re-created) must not drop its successor
+	stale := rootDir.NodeFromAttrs(Attrs{Name: "replaced", Mode: 0644}).(*File)
+	current := rootDir.NodeFromAttrs(Attrs{Name: "replaced", Mode: 0644}).(*File)
+	assert.Equal(t, 3, fileSystem.AttrCache.Len())
+
+	file.Forget()
+	assert.Equal(t, 0, len(subDir.Entries))
+	assert.Equal(t, 2, fileSystem.AttrCache.Len())
+
+	stale.Forget()
+	subDir.Forget()
+	assert.Equal(t, 1, len(rootDir.Entries))
+	assert.Equal(t, current, rootDir.Entries["replaced"])
+	assert.Equal(t, 1, fileSystem.AttrCache.Len())
+
+	// Root directory isn't kept in the node cache
+	rootDir.Forget()
+	current.Forget()
+	assert.Equal(t, 0, len(rootDir.Entries))
+	assert.Equal(t, 0, fileSystem.AttrCache.Len())
+}
@@ -8,7 +8,9 @@ import (
 	"fmt"
 	"github.com/colinmarc/hdfs"
 	"github.com/colinmarc/hdfs/protocol/hadoop_hdfs"
+	"github.com/colinmarc/hdfs/rpc"
 	"io"
+	"math"
 	"os"
 	"os/user"
 	"strconv"
@@ -20,26 +22,42 @@ import (
 // Interface for accessing HDFS
 // Concurrency: thread safe: handles unlimited number of concurrent requests
 type HdfsAccessor interface {
-	OpenRead(path string) (ReadSeekCloser, error)                 // Opens HDFS file for reading
-	CreateFile(path string, mode os.FileMode) (HdfsWriter, error) // Opens HDFS file for writing
-	ReadDir(path string) ([]Attrs, error)                         // Enumerates HDFS directory
-	Stat(path string) (Attrs, error)                              // Retrieves file/directory attributes
-	StatFs() (FsInfo, error)                                      // Retrieves HDFS usage
-	Mkdir(path string, mode os.FileMode) error                    // Creates a directory
-	Remove(path string) error                                     // Removes a file or directory
-	Rename(oldPath string, newPath string) error                  // Renames a file or directory
-	EnsureConnected() error                                       // Ensures HDFS accessor is connected to the HDFS name node
-	Chown(path string, owner, group string) error                 // Changes the owner and group of the file
-	Chmod(path string, mode os.FileMode) error                    // Changes the mode of the file
-	Close() error                                                 // Close current meta connection if needed
+	OpenRead(path string) (ReadSeekCloser, error)                                                 // Opens HDFS file for reading
+	CreateFile(path string, mode os.FileMode) (HdfsWriter, error)                                 // Opens HDFS file for writing
+	CreateFileWithReplication(path string, mode os.FileMode, replication int) (HdfsWriter, error) // Opens HDFS file for writing, with given number of replicas in the write pipeline
+	Append(path string) (HdfsWriter, error)                                                       // Opens existing HDFS file for appending
+	ReadDir(path string) ([]Attrs, error)                                                         // Enumerates HDFS directory
+	OpenDir(path string) (DirReader, error)                                                       // Opens HDFS directory for enumerating it page by page
+	Stat(path string) (Attrs, error)                                                              // Retrieves file/directory attributes
+	StatFs() (FsInfo, error)                                                                      // Retrieves HDFS usage
+	GetContentSummary(path string) (ContentSummary, error)                                        // Retrieves quota and usage of the directory
+	GetChecksum(path string) ([]byte, error)                                                      // Retrieves checksum of the file (MD5 of the block checksums)
+	GetAcl(path string) ([]AclEntry, error)                                                       // Retrieves ACL entries of the file/directory (mask of the access ACL is kept in the group bits)
+	Mkdir(path string, mode os.FileMode) error                                                    // Creates a directory
+	Remove(path string) error                                                                     // Removes a file or directory
+	Rename(oldPath string, newPath string) error                                                  // Renames a file or directory
+	EnsureConnected() error                                                                       // Ensures HDFS accessor is connected to the HDFS name node
+	Chown(path string, owner, group string) error                                                 // Changes the owner and/or group of the file (empty one is left unchanged)
+	Chmod(path string, mode os.FileMode) error                                                    // Changes the mode of the file
+	Chtimes(path string, atime time.Time, mtime time.Time) error                                  // Changes the access and modification times of the file
+	ChmodRecursive(path string, mode os.FileMode) error                                           // Changes the mode of the directory tree (partially on RecursiveOpError)
+	ChownRecursive(path string, owner, group string) error                                        // Changes the owner and group of the directory tree (partially on RecursiveOpError)
+	RecoverLease(path string) (bool, error)                                                       // Triggers lease recovery of the file, returns true if the file is closed
+	GetBlockLocations(path string) ([]BlockLocation, error)                                       // Retrieves layout of the file blocks and the data nodes hosting them
+	CreateSymlink(target string, path string) error                                               // Creates a symbolic link pointing to the target
+	SnapshotDiff(path, from, to string) ([]DiffEntry, error)                                      // Lists changes of the snapshottable directory between two snapshots
+	Close() error                                                                                 // Close current meta connection if needed
 }
 
 type hdfsAccessorImpl struct {
 	Clock               Clock                    // interface to get wall clock time
-	NameNodeAddresses   []string                 // array of Address:port string for the name nodes
+	NameNodeAddresses   []string                 // array of Address:port string for the name nodes (preferred one first)
+	NameNodesMutex      sync.Mutex               // mutex for NameNodeAddresses (rotated on failover)
 	MetadataClient      *hdfs.Client             // HDFS client used for metadata operations
+	Namenode            *rpc.NamenodeConnection  // Name node connection of MetadataClient, used for the RPCs HDFS client doesn't expose
 	MetadataClientMutex sync.Mutex               // Serializing all metadata operations for simplicity (for now), TODO: allow N concurrent operations
 	UserNameToUidCache  map[string]UidCacheEntry // cache for converting usernames to UIDs
+	SecurityOptions     HdfsSecurityOptions      // encryption settings for the connections
 }
 
 type UidCacheEntry struct {
@@ -50,13 +68,14 @@ type UidCacheEntry struct {
 var _ HdfsAccessor = (*hdfsAccessorImpl)(nil) // ensure hdfsAccessorImpl implements HdfsAccessor
 
 // Creates an instance of HdfsAccessor
-func NewHdfsAccessor(nameNodeAddresses string, clock Clock) (HdfsAccessor, error) {
+func NewHdfsAccessor(nameNodeAddresses string, clock Clock, securityOptions HdfsSecurityOptions) (HdfsAccessor, error) {
 	nns := strings.Split(nameNodeAddresses, ",")
 
 	this := &hdfsAccessorImpl{
 		NameNodeAddresses:  nns,
 		Clock:              clock,
-		UserNameToUidCache: make(map[string]UidCacheEntry)}
+		UserNameToUidCache: make(map[string]UidCacheEntry),
+		SecurityOptions:    securityOptions}
 	return this, nil
 }
 
@@ -68,37 +87,65 @@ func (this *hdfsAccessorImpl) EnsureConnected() error {
 	return this.ConnectMetadataClient()
 }
 
-// Establishes connection to the name node (assigns MetadataClient field)
+// Establishes connection to the name node (assigns MetadataClient and Namenode fields)
 func (this *hdfsAccessorImpl) ConnectMetadataClient() error {
-	client, err := this.ConnectToNameNode()
+	client, namenode, err := this.ConnectToNameNode()
 	if err != nil {
 		return err
 	}
 	this.MetadataClient = client
+	this.Namenode = namenode
 	return nil
 }
 
 // Establishes connection to a name node in the context of some other operation
-func (this *hdfsAccessorImpl) ConnectToNameNode() (*hdfs.Client, error) {
-	// connecting to HDFS name node
-	client, err := this.connectToNameNodeImpl()
-	if err != nil {
-		// Connection failed
-		return nil, errors.New(fmt.Sprintf("Fail to connect to name node with error: %s", err.Error()))
+func (this *hdfsAccessorImpl) ConnectToNameNode() (*hdfs.Client, *rpc.NamenodeConnection, error) {
+	for attempt := 1; ; attempt++ {
+		// connecting to HDFS name node
+		client, namenode, err := this.connectToNameNodeImpl()
+		if err == nil {
+			Info.Println("Connected to name node")
+			return client, namenode, nil
+		}
+		if !IsFailoverError(err) || attempt >= len(this.NameNodeAddresses) {
+			// Connection failed
+			return nil, nil, errors.New(fmt.Sprintf("Fail to connect to name node with error: %s", err.Error()))
+		}
+		// Name node is in standby state (e.g. during HA failover), trying the next one
+		this.FailoverNameNode()
+	}
+}
+
+// Makes the next name node preferred for subsequent connections
+func (this *hdfsAccessorImpl) FailoverNameNode() {
+	this.NameNodesMutex.Lock()
+	defer this.NameNodesMutex.Unlock()
+	if len(this.NameNodeAddresses) < 2 {
+		return
 	}
-	Info.Println("Connected to name node")
-	return client, nil
+	Warning.Println("Name node", this.NameNodeAddresses[0], "is in standby state, failing over to", this.NameNodeAddresses[1])
+	this.NameNodeAddresses = append(this.NameNodeAddresses[1:], this.NameNodeAddresses[0])
 }
 
+// Establishes name node connection and creates HDFS client using it (replaceable in tests)
+var NewNamenodeConnection = rpc.NewNamenodeConnectionWithOptions
+var NewHdfsClient = hdfs.NewClient
+
 // Performs an attempt to connect to the HDFS name
-func (this *hdfsAccessorImpl) connectToNameNodeImpl() (*hdfs.Client, error) {
+func (this *hdfsAccessorImpl) connectToNameNodeImpl() (*hdfs.Client, *rpc.NamenodeConnection, error) {
 	// Performing an attempt to connect to the name node
 	// Colinmar's hdfs implementation has supported the multiple name node connection
-	client, err := hdfs.NewClient(hdfs.ClientOptions{
-		Addresses: this.NameNodeAddresses,
-		})
+	options := this.ClientOptions()
+	// Name node connection is established explicitly, so it can be used for the RPCs HDFS client doesn't expose
+	namenode, err := NewNamenodeConnection(rpc.NamenodeConnectionOptions{Addresses: options.Addresses, User: options.User})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	options.Namenode = namenode
+	client, err := NewHdfsClient(options)
+	if err != nil {
+		namenode.Close()
+		return nil, nil, err
 	}
 	// connection is OK, but we need to check whether name node is operating ans expected
 	// (this also checks whether name node is Active)
@@ -109,11 +156,27 @@ func (this *hdfsAccessorImpl) connectToNameNodeImpl() (*hdfs.Client, error) {
 
 	if pathError, ok := statErr.(*os.PathError); statErr == nil || ok && (pathError.Err == os.ErrNotExist) {
 		// Succesfully connected
-		return client, nil
+		return client, namenode, nil
 	} else {
 		client.Close()
-		return nil, statErr
+		return nil, nil, statErr
+	}
+}
+
+// Returns options for establishing connection to HDFS
+func (this *hdfsAccessorImpl) ClientOptions() hdfs.ClientOptions {
+	this.NameNodesMutex.Lock()
+	addresses := append([]string{}, this.NameNodeAddresses...)
+	this.NameNodesMutex.Unlock()
+	options := hdfs.ClientOptions{
+		Addresses:        addresses,
+		DatanodeDialFunc: this.SecurityOptions.DatanodeDialFunc(),
+	}
+	if this.SecurityOptions.DataTransferEncryption {
+		// "privacy" protection level means that block data is encrypted in transit
+		options.DataTransferProtection = "privacy"
 	}
+	return options
 }
 
 // Opens HDFS file for reading
@@ -135,6 +198,11 @@ func (this *hdfsAccessorImpl) OpenRead(path string) (ReadSeekCloser, error) {
 
 // Creates new HDFS file
 func (this *hdfsAccessorImpl) CreateFile(path string, mode os.FileMode) (HdfsWriter, error) {
+	return this.CreateFileWithReplication(path, mode, DefaultReplication)
+}
+
+// Opens HDFS file for writing, with given number of replicas in the write pipeline
+func (this *hdfsAccessorImpl) CreateFileWithReplication(path string, mode os.FileMode, replication int) (HdfsWriter, error) {
 	this.MetadataClientMutex.Lock()
 	defer this.MetadataClientMutex.Unlock()
 	if this.MetadataClient == nil {
@@ -142,7 +210,7 @@ func (this *hdfsAccessorImpl) CreateFile(path string, mode os.FileMode) (HdfsWri
 			return nil, err
 		}
 	}
-	writer, err := this.MetadataClient.CreateFile(path, 3, 64*1024*1024, mode)
+	writer, err := this.MetadataClient.CreateFile(path, replication, 64*1024*1024, mode)
 	if err != nil {
 		return nil, err
 	}
@@ -150,6 +218,22 @@ func (this *hdfsAccessorImpl) CreateFile(path string, mode os.FileMode) (HdfsWri
 	return NewHdfsWriter(writer), nil
 }
 
+// Opens existing HDFS file for appending
+func (this *hdfsAccessorImpl) Append(path string) (HdfsWriter, error) {
+	this.MetadataClientMutex.Lock()
+	defer this.MetadataClientMutex.Unlock()
+	if this.MetadataClient == nil {
+		if err := this.ConnectMetadataClient(); err != nil {
+			return nil, err
+		}
+	}
+	writer, err := this.MetadataClient.Append(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewHdfsWriter(writer), nil
+}
+
 // Enumerates HDFS directory
 func (this *hdfsAccessorImpl) ReadDir(path string) ([]Attrs, error) {
 	this.MetadataClientMutex.Lock()
@@ -177,6 +261,22 @@ func (this *hdfsAccessorImpl) ReadDir(path string) ([]Attrs, error) {
 	return allAttrs, nil
 }
 
+// Opens HDFS directory for enumerating it page by page
+func (this *hdfsAccessorImpl) OpenDir(path string) (DirReader, error) {
+	this.MetadataClientMutex.Lock()
+	defer this.MetadataClientMutex.Unlock()
+	if this.MetadataClient == nil {
+		if err := this.ConnectMetadataClient(); err != nil {
+			return nil, err
+		}
+	}
+	reader, err := this.MetadataClient.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &hdfsDirReaderImpl{Accessor: this, BackendReader: reader}, nil
+}
+
 // Retrieves file/directory attributes
 func (this *hdfsAccessorImpl) Stat(path string) (Attrs, error) {
 	this.MetadataClientMutex.Lock()
@@ -224,24 +324,131 @@ func (this *hdfsAccessorImpl) StatFs() (FsInfo, error) {
 	return this.AttrsFromFsInfo(fsInfo), nil
 }
 
-// Converts os.FileInfo + underlying proto-buf data into Attrs structure
+// Retrieves quota and usage of the directory
+func (this *hdfsAccessorImpl) GetContentSummary(path string) (ContentSummary, error) {
+	this.MetadataClientMutex.Lock()
+	defer this.MetadataClientMutex.Unlock()
+
+	if this.MetadataClient == nil {
+		if err := this.ConnectMetadataClient(); err != nil {
+			return ContentSummary{}, err
+		}
+	}
+
+	contentSummary, err := this.MetadataClient.GetContentSummary(path)
+	if err != nil {
+		if IsSuccessOrBenignError(err) {
+			return ContentSummary{}, err
+		}
+		this.MetadataClient = nil
+		return ContentSummary{}, err
+	}
+	return ContentSummary{
+		Size:           uint64(contentSummary.Size()),
+		SpaceConsumed:  uint64(contentSummary.SizeAfterReplication()),
+		SpaceQuota:     contentSummary.SpaceQuota(),
+		NameQuota:      int64(contentSummary.NameQuota()),
+		FileCount:      uint64(contentSummary.FileCount()),
+		DirectoryCount: uint64(contentSummary.DirectoryCount())}, nil
+}
+
+// Permission bit indicating that the file has ACL (see FsPermissionExtension of HDFS)
+const hdfsAclBit = 1 << 12
+
+// Converts os.FileInfo + underlying proto-buf data into Attrs structure (must be called under MetadataClientMutex).
+// ACL entries aren't retrieved, HasAcl only tells whether there are any (see GetAcl)
 func (this *hdfsAccessorImpl) AttrsFromFileInfo(fileInfo os.FileInfo) Attrs {
 	protoBufData := fileInfo.Sys().(*hadoop_hdfs.HdfsFileStatusProto)
-	mode := os.FileMode(*protoBufData.Permission.Perm)
+	mode := os.FileMode(*protoBufData.Permission.Perm &^ hdfsAclBit)
 	if fileInfo.IsDir() {
 		mode |= os.ModeDir
 	}
-	modificationTime := time.Unix(int64(protoBufData.GetModificationTime())/1000, 0)
-	return Attrs{
+	target := string(protoBufData.GetSymlink())
+	if target != "" {
+		mode |= os.ModeSymlink
+	}
+	modificationTime := HadoopTimestampToTime(protoBufData.GetModificationTime())
+	accessTime := HadoopTimestampToTime(protoBufData.GetAccessTime())
+	// HDFS doesn't track creation time, so birth time (Crtime) is left unknown
+	attrs := Attrs{
 		Inode:  *protoBufData.FileId,
 		Name:   fileInfo.Name(),
 		Mode:   mode,
 		Size:   *protoBufData.Length,
 		Uid:    this.LookupUid(*protoBufData.Owner),
+		Group:  protoBufData.GetGroup(),
 		Mtime:  modificationTime,
-		Ctime:  modificationTime,
-		Crtime: modificationTime,
+		Atime:  accessTime,
+		Ctime:  modificationTime, // HDFS doesn't track metadata changes, modification time is the best known estimate
+		Target: target,
+		HasAcl: *protoBufData.Permission.Perm&hdfsAclBit != 0,
 		Gid:    0} // TODO: Group is now hardcoded to be "root", implement proper mapping
+	return attrs
+}
+
+// Types of ACL entries as named in AclEntry
+var aclEntryTypes = map[hadoop_hdfs.AclEntryProto_AclEntryTypeProto]string{
+	hadoop_hdfs.AclEntryProto_USER:  "user",
+	hadoop_hdfs.AclEntryProto_GROUP: "group",
+	hadoop_hdfs.AclEntryProto_MASK:  "mask",
+	hadoop_hdfs.AclEntryProto_OTHER: "other",
+}
+
+// Retrieves ACL entries of the file/directory.
+// Mask of the access ACL isn't among the entries, HDFS keeps it in the group bits of the permission
+func (this *hdfsAccessorImpl) GetAcl(path string) ([]AclEntry, error) {
+	this.MetadataClientMutex.Lock()
+	defer this.MetadataClientMutex.Unlock()
+	namenode, err := this.namenodeLocked()
+	if err != nil {
+		return nil, err
+	}
+	req := &hadoop_hdfs.GetAclStatusRequestProto{Src: &path}
+	resp := &hadoop_hdfs.GetAclStatusResponseProto{}
+	if err := namenode.Execute("getAclStatus", req, resp); err != nil {
+		return nil, this.namenodeErrorLocked("getAclStatus", path, err)
+	}
+	entries := resp.GetResult().GetEntries()
+	acl := make([]AclEntry, 0, len(entries))
+	for _, entry := range entries {
+		acl = append(acl, AclEntry{
+			Type:    aclEntryTypes[entry.GetType()],
+			Name:    entry.GetName(),
+			Perm:    os.FileMode(entry.GetPermissions()) & 07,
+			Default: entry.GetScope() == hadoop_hdfs.AclEntryProto_DEFAULT})
+	}
+	return acl, nil
+}
+
+// Returns name node connection of the metadata client, used for the RPCs HDFS client doesn't expose
+// (must be called under MetadataClientMutex)
+func (this *hdfsAccessorImpl) namenodeLocked() (*rpc.NamenodeConnection, error) {
+	if this.MetadataClient == nil {
+		if err := this.ConnectMetadataClient(); err != nil {
+			return nil, err
+		}
+	}
+	return this.Namenode, nil
+}
+
+// Converts error of the name node RPC into the error HDFS client would return for the path.
+// If the connection has failed, it's reset, so another one is established next time
+// (must be called under MetadataClientMutex)
+func (this *hdfsAccessorImpl) namenodeErrorLocked(op string, path string, err error) error {
+	namenodeErr, ok := err.(*rpc.NamenodeError)
+	if !ok {
+		this.MetadataClient = nil
+		return err
+	}
+	switch namenodeErr.Exception {
+	case "java.io.FileNotFoundException":
+		return &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+	case "org.apache.hadoop.security.AccessControlException":
+		return &os.PathError{Op: op, Path: path, Err: os.ErrPermission}
+	case "org.apache.hadoop.fs.FileAlreadyExistsException":
+		return &os.PathError{Op: op, Path: path, Err: os.ErrExist}
+	}
+	return err
 }
 
 func (this *hdfsAccessorImpl) AttrsFromFsInfo(fsInfo hdfs.FsInfo) FsInfo {
@@ -251,8 +458,9 @@ func (this *hdfsAccessorImpl) AttrsFromFsInfo(fsInfo hdfs.FsInfo) FsInfo {
 		remaining: fsInfo.Remaining}
 }
 
+// Converts HDFS timestamp (milliseconds since epoch) into time, keeping its millisecond precision
 func HadoopTimestampToTime(timestamp uint64) time.Time {
-	return time.Unix(int64(timestamp)/1000, 0)
+	return time.Unix(0, int64(timestamp)*int64(time.Millisecond))
 }
 
 // Performs a cache-assisted lookup of UID by username
@@ -282,7 +490,7 @@ func (this *hdfsAccessorImpl) LookupUid(userName string) uint32 {
 
 // Returns true if err==nil or err is expected (benign) error which should be propagated directoy to the caller
 func IsSuccessOrBenignError(err error) bool {
-	if err == nil || err == io.EOF || err == fuse.EEXIST {
+	if err == nil || err == io.EOF || err == fuse.EEXIST || IsSafeModeError(err) {
 		return true
 	}
 	if pathError, ok := err.(*os.PathError); ok && (pathError.Err == os.ErrNotExist || pathError.Err == os.ErrPermission) {
@@ -292,6 +500,28 @@ func IsSuccessOrBenignError(err error) bool {
 	}
 }
 
+// Returns true if the error is a backpressure signal of the overloaded name node, asking the client to back off
+// before retrying (RetriableException, RpcNoSuchMethod, CallQueueOverflowException)
+func IsCongestionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	message := err.Error()
+	return strings.Contains(message, "RetriableException") ||
+		strings.Contains(message, "RpcNoSuchMethod") ||
+		strings.Contains(message, "CallQueueOverflowException")
+}
+
+// Returns true if the error indicates that the name node isn't active (e.g. during HA failover),
+// so the operation can be retried against another name node
+func IsFailoverError(err error) bool {
+	if err == nil {
+		return false
+	}
+	message := err.Error()
+	return strings.Contains(message, "StandbyException") || strings.Contains(message, "in state standby")
+}
+
 // Creates a directory
 func (this *hdfsAccessorImpl) Mkdir(path string, mode os.FileMode) error {
 	this.MetadataClientMutex.Lock()
@@ -346,16 +576,265 @@ func (this *hdfsAccessorImpl) Chmod(path string, mode os.FileMode) error {
 	return this.MetadataClient.Chmod(path, mode)
 }
 
-// Changes the owner and group of the file
+// Changes the owner and/or group of the file. Empty user or group isn't sent to the name node, so it's left unchanged
 func (this *hdfsAccessorImpl) Chown(path string, user, group string) error {
 	this.MetadataClientMutex.Lock()
 	defer this.MetadataClientMutex.Unlock()
+	namenode, err := this.namenodeLocked()
+	if err != nil {
+		return err
+	}
+	req := &hadoop_hdfs.SetOwnerRequestProto{Src: &path}
+	if user != "" {
+		req.Username = &user
+	}
+	if group != "" {
+		req.Groupname = &group
+	}
+	resp := &hadoop_hdfs.SetOwnerResponseProto{}
+	if err := namenode.Execute("setOwner", req, resp); err != nil {
+		return this.namenodeErrorLocked("setOwner", path, err)
+	}
+	return nil
+}
+
+// Changes the access and modification times of the file
+func (this *hdfsAccessorImpl) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	this.MetadataClientMutex.Lock()
+	defer this.MetadataClientMutex.Unlock()
+	if this.MetadataClient == nil {
+		if err := this.ConnectMetadataClient(); err != nil {
+			return err
+		}
+	}
+	return this.MetadataClient.Chtimes(path, atime, mtime)
+}
+
+// Error of the recursive operation which has failed for some of the entries of the directory tree.
+// The operation isn't transactional: it's applied to the rest of the tree, and isn't rolled back
+type RecursiveOpError struct {
+	Failed []string // paths the operation has failed for
+	Err    error    // first failure
+}
+
+// Maximum number of failed paths included in the error message
+const recursiveOpErrorMaxPaths = 10
+
+func (this *RecursiveOpError) Error() string {
+	paths := this.Failed
+	if len(paths) > recursiveOpErrorMaxPaths {
+		paths = paths[:recursiveOpErrorMaxPaths]
+	}
+	return fmt.Sprintf("failed for %d path(s) [%s]: %s", len(this.Failed), strings.Join(paths, ", "), this.Err)
+}
+
+// Applies the operation to all the files and directories in the directory tree.
+// Metadata client mutex isn't held during the walk, so other metadata operations aren't blocked by it.
+// Failure of an entry doesn't stop the walk, failed paths are reported by RecursiveOpError
+func (this *hdfsAccessorImpl) applyRecursive(path string, apply func(client *hdfs.Client, path string) error) error {
+	this.MetadataClientMutex.Lock()
 	if this.MetadataClient == nil {
 		if err := this.ConnectMetadataClient(); err != nil {
+			this.MetadataClientMutex.Unlock()
 			return err
 		}
 	}
-	return this.MetadataClient.Chown(path, user, group)
+	client := this.MetadataClient
+	this.MetadataClientMutex.Unlock()
+	var result *RecursiveOpError
+	err := client.Walk(path, func(p string, fileInfo os.FileInfo, err error) error {
+		if err == nil {
+			err = apply(client, p)
+		}
+		if err != nil {
+			if result == nil {
+				result = &RecursiveOpError{Err: err}
+			}
+			result.Failed = append(result.Failed, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	if len(result.Failed) == 1 && result.Failed[0] == path {
+		// Only the root of the tree has failed (e.g. it doesn't exist)
+		return result.Err
+	}
+	return result
+}
+
+// Changes the mode of all the files and directories in the directory tree
+func (this *hdfsAccessorImpl) ChmodRecursive(path string, mode os.FileMode) error {
+	return this.applyRecursive(path, func(client *hdfs.Client, p string) error {
+		return client.Chmod(p, mode)
+	})
+}
+
+// Changes the owner and group of all the files and directories in the directory tree
+func (this *hdfsAccessorImpl) ChownRecursive(path string, user, group string) error {
+	return this.applyRecursive(path, func(client *hdfs.Client, p string) error {
+		return client.Chown(p, user, group)
+	})
+}
+
+// Retrieves checksum of the file (MD5 of the MD5s of CRCs of the blocks, as computed by HDFS getFileChecksum).
+// Metadata client mutex isn't held while the checksum is collected from the data nodes
+func (this *hdfsAccessorImpl) GetChecksum(path string) ([]byte, error) {
+	this.MetadataClientMutex.Lock()
+	if this.MetadataClient == nil {
+		if err := this.ConnectMetadataClient(); err != nil {
+			this.MetadataClientMutex.Unlock()
+			return nil, err
+		}
+	}
+	reader, err := this.MetadataClient.Open(path)
+	this.MetadataClientMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return reader.Checksum()
+}
+
+// Triggers lease recovery of the file
+func (this *hdfsAccessorImpl) RecoverLease(path string) (bool, error) {
+	this.MetadataClientMutex.Lock()
+	defer this.MetadataClientMutex.Unlock()
+	namenode, err := this.namenodeLocked()
+	if err != nil {
+		return false, err
+	}
+	clientName := namenode.ClientName()
+	req := &hadoop_hdfs.RecoverLeaseRequestProto{Src: &path, ClientName: &clientName}
+	resp := &hadoop_hdfs.RecoverLeaseResponseProto{}
+	if err := namenode.Execute("recoverLease", req, resp); err != nil {
+		return false, this.namenodeErrorLocked("recoverLease", path, err)
+	}
+	return resp.GetResult(), nil
+}
+
+// Retrieves layout of the file blocks
+func (this *hdfsAccessorImpl) GetBlockLocations(path string) ([]BlockLocation, error) {
+	this.MetadataClientMutex.Lock()
+	defer this.MetadataClientMutex.Unlock()
+	namenode, err := this.namenodeLocked()
+	if err != nil {
+		return nil, err
+	}
+	offset := uint64(0)
+	length := uint64(math.MaxInt64)
+	req := &hadoop_hdfs.GetBlockLocationsRequestProto{Src: &path, Offset: &offset, Length: &length}
+	resp := &hadoop_hdfs.GetBlockLocationsResponseProto{}
+	if err := namenode.Execute("getBlockLocations", req, resp); err != nil {
+		return nil, this.namenodeErrorLocked("getBlockLocations", path, err)
+	}
+	locations := resp.GetLocations()
+	if locations == nil {
+		// Name node doesn't return locations for paths which don't exist
+		return nil, &os.PathError{Op: "getBlockLocations", Path: path, Err: os.ErrNotExist}
+	}
+	var blocks []BlockLocation
+	for _, located := range locations.GetBlocks() {
+		blocks = append(blocks, blockLocationFromProto(located))
+	}
+	if locations.GetUnderConstruction() {
+		// File is open for write: last block being written might not be listed among the blocks
+		if last := locations.GetLastBlock(); !locations.GetIsLastBlockComplete() && last != nil &&
+			(len(blocks) == 0 || blocks[len(blocks)-1].Offset < last.GetOffset()) {
+			blocks = append(blocks, blockLocationFromProto(last))
+		}
+		if len(blocks) == 0 {
+			// No block has been allocated yet
+			blocks = append(blocks, BlockLocation{Offset: locations.GetFileLength()})
+		}
+		blocks[len(blocks)-1].UnderConstruction = true
+	}
+	return blocks, nil
+}
+
+// Converts located block reported by the name node
+func blockLocationFromProto(located *hadoop_hdfs.LocatedBlockProto) BlockLocation {
+	block := BlockLocation{Offset: located.GetOffset(), Length: located.GetB().GetNumBytes()}
+	for _, datanode := range located.GetLocs() {
+		host := datanode.GetId().GetHostName()
+		if host == "" {
+			host = datanode.GetId().GetIpAddr()
+		}
+		block.Hosts = append(block.Hosts, host)
+		block.States = append(block.States, datanode.GetAdminState().String())
+	}
+	return block
+}
+
+// Creates a symbolic link
+func (this *hdfsAccessorImpl) CreateSymlink(target string, path string) error {
+	this.MetadataClientMutex.Lock()
+	defer this.MetadataClientMutex.Unlock()
+	namenode, err := this.namenodeLocked()
+	if err != nil {
+		return err
+	}
+	// Parent directory has to exist, so its permission isn't applied
+	dirPerm := uint32(0755)
+	createParent := false
+	req := &hadoop_hdfs.CreateSymlinkRequestProto{
+		Target:       &target,
+		Link:         &path,
+		DirPerm:      &hadoop_hdfs.FsPermissionProto{Perm: &dirPerm},
+		CreateParent: &createParent}
+	resp := &hadoop_hdfs.CreateSymlinkResponseProto{}
+	if err := namenode.Execute("createSymlink", req, resp); err != nil {
+		return this.namenodeErrorLocked("createSymlink", path, err)
+	}
+	return nil
+}
+
+// Lists changes of the snapshottable directory between two snapshots
+func (this *hdfsAccessorImpl) SnapshotDiff(path, from, to string) ([]DiffEntry, error) {
+	this.MetadataClientMutex.Lock()
+	defer this.MetadataClientMutex.Unlock()
+	namenode, err := this.namenodeLocked()
+	if err != nil {
+		return nil, err
+	}
+	req := &hadoop_hdfs.GetSnapshotDiffReportRequestProto{SnapshotRoot: &path, FromSnapshot: &from, ToSnapshot: &to}
+	resp := &hadoop_hdfs.GetSnapshotDiffReportResponseProto{}
+	if err := namenode.Execute("getSnapshotDiffReport", req, resp); err != nil {
+		return nil, this.namenodeErrorLocked("getSnapshotDiffReport", path, err)
+	}
+	var diff []DiffEntry
+	for _, entry := range resp.GetDiffReport().GetDiffReportEntries() {
+		diffType, ok := snapshotDiffTypes[entry.GetModificationLabel()]
+		if !ok {
+			Warning.Println("[", path, "] Unknown snapshot diff entry type:", entry.GetModificationLabel())
+			continue
+		}
+		diffEntry := DiffEntry{Type: diffType, Path: snapshotDiffPath(entry.GetFullpath())}
+		if diffType == "R" {
+			diffEntry.TargetPath = snapshotDiffPath(entry.GetTargetPath())
+		}
+		diff = append(diff, diffEntry)
+	}
+	return diff, nil
+}
+
+// Types of snapshot diff entries as named in DiffEntry, by modification labels reported by the name node
+var snapshotDiffTypes = map[string]string{
+	"CREATE": "+",
+	"MODIFY": "M",
+	"DELETE": "-",
+	"RENAME": "R"}
+
+// Converts path of the snapshot diff entry, relative to the snapshottable directory ("." for the directory itself)
+func snapshotDiffPath(fullpath []byte) string {
+	if len(fullpath) == 0 {
+		return "."
+	}
+	return string(fullpath)
 }
 
 // Close current connection if needed 
@@ -0,0 +1,82 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/colinmarc/hdfs"
+	"github.com/colinmarc/hdfs/rpc"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// Testing that encryption of data transfer is requested when configured
+func TestDataTransferEncryption(t *testing.T) {
+	hdfsAccessor, _ := NewHdfsAccessor("nn1:8020", WallClock{}, HdfsSecurityOptions{})
+	options := hdfsAccessor.(*hdfsAccessorImpl).ClientOptions()
+	assert.Equal(t, "", options.DataTransferProtection)
+	assert.Nil(t, options.DatanodeDialFunc)
+
+	hdfsAccessor, _ = NewHdfsAccessor("nn1:8020", WallClock{}, HdfsSecurityOptions{DataTransferEncryption: true})
+	options = hdfsAccessor.(*hdfsAccessorImpl).ClientOptions()
+	assert.Equal(t, "privacy", options.DataTransferProtection)
+}
+
+// Testing that security options reach HDFS client created on connect
+func TestSecurityOptionsPassedToClient(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	defer func(newNamenodeConnection func(rpc.NamenodeConnectionOptions) (*rpc.NamenodeConnection, error), newHdfsClient func(hdfs.ClientOptions) (*hdfs.Client, error)) {
+		NewNamenodeConnection, NewHdfsClient = newNamenodeConnection, newHdfsClient
+	}(NewNamenodeConnection, NewHdfsClient)
+	NewNamenodeConnection = func(options rpc.NamenodeConnectionOptions) (*rpc.NamenodeConnection, error) {
+		return &rpc.NamenodeConnection{}, nil
+	}
+	var clientOptions []hdfs.ClientOptions
+	NewHdfsClient = func(options hdfs.ClientOptions) (*hdfs.Client, error) {
+		clientOptions = append(clientOptions, options)
+		return nil, errors.New("not connecting in test")
+	}
+
+	hdfsAccessor, _ := NewHdfsAccessor("nn1:8020", WallClock{}, HdfsSecurityOptions{DataTransferEncryption: true, TLSConfig: &tls.Config{}})
+	assert.NotNil(t, hdfsAccessor.EnsureConnected())
+	assert.Equal(t, 1, len(clientOptions))
+	assert.Equal(t, []string{"nn1:8020"}, clientOptions[0].Addresses)
+	assert.Equal(t, "privacy", clientOptions[0].DataTransferProtection)
+	assert.NotNil(t, clientOptions[0].DatanodeDialFunc)
+	assert.NotNil(t, clientOptions[0].Namenode)
+}
+
+// Testing that connections to the data nodes negotiate TLS when TLS config is provided
+func TestDatanodeTLSDial(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(server.Certificate())
+
+	hdfsAccessor, _ := NewHdfsAccessor("nn1:8020", WallClock{}, HdfsSecurityOptions{TLSConfig: &tls.Config{RootCAs: rootCAs}})
+	dial := hdfsAccessor.(*hdfsAccessorImpl).ClientOptions().DatanodeDialFunc
+	assert.NotNil(t, dial)
+	conn, err := dial(context.Background(), "tcp", server.Listener.Addr().String())
+	assert.Nil(t, err)
+	tlsConn, ok := conn.(*tls.Conn)
+	assert.True(t, ok)
+	assert.True(t, tlsConn.ConnectionState().HandshakeComplete)
+	conn.Close()
+}
+
+// Testing that failover makes the next name node preferred for subsequent connections
+func TestFailoverNameNode(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	hdfsAccessor, _ := NewHdfsAccessor("nn1:8020,nn2:8020", WallClock{}, HdfsSecurityOptions{})
+	assert.Equal(t, []string{"nn1:8020", "nn2:8020"}, hdfsAccessor.(*hdfsAccessorImpl).ClientOptions().Addresses)
+	hdfsAccessor.(*hdfsAccessorImpl).FailoverNameNode()
+	assert.Equal(t, []string{"nn2:8020", "nn1:8020"}, hdfsAccessor.(*hdfsAccessorImpl).ClientOptions().Addresses)
+	hdfsAccessor.(*hdfsAccessorImpl).FailoverNameNode()
+	assert.Equal(t, []string{"nn1:8020", "nn2:8020"}, hdfsAccessor.(*hdfsAccessorImpl).ClientOptions().Addresses)
+}
@@ -0,0 +1,68 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"net"
+)
+
+// Security settings applied to the connections established by HdfsAccessor
+type HdfsSecurityOptions struct {
+	DataTransferEncryption bool        // Requests encryption of the block data transfer protocol ("privacy" protection level)
+	TLSConfig              *tls.Config // If not nil, connections to the data nodes are wrapped with TLS
+}
+
+// Loads TLS configuration from PEM-encoded certificate/key files.
+// Returns nil config if no files are specified
+func LoadTLSConfig(certFile string, keyFile string, caFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return nil, nil
+	}
+	config := &tls.Config{}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificates found in " + caFile)
+		}
+	}
+	return config, nil
+}
+
+// Returns dial function which establishes TLS connections to the data nodes
+func (this *HdfsSecurityOptions) DatanodeDialFunc() func(ctx context.Context, network, address string) (net.Conn, error) {
+	if this.TLSConfig == nil {
+		return nil
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		config := this.TLSConfig.Clone()
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(address)
+		}
+		tlsConn := tls.Client(conn, config)
+		if err = tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"golang.org/x/net/context"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Returns true if the error indicates that the operation was interrupted (by a signal or cancellation of the request)
+func IsInterruptedError(err error) bool {
+	switch e := err.(type) {
+	case nil:
+		return false
+	case syscall.Errno:
+		return e == syscall.EINTR
+	case fuse.Errno:
+		return syscall.Errno(e) == syscall.EINTR
+	case *os.PathError:
+		return IsInterruptedError(e.Err)
+	case *os.SyscallError:
+		return IsInterruptedError(e.Err)
+	case *net.OpError:
+		return IsInterruptedError(e.Err)
+	}
+	return err == context.Canceled
+}
+
+// Performs idempotent operation (e.g. read or stat) on behalf of FUSE request.
+// Interrupted operation is reported to the client as EINTR. With RetryInterrupted enabled,
+// it is retried once unless the request has been cancelled, and failures of cancelled requests are reported as EINTR
+func (this *FileSystem) RunIdempotent(ctx context.Context, name string, op func() error) error {
+	err := op()
+	cancelled := ctx != nil && ctx.Err() != nil
+	if IsInterruptedError(err) {
+		if this.RetryInterrupted && !cancelled {
+			Warning.Println(name, "was interrupted:", err, ", retrying")
+			err = op()
+			if err == nil || !IsInterruptedError(err) {
+				return err
+			}
+		}
+		return fuse.Errno(syscall.EINTR)
+	}
+	if this.RetryInterrupted && cancelled && !IsSuccessOrBenignError(err) {
+		// Operation has failed since the request was cancelled
+		return fuse.Errno(syscall.EINTR)
+	}
+	return err
+}
+
+// Reports interrupted non-idempotent operation (e.g. write) as EINTR, without retrying it
+func InterruptedAsEINTR(err error) error {
+	if IsInterruptedError(err) {
+		return fuse.Errno(syscall.EINTR)
+	}
+	return err
+}
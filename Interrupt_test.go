@@ -0,0 +1,35 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"syscall"
+	"testing"
+)
+
+// Interrupted operation isn't retried once the request has been cancelled,
+// and failures are mapped to EINTR only with RetryInterrupted enabled
+func TestRunIdempotentCancelled(t *testing.T) {
+	fs := &FileSystem{RetryInterrupted: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	err := fs.RunIdempotent(ctx, "Read", func() error {
+		calls++
+		return syscall.EINTR
+	})
+	assert.Equal(t, fuse.Errno(syscall.EINTR), err)
+	assert.Equal(t, 1, calls)
+
+	failure := errors.New("connection reset")
+	err = fs.RunIdempotent(ctx, "Read", func() error { return failure })
+	assert.Equal(t, fuse.Errno(syscall.EINTR), err)
+
+	fs.RetryInterrupted = false
+	err = fs.RunIdempotent(ctx, "Read", func() error { return failure })
+	assert.Equal(t, failure, err)
+}
@@ -0,0 +1,83 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+// Number of times lease recovery status is checked before giving up
+var LeaseRecoveryAttempts int = 30
+
+// Delay between checks of lease recovery status
+var LeaseRecoveryPollInterval time.Duration = 2 * time.Second
+
+// Returns true if the error indicates that the file is held by a lease of another (possibly crashed) writer
+func IsLeaseError(err error) bool {
+	if err == nil {
+		return false
+	}
+	message := err.Error()
+	return strings.Contains(message, "AlreadyBeingCreatedException") ||
+		strings.Contains(message, "LeaseExpiredException") ||
+		strings.Contains(message, "RecoveryInProgressException")
+}
+
+// Creates HDFS file for writing (with lease recovery, see OpenWithLeaseRecovery).
+// With RetryClose enabled, closing the returned writer is retried according to the retry policy.
+// Write pipeline of the file has as many data nodes as required by WriteAckLevel
+func (this *FileSystem) CreateFile(path string, mode os.FileMode) (HdfsWriter, error) {
+	var w HdfsWriter
+	create := func() error {
+		var err error
+		if replicas := this.WriteAckReplicas(); replicas != DefaultReplication {
+			w, err = this.HdfsAccessor.CreateFileWithReplication(path, mode, replicas)
+		} else {
+			w, err = this.HdfsAccessor.CreateFile(path, mode)
+		}
+		return err
+	}
+	err := this.OpenWithLeaseRecovery(path, func() error {
+		return this.RunMutating("CreateFile", path, create)
+	})
+	if err == nil && this.RetryClose {
+		w = NewFaultTolerantHdfsWriter(w, path, this.RetryPolicy)
+	}
+	return w, err
+}
+
+// Opens HDFS file for writing (create or append). If it fails because the file is held by a lease of a stale writer,
+// and RecoverLease is enabled, lease recovery is triggered and awaited before retrying once
+func (this *FileSystem) OpenWithLeaseRecovery(path string, open func() error) error {
+	err := open()
+	if this.RecoverLease && IsLeaseError(err) {
+		Warning.Println("[", path, "] File is held by a lease of another writer, recovering lease:", err)
+		if recoveryErr := this.AwaitLeaseRecovery(path); recoveryErr != nil {
+			Error.Println("[", path, "] Lease recovery failed:", recoveryErr)
+			return err
+		}
+		err = open()
+	}
+	return err
+}
+
+// Triggers lease recovery of the file and waits for its completion
+func (this *FileSystem) AwaitLeaseRecovery(path string) error {
+	for attempt := 1; ; attempt++ {
+		recovered, err := this.HdfsAccessor.RecoverLease(path)
+		if err != nil {
+			return err
+		}
+		if recovered {
+			Info.Println("[", path, "] Lease recovered")
+			return nil
+		}
+		if attempt >= LeaseRecoveryAttempts {
+			return errors.New("lease recovery hasn't completed in time")
+		}
+		<-this.Clock.After(LeaseRecoveryPollInterval)
+	}
+}
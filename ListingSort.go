@@ -0,0 +1,40 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"sort"
+)
+
+// Orders of the directory listings (see FileSystem.SortListings)
+const (
+	ListingSortName  = "name"  // by name
+	ListingSortMtime = "mtime" // by modification time, oldest first (ties are ordered by name)
+	ListingSortSize  = "size"  // by size, smallest first (ties are ordered by name)
+)
+
+// Sorts directory entries returned by HDFS in the configured order (no-op if sorting isn't enabled)
+func (this *FileSystem) SortListing(allAttrs []Attrs) {
+	var less func(a, b *Attrs) bool
+	switch this.SortListings {
+	case ListingSortName:
+		less = func(a, b *Attrs) bool { return a.Name < b.Name }
+	case ListingSortMtime:
+		less = func(a, b *Attrs) bool {
+			if !a.Mtime.Equal(b.Mtime) {
+				return a.Mtime.Before(b.Mtime)
+			}
+			return a.Name < b.Name
+		}
+	case ListingSortSize:
+		less = func(a, b *Attrs) bool {
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+			return a.Name < b.Name
+		}
+	default:
+		return
+	}
+	sort.Slice(allAttrs, func(i, j int) bool { return less(&allAttrs[i], &allAttrs[j]) })
+}
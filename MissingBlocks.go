@@ -0,0 +1,58 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"strings"
+	"syscall"
+)
+
+// Returns true if the error indicates that no replica of the block being read is available
+// (BlockMissingException, all data nodes hosting the block are dead or the replicas are corrupt)
+func IsMissingBlockError(err error) bool {
+	if err == nil {
+		return false
+	}
+	message := err.Error()
+	return strings.Contains(message, "BlockMissingException") ||
+		strings.Contains(message, "Could not obtain block") ||
+		strings.Contains(strings.ToLower(message), "no available datanodes")
+}
+
+// Handles read which has failed since all replicas of the block at the offset are missing.
+// Fails with EIO, unless ZeroFillMissingBlocks is enabled: then the rest of the block is read as zeros
+// and the backend reader skips to the next block, so the undamaged part of the file can be recovered
+func (this *FileHandleReader) ReadMissingBlock(handle *FileHandle, fileOffset int64, buf []byte, cause error) (int, error) {
+	path := handle.File.AbsolutePath()
+	for i, block := range handle.BlockLocations() {
+		blockEnd := int64(block.Offset + block.Length)
+		if fileOffset < int64(block.Offset) || fileOffset >= blockEnd {
+			continue
+		}
+		if !handle.File.FileSystem.ZeroFillMissingBlocks {
+			Error.Println("[", path, "] Block", i, "@", block.Offset, "(", block.Length, "bytes) has no available replicas, reading @", fileOffset, "failed:", cause)
+			return 0, fuse.Errno(syscall.EIO)
+		}
+		nr := len(buf)
+		if int64(nr) > blockEnd-fileOffset {
+			nr = int(blockEnd - fileOffset)
+		}
+		for j := range buf[:nr] {
+			buf[j] = 0
+		}
+		Warning.Println("[", path, "] Block", i, "@", block.Offset, "(", block.Length, "bytes) has no available replicas, reading", nr, "zeros @", fileOffset)
+		if this.Offset != blockEnd {
+			// Next backend read must not start in the missing block
+			this.Seeks++
+			if err := this.HdfsReader.Seek(blockEnd); err != nil {
+				Error.Println("[", path, "] Seek past missing block to", blockEnd, ":", err)
+				return 0, fuse.Errno(syscall.EIO)
+			}
+			this.Offset = blockEnd
+		}
+		return nr, nil
+	}
+	Error.Println("[", path, "] Block @", fileOffset, "has no available replicas (block location unknown):", cause)
+	return 0, fuse.Errno(syscall.EIO)
+}
@@ -0,0 +1,58 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// Reading block without available replicas fails with EIO, or returns zeros with ZeroFillMissingBlocks
+func TestReadMissingBlock(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	missing := errors.New("org.apache.hadoop.hdfs.BlockMissingException: Could not obtain block: BP-1:blk_1073741826 file=/test.dat")
+	blocks := []BlockLocation{
+		{Offset: 0, Length: 100, Hosts: []string{"dn1"}},
+		{Offset: 100, Length: 100, Hosts: []string{"dn2"}},
+		{Offset: 200, Length: 100, Hosts: []string{"dn3"}}}
+	content := func(offset int, size int) []byte {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(offset + i)
+		}
+		return data
+	}
+
+	for _, zeroFill := range []bool{false, true} {
+		mockCtrl := gomock.NewController(t)
+		hdfsReader := NewMockReadSeekCloser(mockCtrl)
+		handle := createTestHandle(t, mockCtrl, hdfsReader)
+		handle.File.FileSystem.ZeroFillMissingBlocks = zeroFill
+		handle.File.FileSystem.HdfsAccessor.(*MockHdfsAccessor).EXPECT().GetBlockLocations("/test.dat").Return(blocks, nil)
+
+		hdfsReader.whenReadReturn(content(0, 100), nil)
+		handle.readAndVerify(t, 0, 100, content(0, 100))
+
+		hdfsReader.whenReadReturn(nil, missing)
+		if !zeroFill {
+			resp := fuse.ReadResponse{Data: make([]byte, 0, 100)}
+			err := handle.Read(nil, &fuse.ReadRequest{Offset: 100, Size: 100}, &resp)
+			assert.Equal(t, fuse.Errno(syscall.EIO), err)
+		} else {
+			// Missing block is read as zeros, reading continues with the next block
+			hdfsReader.expectSeek(200)
+			handle.readAndVerify(t, 100, 100, make([]byte, 100))
+			hdfsReader.whenReadReturn(content(200, 100), nil)
+			handle.readAndVerify(t, 200, 100, content(200, 100))
+		}
+
+		hdfsReader.EXPECT().Close().Return(nil)
+		handle.Release(nil, nil)
+		mockCtrl.Finish()
+	}
+}
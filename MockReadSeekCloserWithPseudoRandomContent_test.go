@@ -12,11 +12,12 @@ import (
 // where each byte is a deterministic function of its offset, so it is easy to verify
 // whether reading of a chunk returns correct byte sequence
 type MockReadSeekCloserWithPseudoRandomContent struct {
-	Rand        *rand.Rand
-	FileSize    int64
-	position    int64
-	IsClosed    bool
-	ReaderStats *ReaderStats
+	Rand             *rand.Rand
+	FileSize         int64
+	position         int64
+	IsClosed         bool
+	ReaderStats      *ReaderStats
+	EofWithLastChunk bool // io.EOF is returned together with the last chunk of the file (as io.Reader allows)
 }
 
 // Seek to a given position
@@ -62,6 +63,9 @@ func (this *MockReadSeekCloserWithPseudoRandomContent) Read(buf []byte) (int, er
 		buf[i] = generateByteAtOffset(this.position + int64(i))
 	}
 	this.position += int64(nr)
+	if this.EofWithLastChunk && this.position == this.FileSize {
+		return nr, io.EOF
+	}
 	return nr, nil
 }
 
@@ -0,0 +1,74 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"golang.org/x/net/context"
+	"strconv"
+	"time"
+)
+
+// Extended attribute exposing modification sequence of the file (with ExposeModSeq, see File.ModSeq),
+// letting change-data-capture tools detect whether the file has changed since they have seen it
+// (e.g. getfattr -n user.hdfs.modseq file)
+const XattrModSeq = "user.hdfs.modseq"
+
+// Number of modification sequence values per millisecond of the modification time, so modifications
+// which don't advance the modification time still get distinct, increasing values
+var ModSeqPerMillisecond uint64 = 1024
+
+// Returns modification sequence of the file, derived from HDFS modification time, and bumped whenever
+// the content version (inode, size) changes without the modification time advancing,
+// so it increases with each observed modification
+func (this *File) ModSeq() uint64 {
+	version := this.Attrs.ContentVersion()
+	this.activeHandlesMutex.Lock()
+	defer this.activeHandlesMutex.Unlock()
+	if this.modSeq != 0 && version == this.modSeqVersion {
+		return this.modSeq
+	}
+	seq := uint64(version.Mtime/int64(time.Millisecond)) * ModSeqPerMillisecond
+	if seq <= this.modSeq {
+		seq = this.modSeq + 1
+	}
+	this.modSeq, this.modSeqVersion = seq, version
+	return seq
+}
+
+// Responds on FUSE Getxattr request. Only the modification sequence (with ExposeModSeq) and block layout
+// (with ExposeBlockLayout, see XattrBlocks) are exposed. Attributes are refreshed from HDFS,
+// so modifications made by other clients are observed
+func (this *File) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	if this.FileSystem.ExposeBlockLayout && req.Name == XattrBlocks {
+		layout, err := this.BlockLayout()
+		if err != nil {
+			return err
+		}
+		resp.Xattr = layout
+		return nil
+	}
+	if !this.FileSystem.ExposeModSeq || req.Name != XattrModSeq {
+		return fuse.ErrNoXattr
+	}
+	if !this.IsCreateDeferred() {
+		version := this.Attrs.ContentVersion()
+		if err := this.Parent.LookupAttrs(this.Attrs.Name, &this.Attrs); err != nil {
+			return err
+		}
+		this.CheckContentVersion(version)
+	}
+	resp.Xattr = []byte(strconv.FormatUint(this.ModSeq(), 10))
+	return nil
+}
+
+// Responds on FUSE Listxattr request
+func (this *File) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	if this.FileSystem.ExposeModSeq {
+		resp.Append(XattrModSeq)
+	}
+	if this.FileSystem.ExposeBlockLayout {
+		resp.Append(XattrBlocks)
+	}
+	return nil
+}
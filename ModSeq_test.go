@@ -0,0 +1,53 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Modification sequence exposed as extended attribute increases with each modification, even if mtime doesn't change
+func TestModSeqXattr(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.ExposeModSeq = true
+	root, _ := fileSystem.Root()
+	mtime := time.Unix(1500000000, 0)
+	hdfsAccessor.EXPECT().Stat("/cdc.dat").Return(Attrs{Name: "cdc.dat", Inode: 1, Mode: 0644, Size: 5, Mtime: mtime}, nil)
+	file, _ := root.(*Dir).Lookup(nil, "cdc.dat")
+	modSeq := func(attrs Attrs) uint64 {
+		hdfsAccessor.EXPECT().Stat("/cdc.dat").Return(attrs, nil)
+		resp := &fuse.GetxattrResponse{}
+		err := file.(*File).Getxattr(nil, &fuse.GetxattrRequest{Name: XattrModSeq}, resp)
+		assert.Nil(t, err)
+		seq, err := strconv.ParseUint(string(resp.Xattr), 10, 64)
+		assert.Nil(t, err)
+		return seq
+	}
+
+	initial := modSeq(Attrs{Name: "cdc.dat", Inode: 1, Mode: 0644, Size: 5, Mtime: mtime})
+	assert.Equal(t, initial, modSeq(Attrs{Name: "cdc.dat", Inode: 1, Mode: 0644, Size: 5, Mtime: mtime}))
+
+	// Modification within the same modification time
+	appended := modSeq(Attrs{Name: "cdc.dat", Inode: 1, Mode: 0644, Size: 9, Mtime: mtime})
+	assert.True(t, appended > initial)
+
+	// Overwrite with a later modification time
+	overwritten := modSeq(Attrs{Name: "cdc.dat", Inode: 2, Mode: 0644, Size: 3, Mtime: mtime.Add(time.Second)})
+	assert.True(t, overwritten > appended)
+
+	list := &fuse.ListxattrResponse{}
+	assert.Nil(t, file.(*File).Listxattr(nil, &fuse.ListxattrRequest{}, list))
+	assert.Equal(t, XattrModSeq+"\x00", string(list.Xattr))
+	err := file.(*File).Getxattr(nil, &fuse.GetxattrRequest{Name: "user.other"}, &fuse.GetxattrResponse{})
+	assert.Equal(t, fuse.ErrNoXattr, err)
+}
@@ -0,0 +1,117 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"encoding/json"
+	"golang.org/x/net/context"
+	"os"
+	"syscall"
+)
+
+// Name of the virtual directory at the root of the mount exposing information about the mount (with ExposeConfig enabled)
+const MountInfoDirName = ".hdfs-mount"
+
+// Name of the virtual file in MountInfoDirName reporting effective configuration of the mount
+const MountConfigFileName = "config"
+
+// Effective configuration of the mount, as reported by the virtual config file
+type MountConfig struct {
+	NameNode   string       `json:"namenode"`
+	MountPoint string       `json:"mount_point"`
+	ReadOnly   bool         `json:"read_only"`
+	Version    MountVersion `json:"version"`
+	Flags      MountFlags   `json:"flags"`
+}
+
+// Command-line flags of the mount (values formatted as strings), by name
+type MountFlags map[string]string
+
+// Build version of hdfs-mount
+type MountVersion struct {
+	GitCommit string `json:"git_commit"`
+	BuildTime string `json:"build_time"`
+	BuildHost string `json:"build_host"`
+}
+
+// Virtual read-only directory at the root of the mount, holding files which describe the mount itself
+type MountInfoDir struct {
+	FileSystem *FileSystem
+}
+
+// Verify that *MountInfoDir implements necesary FUSE interfaces
+var _ fs.Node = (*MountInfoDir)(nil)
+var _ fs.NodeStringLookuper = (*MountInfoDir)(nil)
+var _ fs.HandleReadDirAller = (*MountInfoDir)(nil)
+
+// Responds on FUSE Attr request to retrieve directory attributes
+func (this *MountInfoDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeDir | 0555
+	a.Mtime = this.FileSystem.Clock.Now()
+	return nil
+}
+
+// Responds on FUSE Lookup request
+func (this *MountInfoDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	if name == MountConfigFileName {
+		return &MountConfigFile{FileSystem: this.FileSystem}, nil
+	}
+	return nil, fuse.ENOENT
+}
+
+// Responds on FUSE ReadDirAll request
+func (this *MountInfoDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	return []fuse.Dirent{{Name: MountConfigFileName, Type: fuse.DT_File}}, nil
+}
+
+// Virtual read-only file whose content reports effective configuration and build version of the mount as JSON
+type MountConfigFile struct {
+	FileSystem *FileSystem
+}
+
+// Verify that *MountConfigFile implements necesary FUSE interfaces
+var _ fs.Node = (*MountConfigFile)(nil)
+var _ fs.NodeOpener = (*MountConfigFile)(nil)
+var _ fs.HandleReadAller = (*MountConfigFile)(nil)
+
+// Responds on FUSE Attr request to retrieve file attributes.
+// Size is reported as zero since the content is generated on open (as in procfs)
+func (this *MountConfigFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = 0444
+	a.Mtime = this.FileSystem.Clock.Now()
+	return nil
+}
+
+// Responds on FUSE Open request, content is served bypassing the page cache
+func (this *MountConfigFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if !req.Flags.IsReadOnly() {
+		return nil, fuse.Errno(syscall.EACCES)
+	}
+	resp.Flags |= fuse.OpenDirectIO
+	return this, nil
+}
+
+// Responds on FUSE Read request by formatting configuration of the mount (doesn't touch HDFS)
+func (this *MountConfigFile) ReadAll(ctx context.Context) ([]byte, error) {
+	config := MountConfig{
+		NameNode:   this.FileSystem.NameNode,
+		MountPoint: this.FileSystem.MountPoint,
+		ReadOnly:   this.FileSystem.ReadOnly,
+		Version:    MountVersion{GitCommit: GITCOMMIT, BuildTime: BUILDTIME, BuildHost: HOSTNAME},
+		Flags:      this.FileSystem.Flags}
+	content, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(content, '\n'), nil
+}
+
+// Returns directory entry of the virtual directory describing the mount, if it is exposed (the root directory only)
+func (this *Dir) MountInfoEntry() (fuse.Dirent, bool) {
+	if this.Parent != nil || !this.FileSystem.ExposeConfig {
+		return fuse.Dirent{}, false
+	}
+	return fuse.Dirent{Name: MountInfoDirName, Type: fuse.DT_Dir}, true
+}
@@ -0,0 +1,52 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"encoding/json"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// Virtual config file reports effective configuration of the mount without touching HDFS
+func TestMountConfigFile(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/mnt/hdfs", []string{"data"}, false, true, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.ExposeConfig = true
+	fileSystem.NameNode = "namenode:8020"
+	fileSystem.Flags = MountFlags{"readerReuseTTL": "5s", "backendReadSize": "1048576"}
+	root, _ := fileSystem.Root()
+
+	// Exposed at the root, even though it isn't under allowed prefixes
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{{Name: "data", Mode: os.ModeDir | 0755}}, nil)
+	dirents, err := root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, MountInfoDirName, dirents[len(dirents)-1].Name)
+	infoDir, err := root.(*Dir).Lookup(nil, MountInfoDirName)
+	assert.Nil(t, err)
+	configFile, err := infoDir.(*MountInfoDir).Lookup(nil, MountConfigFileName)
+	assert.Nil(t, err)
+
+	h, err := configFile.(*MountConfigFile).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	content, err := h.(*MountConfigFile).ReadAll(nil)
+	assert.Nil(t, err)
+	var config MountConfig
+	assert.Nil(t, json.Unmarshal(content, &config))
+	assert.Equal(t, "namenode:8020", config.NameNode)
+	assert.Equal(t, "/mnt/hdfs", config.MountPoint)
+	assert.True(t, config.ReadOnly)
+	assert.Equal(t, "5s", config.Flags["readerReuseTTL"])
+	assert.Equal(t, "1048576", config.Flags["backendReadSize"])
+	assert.Equal(t, GITCOMMIT, config.Version.GitCommit)
+
+	// Config file can't be written
+	_, err = configFile.(*MountConfigFile).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly}, &fuse.OpenResponse{})
+	assert.NotNil(t, err)
+}
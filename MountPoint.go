@@ -0,0 +1,52 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Checks that the mount point can be used, failing with descriptive error if it is
+// missing, stale, already a mount or non-empty (the last two are allowed with AllowNonEmpty)
+func (this *FileSystem) CheckMountPoint() error {
+	mountPoint, err := filepath.Abs(this.MountPoint)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(mountPoint)
+	if err != nil {
+		if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.ENOTCONN {
+			return fmt.Errorf("Mount point %s is a stale mount (transport endpoint is not connected), unmount it with 'fusermount -u %s'", mountPoint, mountPoint)
+		}
+		return fmt.Errorf("Mount point %s is unavailable: %v", mountPoint, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("Mount point %s is not a directory", mountPoint)
+	}
+	if this.AllowNonEmpty {
+		return nil
+	}
+	if parentInfo, err := os.Stat(filepath.Dir(mountPoint)); err == nil && mountPoint != "/" {
+		stat, ok1 := info.Sys().(*syscall.Stat_t)
+		parentStat, ok2 := parentInfo.Sys().(*syscall.Stat_t)
+		if ok1 && ok2 && stat.Dev != parentStat.Dev {
+			return fmt.Errorf("Mount point %s is already in use by another mount (use -nonempty to mount over it)", mountPoint)
+		}
+	}
+	dir, err := os.Open(mountPoint)
+	if err != nil {
+		return fmt.Errorf("Mount point %s is unavailable: %v", mountPoint, err)
+	}
+	defer dir.Close()
+	if _, err := dir.Readdirnames(1); err != io.EOF {
+		if err != nil {
+			return fmt.Errorf("Mount point %s is unavailable: %v", mountPoint, err)
+		}
+		return fmt.Errorf("Mount point %s is not empty (use -nonempty to mount over it)", mountPoint)
+	}
+	return nil
+}
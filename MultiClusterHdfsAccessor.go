@@ -0,0 +1,374 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Exposes several HDFS clusters as subdirectories of a single namespace.
+// The first path component selects the cluster, the rest of the path is passed
+// to the accessor of that cluster (e.g. /clusterA/foo/bar -> /foo/bar on clusterA)
+type MultiClusterHdfsAccessor struct {
+	Clusters  map[string]HdfsAccessor // Per-cluster accessors keyed by the name of the top-level directory
+	MountTime time.Time               // Time reported as modification time of the virtual root and of the cluster roots
+}
+
+var _ HdfsAccessor = (*MultiClusterHdfsAccessor)(nil) // ensure MultiClusterHdfsAccessor implements HdfsAccessor
+
+// Inode of the virtual root, same as the one of the regular root (see FileSystem.Root).
+// Cluster roots follow it in the order of cluster names, HDFS doesn't assign such low inode numbers to its files
+const multiClusterRootInode = 1
+
+// Creates an instance of MultiClusterHdfsAccessor
+func NewMultiClusterHdfsAccessor(clusters map[string]HdfsAccessor, clock Clock) *MultiClusterHdfsAccessor {
+	return &MultiClusterHdfsAccessor{Clusters: clusters, MountTime: clock.Now()}
+}
+
+// Parses cluster specification in the form "name1=nn1:port,nn2:port;name2=nn3:port"
+// into a map of cluster name -> comma-separated list of name node addresses
+func ParseClusterSpec(spec string) (map[string]string, error) {
+	clusters := make(map[string]string)
+	for _, entry := range strings.Split(spec, ";") {
+		if entry == "" {
+			continue
+		}
+		nameAndAddresses := strings.SplitN(entry, "=", 2)
+		if len(nameAndAddresses) != 2 || nameAndAddresses[0] == "" || nameAndAddresses[1] == "" {
+			return nil, fmt.Errorf("invalid cluster '%s', expected NAME=ADDRESSES", entry)
+		}
+		if _, exists := clusters[nameAndAddresses[0]]; exists {
+			return nil, fmt.Errorf("cluster '%s' is specified more than once", nameAndAddresses[0])
+		}
+		clusters[nameAndAddresses[0]] = nameAndAddresses[1]
+	}
+	return clusters, nil
+}
+
+// Returns attributes of the virtual root (empty name) or of the root of the named cluster
+func (this *MultiClusterHdfsAccessor) virtualDirAttrs(name string) Attrs {
+	inode := uint64(multiClusterRootInode)
+	for i, clusterName := range this.clusterNames() {
+		if clusterName == name {
+			inode += uint64(i) + 1
+		}
+	}
+	return Attrs{
+		Inode: inode,
+		Name:  name,
+		Mode:  os.ModeDir | 0755,
+		Mtime: this.MountTime,
+		Atime: this.MountTime,
+		Ctime: this.MountTime}
+}
+
+// Splits absolute path into cluster name and path within the cluster
+func (this *MultiClusterHdfsAccessor) route(path string) (HdfsAccessor, string, error) {
+	components := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	accessor, ok := this.Clusters[components[0]]
+	if !ok {
+		return nil, "", &os.PathError{Op: "route", Path: path, Err: os.ErrNotExist}
+	}
+	if len(components) == 1 {
+		return accessor, "/", nil
+	}
+	return accessor, "/" + components[1], nil
+}
+
+// Returns true if the path refers to the virtual root which lists the clusters
+func isMultiClusterRoot(path string) bool {
+	return path == "/" || path == ""
+}
+
+// Returns sorted list of cluster names
+func (this *MultiClusterHdfsAccessor) clusterNames() []string {
+	names := make([]string, 0, len(this.Clusters))
+	for name := range this.Clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Opens HDFS file for reading
+func (this *MultiClusterHdfsAccessor) OpenRead(path string) (ReadSeekCloser, error) {
+	accessor, clusterPath, err := this.route(path)
+	if err != nil {
+		return nil, err
+	}
+	return accessor.OpenRead(clusterPath)
+}
+
+// Opens HDFS file for writing
+func (this *MultiClusterHdfsAccessor) CreateFile(path string, mode os.FileMode) (HdfsWriter, error) {
+	accessor, clusterPath, err := this.route(path)
+	if err != nil {
+		return nil, err
+	}
+	return accessor.CreateFile(clusterPath, mode)
+}
+
+// Opens HDFS file for writing, with given number of replicas in the write pipeline
+func (this *MultiClusterHdfsAccessor) CreateFileWithReplication(path string, mode os.FileMode, replication int) (HdfsWriter, error) {
+	accessor, clusterPath, err := this.route(path)
+	if err != nil {
+		return nil, err
+	}
+	return accessor.CreateFileWithReplication(clusterPath, mode, replication)
+}
+
+// Opens existing HDFS file for appending
+func (this *MultiClusterHdfsAccessor) Append(path string) (HdfsWriter, error) {
+	accessor, clusterPath, err := this.route(path)
+	if err != nil {
+		return nil, err
+	}
+	return accessor.Append(clusterPath)
+}
+
+// Enumerates HDFS directory (root directory enumerates configured clusters)
+func (this *MultiClusterHdfsAccessor) ReadDir(path string) ([]Attrs, error) {
+	if isMultiClusterRoot(path) {
+		names := this.clusterNames()
+		allAttrs := make([]Attrs, len(names))
+		for i, name := range names {
+			allAttrs[i] = this.virtualDirAttrs(name)
+		}
+		return allAttrs, nil
+	}
+	accessor, clusterPath, err := this.route(path)
+	if err != nil {
+		return nil, err
+	}
+	return accessor.ReadDir(clusterPath)
+}
+
+// Opens directory for enumerating it page by page (root is enumerated from memory)
+func (this *MultiClusterHdfsAccessor) OpenDir(path string) (DirReader, error) {
+	if isMultiClusterRoot(path) {
+		allAttrs, err := this.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		return NewStaticDirReader(allAttrs), nil
+	}
+	accessor, clusterPath, err := this.route(path)
+	if err != nil {
+		return nil, err
+	}
+	return accessor.OpenDir(clusterPath)
+}
+
+// Retrieves file/directory attributes
+func (this *MultiClusterHdfsAccessor) Stat(path string) (Attrs, error) {
+	if isMultiClusterRoot(path) {
+		return this.virtualDirAttrs(""), nil
+	}
+	accessor, clusterPath, err := this.route(path)
+	if err != nil {
+		return Attrs{}, err
+	}
+	if clusterPath == "/" {
+		// Root of the cluster is presented as a directory named after the cluster
+		return this.virtualDirAttrs(strings.TrimPrefix(path, "/")), nil
+	}
+	return accessor.Stat(clusterPath)
+}
+
+// Retrieves HDFS usage (aggregated across all the clusters)
+func (this *MultiClusterHdfsAccessor) StatFs() (FsInfo, error) {
+	var total FsInfo
+	for _, name := range this.clusterNames() {
+		fsInfo, err := this.Clusters[name].StatFs()
+		if err != nil {
+			return FsInfo{}, err
+		}
+		total.capacity += fsInfo.capacity
+		total.used += fsInfo.used
+		total.remaining += fsInfo.remaining
+	}
+	return total, nil
+}
+
+// Retrieves quota and usage of the directory (root directory aggregates usage across all the clusters)
+func (this *MultiClusterHdfsAccessor) GetContentSummary(path string) (ContentSummary, error) {
+	if isMultiClusterRoot(path) {
+		total := ContentSummary{SpaceQuota: -1, NameQuota: -1}
+		for _, name := range this.clusterNames() {
+			contentSummary, err := this.Clusters[name].GetContentSummary("/")
+			if err != nil {
+				return ContentSummary{}, err
+			}
+			total.Size += contentSummary.Size
+			total.SpaceConsumed += contentSummary.SpaceConsumed
+			total.FileCount += contentSummary.FileCount
+			total.DirectoryCount += contentSummary.DirectoryCount
+		}
+		return total, nil
+	}
+	accessor, clusterPath, err := this.route(path)
+	if err != nil {
+		return ContentSummary{}, err
+	}
+	return accessor.GetContentSummary(clusterPath)
+}
+
+// Creates a directory
+func (this *MultiClusterHdfsAccessor) Mkdir(path string, mode os.FileMode) error {
+	accessor, clusterPath, err := this.route(path)
+	if err != nil {
+		return err
+	}
+	return accessor.Mkdir(clusterPath, mode)
+}
+
+// Removes a file or directory
+func (this *MultiClusterHdfsAccessor) Remove(path string) error {
+	accessor, clusterPath, err := this.route(path)
+	if err != nil {
+		return err
+	}
+	return accessor.Remove(clusterPath)
+}
+
+// Renames a file or directory (renames across clusters aren't supported)
+func (this *MultiClusterHdfsAccessor) Rename(oldPath string, newPath string) error {
+	oldAccessor, oldClusterPath, err := this.route(oldPath)
+	if err != nil {
+		return err
+	}
+	newAccessor, newClusterPath, err := this.route(newPath)
+	if err != nil {
+		return err
+	}
+	if oldAccessor != newAccessor {
+		return fuse.Errno(syscall.EXDEV)
+	}
+	return oldAccessor.Rename(oldClusterPath, newClusterPath)
+}
+
+// Ensures all the cluster accessors are connected to their name nodes
+func (this *MultiClusterHdfsAccessor) EnsureConnected() error {
+	for _, name := range this.clusterNames() {
+		if err := this.Clusters[name].EnsureConnected(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Changes the owner and group of the file
+func (this *MultiClusterHdfsAccessor) Chown(path string, owner, group string) error {
+	accessor, clusterPath, err := this.route(path)
+	if err != nil {
+		return err
+	}
+	return accessor.Chown(clusterPath, owner, group)
+}
+
+// Changes the access and modification times of the file
+func (this *MultiClusterHdfsAccessor) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	accessor, clusterPath, err := this.route(path)
+	if err != nil {
+		return err
+	}
+	return accessor.Chtimes(clusterPath, atime, mtime)
+}
+
+// Changes the mode of the file
+func (this *MultiClusterHdfsAccessor) Chmod(path string, mode os.FileMode) error {
+	accessor, clusterPath, err := this.route(path)
+	if err != nil {
+		return err
+	}
+	return accessor.Chmod(clusterPath, mode)
+}
+
+// Changes the mode of the directory tree
+func (this *MultiClusterHdfsAccessor) ChmodRecursive(path string, mode os.FileMode) error {
+	accessor, clusterPath, err := this.route(path)
+	if err != nil {
+		return err
+	}
+	return accessor.ChmodRecursive(clusterPath, mode)
+}
+
+// Changes the owner and group of the directory tree
+func (this *MultiClusterHdfsAccessor) ChownRecursive(path string, owner, group string) error {
+	accessor, clusterPath, err := this.route(path)
+	if err != nil {
+		return err
+	}
+	return accessor.ChownRecursive(clusterPath, owner, group)
+}
+
+// Retrieves checksum of the file
+func (this *MultiClusterHdfsAccessor) GetChecksum(path string) ([]byte, error) {
+	accessor, clusterPath, err := this.route(path)
+	if err != nil {
+		return nil, err
+	}
+	return accessor.GetChecksum(clusterPath)
+}
+
+// Retrieves ACL entries of the file/directory
+func (this *MultiClusterHdfsAccessor) GetAcl(path string) ([]AclEntry, error) {
+	accessor, clusterPath, err := this.route(path)
+	if err != nil {
+		return nil, err
+	}
+	return accessor.GetAcl(clusterPath)
+}
+
+// Triggers lease recovery of the file
+func (this *MultiClusterHdfsAccessor) RecoverLease(path string) (bool, error) {
+	accessor, clusterPath, err := this.route(path)
+	if err != nil {
+		return false, err
+	}
+	return accessor.RecoverLease(clusterPath)
+}
+
+// Retrieves layout of the file blocks
+func (this *MultiClusterHdfsAccessor) GetBlockLocations(path string) ([]BlockLocation, error) {
+	accessor, clusterPath, err := this.route(path)
+	if err != nil {
+		return nil, err
+	}
+	return accessor.GetBlockLocations(clusterPath)
+}
+
+// Creates a symbolic link (target is stored as is, it isn't mapped to the cluster namespace)
+func (this *MultiClusterHdfsAccessor) CreateSymlink(target string, path string) error {
+	accessor, clusterPath, err := this.route(path)
+	if err != nil {
+		return err
+	}
+	return accessor.CreateSymlink(target, clusterPath)
+}
+
+// Lists changes of the snapshottable directory between two snapshots (paths are relative to the directory)
+func (this *MultiClusterHdfsAccessor) SnapshotDiff(path, from, to string) ([]DiffEntry, error) {
+	accessor, clusterPath, err := this.route(path)
+	if err != nil {
+		return nil, err
+	}
+	return accessor.SnapshotDiff(clusterPath, from, to)
+}
+
+// Closes connections of all the cluster accessors
+func (this *MultiClusterHdfsAccessor) Close() error {
+	var retErr error
+	for _, name := range this.clusterNames() {
+		if err := this.Clusters[name].Close(); err != nil {
+			retErr = err
+		}
+	}
+	return retErr
+}
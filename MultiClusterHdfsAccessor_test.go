@@ -0,0 +1,79 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// Testing that lookups are routed to the cluster selected by the first path component
+func TestMultiClusterLookupRouting(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	clusterA := NewMockHdfsAccessor(mockCtrl)
+	clusterB := NewMockHdfsAccessor(mockCtrl)
+	multiClusterAccessor := NewMultiClusterHdfsAccessor(map[string]HdfsAccessor{"clusterA": clusterA, "clusterB": clusterB}, mockClock)
+	fs, _ := NewFileSystem(multiClusterAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+
+	// Root directory lists configured clusters, without touching any backend
+	dirents, err := root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(dirents))
+	assert.Equal(t, "clusterA", dirents[0].Name)
+	assert.Equal(t, "clusterB", dirents[1].Name)
+	assert.Equal(t, fuse.DT_Dir, dirents[0].Type)
+
+	// Virtual directories have stable inodes and mount time as their modification time
+	var a fuse.Attr
+	rootAttrs, err := multiClusterAccessor.Stat("/")
+	assert.Nil(t, err)
+	assert.Nil(t, rootAttrs.Attr(&a))
+	assert.Equal(t, uint64(1), a.Inode)
+	assert.Equal(t, mockClock.Now(), a.Mtime)
+	clusterAttrs, err := multiClusterAccessor.Stat("/clusterB")
+	assert.Nil(t, err)
+	assert.Nil(t, clusterAttrs.Attr(&a))
+	assert.Equal(t, uint64(3), a.Inode)
+	assert.Equal(t, dirents[1].Inode, a.Inode)
+
+	// Lookups inside each cluster are routed to the corresponding accessor with cluster prefix stripped
+	clusterA.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: 0644}, nil)
+	clusterB.EXPECT().Stat("/bar").Return(Attrs{Name: "bar", Mode: os.ModeDir | 0755}, nil)
+	dirA, err := root.(*Dir).Lookup(nil, "clusterA")
+	assert.Nil(t, err)
+	dirB, err := root.(*Dir).Lookup(nil, "clusterB")
+	assert.Nil(t, err)
+	foo, err := dirA.(*Dir).Lookup(nil, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, "/clusterA/foo", foo.(*File).AbsolutePath())
+	bar, err := dirB.(*Dir).Lookup(nil, "bar")
+	assert.Nil(t, err)
+	assert.Equal(t, "/clusterB/bar", bar.(*Dir).AbsolutePath())
+
+	// Unknown cluster isn't found
+	_, err = root.(*Dir).Lookup(nil, "clusterC")
+	assert.Equal(t, fuse.ENOENT, err)
+
+	// Renames across clusters are rejected
+	assert.NotNil(t, multiClusterAccessor.Rename("/clusterA/foo", "/clusterB/foo"))
+}
+
+// Testing parsing of the cluster specification
+func TestParseClusterSpec(t *testing.T) {
+	clusters, err := ParseClusterSpec("clusterA=nn1:8020,nn2:8020;clusterB=nn3:8020")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(clusters))
+	assert.Equal(t, "nn1:8020,nn2:8020", clusters["clusterA"])
+	assert.Equal(t, "nn3:8020", clusters["clusterB"])
+
+	// Malformed entries are rejected instead of being skipped
+	for _, spec := range []string{"clusterA=nn1:8020;nn3:8020", "=nn1:8020", "clusterA=", "clusterA=nn1:8020;clusterA=nn2:8020"} {
+		_, err = ParseClusterSpec(spec)
+		assert.NotNil(t, err, spec)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Percent-encodes bytes of the name which aren't part of valid UTF-8 sequences.
+// '%' itself is encoded as well, so the original name can be restored by UnescapeName
+func EscapeName(name string) string {
+	if utf8.ValidString(name) && !strings.Contains(name, "%") {
+		return name
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(name); {
+		r, size := utf8.DecodeRuneInString(name[i:])
+		if (r == utf8.RuneError && size == 1) || name[i] == '%' {
+			fmt.Fprintf(&buf, "%%%02X", name[i])
+			i++
+			continue
+		}
+		buf.WriteString(name[i : i+size])
+		i += size
+	}
+	return buf.String()
+}
+
+// Restores the name escaped by EscapeName. '%' which isn't followed by two hex digits is kept as is
+func UnescapeName(name string) string {
+	if !strings.Contains(name, "%") {
+		return name
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(name); i++ {
+		if name[i] == '%' && i+2 < len(name) && isHexDigit(name[i+1]) && isHexDigit(name[i+2]) {
+			buf.WriteByte(hexDigitValue(name[i+1])<<4 | hexDigitValue(name[i+2]))
+			i += 2
+			continue
+		}
+		buf.WriteByte(name[i])
+	}
+	return buf.String()
+}
+
+func isHexDigit(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func hexDigitValue(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// Returns name of HDFS file/directory as presented via mount point (escaped if EscapeNames is enabled)
+func (this *FileSystem) PresentedName(hdfsName string) string {
+	if !this.EscapeNames {
+		return hdfsName
+	}
+	return EscapeName(hdfsName)
+}
+
+// Returns name of HDFS file/directory for the name presented via mount point (reverses PresentedName)
+func (this *FileSystem) HdfsName(presentedName string) string {
+	if !this.EscapeNames {
+		return presentedName
+	}
+	return UnescapeName(presentedName)
+}
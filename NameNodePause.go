@@ -0,0 +1,40 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"syscall"
+)
+
+// Opens additional backend reader for reading the opened file (e.g. a block read in parallel).
+// Opening the reader requires the name node, which may pause metadata operations (e.g. while checkpointing),
+// while the handle's own reader only needs the data nodes. With ReadMetadataWait > 0, gives up with ETIMEDOUT
+// if the name node hasn't responded in time, so the read is served by the handle's own reader instead of waiting
+func (this *FileSystem) OpenReadForHandle(path string) (ReadSeekCloser, error) {
+	if this.ReadMetadataWait <= 0 {
+		return this.HdfsAccessor.OpenRead(path)
+	}
+	type openResult struct {
+		reader ReadSeekCloser
+		err    error
+	}
+	done := make(chan openResult, 1)
+	go func() {
+		reader, err := this.HdfsAccessor.OpenRead(path)
+		done <- openResult{reader, err}
+	}()
+	select {
+	case result := <-done:
+		return result.reader, result.err
+	case <-this.Clock.After(this.ReadMetadataWait):
+		Warning.Println("[", path, "] Name node hasn't responded within", this.ReadMetadataWait, ", reading through the opened reader")
+		go func() {
+			// Reader opened once the name node resumes isn't needed anymore
+			if result := <-done; result.err == nil {
+				result.reader.Close()
+			}
+		}()
+		return nil, fuse.Errno(syscall.ETIMEDOUT)
+	}
+}
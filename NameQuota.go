@@ -0,0 +1,96 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Default time for which namespace quotas and usage of directories are cached (see FileSystem.NameQuotaCacheTTL)
+var DefaultNameQuotaCacheTTL time.Duration = time.Minute
+
+// Caches namespace quotas and usage of directories checked before creating entries (with CheckNameQuota).
+// Content summary costs the name node a walk of the whole directory tree, so it is fetched at most once per TTL,
+// and usage is adjusted locally by the entries created and removed through the mount in the meantime
+type NameQuotaCache struct {
+	entries map[string]*nameQuota // cached quotas by HDFS paths of the directories
+	mutex   sync.Mutex            // mutex for entries
+}
+
+// Cached namespace quota and usage of the directory
+type nameQuota struct {
+	Quota   int64     // namespace quota (negative if not set)
+	Used    int64     // number of files and directories in the tree, including the directory itself
+	Expires time.Time // time when the quota is re-fetched
+}
+
+// Returns cached quota of the directory, fetching its content summary if it isn't cached or has expired
+func (this *FileSystem) nameQuota(dirPath string) (nameQuota, error) {
+	cache := &this.nameQuotas
+	now := this.Clock.Now()
+	cache.mutex.Lock()
+	entry, ok := cache.entries[dirPath]
+	if ok && now.Before(entry.Expires) {
+		defer cache.mutex.Unlock()
+		return *entry, nil
+	}
+	cache.mutex.Unlock()
+
+	contentSummary, err := this.HdfsAccessor.GetContentSummary(dirPath)
+	if err != nil {
+		return nameQuota{Quota: -1}, err
+	}
+	entry = &nameQuota{
+		Quota:   contentSummary.NameQuota,
+		Used:    int64(contentSummary.FileCount + contentSummary.DirectoryCount),
+		Expires: now.Add(this.NameQuotaCacheTTL)}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.entries == nil {
+		cache.entries = make(map[string]*nameQuota)
+	}
+	cache.entries[dirPath] = entry
+	return *entry, nil
+}
+
+// Checks that a new entry fits into namespace quotas of the directory and all its ancestors,
+// failing with EDQUOT if any of them is reached (directories whose quota can't be retrieved aren't checked)
+func (this *FileSystem) CheckNameQuotaAvailable(dirPath string) error {
+	for {
+		quota, err := this.nameQuota(dirPath)
+		if err != nil {
+			Warning.Println("[", dirPath, "] GetContentSummary:", err)
+		} else if quota.Quota >= 0 && quota.Used >= quota.Quota {
+			Warning.Println("[", dirPath, "] Namespace quota of the directory is reached:", quota.Used, "/", quota.Quota)
+			return fuse.Errno(syscall.EDQUOT)
+		}
+		if dirPath == "/" || dirPath == "." || dirPath == "" {
+			return nil
+		}
+		dirPath = path.Dir(dirPath)
+	}
+}
+
+// Adjusts cached usage of the directory and its ancestors by the number of entries created (positive delta)
+// or removed (negative delta) in the directory
+func (this *FileSystem) AdjustNameQuotaUsage(dirPath string, delta int64) {
+	cache := &this.nameQuotas
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	for {
+		if entry, ok := cache.entries[dirPath]; ok {
+			entry.Used += delta
+			if entry.Used < 0 {
+				entry.Used = 0
+			}
+		}
+		if dirPath == "/" || dirPath == "." || dirPath == "" {
+			return
+		}
+		dirPath = path.Dir(dirPath)
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Quotas of the directory and its ancestors are checked before create and mkdir, content summaries are cached
+// and usage is adjusted by the entries created and removed through the mount
+func TestNameQuotaOfAncestors(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.CheckNameQuota = true
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: os.ModeDir | 0755}, nil)
+	foo, _ := root.(*Dir).Lookup(nil, "foo")
+
+	// Directory itself has no quota, but its parent has room for one more entry
+	hdfsAccessor.EXPECT().GetContentSummary("/foo").Return(ContentSummary{NameQuota: -1, FileCount: 5, DirectoryCount: 1}, nil)
+	hdfsAccessor.EXPECT().GetContentSummary("/").Return(ContentSummary{NameQuota: 10, FileCount: 5, DirectoryCount: 4}, nil)
+	hdfsAccessor.EXPECT().Mkdir("/foo/bar", os.FileMode(0755)|os.ModeDir).Return(nil)
+	_, err := foo.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Name: "bar", Mode: os.FileMode(0755) | os.ModeDir})
+	assert.Nil(t, err)
+
+	// Quota of the root is now reached, known without querying content summaries again
+	_, _, err = foo.(*Dir).Create(nil, &fuse.CreateRequest{Name: "baz", Mode: 0644}, &fuse.CreateResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EDQUOT), err)
+	_, err = foo.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Name: "baz", Mode: os.FileMode(0755) | os.ModeDir})
+	assert.Equal(t, fuse.Errno(syscall.EDQUOT), err)
+
+	// Removing an entry frees room in the cached usage
+	hdfsAccessor.EXPECT().Remove("/foo/bar").Return(nil)
+	assert.Nil(t, foo.(*Dir).Remove(nil, &fuse.RemoveRequest{Name: "bar", Dir: true}))
+	hdfsAccessor.EXPECT().Mkdir("/foo/baz", os.FileMode(0755)|os.ModeDir).Return(nil)
+	_, err = foo.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Name: "baz", Mode: os.FileMode(0755) | os.ModeDir})
+	assert.Nil(t, err)
+
+	// Content summaries are fetched again once cached quotas expire
+	mockClock.NotifyTimeElapsed(DefaultNameQuotaCacheTTL + time.Second)
+	hdfsAccessor.EXPECT().GetContentSummary("/foo").Return(ContentSummary{NameQuota: 3, FileCount: 1, DirectoryCount: 2}, nil)
+	_, err = foo.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Name: "qux", Mode: os.FileMode(0755) | os.ModeDir})
+	assert.Equal(t, fuse.Errno(syscall.EDQUOT), err)
+	mockCtrl.Finish()
+}
@@ -0,0 +1,119 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// Behaviors which can be assigned to the open flags (see FileSystem.OpenFlagModes)
+const (
+	OpenModeWriteThrough = "writethrough" // writes to new and appended files are flushed to HDFS before they are acknowledged
+	OpenModeNoAtime      = "noatime"      // reads through the handle don't update access time of the file
+	OpenModeFresh        = "fresh"        // handle bypasses metadata and content caches (see ConsistencyFresh)
+)
+
+// O_NOATIME flag (not defined by bazil.org/fuse)
+const OpenNoatime = fuse.OpenFlags(syscall.O_NOATIME)
+
+// Open flags which can be mapped to behaviors, by name
+var OpenFlagsByName = map[string]fuse.OpenFlags{
+	"sync":    fuse.OpenSync,
+	"dsync":   fuse.OpenFlags(syscall.O_DSYNC),
+	"noatime": OpenNoatime}
+
+// Maps open flags to behaviors of the handles opened with them
+type OpenFlagModes struct {
+	Rules []OpenFlagModeRule
+}
+
+// Single rule: handles opened with the flag get the behavior
+type OpenFlagModeRule struct {
+	Flag fuse.OpenFlags // Open flag (one of OpenFlagsByName)
+	Mode string         // One of the OpenMode* constants
+}
+
+// Creates OpenFlagModes from comma-separated list of "flag=mode" pairs
+func NewOpenFlagModes(spec string) (*OpenFlagModes, error) {
+	this := &OpenFlagModes{}
+	for _, entry := range strings.Split(spec, ",") {
+		if entry == "" {
+			continue
+		}
+		flagAndMode := strings.SplitN(entry, "=", 2)
+		if len(flagAndMode) != 2 {
+			return nil, errors.New(fmt.Sprintf("Invalid open flag mapping: %s", entry))
+		}
+		flag, ok := OpenFlagsByName[flagAndMode[0]]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Unknown open flag: %s", flagAndMode[0]))
+		}
+		if flagAndMode[1] != OpenModeWriteThrough && flagAndMode[1] != OpenModeNoAtime && flagAndMode[1] != OpenModeFresh {
+			return nil, errors.New(fmt.Sprintf("Unknown open flag mode: %s", flagAndMode[1]))
+		}
+		Info.Println("Open flag mapping: [", flagAndMode[0], "] ->", flagAndMode[1])
+		this.Rules = append(this.Rules, OpenFlagModeRule{Flag: flag, Mode: flagAndMode[1]})
+	}
+	return this, nil
+}
+
+// Returns true if any of the given open flags is mapped to the mode
+func (this *OpenFlagModes) Has(flags fuse.OpenFlags, mode string) bool {
+	if this == nil {
+		return false
+	}
+	for _, rule := range this.Rules {
+		if rule.Mode == mode && flags&rule.Flag == rule.Flag {
+			return true
+		}
+	}
+	return false
+}
+
+// Applies behaviors associated with the open flags to the newly opened handle
+func (this *File) ApplyOpenFlags(handle *FileHandle, flags fuse.OpenFlags, resp *fuse.OpenResponse) error {
+	if this.IsFreshOpen(flags) {
+		handle.Fresh = true
+		// Bypassing cached metadata, so the reader sees current size of the file
+		if err := this.RefreshAttrs(); err != nil {
+			return err
+		}
+		if resp != nil {
+			// Bypassing kernel page cache as well
+			resp.Flags |= fuse.OpenDirectIO
+		}
+	}
+	if this.FileSystem.HonorODirect && flags&OpenDirect == OpenDirect {
+		handle.Direct = true
+		if resp != nil {
+			// Bypassing kernel page cache as well
+			resp.Flags |= fuse.OpenDirectIO
+		}
+	}
+	if this.IsTranscoded() && resp != nil {
+		// Reported size might be the size before transcoding (see ApplyTranscodedSize)
+		resp.Flags |= fuse.OpenDirectIO
+	}
+	handle.ApplyOpenFlagModes(flags)
+	return nil
+}
+
+// Applies behaviors configured for the open flags (see FileSystem.OpenFlagModes)
+func (this *FileHandle) ApplyOpenFlagModes(flags fuse.OpenFlags) {
+	modes := this.File.FileSystem.OpenFlagModes
+	this.WriteThrough = modes.Has(flags, OpenModeWriteThrough)
+	this.NoAtime = modes.Has(flags, OpenModeNoAtime)
+}
+
+// Updates access time of the file on the first read through the handle (unless opened with NoAtime)
+func (this *FileHandle) TouchAtime() {
+	if this.NoAtime || this.atimeUpdated {
+		return
+	}
+	this.atimeUpdated = true
+	this.File.TouchAtime(this.File.FileSystem.Clock.Now())
+}
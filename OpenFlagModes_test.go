@@ -0,0 +1,83 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// Writes through handle opened with O_SYNC (mapped to write-through) reach HDFS before being acknowledged
+func TestWriteThroughOnOSync(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.OpenFlagModes, _ = NewOpenFlagModes("sync=writethrough,noatime=noatime")
+	root, _ := fileSystem.Root()
+	hdfsAccessor.EXPECT().Stat("/journal").Return(Attrs{Name: "journal", Mode: 0644}, nil)
+	file, _ := root.(*Dir).Lookup(nil, "journal")
+
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Remove("/journal").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/journal", os.FileMode(0644)).Return(hdfsWriter, nil)
+	h, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenSync}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	handle := h.(*FileHandle)
+	assert.True(t, handle.WriteThrough)
+	assert.False(t, handle.NoAtime)
+
+	// Each write is flushed to HDFS pipeline without waiting for flush or close, previously written data isn't uploaded again
+	for i, entry := range []string{"entry1", "entry2"} {
+		hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: 100, remaining: 100}, nil)
+		hdfsWriter.EXPECT().Write([]byte(entry)).Return(6, nil)
+		hdfsWriter.EXPECT().Flush().Return(nil)
+		resp := &fuse.WriteResponse{}
+		assert.Nil(t, handle.Write(nil, &fuse.WriteRequest{Data: []byte(entry), Offset: int64(i * 6)}, resp))
+		assert.Equal(t, 6, resp.Size)
+		assert.Equal(t, uint64(0), handle.Writer.BytesWritten)
+	}
+}
+
+// Reads through handle opened with O_NOATIME (mapped to noatime) don't update access time, unlike other reads
+func TestNoAtimeOnONoatime(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.OpenFlagModes, _ = NewOpenFlagModes("sync=writethrough,noatime=noatime")
+	root, _ := fileSystem.Root()
+	atime := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+	hdfsAccessor.EXPECT().Stat("/data.txt").Return(Attrs{Name: "data.txt", Mode: 0644, Size: 5, Atime: atime}, nil)
+	node, _ := root.(*Dir).Lookup(nil, "data.txt")
+	file := node.(*File)
+	mockClock.NotifyTimeElapsed(time.Second)
+	open := func(flags fuse.OpenFlags) *FileHandle {
+		hdfsReader := NewMockReadSeekCloser(mockCtrl)
+		hdfsAccessor.EXPECT().OpenRead("/data.txt").Return(hdfsReader, nil)
+		hdfsReader.whenReadReturn([]byte("Hello"), io.EOF)
+		h, err := file.Open(nil, &fuse.OpenRequest{Flags: flags}, &fuse.OpenResponse{})
+		assert.Nil(t, err)
+		return h.(*FileHandle)
+	}
+
+	handle := open(fuse.OpenReadOnly | OpenNoatime)
+	assert.True(t, handle.NoAtime)
+	handle.readAndVerify(t, 0, 5, []byte("Hello"))
+	var attr fuse.Attr
+	assert.Nil(t, file.Attr(nil, &attr))
+	assert.Equal(t, atime, attr.Atime)
+
+	handle = open(fuse.OpenReadOnly)
+	assert.False(t, handle.NoAtime)
+	handle.readAndVerify(t, 0, 5, []byte("Hello"))
+	assert.Nil(t, file.Attr(nil, &attr))
+	assert.Equal(t, mockClock.Now(), attr.Atime)
+}
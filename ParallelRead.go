@@ -0,0 +1,88 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"io"
+	"sync"
+)
+
+// Part of the read request falling into a single HDFS block
+type blockSegment struct {
+	Offset int64  // Offset of the segment within the file
+	Data   []byte // Part of the response buffer receiving the segment
+}
+
+// Attempts to serve read request spanning several HDFS blocks by fetching its parts in parallel
+// (with ParallelBlockReads > 0), each block through its own backend reader, at most ParallelBlockReads at once.
+// Returns false if the request can't be served this way (e.g. it falls into a single block, block locations
+// are unknown or fetching of any part has failed), so it has to be served by the handle's reader
+func (this *FileHandle) ReadParallelBlocks(req *fuse.ReadRequest, resp *fuse.ReadResponse) bool {
+	parallelism := this.File.FileSystem.ParallelBlockReads
+	if parallelism <= 0 || req.Offset < 0 || this.Reader.WholeFile {
+		return false
+	}
+	resp.Data = resp.Data[:req.Size]
+	end := req.Offset + int64(req.Size)
+	var segments []blockSegment
+	for _, block := range this.BlockLocations() {
+		start, stop := int64(block.Offset), int64(block.Offset+block.Length)
+		if stop <= req.Offset || start >= end {
+			continue
+		}
+		if start < req.Offset {
+			start = req.Offset
+		}
+		if stop > end {
+			stop = end
+		}
+		segments = append(segments, blockSegment{Offset: start, Data: resp.Data[start-req.Offset : stop-req.Offset]})
+	}
+	if len(segments) < 2 || segments[0].Offset != req.Offset {
+		resp.Data = resp.Data[:0]
+		return false
+	}
+
+	path := this.File.AbsolutePath()
+	slots := make(chan struct{}, parallelism)
+	errs := make(chan error, len(segments))
+	var wg sync.WaitGroup
+	for _, segment := range segments {
+		wg.Add(1)
+		go func(segment blockSegment) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			errs <- this.readSegment(path, segment)
+		}(segment)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			Warning.Println("[", path, "] Parallel read @", req.Offset, "(", req.Size, "bytes):", err)
+			resp.Data = resp.Data[:0]
+			return false
+		}
+	}
+	last := segments[len(segments)-1]
+	// Request reaching beyond the last block is served up to the end of the file
+	resp.Data = resp.Data[:last.Offset-req.Offset+int64(len(last.Data))]
+	return true
+}
+
+// Reads the segment of the file through a dedicated backend reader
+func (this *FileHandle) readSegment(path string, segment blockSegment) error {
+	reader, err := this.File.FileSystem.OpenReadForHandle(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	reader = this.File.LimitReads(reader)
+	if err = reader.Seek(segment.Offset); err != nil {
+		return err
+	}
+	_, err = io.ReadFull(reader, segment.Data)
+	return err
+}
@@ -0,0 +1,124 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Backend reader of a block which doesn't return data until all the expected readers are reading concurrently
+type concurrentBlockReader struct {
+	Offset  int64  // Current position within the file
+	Readers int32  // Number of readers expected to read concurrently
+	Reading *int32 // Number of readers which have started reading (shared by the readers)
+}
+
+var _ ReadSeekCloser = (*concurrentBlockReader)(nil)
+
+func (this *concurrentBlockReader) Seek(pos int64) error {
+	this.Offset = pos
+	return nil
+}
+
+func (this *concurrentBlockReader) Position() (int64, error) {
+	return this.Offset, nil
+}
+
+func (this *concurrentBlockReader) Read(buffer []byte) (int, error) {
+	atomic.AddInt32(this.Reading, 1)
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(this.Reading) < this.Readers {
+		if time.Now().After(deadline) {
+			return 0, errors.New("blocks aren't read concurrently")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := range buffer {
+		buffer[i] = generateByteAtOffset(this.Offset + int64(i))
+	}
+	this.Offset += int64(len(buffer))
+	return len(buffer), nil
+}
+
+func (this *concurrentBlockReader) Close() error {
+	return nil
+}
+
+// Testing that a read spanning several blocks fetches them concurrently and assembles them in order
+func TestReadParallelBlocks(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	handle := createTestHandle(t, mockCtrl, hdfsReader)
+	handle.File.FileSystem.ParallelBlockReads = 4
+	hdfsAccessor := handle.File.FileSystem.HdfsAccessor.(*MockHdfsAccessor)
+	hdfsAccessor.EXPECT().GetBlockLocations("/test.dat").Return([]BlockLocation{
+		{Offset: 0, Length: 100},
+		{Offset: 100, Length: 100},
+		{Offset: 200, Length: 100},
+		{Offset: 300, Length: 100}}, nil)
+	reading := int32(0)
+	hdfsAccessor.EXPECT().OpenRead("/test.dat").DoAndReturn(func(path string) (ReadSeekCloser, error) {
+		return &concurrentBlockReader{Readers: 3, Reading: &reading}, nil
+	}).Times(3)
+
+	// Request spans parts of three blocks, none of them is read through the handle's own reader
+	expected := make([]byte, 200)
+	for i := range expected {
+		expected[i] = generateByteAtOffset(int64(50 + i))
+	}
+	handle.readAndVerify(t, 50, 200, expected)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&reading))
+
+	hdfsReader.EXPECT().Close().Return(nil)
+	handle.Release(nil, nil)
+}
+
+// Read of an opened file with known block locations doesn't wait for the name node paused by checkpointing,
+// blocks which would be read through additional readers are read by the handle's own reader instead
+func TestReadDuringNameNodePause(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	hdfsReader := &MockReadSeekCloserWithPseudoRandomContent{FileSize: 400, ReaderStats: &ReaderStats{}}
+	handle := createTestHandle(t, mockCtrl, hdfsReader)
+	handle.File.FileSystem.ParallelBlockReads = 4
+	handle.File.FileSystem.ReadMetadataWait = time.Second
+	hdfsAccessor := handle.File.FileSystem.HdfsAccessor.(*MockHdfsAccessor)
+	hdfsAccessor.EXPECT().GetBlockLocations("/test.dat").Return([]BlockLocation{
+		{Offset: 0, Length: 100},
+		{Offset: 100, Length: 100},
+		{Offset: 200, Length: 100},
+		{Offset: 300, Length: 100}}, nil)
+	assert.Equal(t, 4, len(handle.BlockLocations()))
+
+	// Name node doesn't respond until the pause ends
+	paused := make(chan struct{})
+	opened := make(chan *MockReadSeekCloserWithPseudoRandomContent, 3)
+	hdfsAccessor.EXPECT().OpenRead("/test.dat").DoAndReturn(func(path string) (ReadSeekCloser, error) {
+		<-paused
+		reader := &MockReadSeekCloserWithPseudoRandomContent{FileSize: 400, ReaderStats: &ReaderStats{}}
+		opened <- reader
+		return reader, nil
+	}).Times(3)
+
+	expected := make([]byte, 200)
+	for i := range expected {
+		expected[i] = generateByteAtOffset(int64(50 + i))
+	}
+	handle.readAndVerify(t, 50, 200, expected)
+
+	// Readers opened once the pause ends are discarded
+	close(paused)
+	for i := 0; i < 3; i++ {
+		<-opened
+	}
+	handle.Release(nil, nil)
+	assert.True(t, hdfsReader.IsClosed)
+	mockCtrl.Finish()
+}
@@ -0,0 +1,28 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"syscall"
+)
+
+// Default HDFS limits of the path component and total path length
+// (dfs.namenode.fs-limits.max-component-length and the name node's maximum path length)
+const (
+	DefaultMaxNameLength = 255
+	DefaultMaxPathLength = 8000
+)
+
+// Checks that the new child of the directory doesn't exceed HDFS limits of the name
+// and path lengths (see FileSystem.MaxNameLength, FileSystem.MaxPathLength), returns ENAMETOOLONG otherwise
+func (this *Dir) CheckNameLength(name string) error {
+	maxNameLength := this.FileSystem.MaxNameLength
+	maxPathLength := this.FileSystem.MaxPathLength
+	absolutePath := this.AbsolutePathForChild(name)
+	if maxNameLength > 0 && len(name) > maxNameLength || maxPathLength > 0 && len(absolutePath) > maxPathLength {
+		Warning.Println("[", absolutePath, "] Name (", len(name), "bytes) or path (", len(absolutePath), "bytes) exceeds HDFS limits")
+		return fuse.Errno(syscall.ENAMETOOLONG)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Maps virtual paths presented to the clients of the mount point to different HDFS paths
+// (e.g. /old/data -> /new/location/data), which allows to move data on HDFS without breaking clients
+type PathRewriter struct {
+	Rules []PathRewriteRule // List of rewrite rules, no rule prefix is a prefix of another one
+}
+
+// Single rewrite rule: all paths under From prefix are mapped to the same paths under To prefix
+type PathRewriteRule struct {
+	From string // Virtual path prefix
+	To   string // HDFS path prefix
+}
+
+// Creates PathRewriter from comma-separated list of "from=to" pairs.
+// Returns an error if rules are ambiguous (one virtual prefix contains another one)
+func NewPathRewriter(spec string) (*PathRewriter, error) {
+	this := &PathRewriter{}
+	for _, entry := range strings.Split(spec, ",") {
+		if entry == "" {
+			continue
+		}
+		fromAndTo := strings.SplitN(entry, "=", 2)
+		if len(fromAndTo) != 2 || !path.IsAbs(fromAndTo[0]) || !path.IsAbs(fromAndTo[1]) {
+			return nil, errors.New(fmt.Sprintf("Invalid path rewrite rule: %s", entry))
+		}
+		if err := this.AddRule(fromAndTo[0], fromAndTo[1]); err != nil {
+			return nil, err
+		}
+	}
+	return this, nil
+}
+
+// Adds rule mapping virtual path prefix to HDFS path prefix.
+// Returns an error if the rule is ambiguous with existing ones
+func (this *PathRewriter) AddRule(from string, to string) error {
+	rule := PathRewriteRule{From: path.Clean(from), To: path.Clean(to)}
+	for _, existing := range this.Rules {
+		if isPathUnderPrefix(rule.From, existing.From) || isPathUnderPrefix(existing.From, rule.From) {
+			return errors.New(fmt.Sprintf("Ambiguous path rewrite rules: %s and %s", existing.From, rule.From))
+		}
+	}
+	Info.Println("Path rewrite rule: [", rule.From, "] -> [", rule.To, "]")
+	this.Rules = append(this.Rules, rule)
+	return nil
+}
+
+// Returns true if the path is equal to the prefix or located underneath it
+func isPathUnderPrefix(p string, prefix string) bool {
+	return p == prefix || prefix == "/" || strings.HasPrefix(p, prefix+"/")
+}
+
+// Maps virtual path to HDFS path
+func (this *PathRewriter) Rewrite(p string) string {
+	if this == nil {
+		return p
+	}
+	for _, rule := range this.Rules {
+		if isPathUnderPrefix(p, rule.From) {
+			return path.Join(rule.To, strings.TrimPrefix(p, rule.From))
+		}
+	}
+	return p
+}
@@ -0,0 +1,56 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// Testing that lookup of an aliased path resolves to the rewritten HDFS path
+func TestLookupWithPathRewrite(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	var err error
+	fs.PathRewriter, err = NewPathRewriter("/old/data=/new/location/data")
+	assert.Nil(t, err)
+	root, _ := fs.Root()
+
+	hdfsAccessor.EXPECT().Stat("/old").Return(Attrs{Name: "old", Mode: os.ModeDir | 0755}, nil)
+	hdfsAccessor.EXPECT().Stat("/new/location/data").Return(Attrs{Name: "data", Mode: os.ModeDir | 0755}, nil)
+	hdfsAccessor.EXPECT().Stat("/new/location/data/foo").Return(Attrs{Name: "foo", Mode: 0644}, nil)
+	old, err := root.(*Dir).Lookup(nil, "old")
+	assert.Nil(t, err)
+	data, err := old.(*Dir).Lookup(nil, "data")
+	assert.Nil(t, err)
+	assert.Equal(t, "/old/data", data.(*Dir).VirtualPath())
+	assert.Equal(t, "/new/location/data", data.(*Dir).AbsolutePath())
+	foo, err := data.(*Dir).Lookup(nil, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, "/new/location/data/foo", foo.(*File).AbsolutePath())
+}
+
+// Testing rewrite rules parsing and matching
+func TestPathRewriterRules(t *testing.T) {
+	rewriter, err := NewPathRewriter("/old/data=/new/location/data,/a=/b")
+	assert.Nil(t, err)
+	assert.Equal(t, "/new/location/data", rewriter.Rewrite("/old/data"))
+	assert.Equal(t, "/new/location/data/x/y", rewriter.Rewrite("/old/data/x/y"))
+	assert.Equal(t, "/old/database", rewriter.Rewrite("/old/database"))
+	assert.Equal(t, "/b/c", rewriter.Rewrite("/a/c"))
+	assert.Equal(t, "/old", rewriter.Rewrite("/old"))
+
+	// nil rewriter doesn't change paths
+	var noRewriter *PathRewriter
+	assert.Equal(t, "/old/data", noRewriter.Rewrite("/old/data"))
+
+	// Ambiguous or malformed rules are rejected
+	_, err = NewPathRewriter("/old=/new,/old/data=/other")
+	assert.NotNil(t, err)
+	_, err = NewPathRewriter("old=/new")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,219 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// Single entry of HDFS access control list
+type AclEntry struct {
+	Type    string      // "user", "group", "mask" or "other"
+	Name    string      // Name of the user or group the entry applies to ("" for the owner or owning group)
+	Perm    os.FileMode // Permission bits granted by the entry (rwx, 0-7)
+	Default bool        // True for default entry of a directory, inherited by its new children instead of being checked
+}
+
+// Access modes being checked (same as rwx permission bits of 'other' class)
+const (
+	AccessRead    os.FileMode = 04
+	AccessWrite   os.FileMode = 02
+	AccessExecute os.FileMode = 01
+)
+
+// Returns names of the groups the user belongs to (overridable for testing)
+var LookupUserGroups = func(uid uint32) ([]string, error) {
+	u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10))
+	if err != nil {
+		return nil, err
+	}
+	gids, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]string, 0, len(gids))
+	for _, gid := range gids {
+		if g, err := user.LookupGroupId(gid); err == nil {
+			groups = append(groups, g.Name)
+		}
+	}
+	return groups, nil
+}
+
+// Returns name of the user (overridable for testing)
+var LookupUserName = func(uid uint32) (string, error) {
+	u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10))
+	if err != nil {
+		return "", err
+	}
+	return u.Username, nil
+}
+
+// Returns name of the group (overridable for testing)
+var LookupGroupName = func(gid uint32) (string, error) {
+	g, err := user.LookupGroupId(strconv.FormatUint(uint64(gid), 10))
+	if err != nil {
+		return "", err
+	}
+	return g.Name, nil
+}
+
+// Returns names of the groups of the caller: its group (gid of the request) and supplementary groups of the user
+func callerGroups(uid uint32, gid uint32) []string {
+	groups := []string{}
+	if name, err := LookupGroupName(gid); err == nil {
+		groups = append(groups, name)
+	}
+	supplementary, err := LookupUserGroups(uid)
+	if err != nil {
+		Warning.Println("Can't lookup groups of uid", uid, ":", err)
+	}
+	return append(groups, supplementary...)
+}
+
+// Returns true if the group is among the given ones
+func containsGroup(groups []string, group string) bool {
+	for _, g := range groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks whether user (uid, gid) is allowed to access the file/directory with the requested mode.
+// Permissions are evaluated the way HDFS does: the owner gets owner bits, a user named by ACL entry gets its permissions,
+// members of the owning group or of the groups named by ACL entries get the union of their permissions
+// (the entries are limited by the mask, which HDFS keeps in the group bits of the mode), others get other bits
+func (this *Attrs) CheckAccess(uid uint32, gid uint32, access os.FileMode) bool {
+	if uid == 0 {
+		return true
+	}
+	if uid == this.Uid {
+		return (this.Mode>>6)&access == access
+	}
+	groupBits := (this.Mode >> 3) & 07
+	hasAccessAcl := false
+	for _, entry := range this.Acl {
+		hasAccessAcl = hasAccessAcl || !entry.Default
+	}
+	var groups []string
+	isMember := func(group string) bool {
+		if group == "" {
+			return false
+		}
+		if groups == nil {
+			groups = callerGroups(uid, gid)
+		}
+		return containsGroup(groups, group)
+	}
+	if !hasAccessAcl {
+		// Membership in the owning group is known by its name, gid is compared for the attributes without it
+		if isMember(this.Group) || (this.Group == "" && gid == this.Gid) {
+			return groupBits&access == access
+		}
+		return this.Mode&access == access
+	}
+	mask := groupBits
+	if userName, err := LookupUserName(uid); err == nil {
+		for _, entry := range this.Acl {
+			if !entry.Default && entry.Type == "user" && entry.Name == userName {
+				return entry.Perm&mask&access == access
+			}
+		}
+	}
+	member := false
+	for _, entry := range this.Acl {
+		if entry.Default || entry.Type != "group" {
+			continue
+		}
+		group := entry.Name
+		if group == "" {
+			group = this.Group
+		}
+		if !isMember(group) {
+			continue
+		}
+		member = true
+		if entry.Perm&mask&access == access {
+			return true
+		}
+	}
+	if member {
+		return false
+	}
+	return this.Mode&access == access
+}
+
+// Returns true if the directory has default ACL, which is inherited by its new children
+func (this *Attrs) HasDefaultAcl() bool {
+	for _, entry := range this.Acl {
+		if entry.Default {
+			return true
+		}
+	}
+	return false
+}
+
+// Computes permissions of a new file created in the directory with default ACL, the way HDFS does:
+// requested permission bits (umask doesn't apply) are limited by the default entries of the owner,
+// the owning group (or the mask, if present) and others. Named default entries become ACL of the file
+func (this *Attrs) ApplyDefaultAcl(requested os.FileMode) (os.FileMode, []AclEntry) {
+	owner, group, mask, other := os.FileMode(07), os.FileMode(07), os.FileMode(0), os.FileMode(07)
+	hasMask := false
+	var acl []AclEntry
+	for _, entry := range this.Acl {
+		if !entry.Default {
+			continue
+		}
+		switch {
+		case entry.Type == "mask":
+			mask, hasMask = entry.Perm, true
+		case entry.Type == "other":
+			other = entry.Perm
+		case entry.Name != "":
+			acl = append(acl, AclEntry{Type: entry.Type, Name: entry.Name, Perm: entry.Perm})
+		case entry.Type == "user":
+			owner = entry.Perm
+		case entry.Type == "group":
+			group = entry.Perm
+		}
+	}
+	if hasMask {
+		group = mask
+	}
+	return requested&^os.ModePerm | requested&(owner<<6|group<<3|other), acl
+}
+
+// Retrieves ACL entries of the file/directory with given attributes, unless they are already cached along with them.
+// ACL is only needed for permission checks, so it isn't retrieved with the attributes (see HdfsAccessor.GetAcl).
+// If it can't be retrieved, permissions are checked by the mode bits
+func (this *FileSystem) LoadAcl(path string, attrs *Attrs) {
+	if !attrs.HasAcl || attrs.Acl != nil {
+		return
+	}
+	acl, err := this.HdfsAccessor.GetAcl(path)
+	if err != nil {
+		Warning.Println("[", path, "] GetAcl:", err)
+		return
+	}
+	attrs.Acl = acl
+}
+
+// Responds to FUSE access request for the file/directory with given attributes. If EffectiveAccess is enabled,
+// effective permission of the caller (including ACL entries) is computed, otherwise access is granted
+func (this *FileSystem) CheckAccessRequest(path string, attrs *Attrs, req *fuse.AccessRequest) error {
+	access := os.FileMode(req.Mask) & (AccessRead | AccessWrite | AccessExecute)
+	if !this.EffectiveAccess || access == 0 {
+		return nil
+	}
+	this.LoadAcl(path, attrs)
+	if !attrs.CheckAccess(req.Header.Uid, req.Header.Gid, access) {
+		return fuse.Errno(syscall.EACCES)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// User denied by the mode bits, but allowed by ACL group entry (for one of its supplementary groups) can open the file
+func TestAclGroupEntryGrantsAccess(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.EnforcePermissions = true
+	originalLookupUserGroups := LookupUserGroups
+	defer func() { LookupUserGroups = originalLookupUserGroups }()
+	LookupUserGroups = func(uid uint32) ([]string, error) {
+		return []string{"users", "analysts"}, nil
+	}
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/shared.dat").Return(Attrs{Name: "shared.dat", Mode: 0640, Uid: 1000, Gid: 1000,
+		Acl: []AclEntry{AclEntry{Type: "group", Name: "analysts", Perm: 04}}}, nil)
+	hdfsAccessor.EXPECT().Stat("/private.dat").Return(Attrs{Name: "private.dat", Mode: 0640, Uid: 1000, Gid: 1000,
+		Acl: []AclEntry{AclEntry{Type: "group", Name: "admins", Perm: 04}}}, nil)
+	shared, _ := root.(*Dir).Lookup(nil, "shared.dat")
+	private, _ := root.(*Dir).Lookup(nil, "private.dat")
+	header := fuse.Header{Uid: 2000, Gid: 2000}
+
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/shared.dat").Return(hdfsReader, nil)
+	h, err := shared.(*File).Open(nil, &fuse.OpenRequest{Header: header, Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	hdfsReader.EXPECT().Close().Return(nil)
+	h.(*FileHandle).Release(nil, nil)
+
+	// ACL entry grants read access only
+	_, err = shared.(*File).Open(nil, &fuse.OpenRequest{Header: header, Flags: fuse.OpenWriteOnly}, &fuse.OpenResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EACCES), err)
+
+	// None of the user's groups is granted access by ACL
+	_, err = private.(*File).Open(nil, &fuse.OpenRequest{Header: header, Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EACCES), err)
+}
+
+// access() is answered with effective permission: ACL group entry grants access denied by the mode bits
+func TestAccessGrantedByAcl(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.EffectiveAccess = true
+	originalLookupUserGroups := LookupUserGroups
+	defer func() { LookupUserGroups = originalLookupUserGroups }()
+	LookupUserGroups = func(uid uint32) ([]string, error) {
+		return []string{"analysts"}, nil
+	}
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/reports").Return(Attrs{Name: "reports", Mode: os.ModeDir | 0750, Uid: 1000, Gid: 1000,
+		Acl: []AclEntry{AclEntry{Type: "group", Name: "analysts", Perm: 05}}}, nil)
+	hdfsAccessor.EXPECT().Stat("/shared.dat").Return(Attrs{Name: "shared.dat", Mode: 0640, Uid: 1000, Gid: 1000,
+		Acl: []AclEntry{AclEntry{Type: "group", Name: "analysts", Perm: 04}}}, nil)
+	dir, _ := root.(*Dir).Lookup(nil, "reports")
+	file, _ := root.(*Dir).Lookup(nil, "shared.dat")
+	header := fuse.Header{Uid: 2000, Gid: 2000}
+
+	assert.Nil(t, file.(*File).Access(nil, &fuse.AccessRequest{Header: header, Mask: uint32(AccessRead)}))
+	assert.Equal(t, fuse.Errno(syscall.EACCES), file.(*File).Access(nil, &fuse.AccessRequest{Header: header, Mask: uint32(AccessWrite)}))
+	assert.Nil(t, dir.(*Dir).Access(nil, &fuse.AccessRequest{Header: header, Mask: uint32(AccessRead | AccessExecute)}))
+	assert.Equal(t, fuse.Errno(syscall.EACCES), dir.(*Dir).Access(nil, &fuse.AccessRequest{Header: header, Mask: uint32(AccessWrite)}))
+	// Existence check (F_OK) always succeeds
+	assert.Nil(t, file.(*File).Access(nil, &fuse.AccessRequest{Header: header, Mask: 0}))
+
+	// Without effective access computation, access() is granted
+	fs.EffectiveAccess = false
+	assert.Nil(t, file.(*File).Access(nil, &fuse.AccessRequest{Header: header, Mask: uint32(AccessWrite)}))
+}
+
+// Permission check follows HDFS: ACL entries are limited by the mask (group bits of the mode),
+// membership in the owning group is determined by its name (for the caller's gid and supplementary groups)
+func TestCheckAccessWithMaskAndOwningGroup(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	originalLookupUserGroups, originalLookupUserName, originalLookupGroupName := LookupUserGroups, LookupUserName, LookupGroupName
+	defer func() {
+		LookupUserGroups, LookupUserName, LookupGroupName = originalLookupUserGroups, originalLookupUserName, originalLookupGroupName
+	}()
+	LookupUserGroups = func(uid uint32) ([]string, error) {
+		return []string{"analysts"}, nil
+	}
+	LookupUserName = func(uid uint32) (string, error) {
+		return map[uint32]string{2000: "bob", 3000: "carol"}[uid], nil
+	}
+	LookupGroupName = func(gid uint32) (string, error) {
+		return map[uint32]string{2000: "staff", 3000: "guests"}[gid], nil
+	}
+
+	// Owning group is matched by name, its gid isn't known
+	attrs := Attrs{Mode: 0640, Uid: 1000, Group: "staff"}
+	assert.True(t, attrs.CheckAccess(2000, 2000, AccessRead))
+	assert.False(t, attrs.CheckAccess(2000, 2000, AccessWrite))
+	assert.False(t, attrs.CheckAccess(3000, 0, AccessRead))
+	attrs.Group = "analysts"
+	assert.True(t, attrs.CheckAccess(3000, 3000, AccessRead))
+
+	// Mask (r--) limits the entries granting more
+	attrs = Attrs{Mode: 0640, Uid: 1000, Group: "hdfs", Acl: []AclEntry{
+		AclEntry{Type: "user", Name: "bob", Perm: 06},
+		AclEntry{Type: "group", Perm: 04},
+		AclEntry{Type: "group", Name: "analysts", Perm: 06}}}
+	assert.True(t, attrs.CheckAccess(2000, 2000, AccessRead))
+	assert.False(t, attrs.CheckAccess(2000, 2000, AccessWrite))
+	assert.False(t, attrs.CheckAccess(3000, 3000, AccessWrite))
+	attrs.Mode = 0660
+	assert.True(t, attrs.CheckAccess(2000, 2000, AccessWrite))
+	assert.True(t, attrs.CheckAccess(3000, 3000, AccessWrite))
+
+	// Member of a matching group which doesn't grant access doesn't fall back to other bits
+	attrs = Attrs{Mode: 0604, Uid: 1000, Group: "hdfs", Acl: []AclEntry{AclEntry{Type: "group", Name: "analysts", Perm: 0}}}
+	assert.False(t, attrs.CheckAccess(3000, 3000, AccessRead))
+}
+
+// File created in a shared directory with default ACL inherits its group-writable permissions, regardless of the umask
+func TestCreateInheritsDefaultAcl(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.InheritDefaultAcl = true
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/shared").Return(Attrs{Name: "shared", Mode: os.ModeDir | 0775,
+		Acl: []AclEntry{
+			AclEntry{Type: "user", Perm: 07, Default: true},
+			AclEntry{Type: "group", Perm: 07, Default: true},
+			AclEntry{Type: "group", Name: "ingest", Perm: 06, Default: true},
+			AclEntry{Type: "mask", Perm: 06, Default: true},
+			AclEntry{Type: "other", Perm: 04, Default: true}}}, nil)
+	shared, err := root.(*Dir).Lookup(nil, "shared")
+	assert.Nil(t, err)
+
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Remove("/shared/batch.csv").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/shared/batch.csv", os.FileMode(0664)).Return(hdfsWriter, nil)
+	hdfsWriter.EXPECT().Close().Return(nil)
+	node, _, err := shared.(*Dir).Create(nil, &fuse.CreateRequest{Name: "batch.csv", Mode: 0644, Umask: 022}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	file := node.(*File)
+	assert.Equal(t, os.FileMode(0664), file.Attrs.Mode)
+	assert.Equal(t, []AclEntry{AclEntry{Type: "group", Name: "ingest", Perm: 06}}, file.Attrs.Acl)
+}
+
+// ACL is retrieved only once permissions are checked, and it's cached along with the attributes
+func TestAclRetrievedOnPermissionCheck(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	originalLookupUserGroups := LookupUserGroups
+	defer func() { LookupUserGroups = originalLookupUserGroups }()
+	LookupUserGroups = func(uid uint32) ([]string, error) {
+		return []string{"analysts"}, nil
+	}
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/shared.dat").Return(Attrs{Name: "shared.dat", Mode: 0640, Uid: 1000, Gid: 1000, HasAcl: true}, nil)
+	shared, _ := root.(*Dir).Lookup(nil, "shared.dat")
+	header := fuse.Header{Uid: 2000, Gid: 2000}
+
+	// Permissions aren't checked, so ACL isn't needed
+	assert.Nil(t, shared.(*File).Access(nil, &fuse.AccessRequest{Header: header, Mask: uint32(AccessRead)}))
+
+	fs.EffectiveAccess = true
+	hdfsAccessor.EXPECT().GetAcl("/shared.dat").Return([]AclEntry{AclEntry{Type: "group", Name: "analysts", Perm: 04}}, nil).Times(1)
+	assert.Nil(t, shared.(*File).Access(nil, &fuse.AccessRequest{Header: header, Mask: uint32(AccessRead)}))
+	assert.Equal(t, fuse.Errno(syscall.EACCES), shared.(*File).Access(nil, &fuse.AccessRequest{Header: header, Mask: uint32(AccessWrite)}))
+}
@@ -0,0 +1,123 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// Beginning of the file fetched in the background before the file is opened by the client
+// (see FileSystem.SequentialDirPrefetch)
+type PrefetchedFile struct {
+	Reader   ReadSeekCloser  // Backend reader positioned right after the prefetched block
+	Fragment *FileFragment   // Prefetched first block of the file
+	Offset   int64           // Current offset of the backend reader
+	Blocks   []BlockLocation // Block locations of the file (with PrefetchBlocks only)
+	Err      error           // Error which happened during prefetch (nil if successful)
+	reserved int64           // bytes of PrefetchBudget held by the prefetched block
+	done     chan struct{}   // closed once prefetch completes
+	abort    chan struct{}   // closed once the file is opened or prefetch is discarded, so prefetch doesn't wait for the budget
+}
+
+// Reported by prefetch which has been aborted while waiting for the prefetch budget
+var errPrefetchAborted = errors.New("prefetch aborted")
+
+// Starts fetching first block of the file in the background, unless it has been already started
+func (this *File) StartPrefetch() {
+	this.prefetchMutex.Lock()
+	defer this.prefetchMutex.Unlock()
+	if this.prefetched != nil {
+		return
+	}
+	prefetched := &PrefetchedFile{done: make(chan struct{}), abort: make(chan struct{})}
+	this.prefetched = prefetched
+	path := this.AbsolutePath()
+	budget := this.FileSystem.PrefetchBudget
+	go func() {
+		defer close(prefetched.done)
+		if !budget.Acquire(int64(BLOCKSIZE), prefetched.abort) {
+			prefetched.Err = errPrefetchAborted
+			return
+		}
+		prefetched.reserved = int64(BLOCKSIZE)
+		Info.Println("[", path, "] Prefetching")
+		reader, err := this.FileSystem.HdfsAccessor.OpenRead(path)
+		if err != nil {
+			Warning.Println("[", path, "] Prefetch failed:", err)
+			prefetched.Err = err
+			budget.Release(prefetched.reserved)
+			return
+		}
+		reader = this.LimitReads(reader)
+		prefetched.Fragment = &FileFragment{}
+		err = prefetched.Fragment.ReadFromBackend(reader, &prefetched.Offset, 1, BLOCKSIZE)
+		if err != nil && err != io.EOF {
+			Warning.Println("[", path, "] Prefetch failed:", err)
+			reader.Close()
+			prefetched.Err = err
+			budget.Release(prefetched.reserved)
+			return
+		}
+		prefetched.Reader = reader
+		if this.FileSystem.PrefetchBlocks {
+			if prefetched.Blocks, err = this.FileSystem.HdfsAccessor.GetBlockLocations(path); err != nil {
+				Warning.Println("[", path, "] Prefetching block locations:", err)
+			}
+		}
+	}()
+}
+
+// Returns the prefetched beginning of the file (waiting for the prefetch in progress), ownership
+// of the backend reader is transferred to the caller. Returns nil if the file hasn't been prefetched
+func (this *File) TakePrefetched() *PrefetchedFile {
+	this.prefetchMutex.Lock()
+	prefetched := this.prefetched
+	this.prefetched = nil
+	this.prefetchMutex.Unlock()
+	if prefetched == nil {
+		return nil
+	}
+	close(prefetched.abort)
+	<-prefetched.done
+	if prefetched.Err != nil {
+		return nil
+	}
+	// Prefetched block is handed over to the reader, it no longer counts as prefetch
+	this.FileSystem.PrefetchBudget.Release(prefetched.reserved)
+	return prefetched
+}
+
+// Releases the prefetched data (in the background, since prefetch might be still in progress)
+func (this *File) DiscardPrefetched() {
+	go func() {
+		if prefetched := this.TakePrefetched(); prefetched != nil {
+			prefetched.Reader.Close()
+		}
+	}()
+}
+
+// Prefetches the file which follows the given one in the most recent listing of the directory.
+// At most one file per directory is kept prefetched
+func (this *Dir) PrefetchNext(name string) {
+	this.EntriesMutex.Lock()
+	var next *File
+	for i, entry := range this.listing {
+		if entry == name && i+1 < len(this.listing) {
+			next, _ = this.Entries[this.listing[i+1]].(*File)
+			break
+		}
+	}
+	previous := this.prefetchedFile
+	if next != nil {
+		this.prefetchedFile = next
+	}
+	this.EntriesMutex.Unlock()
+	if next == nil {
+		return
+	}
+	if previous != nil && previous != next {
+		previous.DiscardPrefetched()
+	}
+	next.StartPrefetch()
+}
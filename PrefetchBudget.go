@@ -0,0 +1,81 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"sync"
+)
+
+// Bounds total number of bytes held by prefetch and read-ahead buffers across all the files
+// (see FileSystem.PrefetchBudget). Prefetch pauses once the budget is exhausted and resumes
+// as the buffers are consumed or discarded. Methods are nil-safe (nil budget is unbounded)
+type PrefetchBudget struct {
+	MaxBytes int64         // Maximum number of bytes held by prefetch buffers
+	used     int64         // Number of bytes currently held
+	changed  chan struct{} // closed (and replaced) whenever bytes are released
+	mutex    sync.Mutex    // mutex for used and changed
+}
+
+// Creates budget allowing at most maxBytes held by prefetch buffers
+func NewPrefetchBudget(maxBytes int64) *PrefetchBudget {
+	return &PrefetchBudget{MaxBytes: maxBytes, changed: make(chan struct{})}
+}
+
+// Reserves n bytes if they fit into the budget, returns false otherwise
+func (this *PrefetchBudget) TryAcquire(n int64) bool {
+	if this == nil {
+		return true
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.used+n > this.MaxBytes {
+		return false
+	}
+	this.used += n
+	return true
+}
+
+// Reserves n bytes, waiting for other buffers to release them if needed.
+// Returns false if the wait has been aborted (closing abort channel)
+func (this *PrefetchBudget) Acquire(n int64, abort <-chan struct{}) bool {
+	if this == nil {
+		return true
+	}
+	for {
+		this.mutex.Lock()
+		if this.used+n <= this.MaxBytes {
+			this.used += n
+			this.mutex.Unlock()
+			return true
+		}
+		changed := this.changed
+		this.mutex.Unlock()
+		select {
+		case <-changed:
+		case <-abort:
+			return false
+		}
+	}
+}
+
+// Returns n previously reserved bytes to the budget, resuming paused prefetches
+func (this *PrefetchBudget) Release(n int64) {
+	if this == nil || n == 0 {
+		return
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.used -= n
+	close(this.changed)
+	this.changed = make(chan struct{})
+}
+
+// Returns number of bytes currently held by prefetch buffers
+func (this *PrefetchBudget) Used() int64 {
+	if this == nil {
+		return 0
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.used
+}
@@ -0,0 +1,77 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// Read-ahead of many handles stays within the global prefetch budget, paused read-ahead resumes once budget is released
+func TestPrefetchBudgetAcrossHandles(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(&MockClock{}), &MockClock{})
+	fileSystem.ReadaheadTriggerCount = 1
+	fileSystem.PrefetchBudget = NewPrefetchBudget(int64(2 * READAHEADSIZE))
+	root, _ := fileSystem.Root()
+	var handles []*FileHandle
+	for i := 0; i < 4; i++ {
+		name := fmt.Sprintf("part-%d", i)
+		hdfsAccessor.EXPECT().Stat("/"+name).Return(Attrs{Name: name, Mode: 0644, Size: 1 << 30}, nil)
+		hdfsAccessor.EXPECT().OpenRead("/"+name).Return(&MockReadSeekCloserWithPseudoRandomContent{FileSize: 1 << 30, ReaderStats: &ReaderStats{}}, nil)
+		file, _ := root.(*Dir).Lookup(nil, name)
+		h, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+		assert.Nil(t, err)
+		handles = append(handles, h.(*FileHandle))
+	}
+	readNext := func(handle *FileHandle) {
+		offset := handle.Reader.LastReadEnd
+		resp := fuse.ReadResponse{Data: make([]byte, 0, BLOCKSIZE)}
+		assert.Nil(t, handle.Read(nil, &fuse.ReadRequest{Offset: offset, Size: BLOCKSIZE}, &resp))
+		assert.Equal(t, generateByteAtOffset(offset), resp.Data[0])
+		assert.True(t, fileSystem.PrefetchBudget.Used() <= fileSystem.PrefetchBudget.MaxBytes)
+	}
+
+	// All the handles read sequentially, but only two of them fit into the budget with their read-ahead
+	for round := 0; round < 2; round++ {
+		for _, handle := range handles {
+			readNext(handle)
+		}
+	}
+	readingAhead := 0
+	for _, handle := range handles {
+		if handle.Reader.readaheadBytes > 0 {
+			readingAhead++
+		}
+	}
+	assert.Equal(t, 2, readingAhead)
+	assert.Equal(t, int64(2*READAHEADSIZE), fileSystem.PrefetchBudget.Used())
+	assert.Equal(t, int64(0), handles[3].Reader.readaheadBytes)
+
+	// Closing a handle drains its read-ahead, letting paused handle read ahead
+	handles[0].Release(nil, nil)
+	readNext(handles[3])
+	assert.Equal(t, int64(READAHEADSIZE), handles[3].Reader.readaheadBytes)
+	for _, handle := range handles[1:] {
+		handle.Release(nil, nil)
+	}
+	assert.Equal(t, int64(0), fileSystem.PrefetchBudget.Used())
+
+	// Background prefetch waits for the budget, unless it is aborted
+	budget := NewPrefetchBudget(10)
+	assert.True(t, budget.TryAcquire(10))
+	abort := make(chan struct{})
+	close(abort)
+	assert.False(t, budget.Acquire(1, abort))
+	acquired := make(chan bool)
+	go func() { acquired <- budget.Acquire(5, nil) }()
+	budget.Release(10)
+	assert.True(t, <-acquired)
+	assert.Equal(t, int64(5), budget.Used())
+}
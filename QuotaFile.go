@@ -0,0 +1,73 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"fmt"
+	"golang.org/x/net/context"
+	"strings"
+	"syscall"
+)
+
+// Name of the virtual file exposing quota and usage of the directory (with ExposeQuotaFile enabled)
+const QuotaFileName = ".quota"
+
+// Virtual read-only file whose content reports quota and usage of the parent directory
+type QuotaFile struct {
+	Dir *Dir // Directory, quota of which is reported
+}
+
+// Verify that *QuotaFile implements necesary FUSE interfaces
+var _ fs.Node = (*QuotaFile)(nil)
+var _ fs.NodeOpener = (*QuotaFile)(nil)
+var _ fs.HandleReadAller = (*QuotaFile)(nil)
+
+// Responds on FUSE Attr request to retrieve file attributes.
+// Size is reported as zero since the content is generated on open (as in procfs)
+func (this *QuotaFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = 0444
+	a.Uid = this.Dir.Attrs.Uid
+	a.Gid = this.Dir.Attrs.Gid
+	a.Mtime = this.Dir.FileSystem.Clock.Now()
+	return nil
+}
+
+// Responds on FUSE Open request, content is served bypassing the page cache
+func (this *QuotaFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if !req.Flags.IsReadOnly() {
+		return nil, fuse.Errno(syscall.EACCES)
+	}
+	resp.Flags |= fuse.OpenDirectIO
+	return this, nil
+}
+
+// Responds on FUSE Read request by formatting quota and usage of the directory as text
+func (this *QuotaFile) ReadAll(ctx context.Context) ([]byte, error) {
+	absolutePath := this.Dir.AbsolutePath()
+	contentSummary, err := this.Dir.FileSystem.HdfsAccessor.GetContentSummary(absolutePath)
+	if err != nil {
+		Warning.Println("[", absolutePath, "] GetContentSummary:", err)
+		return nil, err
+	}
+	return []byte(contentSummary.String()), nil
+}
+
+// Formats quota and usage as "key: value" lines ("none" for quotas which aren't set)
+func (this ContentSummary) String() string {
+	formatQuota := func(quota int64) string {
+		if quota < 0 {
+			return "none"
+		}
+		return fmt.Sprint(quota)
+	}
+	return fmt.Sprintf("space_quota: %s\nspace_consumed: %d\nname_quota: %s\nfile_count: %d\ndirectory_count: %d\ncontent_size: %d\n",
+		formatQuota(this.SpaceQuota), this.SpaceConsumed, formatQuota(this.NameQuota), this.FileCount, this.DirectoryCount, this.Size)
+}
+
+// Returns true if the error indicates that namespace or space quota of a directory is exceeded
+// (NSQuotaExceededException or DSQuotaExceededException)
+func IsQuotaError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "QuotaExceededException")
+}
@@ -0,0 +1,42 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+// Number of recently read ranges kept by each file handle
+var RANGECACHEENTRIES int = 4
+
+// Reads larger than this aren't kept in the range cache
+var RANGECACHEMAXSIZE int = 1024 * 1024
+
+// Bounded MRU cache of the ranges recently returned to the client by a file handle,
+// so repeated reads of the same range (e.g. Parquet footer) don't hit the backend
+// Concurrency: not thread safe, protected by the mutex of the file handle
+type RangeCache struct {
+	Ranges []*FileFragment // Recently read ranges, most recently used first
+}
+
+// Attempts to satisfy a read request from a single cached range, returns true if successful
+func (this *RangeCache) Read(fileOffset int64, buf []byte) bool {
+	for i, fragment := range this.Ranges {
+		if fileOffset >= fragment.Offset && fileOffset+int64(len(buf)) <= fragment.Offset+int64(len(fragment.Data)) {
+			copy(buf, fragment.Data[fileOffset-fragment.Offset:])
+			// Moving to the front to keep MRU order
+			copy(this.Ranges[1:i+1], this.Ranges[:i])
+			this.Ranges[0] = fragment
+			return true
+		}
+	}
+	return false
+}
+
+// Remembers the range returned to the client, evicting the least recently used one if needed
+func (this *RangeCache) Add(fileOffset int64, data []byte) {
+	if len(data) == 0 || len(data) > RANGECACHEMAXSIZE || RANGECACHEENTRIES <= 0 {
+		return
+	}
+	fragment := &FileFragment{Offset: fileOffset, Data: append([]byte(nil), data...)}
+	this.Ranges = append([]*FileFragment{fragment}, this.Ranges...)
+	if len(this.Ranges) > RANGECACHEENTRIES {
+		this.Ranges = this.Ranges[:RANGECACHEENTRIES]
+	}
+}
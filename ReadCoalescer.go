@@ -0,0 +1,123 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Size of the aligned window fetched from the backend for coalesced small reads
+var CoalesceWindowSize int = 65536
+
+// Coalesces concurrent small reads of nearby offsets from all the handles of the file (see FileSystem.CoalesceReadSize):
+// reads falling into the same aligned window share a single backend fetch of the window, fanning its result out
+type ReadCoalescer struct {
+	WindowSize int   // Size of the aligned windows fetched from the backend
+	Fetches    int64 // Number of backend fetches (updated atomically)
+	Coalesced  int64 // Number of reads served by fetches started for other reads (updated atomically)
+
+	inflight map[int64]*windowFetch // fetches in progress, keyed by window offset
+	mutex    sync.Mutex             // mutex for inflight
+}
+
+// Backend fetch of a window in progress
+type windowFetch struct {
+	done chan struct{} // closed once the fetch has completed
+	data []byte        // content of the window (shorter at the end of the file)
+	err  error         // error of the fetch
+}
+
+// Creates read coalescer fetching windows of a given size
+func NewReadCoalescer(windowSize int) *ReadCoalescer {
+	return &ReadCoalescer{WindowSize: windowSize, inflight: make(map[int64]*windowFetch)}
+}
+
+// Reads data at the offset from the window fetched by the concurrent read of the same window,
+// or fetches the window (using fetch function) if there is no such read in progress.
+// Returns false if the request spans windows, so it can't be coalesced
+func (this *ReadCoalescer) Read(offset int64, buf []byte, fetch func(offset int64, buf []byte) (int, error)) (int, bool, error) {
+	windowOffset := offset / int64(this.WindowSize) * int64(this.WindowSize)
+	if offset+int64(len(buf)) > windowOffset+int64(this.WindowSize) {
+		return 0, false, nil
+	}
+	this.mutex.Lock()
+	f, joined := this.inflight[windowOffset]
+	if !joined {
+		f = &windowFetch{done: make(chan struct{})}
+		this.inflight[windowOffset] = f
+	}
+	this.mutex.Unlock()
+
+	if joined {
+		atomic.AddInt64(&this.Coalesced, 1)
+		<-f.done
+	} else {
+		atomic.AddInt64(&this.Fetches, 1)
+		f.data = make([]byte, this.WindowSize)
+		var n int
+		n, f.err = fetch(windowOffset, f.data)
+		f.data = f.data[:n]
+		this.mutex.Lock()
+		delete(this.inflight, windowOffset)
+		this.mutex.Unlock()
+		close(f.done)
+	}
+	if f.err != nil {
+		return 0, true, f.err
+	}
+	start := int(offset - windowOffset)
+	if start >= len(f.data) {
+		return 0, true, nil
+	}
+	return copy(buf, f.data[start:]), true, nil
+}
+
+// Returns read coalescer of the file, creating it on first use
+func (this *File) ReadCoalescer() *ReadCoalescer {
+	this.coalescerOnce.Do(func() {
+		this.coalescer = NewReadCoalescer(CoalesceWindowSize)
+	})
+	return this.coalescer
+}
+
+// Attempts to serve small read request through the read coalescer of the file (with CoalesceReadSize > 0),
+// as long as the file is opened by several handles. Returns false if the request has to be served
+// by the handle's reader (e.g. it is too large, or coalesced fetch has failed)
+func (this *FileHandle) ReadCoalesced(req *fuse.ReadRequest, resp *fuse.ReadResponse) bool {
+	maxSize := this.File.FileSystem.CoalesceReadSize
+	if maxSize <= 0 || req.Size > maxSize || req.Offset < 0 || this.Fresh || this.Reader.WholeFile ||
+		len(this.File.GetActiveHandles()) < 2 {
+		return false
+	}
+	path := this.File.AbsolutePath()
+	resp.Data = resp.Data[:req.Size]
+	n, ok, err := this.File.ReadCoalescer().Read(req.Offset, resp.Data, func(offset int64, buf []byte) (int, error) {
+		reader, err := this.File.FileSystem.OpenReadForHandle(path)
+		if err != nil {
+			return 0, err
+		}
+		defer reader.Close()
+		reader = this.File.LimitReads(reader)
+		if err = reader.Seek(offset); err != nil {
+			return 0, err
+		}
+		n, err := io.ReadFull(reader, buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// Window reaches beyond the end of the file
+			err = nil
+		}
+		return n, err
+	})
+	if !ok || err != nil {
+		if err != nil {
+			Warning.Println("[", path, "] Coalesced read @", req.Offset, ":", err)
+		}
+		resp.Data = resp.Data[:0]
+		return false
+	}
+	resp.Data = resp.Data[:n]
+	return true
+}
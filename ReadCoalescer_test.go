@@ -0,0 +1,101 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Backend reader with pseudo-random content, which doesn't return data until the gate is opened
+type gatedReader struct {
+	Offset int64         // Current position within the file
+	Gate   chan struct{} // Closed to let the reads proceed
+}
+
+var _ ReadSeekCloser = (*gatedReader)(nil)
+
+func (this *gatedReader) Seek(pos int64) error {
+	this.Offset = pos
+	return nil
+}
+
+func (this *gatedReader) Position() (int64, error) {
+	return this.Offset, nil
+}
+
+func (this *gatedReader) Read(buffer []byte) (int, error) {
+	<-this.Gate
+	for i := range buffer {
+		buffer[i] = generateByteAtOffset(this.Offset + int64(i))
+	}
+	this.Offset += int64(len(buffer))
+	return len(buffer), nil
+}
+
+func (this *gatedReader) Close() error {
+	return nil
+}
+
+// Testing that concurrent small reads of adjacent ranges by several handles are served by a single backend fetch
+func TestCoalescedSmallReads(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.CoalesceReadSize = 4096
+	root, _ := fileSystem.Root()
+	hdfsAccessor.EXPECT().Stat("/index.dat").Return(Attrs{Name: "index.dat", Mode: 0644, Size: 1 << 20}, nil)
+	file, _ := root.(*Dir).Lookup(nil, "index.dat")
+
+	const handles = 4
+	var handleReaders []*MockReadSeekCloser
+	var fileHandles []*FileHandle
+	for i := 0; i < handles; i++ {
+		hdfsReader := NewMockReadSeekCloser(mockCtrl)
+		hdfsAccessor.EXPECT().OpenRead("/index.dat").Return(hdfsReader, nil)
+		h, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+		assert.Nil(t, err)
+		// Access time updates of the shared attributes aren't relevant here
+		h.(*FileHandle).NoAtime = true
+		handleReaders = append(handleReaders, hdfsReader)
+		fileHandles = append(fileHandles, h.(*FileHandle))
+	}
+
+	// Window is fetched once, through a dedicated backend reader
+	gate := make(chan struct{})
+	hdfsAccessor.EXPECT().OpenRead("/index.dat").Return(&gatedReader{Gate: gate}, nil).Times(1)
+	var wg sync.WaitGroup
+	for i, handle := range fileHandles {
+		wg.Add(1)
+		go func(offset int64, handle *FileHandle) {
+			defer wg.Done()
+			expected := make([]byte, 16)
+			for j := range expected {
+				expected[j] = generateByteAtOffset(offset + int64(j))
+			}
+			handle.readAndVerify(t, offset, 16, expected)
+		}(int64(1000+16*i), handle)
+	}
+	coalescer := file.(*File).ReadCoalescer()
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt64(&coalescer.Coalesced) < handles-1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(gate)
+	wg.Wait()
+	assert.Equal(t, int64(1), atomic.LoadInt64(&coalescer.Fetches))
+	assert.Equal(t, int64(handles-1), atomic.LoadInt64(&coalescer.Coalesced))
+
+	for i, handle := range fileHandles {
+		handleReaders[i].EXPECT().Close().Return(nil)
+		handle.Release(nil, nil)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"syscall"
+	"time"
+)
+
+// Behaviors on opening for read a file which is still being written by another client (see FileSystem.ReadInProgress)
+const (
+	ReadInProgressAllow = "allow" // data available so far is read (might be stale or short)
+	ReadInProgressDeny  = "deny"  // open fails with EAGAIN
+	ReadInProgressWait  = "wait"  // open waits until the file is finalized (fails with EAGAIN if it takes too long)
+)
+
+// Number of times the file status is checked while waiting for the file to be finalized
+var ReadInProgressWaitAttempts int = 30
+
+// Delay between checks of the file status while waiting for the file to be finalized
+var ReadInProgressPollInterval time.Duration = 2 * time.Second
+
+// Returns true if the blocks are of a file which is still open for write by some client
+func IsBeingWritten(blocks []BlockLocation) bool {
+	return len(blocks) > 0 && blocks[len(blocks)-1].UnderConstruction
+}
+
+// Applies configured behavior if the file opened for read is still being written.
+// Whether the file is open for write is only reported with its block locations, so they are fetched on each such open
+func (this *File) CheckInProgressRead() error {
+	behavior := this.FileSystem.ReadInProgress
+	if behavior == "" || behavior == ReadInProgressAllow {
+		return nil
+	}
+	path := this.AbsolutePath()
+	for attempt := 1; ; attempt++ {
+		blocks, err := this.FileSystem.HdfsAccessor.GetBlockLocations(path)
+		if err != nil {
+			return err
+		}
+		if !IsBeingWritten(blocks) {
+			if attempt > 1 {
+				// File has been finalized while waiting, cached attributes are stale
+				return this.Parent.LookupAttrs(this.Attrs.Name, &this.Attrs)
+			}
+			return nil
+		}
+		if behavior != ReadInProgressWait || attempt >= ReadInProgressWaitAttempts {
+			Warning.Println("[", path, "] File is being written, can't be read yet")
+			return fuse.Errno(syscall.EAGAIN)
+		}
+		<-this.FileSystem.Clock.After(ReadInProgressPollInterval)
+	}
+}
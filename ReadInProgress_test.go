@@ -0,0 +1,73 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// Opening for read a file which is being written elsewhere is allowed, denied or waits until the file is finalized
+func TestReadInProgress(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	lookup := func(name string) *File {
+		hdfsAccessor.EXPECT().Stat("/"+name).Return(Attrs{Name: name, Mode: 0644, Size: 5}, nil)
+		node, err := root.(*Dir).Lookup(nil, name)
+		assert.Nil(t, err)
+		return node.(*File)
+	}
+	open := func(file *File) error {
+		_, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+		return err
+	}
+
+	// allow: available data is read
+	fs.ReadInProgress = ReadInProgressAllow
+	file := lookup("allowed")
+	hdfsAccessor.EXPECT().OpenRead("/allowed").Return(hdfsReader, nil)
+	assert.Nil(t, open(file))
+
+	// deny: EAGAIN if the file is still being written
+	fs.ReadInProgress = ReadInProgressDeny
+	writing := []BlockLocation{{Offset: 0, Length: 4}, {Offset: 4, Length: 1, UnderConstruction: true}}
+	file = lookup("denied")
+	hdfsAccessor.EXPECT().GetBlockLocations("/denied").Return(writing, nil)
+	assert.Equal(t, fuse.Errno(syscall.EAGAIN), open(file))
+
+	// wait: block locations are polled until the file is finalized, then the file is re-statted
+	fs.ReadInProgress = ReadInProgressWait
+	file = lookup("awaited")
+	gomock.InOrder(
+		hdfsAccessor.EXPECT().GetBlockLocations("/awaited").Return(writing, nil),
+		hdfsAccessor.EXPECT().GetBlockLocations("/awaited").Return([]BlockLocation{{Offset: 0, UnderConstruction: true}}, nil),
+		hdfsAccessor.EXPECT().GetBlockLocations("/awaited").Return([]BlockLocation{{Offset: 0, Length: 10}}, nil),
+		hdfsAccessor.EXPECT().Stat("/awaited").Return(Attrs{Name: "awaited", Mode: 0644, Size: 10}, nil),
+		hdfsAccessor.EXPECT().OpenRead("/awaited").Return(hdfsReader, nil))
+	assert.Nil(t, open(file))
+	assert.Equal(t, ReadInProgressPollInterval, mockClock.LastSleepDuration)
+	assert.Equal(t, uint64(10), file.Attrs.Size)
+
+	// wait: waiting is bounded
+	ReadInProgressWaitAttempts = 2
+	defer func() { ReadInProgressWaitAttempts = 30 }()
+	file = lookup("stuck")
+	hdfsAccessor.EXPECT().GetBlockLocations("/stuck").Return(writing, nil).Times(2)
+	assert.Equal(t, fuse.Errno(syscall.EAGAIN), open(file))
+
+	// deny: finalized file is opened as usual
+	fs.ReadInProgress = ReadInProgressDeny
+	file = lookup("finalized")
+	hdfsAccessor.EXPECT().GetBlockLocations("/finalized").Return([]BlockLocation{{Offset: 0, Length: 5}}, nil)
+	hdfsAccessor.EXPECT().OpenRead("/finalized").Return(hdfsReader, nil)
+	assert.Nil(t, open(file))
+}
@@ -0,0 +1,62 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Daily time window (e.g. maintenance window), as offsets from midnight of the local time.
+// Window ending before it starts wraps around midnight
+type TimeWindow struct {
+	Start time.Duration // Start of the window (inclusive)
+	End   time.Duration // End of the window (exclusive)
+}
+
+// Parses comma-separated list of daily time windows in HH:MM-HH:MM format (e.g. "01:00-03:30,23:00-00:30")
+func ParseTimeWindows(spec string) ([]TimeWindow, error) {
+	var windows []TimeWindow
+	for _, window := range strings.Split(spec, ",") {
+		if window == "" {
+			continue
+		}
+		bounds := strings.Split(window, "-")
+		if len(bounds) != 2 {
+			return nil, errors.New(fmt.Sprintf("Invalid time window: %s (expected HH:MM-HH:MM)", window))
+		}
+		var parsed [2]time.Duration
+		for i, bound := range bounds {
+			t, err := time.Parse("15:04", strings.TrimSpace(bound))
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Invalid time window: %s (expected HH:MM-HH:MM)", window))
+			}
+			parsed[i] = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+		}
+		windows = append(windows, TimeWindow{Start: parsed[0], End: parsed[1]})
+	}
+	return windows, nil
+}
+
+// Returns true if the time of day falls into the window
+func (this TimeWindow) Contains(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+	if this.Start <= this.End {
+		return offset >= this.Start && offset < this.End
+	}
+	return offset >= this.Start || offset < this.End
+}
+
+// Returns true if the mount is read-only at the moment, since the current time falls into one of ReadOnlyWindows
+func (this *FileSystem) InReadOnlyWindow() bool {
+	now := this.Clock.Now()
+	for _, window := range this.ReadOnlyWindows {
+		if window.Contains(now) {
+			return true
+		}
+	}
+	return false
+}
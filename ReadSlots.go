@@ -0,0 +1,40 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+// Waits for a slot to read the file from the backend (at most FileSystem.MaxReadsPerFile
+// concurrent reads per file), returns function releasing the slot
+func (this *File) AcquireReadSlot() func() {
+	if this.FileSystem.MaxReadsPerFile <= 0 {
+		return func() {}
+	}
+	this.readSlotsOnce.Do(func() {
+		this.readSlots = make(chan struct{}, this.FileSystem.MaxReadsPerFile)
+	})
+	this.readSlots <- struct{}{}
+	return func() { <-this.readSlots }
+}
+
+// Backend reader of the file holding a read slot for the duration of each read
+type slotReader struct {
+	ReadSeekCloser
+	File *File
+}
+
+// Reads from the backend once a read slot of the file is available
+func (this *slotReader) Read(buf []byte) (int, error) {
+	defer this.File.AcquireReadSlot()()
+	return this.ReadSeekCloser.Read(buf)
+}
+
+// Limits concurrent reads through the backend reader of the file by MaxReadsPerFile. All the backend readers
+// of the file (handles, read-ahead, prefetch, parallel and coalesced reads) are wrapped, so they share the limit
+func (this *File) LimitReads(reader ReadSeekCloser) ReadSeekCloser {
+	if this.FileSystem.MaxReadsPerFile <= 0 || reader == nil {
+		return reader
+	}
+	if _, ok := reader.(*slotReader); ok {
+		return reader
+	}
+	return &slotReader{ReadSeekCloser: reader, File: this}
+}
@@ -0,0 +1,100 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Backend reader tracking number of concurrent reads across all the readers sharing the counters
+type concurrencyTrackingReader struct {
+	MockReadSeekCloserWithPseudoRandomContent
+	mutex   *sync.Mutex
+	active  *int
+	maxSeen *int
+}
+
+func (this *concurrencyTrackingReader) Read(buf []byte) (int, error) {
+	this.mutex.Lock()
+	*this.active++
+	if *this.active > *this.maxSeen {
+		*this.maxSeen = *this.active
+	}
+	this.mutex.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	defer func() {
+		this.mutex.Lock()
+		*this.active--
+		this.mutex.Unlock()
+	}()
+	return this.MockReadSeekCloserWithPseudoRandomContent.Read(buf)
+}
+
+// Concurrent reads of a single file through many handles don't exceed per-file limit of backend reads
+func TestMaxReadsPerFile(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.MaxReadsPerFile = 2
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/hot.dat").Return(Attrs{Name: "hot.dat", Mode: 0644, Size: 1024 * 1024}, nil)
+	file, _ := root.(*Dir).Lookup(nil, "hot.dat")
+
+	var mutex sync.Mutex
+	active, maxSeen := 0, 0
+	hdfsAccessor.EXPECT().OpenRead("/hot.dat").DoAndReturn(func(path string) (ReadSeekCloser, error) {
+		return &concurrencyTrackingReader{
+			MockReadSeekCloserWithPseudoRandomContent: MockReadSeekCloserWithPseudoRandomContent{FileSize: 1024 * 1024},
+			mutex:   &mutex,
+			active:  &active,
+			maxSeen: &maxSeen}, nil
+	}).Times(16)
+	var handles []*FileHandle
+	for i := 0; i < 16; i++ {
+		h, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+		assert.Nil(t, err)
+		handles = append(handles, h.(*FileHandle))
+	}
+	var wg sync.WaitGroup
+	for i, handle := range handles {
+		wg.Add(1)
+		go func(i int, handle *FileHandle) {
+			defer wg.Done()
+			resp := fuse.ReadResponse{Data: make([]byte, 0, 4096)}
+			err := handle.Read(nil, &fuse.ReadRequest{Offset: int64(i * 65536), Size: 4096}, &resp)
+			assert.Nil(t, err)
+			assert.Equal(t, 4096, len(resp.Data))
+		}(i, handle)
+	}
+	wg.Wait()
+	assert.True(t, maxSeen >= 1)
+	assert.True(t, maxSeen <= 2, "concurrent backend reads: %d", maxSeen)
+
+	// Backend reads in background (e.g. read-ahead) share the limit with the reads of FUSE requests
+	release1 := file.(*File).AcquireReadSlot()
+	release2 := file.(*File).AcquireReadSlot()
+	reader := file.(*File).LimitReads(&MockReadSeekCloserWithPseudoRandomContent{FileSize: 1024})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		n, err := reader.Read(make([]byte, 100))
+		assert.Nil(t, err)
+		assert.Equal(t, 100, n)
+	}()
+	select {
+	case <-done:
+		t.Error("read hasn't waited for a slot")
+	case <-time.After(20 * time.Millisecond):
+	}
+	release1()
+	<-done
+	release2()
+}
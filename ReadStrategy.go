@@ -0,0 +1,69 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Read strategies which can be assigned to the files based on their names
+const (
+	ReadStrategyWholeFile  = "whole-file" // entire file is read into memory on open
+	ReadStrategySequential = "sequential" // large buffer, good for sequential scans (e.g. logs)
+	ReadStrategyRandom     = "random"     // small buffer, good for random access (e.g. columnar formats)
+)
+
+// Buffer sizes used by sequential and random read strategies
+var SequentialBlockSize int = 1024 * 1024
+var RandomBlockSize int = 16 * 1024
+
+// Maps file names to read strategies (first matching rule wins)
+type ReadStrategies struct {
+	Rules []ReadStrategyRule
+}
+
+// Single rule: files with names matching the glob pattern are read using the strategy
+type ReadStrategyRule struct {
+	Pattern  string // Glob pattern matched against the file name (e.g. "*.parquet")
+	Strategy string // One of the ReadStrategy* constants
+}
+
+// Creates ReadStrategies from comma-separated list of "pattern=strategy" pairs
+func NewReadStrategies(spec string) (*ReadStrategies, error) {
+	this := &ReadStrategies{}
+	for _, entry := range strings.Split(spec, ",") {
+		if entry == "" {
+			continue
+		}
+		patternAndStrategy := strings.SplitN(entry, "=", 2)
+		if len(patternAndStrategy) != 2 {
+			return nil, errors.New(fmt.Sprintf("Invalid read strategy rule: %s", entry))
+		}
+		rule := ReadStrategyRule{Pattern: patternAndStrategy[0], Strategy: patternAndStrategy[1]}
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid pattern in read strategy rule: %s", entry))
+		}
+		if rule.Strategy != ReadStrategyWholeFile && rule.Strategy != ReadStrategySequential && rule.Strategy != ReadStrategyRandom {
+			return nil, errors.New(fmt.Sprintf("Unknown read strategy: %s", rule.Strategy))
+		}
+		Info.Println("Read strategy rule: [", rule.Pattern, "] ->", rule.Strategy)
+		this.Rules = append(this.Rules, rule)
+	}
+	return this, nil
+}
+
+// Returns read strategy for the file name, or "" if none of the rules match
+func (this *ReadStrategies) Match(name string) string {
+	if this == nil {
+		return ""
+	}
+	for _, rule := range this.Rules {
+		if matched, _ := path.Match(rule.Pattern, name); matched {
+			return rule.Strategy
+		}
+	}
+	return ""
+}
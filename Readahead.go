@@ -0,0 +1,76 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io"
+)
+
+// Default size of the window read ahead in background once sequential access is detected (see FileSystem.ReadaheadBytes)
+const DefaultReadaheadBytes = 1024 * 1024
+
+// Background read of the window following the most recent backend read.
+// Backend reader of the handle is used exclusively by the fetch until it is taken (see takeReadahead)
+type readaheadFetch struct {
+	done     chan struct{} // closed once the fetch has completed
+	fragment *FileFragment // data read ahead
+	offset   int64         // position of the backend reader after the fetch
+	err      error         // error of the fetch
+	reserved int64         // bytes of PrefetchBudget reserved by the fetched data
+}
+
+// Starts reading the next ReadaheadBytes of the file in background, so the subsequent read requests of a sequential
+// scan are served from memory. Nothing is read ahead for random access (read request not starting where the previous
+// one ended), for reads bypassing buffers, or if the read-ahead data wouldn't fit into PrefetchBudget
+func (this *FileHandleReader) startReadahead() {
+	size := this.Handle.File.FileSystem.ReadaheadBytes
+	if size <= 0 || this.readahead != nil || this.SequentialReads < 2 || this.Direct || this.WholeFile || this.HdfsReader == nil {
+		return
+	}
+	if !this.Handle.File.FileSystem.PrefetchBudget.TryAcquire(int64(size)) {
+		return
+	}
+	fetch := &readaheadFetch{done: make(chan struct{}), fragment: &FileFragment{}, offset: this.Offset, reserved: int64(size)}
+	this.readahead = fetch
+	reader := this.HdfsReader
+	go func() {
+		fetch.err = fetch.fragment.ReadFromBackend(reader, &fetch.offset, size, size)
+		close(fetch.done)
+	}()
+}
+
+// Waits for the background read-ahead (if any) and makes its data the most recent fragment (Buffer1).
+// Returns false if there was no read-ahead or it hasn't read any data, and the error of the read-ahead
+// (reading ahead doesn't continue past EOF or a failure)
+func (this *FileHandleReader) takeReadahead() (bool, error) {
+	fetch := this.readahead
+	if fetch == nil {
+		return false, nil
+	}
+	<-fetch.done
+	this.readahead = nil
+	this.Offset = fetch.offset
+	// Read-ahead data replaces the data of the previous backend read, taking over its share of the prefetch budget
+	this.Handle.File.FileSystem.PrefetchBudget.Release(this.readaheadBytes)
+	this.readaheadBytes = fetch.reserved
+	if fetch.err != nil && fetch.err != io.EOF {
+		Warning.Println("[", this.Handle.File.AbsolutePath(), "] Read-ahead @", fetch.fragment.Offset, ":", fetch.err)
+	}
+	if len(fetch.fragment.Data) == 0 {
+		return false, fetch.err
+	}
+	this.Buffer2, this.Buffer1 = this.Buffer1, fetch.fragment
+	return true, fetch.err
+}
+
+// Waits for the background read-ahead (if any) and discards its data, so the backend reader can be used elsewhere
+func (this *FileHandleReader) DiscardReadahead() {
+	fetch := this.readahead
+	if fetch == nil {
+		return
+	}
+	<-fetch.done
+	this.readahead = nil
+	this.Offset = fetch.offset
+	this.Handle.File.FileSystem.PrefetchBudget.Release(fetch.reserved)
+}
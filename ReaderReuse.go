@@ -0,0 +1,71 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"time"
+)
+
+// Backend reader of the recently released handle, kept open so quick reopen of the file doesn't reconnect
+type IdleReader struct {
+	Reader  ReadSeekCloser // Backend reader
+	Size    uint64         // Size of the file when the reader was released
+	Mtime   time.Time      // Modification time of the file when the reader was released
+	Expires time.Time      // Time after which the reader is closed instead of being reused
+}
+
+// Keeps backend reader of the released handle open for FileSystem.ReaderReuseTTL. Expired reader is closed
+// lazily, on the next open of the file or once the kernel forgets the file (see TakeIdleReader and DropIdleReader).
+// Returns false if reuse is disabled, in which case the caller is responsible for closing the reader
+func (this *File) ParkReader(reader ReadSeekCloser) bool {
+	ttl := this.FileSystem.ReaderReuseTTL
+	if ttl <= 0 {
+		return false
+	}
+	idle := &IdleReader{
+		Reader:  reader,
+		Size:    this.Attrs.Size,
+		Mtime:   this.Attrs.Mtime,
+		Expires: this.FileSystem.Clock.Now().Add(ttl)}
+	this.prefetchMutex.Lock()
+	previous := this.idleReader
+	this.idleReader = idle
+	this.prefetchMutex.Unlock()
+	if previous != nil {
+		previous.Reader.Close()
+	}
+	return true
+}
+
+// Returns backend reader kept open after the file was recently closed, repositioned to offset 0.
+// Returns nil if there is no such reader, or it has expired, or the file has changed since then
+func (this *File) TakeIdleReader() ReadSeekCloser {
+	this.prefetchMutex.Lock()
+	idle := this.idleReader
+	this.idleReader = nil
+	this.prefetchMutex.Unlock()
+	if idle == nil {
+		return nil
+	}
+	if this.FileSystem.Clock.Now().Before(idle.Expires) && idle.Size == this.Attrs.Size && idle.Mtime.Equal(this.Attrs.Mtime) {
+		if err := idle.Reader.Seek(0); err == nil {
+			Info.Println("[", this.AbsolutePath(), "] Reusing backend reader")
+			return idle.Reader
+		} else {
+			Warning.Println("[", this.AbsolutePath(), "] Can't rewind backend reader for reuse:", err)
+		}
+	}
+	idle.Reader.Close()
+	return nil
+}
+
+// Closes backend reader kept open after the file was recently closed (if any)
+func (this *File) DropIdleReader() {
+	this.prefetchMutex.Lock()
+	idle := this.idleReader
+	this.idleReader = nil
+	this.prefetchMutex.Unlock()
+	if idle != nil {
+		idle.Reader.Close()
+	}
+}
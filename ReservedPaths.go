@@ -0,0 +1,47 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"strings"
+)
+
+// HDFS reserved paths, which aren't listed but can be accessed by name
+const (
+	ReservedDir = "/.reserved"     // root of the reserved paths (e.g. /.reserved/.inodes/<id>)
+	RawDir      = "/.reserved/raw" // raw view of the namespace: content of encrypted files is returned as stored, without decryption
+)
+
+// Access to the reserved paths via the mount (see FileSystem.ReservedPaths)
+const (
+	ReservedPathsAll  = "all"  // reserved paths are passed to HDFS as any other path
+	ReservedPathsRaw  = "raw"  // only /.reserved/raw is accessible and it is read-only
+	ReservedPathsNone = "none" // reserved paths don't exist (ENOENT)
+)
+
+// Returns true if the path is /.reserved or a path under it
+func IsReservedPath(path string) bool {
+	return path == ReservedDir || strings.HasPrefix(path, ReservedDir+"/")
+}
+
+// Returns true if the path is /.reserved/raw or a path under it
+func IsRawPath(path string) bool {
+	return path == RawDir || strings.HasPrefix(path, RawDir+"/")
+}
+
+// Returns true if the reserved path can be accessed according to ReservedPaths
+func (this *FileSystem) IsReservedPathAllowed(path string) bool {
+	switch this.ReservedPaths {
+	case ReservedPathsNone:
+		return false
+	case ReservedPathsRaw:
+		return path == ReservedDir || IsRawPath(path)
+	}
+	return true
+}
+
+// Returns true if content of the file is served exactly as stored in HDFS, bypassing transformations
+// such as transcoding (raw bytes of encrypted files read by admin tools must stay intact)
+func (this *File) IsRaw() bool {
+	return IsRawPath(this.AbsolutePath())
+}
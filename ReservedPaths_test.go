@@ -0,0 +1,60 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// Files under /.reserved/raw are read byte-exact (as stored in HDFS), the raw view is read-only
+func TestReadRawReservedPath(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.ReservedPaths = ReservedPathsRaw
+	fileSystem.Transcoding, _ = NewTranscoding("latin1", "*.csv")
+	root, _ := fileSystem.Root()
+
+	hdfsAccessor.EXPECT().Stat("/.reserved").Return(Attrs{Name: ".reserved", Mode: 0755 | os.ModeDir}, nil)
+	reserved, err := root.(*Dir).Lookup(nil, ".reserved")
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().Stat("/.reserved/raw").Return(Attrs{Name: "raw", Mode: 0755 | os.ModeDir}, nil)
+	raw, err := reserved.(*Dir).Lookup(nil, "raw")
+	assert.Nil(t, err)
+	var attr fuse.Attr
+	assert.Nil(t, raw.Attr(nil, &attr))
+	assert.True(t, attr.Mode.IsDir())
+
+	// Encrypted bytes come back as stored, not transcoded nor otherwise altered
+	encrypted := []byte{0xe9, 0x00, 0xff, 0x80, 0x1b}
+	hdfsAccessor.EXPECT().Stat("/.reserved/raw/data.csv").Return(Attrs{Name: "data.csv", Mode: 0644, Size: uint64(len(encrypted))}, nil)
+	file, err := raw.(*Dir).Lookup(nil, "data.csv")
+	assert.Nil(t, err)
+	assert.Nil(t, file.Attr(nil, &attr))
+	assert.True(t, attr.Mode.IsRegular())
+	assert.Equal(t, uint64(len(encrypted)), attr.Size)
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/.reserved/raw/data.csv").Return(hdfsReader, nil)
+	h, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+	assert.Nil(t, err)
+	hdfsReader.whenReadReturn(encrypted, nil)
+	h.(*FileHandle).readAndVerify(t, 0, len(encrypted), encrypted)
+	hdfsReader.EXPECT().Close().Return(nil)
+	h.(*FileHandle).Release(nil, nil)
+
+	// Writes into raw view are rejected, other reserved paths aren't accessible
+	_, _, err = raw.(*Dir).Create(nil, &fuse.CreateRequest{Name: "new.csv", Mode: 0644}, &fuse.CreateResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EROFS), err)
+	_, err = reserved.(*Dir).Lookup(nil, ".inodes")
+	assert.Equal(t, fuse.ENOENT, err)
+	fileSystem.ReservedPaths = ReservedPathsNone
+	_, err = root.(*Dir).Lookup(nil, ".reserved")
+	assert.Equal(t, fuse.ENOENT, err)
+}
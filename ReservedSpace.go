@@ -0,0 +1,29 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+// Returns space of the data nodes used by other than HDFS data (the part of the capacity
+// which is neither used by HDFS nor remaining for it, as reported by the name node)
+func (this FsInfo) NonDfsUsed() uint64 {
+	if this.capacity <= this.used+this.remaining {
+		return 0
+	}
+	return this.capacity - this.used - this.remaining
+}
+
+// Returns capacity and free space available for HDFS data, in bytes. With ExcludeReservedSpace,
+// capacity excludes space used by non-HDFS data, and available space excludes ReservedSpace
+// (dfs.datanode.du.reserved of all the data nodes), which HDFS can't fill up
+func (this *FileSystem) AvailableSpace(fsInfo FsInfo) (capacity uint64, free uint64, available uint64) {
+	if !this.ExcludeReservedSpace {
+		return fsInfo.capacity, fsInfo.remaining, fsInfo.remaining
+	}
+	capacity = fsInfo.capacity - fsInfo.NonDfsUsed()
+	available = fsInfo.remaining
+	if available > this.ReservedSpace {
+		available -= this.ReservedSpace
+	} else {
+		available = 0
+	}
+	return capacity, fsInfo.remaining, available
+}
@@ -17,6 +17,9 @@ type RetryPolicy struct {
 	MaxDelay        time.Duration // maximum delay between retries
 	RandomizeDelays bool          // true to randomize delays between retires
 	ExpBackoffBase  float64       // base for the exponent function to compute delays between attempts
+
+	CongestionMinDelay time.Duration // minimum delay before retrying operation rejected by overloaded name node (0 to retry it as any other failure)
+	CongestionMaxDelay time.Duration // maximum delay before retrying operation rejected by overloaded name node
 }
 
 type Op struct {
@@ -24,6 +27,8 @@ type Op struct {
 	Attempt     int           // 1-based index of current attemmpt
 	Expires     time.Time     // point in time after which no retries are allowed
 	Delay       time.Duration // last delay (exponentially grows)
+
+	CongestionDelay time.Duration // last delay after name node congestion (grows separately from Delay)
 }
 
 // Creates trivial retry policy which disallows all retries
@@ -44,7 +49,10 @@ func NewDefaultRetryPolicy(clock Clock) *RetryPolicy {
 		MinDelay:        1 * time.Second,
 		MaxDelay:        1 * time.Minute,
 		RandomizeDelays: true,
-		ExpBackoffBase:  1.618}
+		ExpBackoffBase:  1.618,
+
+		CongestionMinDelay: 5 * time.Second,
+		CongestionMaxDelay: 2 * time.Minute}
 }
 
 // Starts a new operation (a retry context) and returns data structure to track operation retires
@@ -70,6 +78,9 @@ func (op *Op) ShouldRetry(message string, args ...interface{}) bool {
 		Error.Printf(fmt.Sprintf("%s -> failed attempt #%d: will NOT be retried (%s)", message, op.Attempt, diag), args...)
 		return false
 	}
+	if op.RetryPolicy.CongestionMinDelay > 0 && hasCongestionError(args) {
+		return op.backOffCongestion(message, args...)
+	}
 	// Computing delay (exponential backoff)
 	if op.Attempt == 2 {
 		op.Delay = op.RetryPolicy.MinDelay
@@ -95,3 +106,37 @@ func (op *Op) ShouldRetry(message string, args ...interface{}) bool {
 	// Allowing to retry
 	return true
 }
+
+// Returns true if any of the arguments is an error signalling name node overload
+func hasCongestionError(args []interface{}) bool {
+	for _, arg := range args {
+		if err, ok := arg.(error); ok && IsCongestionError(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sleeps before retrying operation rejected by overloaded name node. Delays start from CongestionMinDelay
+// (the retry is never immediate) and grow exponentially up to CongestionMaxDelay, independently of other failures
+func (op *Op) backOffCongestion(message string, args ...interface{}) bool {
+	policy := op.RetryPolicy
+	if op.CongestionDelay == 0 {
+		op.CongestionDelay = policy.CongestionMinDelay
+	} else {
+		op.CongestionDelay = time.Duration(float64(op.CongestionDelay) * policy.ExpBackoffBase)
+	}
+	if op.CongestionDelay > policy.CongestionMaxDelay && policy.CongestionMaxDelay >= policy.CongestionMinDelay {
+		op.CongestionDelay = policy.CongestionMaxDelay
+	}
+
+	effectiveDelay := op.CongestionDelay
+	if policy.RandomizeDelays && op.CongestionDelay > policy.CongestionMinDelay {
+		effectiveDelay = policy.CongestionMinDelay + time.Duration(float64(op.CongestionDelay-policy.CongestionMinDelay)*rand.Float64())
+	}
+
+	Warning.Printf(fmt.Sprintf("%s -> failed attempt #%d: name node is congested, backing off for %s", message, op.Attempt, effectiveDelay), args...)
+	op.Attempt++
+	<-policy.Clock.After(effectiveDelay)
+	return true
+}
@@ -0,0 +1,43 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Delay between attempts of the operation rejected by the name node in safe mode (see FileSystem.WaitSafeMode)
+var SafeModePollInterval time.Duration = 5 * time.Second
+
+// Returns true if the error indicates that the name node is in safe mode, so the namespace is read-only
+// (SafeModeException)
+func IsSafeModeError(err error) bool {
+	if err == nil {
+		return false
+	}
+	message := err.Error()
+	return strings.Contains(message, "SafeModeException") || strings.Contains(message, "in safe mode")
+}
+
+// Performs operation modifying the namespace (mkdir, create, remove, rename, chmod, ...).
+// If the name node rejects it being in safe mode, the operation is re-attempted until safe mode is left,
+// for at most WaitSafeMode, then EROFS is reported to the client
+func (this *FileSystem) RunMutating(name string, path string, op func() error) error {
+	err := op()
+	if !IsSafeModeError(err) {
+		return err
+	}
+	attempts := int(this.WaitSafeMode / SafeModePollInterval)
+	for attempt := 0; attempt < attempts; attempt++ {
+		Warning.Println(name, "[", path, "] rejected since name node is in safe mode, waiting")
+		<-this.Clock.After(SafeModePollInterval)
+		if err = op(); !IsSafeModeError(err) {
+			return err
+		}
+	}
+	Error.Println(name, "[", path, "] failed since name node is in safe mode:", err)
+	return fuse.Errno(syscall.EROFS)
+}
@@ -0,0 +1,136 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"bytes"
+	"fmt"
+	"golang.org/x/net/context"
+	"os"
+	"path"
+	"syscall"
+)
+
+// Name of the virtual directory exposing diffs between snapshots of the directory (with ExposeSnapshotDiff enabled)
+const SnapshotDiffName = ".snapshotdiff"
+
+// Name of the HDFS directory holding snapshots of the snapshottable directory
+const SnapshotDirName = ".snapshot"
+
+// Virtual read-only directory listing snapshots of the parent directory. At the top level
+// (From is empty) its entries are the 'from' snapshots, at the second level - the 'to' snapshots,
+// which are virtual files listing changes between the two snapshots (e.g. .snapshotdiff/s1/s2)
+type SnapshotDiffDir struct {
+	Dir  *Dir   // Snapshottable directory
+	From string // Snapshot to compare from ("" at the top level)
+}
+
+// Verify that *SnapshotDiffDir implements necesary FUSE interfaces
+var _ fs.Node = (*SnapshotDiffDir)(nil)
+var _ fs.NodeStringLookuper = (*SnapshotDiffDir)(nil)
+var _ fs.HandleReadDirAller = (*SnapshotDiffDir)(nil)
+
+// Responds on FUSE Attr request to retrieve directory attributes
+func (this *SnapshotDiffDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeDir | 0555
+	a.Uid = this.Dir.Attrs.Uid
+	a.Gid = this.Dir.Attrs.Gid
+	a.Mtime = this.Dir.FileSystem.Clock.Now()
+	return nil
+}
+
+// Returns HDFS path of the directory holding snapshots of the snapshottable directory
+func (this *SnapshotDiffDir) SnapshotsPath() string {
+	return path.Join(this.Dir.AbsolutePath(), SnapshotDirName)
+}
+
+// Responds on FUSE Lookup request, snapshot which doesn't exist is reported as ENOENT
+func (this *SnapshotDiffDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	snapshotPath := path.Join(this.SnapshotsPath(), name)
+	if _, err := this.Dir.FileSystem.HdfsAccessor.Stat(snapshotPath); err != nil {
+		if pathError, ok := err.(*os.PathError); ok && pathError.Err == os.ErrNotExist {
+			return nil, fuse.ENOENT
+		}
+		Warning.Println("[", snapshotPath, "] Stat:", err)
+		return nil, err
+	}
+	if this.From == "" {
+		return &SnapshotDiffDir{Dir: this.Dir, From: name}, nil
+	}
+	return &SnapshotDiffFile{Dir: this.Dir, From: this.From, To: name}, nil
+}
+
+// Responds on FUSE ReadDirAll request by listing snapshots of the directory
+func (this *SnapshotDiffDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	snapshotsPath := this.SnapshotsPath()
+	snapshots, err := this.Dir.FileSystem.HdfsAccessor.ReadDir(snapshotsPath)
+	if err != nil {
+		Warning.Println("[", snapshotsPath, "] ReadDir:", err)
+		return nil, err
+	}
+	entries := make([]fuse.Dirent, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		if snapshot.Name == this.From {
+			continue
+		}
+		entryType := fuse.DT_Dir
+		if this.From != "" {
+			entryType = fuse.DT_File
+		}
+		entries = append(entries, fuse.Dirent{Name: snapshot.Name, Type: entryType})
+	}
+	return entries, nil
+}
+
+// Virtual read-only file whose content lists changes of the directory between two snapshots
+type SnapshotDiffFile struct {
+	Dir  *Dir   // Snapshottable directory
+	From string // Snapshot to compare from
+	To   string // Snapshot to compare to
+}
+
+// Verify that *SnapshotDiffFile implements necesary FUSE interfaces
+var _ fs.Node = (*SnapshotDiffFile)(nil)
+var _ fs.NodeOpener = (*SnapshotDiffFile)(nil)
+var _ fs.HandleReadAller = (*SnapshotDiffFile)(nil)
+
+// Responds on FUSE Attr request to retrieve file attributes.
+// Size is reported as zero since the content is generated on open (as in procfs)
+func (this *SnapshotDiffFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = 0444
+	a.Uid = this.Dir.Attrs.Uid
+	a.Gid = this.Dir.Attrs.Gid
+	a.Mtime = this.Dir.FileSystem.Clock.Now()
+	return nil
+}
+
+// Responds on FUSE Open request, content is served bypassing the page cache
+func (this *SnapshotDiffFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if !req.Flags.IsReadOnly() {
+		return nil, fuse.Errno(syscall.EACCES)
+	}
+	resp.Flags |= fuse.OpenDirectIO
+	return this, nil
+}
+
+// Responds on FUSE Read request by formatting changes between the snapshots as "TYPE\tPATH" lines
+// ("R\tOLDPATH -> NEWPATH" for renames)
+func (this *SnapshotDiffFile) ReadAll(ctx context.Context) ([]byte, error) {
+	absolutePath := this.Dir.AbsolutePath()
+	diff, err := this.Dir.FileSystem.HdfsAccessor.SnapshotDiff(absolutePath, this.From, this.To)
+	if err != nil {
+		Warning.Println("[", absolutePath, "] SnapshotDiff", this.From, this.To, ":", err)
+		return nil, err
+	}
+	var content bytes.Buffer
+	for _, entry := range diff {
+		if entry.TargetPath != "" {
+			fmt.Fprintf(&content, "%s\t%s -> %s\n", entry.Type, entry.Path, entry.TargetPath)
+		} else {
+			fmt.Fprintf(&content, "%s\t%s\n", entry.Type, entry.Path)
+		}
+	}
+	return content.Bytes(), nil
+}
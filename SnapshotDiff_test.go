@@ -0,0 +1,73 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// Reading .snapshotdiff/FROM/TO lists changes reported by HDFS between the two snapshots
+func TestSnapshotDiff(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.ExposeSnapshotDiff = true
+	root, _ := fileSystem.Root()
+	hdfsAccessor.EXPECT().Stat("/data").Return(Attrs{Name: "data", Mode: os.ModeDir | 0755}, nil)
+	data, _ := root.(*Dir).Lookup(nil, "data")
+
+	diffNode, err := data.(*Dir).Lookup(nil, SnapshotDiffName)
+	assert.Nil(t, err)
+	diffDir := diffNode.(*SnapshotDiffDir)
+
+	// Snapshots are listed as 'from' directories
+	hdfsAccessor.EXPECT().ReadDir("/data/.snapshot").Return([]Attrs{
+		Attrs{Name: "s1", Mode: os.ModeDir | 0755},
+		Attrs{Name: "s2", Mode: os.ModeDir | 0755}}, nil)
+	dirents, err := diffDir.ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []fuse.Dirent{{Name: "s1", Type: fuse.DT_Dir}, {Name: "s2", Type: fuse.DT_Dir}}, dirents)
+
+	hdfsAccessor.EXPECT().Stat("/data/.snapshot/s1").Return(Attrs{Name: "s1", Mode: os.ModeDir | 0755}, nil)
+	fromNode, err := diffDir.Lookup(nil, "s1")
+	assert.Nil(t, err)
+
+	// 'to' snapshots exclude the 'from' one
+	hdfsAccessor.EXPECT().ReadDir("/data/.snapshot").Return([]Attrs{
+		Attrs{Name: "s1", Mode: os.ModeDir | 0755},
+		Attrs{Name: "s2", Mode: os.ModeDir | 0755}}, nil)
+	dirents, err = fromNode.(*SnapshotDiffDir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []fuse.Dirent{{Name: "s2", Type: fuse.DT_File}}, dirents)
+
+	hdfsAccessor.EXPECT().Stat("/data/.snapshot/s2").Return(Attrs{Name: "s2", Mode: os.ModeDir | 0755}, nil)
+	diffFileNode, err := fromNode.(*SnapshotDiffDir).Lookup(nil, "s2")
+	assert.Nil(t, err)
+	diffFile := diffFileNode.(*SnapshotDiffFile)
+
+	hdfsAccessor.EXPECT().SnapshotDiff("/data", "s1", "s2").Return([]DiffEntry{
+		DiffEntry{Type: "M", Path: "."},
+		DiffEntry{Type: "+", Path: "new.txt"},
+		DiffEntry{Type: "-", Path: "old.txt"},
+		DiffEntry{Type: "R", Path: "a.txt", TargetPath: "b.txt"}}, nil)
+	h, err := diffFile.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	content, err := h.(*SnapshotDiffFile).ReadAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "M\t.\n+\tnew.txt\n-\told.txt\nR\ta.txt -> b.txt\n", string(content))
+
+	// Diff file can't be written
+	_, err = diffFile.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly}, &fuse.OpenResponse{})
+	assert.NotNil(t, err)
+
+	// Snapshot which doesn't exist is reported as ENOENT
+	hdfsAccessor.EXPECT().Stat("/data/.snapshot/s3").Return(Attrs{}, &os.PathError{Op: "stat", Path: "/data/.snapshot/s3", Err: os.ErrNotExist})
+	_, err = fromNode.(*SnapshotDiffDir).Lookup(nil, "s3")
+	assert.Equal(t, fuse.ENOENT, err)
+}
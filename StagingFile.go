@@ -0,0 +1,154 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Storage used to buffer contents of the file being written before it's flushed to HDFS
+type StagingFile interface {
+	io.Reader
+	io.Writer
+	io.Seeker
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+	Name() string         // Name of the staging file (for logging)
+	Size() (int64, error) // Current size of the staged content
+	Truncate(int64) error // Changes size of the staged content (extending it with zeroes if needed)
+}
+
+// Staging file residing in the local staging directory
+type DiskStagingFile struct {
+	*os.File
+}
+
+var _ StagingFile = (*DiskStagingFile)(nil) // ensure DiskStagingFile implements StagingFile
+
+// Creates staging file in the given directory. The file is unlinked immediately,
+// so it disappears once closed
+func NewDiskStagingFile(stagingDir string) (*DiskStagingFile, error) {
+	file, err := ioutil.TempFile(stagingDir, "stage")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(file.Name()) //TODO: handle error
+	return &DiskStagingFile{File: file}, nil
+}
+
+// Returns current size of the staging file
+func (this *DiskStagingFile) Size() (int64, error) {
+	info, err := this.File.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Staging file kept in memory (used when the staging directory is unavailable)
+type MemoryStagingFile struct {
+	data   []byte
+	offset int64
+}
+
+var _ StagingFile = (*MemoryStagingFile)(nil) // ensure MemoryStagingFile implements StagingFile
+
+// Reads from the current offset
+func (this *MemoryStagingFile) Read(p []byte) (int, error) {
+	n, err := this.ReadAt(p, this.offset)
+	this.offset += int64(n)
+	if n > 0 && err == io.EOF {
+		// Same as os.File, EOF is reported by the next read (callers stop reading on first error)
+		err = nil
+	}
+	return n, err
+}
+
+// Writes at the current offset
+func (this *MemoryStagingFile) Write(p []byte) (int, error) {
+	n, err := this.WriteAt(p, this.offset)
+	this.offset += int64(n)
+	return n, err
+}
+
+// Reads at the given offset
+func (this *MemoryStagingFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= int64(len(this.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, this.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Writes at the given offset, extending the buffer (with zeroes) if necessary
+func (this *MemoryStagingFile) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	end := off + int64(len(p))
+	if end > int64(len(this.data)) {
+		if end > int64(cap(this.data)) {
+			newData := make([]byte, end, 2*end)
+			copy(newData, this.data)
+			this.data = newData
+		} else {
+			this.data = this.data[:end]
+		}
+	}
+	return copy(this.data[off:], p), nil
+}
+
+// Changes the current offset
+func (this *MemoryStagingFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += this.offset
+	case io.SeekEnd:
+		offset += int64(len(this.data))
+	}
+	if offset < 0 {
+		return this.offset, errors.New("negative offset")
+	}
+	this.offset = offset
+	return offset, nil
+}
+
+// Releases the buffer
+func (this *MemoryStagingFile) Close() error {
+	this.data = nil
+	this.offset = 0
+	return nil
+}
+
+// Returns name of the staging file (for logging)
+func (this *MemoryStagingFile) Name() string {
+	return "<memory>"
+}
+
+// Returns current size of the staged content
+func (this *MemoryStagingFile) Size() (int64, error) {
+	return int64(len(this.data)), nil
+}
+
+// Changes size of the staged content, extending it with zeroes if needed
+func (this *MemoryStagingFile) Truncate(size int64) error {
+	if size < 0 {
+		return errors.New("negative size")
+	}
+	if size > int64(len(this.data)) {
+		_, err := this.WriteAt(make([]byte, size-int64(len(this.data))), int64(len(this.data)))
+		return err
+	}
+	this.data = this.data[:size]
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"golang.org/x/net/context"
+	"sync"
+	"syscall"
+)
+
+// Streams data to HDFS writer in the background, bounding amount of the data which has been
+// accepted from the client but not yet written to the HDFS pipeline. Once the bound is hit,
+// Write blocks until the pipeline drains (applying backpressure to the client)
+type StreamingWriter struct {
+	Writer         HdfsWriter // Backend writer
+	MaxOutstanding int64      // Maximum number of bytes accepted, but not yet written to the backend
+
+	mutex       sync.Mutex
+	outstanding int64         // number of bytes accepted, but not yet written to the backend
+	err         error         // first error returned by the backend writer
+	queue       chan []byte   // chunks of data to be written to the backend
+	drained     chan struct{} // signaled each time a chunk is written to the backend
+	done        chan struct{} // closed once background goroutine exits
+}
+
+// Creates StreamingWriter and starts background goroutine writing to the backend
+func NewStreamingWriter(writer HdfsWriter, maxOutstanding int64) *StreamingWriter {
+	this := &StreamingWriter{
+		Writer:         writer,
+		MaxOutstanding: maxOutstanding,
+		queue:          make(chan []byte, 1024),
+		drained:        make(chan struct{}, 1),
+		done:           make(chan struct{})}
+	go this.run()
+	return this
+}
+
+// Writes queued chunks to the backend
+func (this *StreamingWriter) run() {
+	defer close(this.done)
+	for data := range this.queue {
+		this.mutex.Lock()
+		failed := this.err != nil
+		this.mutex.Unlock()
+		var err error
+		if !failed {
+			_, err = this.Writer.Write(data)
+		}
+		this.mutex.Lock()
+		this.outstanding -= int64(len(data))
+		if err != nil && this.err == nil {
+			Error.Println("Streaming write failed:", err)
+			this.err = err
+		}
+		this.mutex.Unlock()
+		select {
+		case this.drained <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Queues the data to be written to the backend. Blocks while the bound of outstanding bytes is hit,
+// returns EINTR if the request is cancelled while waiting
+func (this *StreamingWriter) Write(ctx context.Context, data []byte) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	chunk := make([]byte, len(data))
+	copy(chunk, data)
+	for {
+		this.mutex.Lock()
+		if this.err != nil {
+			this.mutex.Unlock()
+			return this.err
+		}
+		// Chunk larger than the bound is accepted once the pipeline is drained completely
+		if this.outstanding == 0 || this.outstanding+int64(len(chunk)) <= this.MaxOutstanding {
+			this.outstanding += int64(len(chunk))
+			this.mutex.Unlock()
+			this.queue <- chunk
+			return nil
+		}
+		this.mutex.Unlock()
+		select {
+		case <-this.drained:
+		case <-ctx.Done():
+			return fuse.Errno(syscall.EINTR)
+		}
+	}
+}
+
+// Returns number of bytes accepted, but not yet written to the backend
+func (this *StreamingWriter) Outstanding() int64 {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.outstanding
+}
+
+// Waits until all the accepted data is written to the backend
+func (this *StreamingWriter) Drain() error {
+	for {
+		this.mutex.Lock()
+		outstanding, err := this.outstanding, this.err
+		this.mutex.Unlock()
+		if outstanding == 0 || err != nil {
+			return err
+		}
+		<-this.drained
+	}
+}
+
+// Writes all the accepted data and closes the backend writer
+func (this *StreamingWriter) Close() error {
+	close(this.queue)
+	<-this.done
+	err := this.Writer.Close()
+	if this.err != nil {
+		return this.err
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// HdfsWriter which accepts each chunk only when allowed to proceed (emulates slow HDFS pipeline)
+type slowHdfsWriter struct {
+	proceed chan struct{}
+	written []byte
+}
+
+func (this *slowHdfsWriter) Write(buffer []byte) (int, error) {
+	<-this.proceed
+	this.written = append(this.written, buffer...)
+	return len(buffer), nil
+}
+
+func (this *slowHdfsWriter) Seek(pos int64) error { return errors.New("not supported") }
+func (this *slowHdfsWriter) Flush() error         { return nil }
+func (this *slowHdfsWriter) Truncate() error      { return errors.New("not supported") }
+func (this *slowHdfsWriter) Close() error         { return nil }
+
+// Write blocks once outstanding bytes hit the bound, until the pipeline accepts more data
+func TestStreamingWriteBackpressure(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	backend := &slowHdfsWriter{proceed: make(chan struct{})}
+	stream := NewStreamingWriter(backend, 10)
+
+	// Below the bound writes are accepted immediately
+	assert.Nil(t, stream.Write(nil, []byte("hello ")))
+	assert.Equal(t, int64(6), stream.Outstanding())
+
+	// Exceeding the bound blocks the write
+	writeDone := make(chan error)
+	go func() {
+		writeDone <- stream.Write(nil, []byte("world!"))
+	}()
+	select {
+	case <-writeDone:
+		t.Fatal("Write wasn't blocked by backpressure")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.True(t, stream.Outstanding() <= 10)
+
+	// Once the pipeline accepts the first chunk, blocked write proceeds
+	backend.proceed <- struct{}{}
+	assert.Nil(t, <-writeDone)
+	assert.True(t, stream.Outstanding() <= 10)
+
+	// Blocked write is interrupted by cancellation of the request
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, fuse.Errno(syscall.EINTR), stream.Write(ctx, []byte("more data")))
+
+	backend.proceed <- struct{}{}
+	assert.Nil(t, stream.Drain())
+	assert.Equal(t, int64(0), stream.Outstanding())
+	assert.Nil(t, stream.Close())
+	assert.Equal(t, "hello world!", string(backend.written))
+}
@@ -0,0 +1,116 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// Allowed symlink operations (see FileSystem.AllowSymlinks)
+const (
+	SymlinksCreate = "create" // existing symlinks can be read and new ones created
+	SymlinksRead   = "read"   // existing symlinks can be read, creating new ones fails with EPERM (default)
+	SymlinksNone   = "none"   // both reading and creating symlinks fail with EPERM
+)
+
+// Maximum number of symlinks traversed while resolving a path, before resolution fails with ELOOP (as on Linux)
+var DefaultMaxSymlinkHops int = 40
+
+// Verify that symlinks can be created and read
+var _ fs.NodeSymlinker = (*Dir)(nil)
+var _ fs.NodeReadlinker = (*File)(nil)
+
+// Responds on FUSE Symlink request
+func (this *Dir) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (fs.Node, error) {
+	req.NewName = this.FileSystem.HdfsName(req.NewName)
+	path := this.AbsolutePathForChild(req.NewName)
+	if this.FileSystem.AllowSymlinks != SymlinksCreate {
+		Warning.Println("[", path, "] Symlink creation isn't allowed")
+		return nil, fuse.Errno(syscall.EPERM)
+	}
+	if err := this.FileSystem.CheckOpEnabled("symlink", path); err != nil {
+		return nil, err
+	}
+	if err := this.CheckNameLength(req.NewName); err != nil {
+		return nil, err
+	}
+	if _, err := this.FileSystem.ResolveSymlinks(path, req.Target); err != nil {
+		Warning.Println("[", path, "] Symlink to", req.Target, ":", err)
+		return nil, err
+	}
+	err := this.FileSystem.RunMutating("CreateSymlink", path, func() error {
+		return this.FileSystem.HdfsAccessor.CreateSymlink(req.Target, path)
+	})
+	if err != nil {
+		Warning.Println("[", path, "] CreateSymlink:", err)
+		if IsUnsupportedOperationError(err) {
+			// Symlinks are disabled on the cluster (they are unless dfs.symlinks are enabled on the name node)
+			return nil, fuse.Errno(syscall.ENOTSUP)
+		}
+		return nil, err
+	}
+	this.FileSystem.Audit(req.Header, "symlink", path, req.Target)
+	return this.NodeFromAttrs(Attrs{Name: req.NewName, Mode: os.ModeSymlink | 0777, Target: req.Target}), nil
+}
+
+// Returns true if the error indicates that the name node doesn't support the operation (UnsupportedOperationException)
+func IsUnsupportedOperationError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UnsupportedOperationException")
+}
+
+// Responds on FUSE Readlink request
+func (this *File) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
+	// Refreshing expired attributes
+	if err := this.Attr(ctx, &fuse.Attr{}); err != nil {
+		return "", err
+	}
+	if this.Attrs.Mode&os.ModeSymlink == 0 {
+		return "", fuse.Errno(syscall.EINVAL)
+	}
+	if this.FileSystem.AllowSymlinks == SymlinksNone {
+		Warning.Println("[", this.AbsolutePath(), "] Reading symlinks isn't allowed")
+		return "", fuse.Errno(syscall.EPERM)
+	}
+	return this.Attrs.Target, nil
+}
+
+// Resolves the chain of symlinks starting with the link at the given path pointing to the target,
+// returns path of the final target (which may not exist). Fails with ELOOP if the chain has more than
+// MaxSymlinkHops links or leads back to the first link (0 disables resolution, target is returned as is)
+func (this *FileSystem) ResolveSymlinks(link string, target string) (string, error) {
+	if this.MaxSymlinkHops <= 0 {
+		return target, nil
+	}
+	first := path.Clean(link)
+	for hops := 1; ; hops++ {
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(link), target)
+		}
+		if path.Clean(target) == first {
+			Warning.Println("[", first, "] Symbolic link loop")
+			return "", fuse.Errno(syscall.ELOOP)
+		}
+		attrs, err := this.HdfsAccessor.Stat(target)
+		if err != nil {
+			if pathError, ok := err.(*os.PathError); ok && pathError.Err == os.ErrNotExist {
+				// Dangling link
+				return target, nil
+			}
+			return "", err
+		}
+		if attrs.Mode&os.ModeSymlink == 0 {
+			return target, nil
+		}
+		if hops >= this.MaxSymlinkHops {
+			Warning.Println("[", first, "] Too many levels of symbolic links (more than", this.MaxSymlinkHops, ")")
+			return "", fuse.Errno(syscall.ELOOP)
+		}
+		link, target = target, attrs.Target
+	}
+}
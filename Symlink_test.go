@@ -0,0 +1,98 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"errors"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// With 'read' setting, existing symlinks can be read while creating new ones fails with EPERM
+func TestSymlinkRestrictions(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.AllowSymlinks = SymlinksRead
+	root, _ := fs.Root()
+
+	_, err := root.(*Dir).Symlink(nil, &fuse.SymlinkRequest{NewName: "new", Target: "/etc/passwd"})
+	assert.Equal(t, fuse.Errno(syscall.EPERM), err)
+
+	hdfsAccessor.EXPECT().Stat("/link").Return(Attrs{Name: "link", Mode: os.ModeSymlink | 0777, Target: "/data/file"}, nil)
+	link, err := root.(*Dir).Lookup(nil, "link")
+	assert.Nil(t, err)
+	var attr fuse.Attr
+	assert.Nil(t, link.Attr(nil, &attr))
+	assert.Equal(t, os.ModeSymlink, attr.Mode&os.ModeSymlink)
+	target, err := link.(*File).Readlink(nil, &fuse.ReadlinkRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, "/data/file", target)
+
+	// 'none' forbids reading as well
+	fs.AllowSymlinks = SymlinksNone
+	_, err = link.(*File).Readlink(nil, &fuse.ReadlinkRequest{})
+	assert.Equal(t, fuse.Errno(syscall.EPERM), err)
+
+	// 'create' allows creating new symlinks
+	fs.AllowSymlinks = SymlinksCreate
+	hdfsAccessor.EXPECT().CreateSymlink("/data/file", "/new").Return(nil)
+	node, err := root.(*Dir).Symlink(nil, &fuse.SymlinkRequest{NewName: "new", Target: "/data/file"})
+	assert.Nil(t, err)
+	target, err = node.(*File).Readlink(nil, &fuse.ReadlinkRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, "/data/file", target)
+
+	// Cluster with symlinks disabled
+	hdfsAccessor.EXPECT().CreateSymlink("/data/file", "/other").Return(
+		errors.New("java.lang.UnsupportedOperationException: Symlinks not supported"))
+	_, err = root.(*Dir).Symlink(nil, &fuse.SymlinkRequest{NewName: "other", Target: "/data/file"})
+	assert.Equal(t, fuse.Errno(syscall.ENOTSUP), err)
+}
+
+// Resolving chain of symlinks longer than MaxSymlinkHops fails with ELOOP
+func TestSymlinkHops(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.AllowSymlinks = SymlinksCreate
+	fileSystem.MaxSymlinkHops = DefaultMaxSymlinkHops
+	root, _ := fileSystem.Root()
+
+	// /l1 -> l2 -> ... -> l50 -> /data (relative targets)
+	hdfsAccessor.EXPECT().Stat(gomock.Any()).DoAndReturn(func(path string) (Attrs, error) {
+		var n int
+		if _, err := fmt.Sscanf(path, "/l%d", &n); err == nil && n < 50 {
+			return Attrs{Name: path[1:], Mode: os.ModeSymlink | 0777, Target: fmt.Sprintf("l%d", n+1)}, nil
+		}
+		if path == "/l50" {
+			return Attrs{Name: "l50", Mode: os.ModeSymlink | 0777, Target: "/data"}, nil
+		}
+		return Attrs{Name: "data", Mode: os.ModeDir | 0755}, nil
+	}).AnyTimes()
+
+	// New link to l11 makes a chain of 41 links
+	_, err := root.(*Dir).Symlink(nil, &fuse.SymlinkRequest{NewName: "new", Target: "l11"})
+	assert.Equal(t, fuse.Errno(syscall.ELOOP), err)
+
+	// New link to l12 makes a chain of 40 links, within the limit
+	target, err := fileSystem.ResolveSymlinks("/new", "l12")
+	assert.Nil(t, err)
+	assert.Equal(t, "/data", target)
+	hdfsAccessor.EXPECT().CreateSymlink("l12", "/new").Return(nil)
+	_, err = root.(*Dir).Symlink(nil, &fuse.SymlinkRequest{NewName: "new", Target: "l12"})
+	assert.Nil(t, err)
+
+	// Link pointing to itself is a loop
+	_, err = fileSystem.ResolveSymlinks("/l51", "/l51")
+	assert.Equal(t, fuse.Errno(syscall.ELOOP), err)
+}
@@ -0,0 +1,17 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// Prefix of the names of transient files the mount writes to HDFS (e.g. temporary files of atomic writes),
+// which are hidden from listings and lookups (with HideTempFiles)
+const MountTempPrefix = ".hdfs-mount-tmp-"
+
+// Returns true if the last component of the path is a name of mount's temporary file
+func IsMountTempPath(p string) bool {
+	return strings.HasPrefix(path.Base(p), MountTempPrefix)
+}
@@ -0,0 +1,132 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Span of a traced operation: FUSE operation or backend call made on its behalf
+type Span struct {
+	Id       uint64        // Unique id of the span
+	Name     string        // Name of the operation ("Read", "Lookup", ... for FUSE operations, "hdfs.*" for backend calls)
+	Path     string        // HDFS path the operation is applied to
+	Offset   int64         // Offset of the data read or written (0 if not applicable)
+	Size     int           // Size of the data read or written (0 if not applicable)
+	Start    time.Time     // Time the operation has started
+	Duration time.Duration // Latency of the operation (known once the span is ended)
+	Parent   *Span         // FUSE operation span enclosing the backend call (nil for FUSE operation spans)
+	tracer   *Tracer       // tracer which started the span
+}
+
+// Receives finished spans (e.g. exports them to a tracing backend)
+type SpanRecorder interface {
+	Record(span *Span)
+}
+
+// Produces spans around FUSE operations and backend calls (see TracingHdfsAccessor).
+// Backend calls are nested into the FUSE operation in progress on the same path,
+// only sampled fraction of FUSE operations is traced to bound overhead
+type Tracer struct {
+	Recorder   SpanRecorder       // Destination of the finished spans
+	SampleRate float64            // Fraction of FUSE operations which are traced (0..1)
+	Clock      Clock              // interface to get wall clock time
+	Rand       *rand.Rand         // Source of randomness for sampling
+	active     map[string][]*Span // FUSE operation spans in progress, by path
+	lastId     uint64             // id of the last started span (updated atomically)
+	mutex      sync.Mutex         // mutex for Rand and active
+}
+
+// Creates tracer sampling given fraction of the FUSE operations
+func NewTracer(recorder SpanRecorder, sampleRate float64, clock Clock) *Tracer {
+	return &Tracer{
+		Recorder:   recorder,
+		SampleRate: sampleRate,
+		Clock:      clock,
+		Rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		active:     make(map[string][]*Span)}
+}
+
+// Starts span of a FUSE operation (nil-safe, returns nil if tracing is disabled or the operation isn't sampled)
+func (this *Tracer) StartOperation(name string, path string, offset int64, size int) *Span {
+	if this == nil {
+		return nil
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.Rand.Float64() >= this.SampleRate {
+		return nil
+	}
+	span := this.newSpan(name, path, offset, size, nil)
+	this.active[path] = append(this.active[path], span)
+	return span
+}
+
+// Starts span of a backend call nested into the FUSE operation in progress on the same path
+// (nil-safe, returns nil if there is no such sampled operation)
+func (this *Tracer) StartBackendCall(name string, path string, offset int64, size int) *Span {
+	if this == nil {
+		return nil
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	spans := this.active[path]
+	if len(spans) == 0 {
+		return nil
+	}
+	return this.newSpan(name, path, offset, size, spans[len(spans)-1])
+}
+
+// Creates new span starting now
+func (this *Tracer) newSpan(name string, path string, offset int64, size int, parent *Span) *Span {
+	return &Span{
+		Id:     atomic.AddUint64(&this.lastId, 1),
+		Name:   name,
+		Path:   path,
+		Offset: offset,
+		Size:   size,
+		Start:  this.Clock.Now(),
+		Parent: parent,
+		tracer: this}
+}
+
+// Ends the span and passes it to the recorder (nil-safe)
+func (this *Span) End() {
+	if this == nil {
+		return
+	}
+	tracer := this.tracer
+	this.Duration = tracer.Clock.Now().Sub(this.Start)
+	if this.Parent == nil {
+		tracer.mutex.Lock()
+		spans := tracer.active[this.Path]
+		for i, span := range spans {
+			if span == this {
+				spans = append(spans[:i], spans[i+1:]...)
+				break
+			}
+		}
+		if len(spans) == 0 {
+			delete(tracer.active, this.Path)
+		} else {
+			tracer.active[this.Path] = spans
+		}
+		tracer.mutex.Unlock()
+	}
+	tracer.Recorder.Record(this)
+}
+
+// Writes finished spans to the info log
+type LogSpanRecorder struct{}
+
+// Writes the span to the info log
+func (LogSpanRecorder) Record(span *Span) {
+	parentId := uint64(0)
+	if span.Parent != nil {
+		parentId = span.Parent.Id
+	}
+	Info.Println("Trace: span", span.Id, "parent", parentId, span.Name, "[", span.Path, "] @", span.Offset, "size", span.Size, "latency", span.Duration)
+}
@@ -0,0 +1,264 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// Wraps backend calls into spans (see Tracer), nesting them into the FUSE operations made them
+type TracingHdfsAccessor struct {
+	Impl   HdfsAccessor
+	Tracer *Tracer
+}
+
+var _ HdfsAccessor = (*TracingHdfsAccessor)(nil) // ensure TracingHdfsAccessor implements HdfsAccessor
+
+// Creates an instance of TracingHdfsAccessor
+func NewTracingHdfsAccessor(impl HdfsAccessor, tracer *Tracer) *TracingHdfsAccessor {
+	return &TracingHdfsAccessor{
+		Impl:   impl,
+		Tracer: tracer}
+}
+
+// Opens HDFS file for reading
+func (this *TracingHdfsAccessor) OpenRead(path string) (ReadSeekCloser, error) {
+	defer this.Tracer.StartBackendCall("hdfs.OpenRead", path, 0, 0).End()
+	reader, err := this.Impl.OpenRead(path)
+	if err != nil {
+		return nil, err
+	}
+	return &TracingReader{Impl: reader, Path: path, Tracer: this.Tracer}, nil
+}
+
+// Opens HDFS file for writing
+func (this *TracingHdfsAccessor) CreateFile(path string, mode os.FileMode) (HdfsWriter, error) {
+	defer this.Tracer.StartBackendCall("hdfs.CreateFile", path, 0, 0).End()
+	writer, err := this.Impl.CreateFile(path, mode)
+	if err != nil {
+		return nil, err
+	}
+	return &TracingWriter{Impl: writer, Path: path, Tracer: this.Tracer}, nil
+}
+
+// Opens HDFS file for writing, with given number of replicas in the write pipeline
+func (this *TracingHdfsAccessor) CreateFileWithReplication(path string, mode os.FileMode, replication int) (HdfsWriter, error) {
+	defer this.Tracer.StartBackendCall("hdfs.CreateFile", path, 0, 0).End()
+	writer, err := this.Impl.CreateFileWithReplication(path, mode, replication)
+	if err != nil {
+		return nil, err
+	}
+	return &TracingWriter{Impl: writer, Path: path, Tracer: this.Tracer}, nil
+}
+
+// Opens existing HDFS file for appending
+func (this *TracingHdfsAccessor) Append(path string) (HdfsWriter, error) {
+	defer this.Tracer.StartBackendCall("hdfs.Append", path, 0, 0).End()
+	writer, err := this.Impl.Append(path)
+	if err != nil {
+		return nil, err
+	}
+	return &TracingWriter{Impl: writer, Path: path, Tracer: this.Tracer}, nil
+}
+
+// Enumerates HDFS directory
+func (this *TracingHdfsAccessor) ReadDir(path string) ([]Attrs, error) {
+	defer this.Tracer.StartBackendCall("hdfs.ReadDir", path, 0, 0).End()
+	return this.Impl.ReadDir(path)
+}
+
+// Opens HDFS directory for enumerating it page by page
+func (this *TracingHdfsAccessor) OpenDir(path string) (DirReader, error) {
+	defer this.Tracer.StartBackendCall("hdfs.OpenDir", path, 0, 0).End()
+	return this.Impl.OpenDir(path)
+}
+
+// Retrieves file/directory attributes
+func (this *TracingHdfsAccessor) Stat(path string) (Attrs, error) {
+	defer this.Tracer.StartBackendCall("hdfs.Stat", path, 0, 0).End()
+	return this.Impl.Stat(path)
+}
+
+// Retrieves HDFS usage
+func (this *TracingHdfsAccessor) StatFs() (FsInfo, error) {
+	return this.Impl.StatFs()
+}
+
+// Retrieves quota and usage of the directory
+func (this *TracingHdfsAccessor) GetContentSummary(path string) (ContentSummary, error) {
+	defer this.Tracer.StartBackendCall("hdfs.GetContentSummary", path, 0, 0).End()
+	return this.Impl.GetContentSummary(path)
+}
+
+// Creates a directory
+func (this *TracingHdfsAccessor) Mkdir(path string, mode os.FileMode) error {
+	defer this.Tracer.StartBackendCall("hdfs.Mkdir", path, 0, 0).End()
+	return this.Impl.Mkdir(path, mode)
+}
+
+// Removes a file or directory
+func (this *TracingHdfsAccessor) Remove(path string) error {
+	defer this.Tracer.StartBackendCall("hdfs.Remove", path, 0, 0).End()
+	return this.Impl.Remove(path)
+}
+
+// Renames a file or directory
+func (this *TracingHdfsAccessor) Rename(oldPath string, newPath string) error {
+	defer this.Tracer.StartBackendCall("hdfs.Rename", oldPath, 0, 0).End()
+	return this.Impl.Rename(oldPath, newPath)
+}
+
+// Ensures HDFS accessor is connected to the HDFS name node
+func (this *TracingHdfsAccessor) EnsureConnected() error {
+	return this.Impl.EnsureConnected()
+}
+
+// Changes the owner and group of the file
+func (this *TracingHdfsAccessor) Chown(path string, owner, group string) error {
+	defer this.Tracer.StartBackendCall("hdfs.Chown", path, 0, 0).End()
+	return this.Impl.Chown(path, owner, group)
+}
+
+// Changes the access and modification times of the file
+func (this *TracingHdfsAccessor) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	defer this.Tracer.StartBackendCall("hdfs.Chtimes", path, 0, 0).End()
+	return this.Impl.Chtimes(path, atime, mtime)
+}
+
+// Changes the mode of the file
+func (this *TracingHdfsAccessor) Chmod(path string, mode os.FileMode) error {
+	defer this.Tracer.StartBackendCall("hdfs.Chmod", path, 0, 0).End()
+	return this.Impl.Chmod(path, mode)
+}
+
+// Changes the mode of the directory tree
+func (this *TracingHdfsAccessor) ChmodRecursive(path string, mode os.FileMode) error {
+	defer this.Tracer.StartBackendCall("hdfs.ChmodRecursive", path, 0, 0).End()
+	return this.Impl.ChmodRecursive(path, mode)
+}
+
+// Changes the owner and group of the directory tree
+func (this *TracingHdfsAccessor) ChownRecursive(path string, owner, group string) error {
+	defer this.Tracer.StartBackendCall("hdfs.ChownRecursive", path, 0, 0).End()
+	return this.Impl.ChownRecursive(path, owner, group)
+}
+
+// Retrieves checksum of the file
+func (this *TracingHdfsAccessor) GetChecksum(path string) ([]byte, error) {
+	defer this.Tracer.StartBackendCall("hdfs.GetChecksum", path, 0, 0).End()
+	return this.Impl.GetChecksum(path)
+}
+
+// Retrieves ACL entries of the file/directory
+func (this *TracingHdfsAccessor) GetAcl(path string) ([]AclEntry, error) {
+	defer this.Tracer.StartBackendCall("hdfs.GetAcl", path, 0, 0).End()
+	return this.Impl.GetAcl(path)
+}
+
+// Triggers lease recovery of the file
+func (this *TracingHdfsAccessor) RecoverLease(path string) (bool, error) {
+	defer this.Tracer.StartBackendCall("hdfs.RecoverLease", path, 0, 0).End()
+	return this.Impl.RecoverLease(path)
+}
+
+// Retrieves layout of the file blocks
+func (this *TracingHdfsAccessor) GetBlockLocations(path string) ([]BlockLocation, error) {
+	defer this.Tracer.StartBackendCall("hdfs.GetBlockLocations", path, 0, 0).End()
+	return this.Impl.GetBlockLocations(path)
+}
+
+// Creates a symbolic link
+func (this *TracingHdfsAccessor) CreateSymlink(target string, path string) error {
+	defer this.Tracer.StartBackendCall("hdfs.CreateSymlink", path, 0, 0).End()
+	return this.Impl.CreateSymlink(target, path)
+}
+
+// Lists changes of the snapshottable directory between two snapshots
+func (this *TracingHdfsAccessor) SnapshotDiff(path, from, to string) ([]DiffEntry, error) {
+	defer this.Tracer.StartBackendCall("hdfs.SnapshotDiff", path, 0, 0).End()
+	return this.Impl.SnapshotDiff(path, from, to)
+}
+
+// Closes current meta connection if needed
+func (this *TracingHdfsAccessor) Close() error {
+	return this.Impl.Close()
+}
+
+// Reader wrapping reads into spans
+type TracingReader struct {
+	Impl     ReadSeekCloser
+	Path     string
+	Tracer   *Tracer
+	position int64 // offset of the next read (tracked locally, so tracing doesn't issue backend calls)
+}
+
+var _ ReadSeekCloser = (*TracingReader)(nil) // ensure TracingReader implements ReadSeekCloser
+
+// Reads a chunk of data
+func (this *TracingReader) Read(buffer []byte) (int, error) {
+	defer this.Tracer.StartBackendCall("hdfs.Read", this.Path, this.position, len(buffer)).End()
+	n, err := this.Impl.Read(buffer)
+	this.position += int64(n)
+	return n, err
+}
+
+// Seeks to a given position
+func (this *TracingReader) Seek(pos int64) error {
+	defer this.Tracer.StartBackendCall("hdfs.Seek", this.Path, pos, 0).End()
+	err := this.Impl.Seek(pos)
+	if err == nil {
+		this.position = pos
+	}
+	return err
+}
+
+// Returns current position
+func (this *TracingReader) Position() (int64, error) {
+	return this.Impl.Position()
+}
+
+// Closes the stream
+func (this *TracingReader) Close() error {
+	return this.Impl.Close()
+}
+
+// Writer wrapping writes into spans
+type TracingWriter struct {
+	Impl     HdfsWriter
+	Path     string
+	Tracer   *Tracer
+	position int64 // offset of the next write
+}
+
+var _ HdfsWriter = (*TracingWriter)(nil) // ensure TracingWriter implements HdfsWriter
+
+// Writes chunk of data
+func (this *TracingWriter) Write(buffer []byte) (int, error) {
+	defer this.Tracer.StartBackendCall("hdfs.Write", this.Path, this.position, len(buffer)).End()
+	n, err := this.Impl.Write(buffer)
+	this.position += int64(n)
+	return n, err
+}
+
+// Seeks to a given position
+func (this *TracingWriter) Seek(pos int64) error {
+	return this.Impl.Seek(pos)
+}
+
+// Flushes all the data
+func (this *TracingWriter) Flush() error {
+	defer this.Tracer.StartBackendCall("hdfs.Flush", this.Path, this.position, 0).End()
+	return this.Impl.Flush()
+}
+
+// Closes the stream
+func (this *TracingWriter) Close() error {
+	defer this.Tracer.StartBackendCall("hdfs.Close", this.Path, this.position, 0).End()
+	return this.Impl.Close()
+}
+
+// Truncates the HDFS file
+func (this *TracingWriter) Truncate() error {
+	return this.Impl.Truncate()
+}
@@ -0,0 +1,70 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// Span recorder keeping finished spans in memory
+type recordingSpanRecorder struct {
+	Spans []*Span
+}
+
+func (this *recordingSpanRecorder) Record(span *Span) {
+	this.Spans = append(this.Spans, span)
+}
+
+// Read produces FUSE operation span with nested spans of the backend calls, capturing path, offset, size and latency
+func TestReadProducesNestedSpans(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	recorder := &recordingSpanRecorder{}
+	tracer := NewTracer(recorder, 1, mockClock)
+	fileSystem, _ := NewFileSystem(NewTracingHdfsAccessor(hdfsAccessor, tracer), "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fileSystem.Tracer = tracer
+	root, _ := fileSystem.Root()
+	hdfsAccessor.EXPECT().Stat("/data.bin").Return(Attrs{Name: "data.bin", Mode: 0644, Size: 5}, nil)
+	node, err := root.(*Dir).Lookup(nil, "data.bin")
+	assert.Nil(t, err)
+	hdfsReader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/data.bin").Return(hdfsReader, nil)
+	h, err := node.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+
+	recorder.Spans = nil
+	hdfsReader.EXPECT().Read(gomock.Any()).Do(func(buf []byte) {
+		mockClock.NotifyTimeElapsed(10 * time.Millisecond)
+		copy(buf, "Hello")
+	}).Return(5, io.EOF)
+	h.(*FileHandle).readAndVerify(t, 0, 5, []byte("Hello"))
+
+	// Backend read ends first and is nested into the FUSE read
+	assert.Equal(t, 2, len(recorder.Spans))
+	backendRead, read := recorder.Spans[0], recorder.Spans[1]
+	assert.Equal(t, "Read", read.Name)
+	assert.Equal(t, "/data.bin", read.Path)
+	assert.Equal(t, int64(0), read.Offset)
+	assert.Equal(t, 5, read.Size)
+	assert.Nil(t, read.Parent)
+	assert.Equal(t, 10*time.Millisecond, read.Duration)
+	assert.Equal(t, "hdfs.Read", backendRead.Name)
+	assert.Equal(t, "/data.bin", backendRead.Path)
+	assert.Equal(t, int64(0), backendRead.Offset)
+	assert.Equal(t, read, backendRead.Parent)
+	assert.Equal(t, 10*time.Millisecond, backendRead.Duration)
+
+	// Nothing is traced if the operation isn't sampled
+	tracer.SampleRate = 0
+	recorder.Spans = nil
+	h.(*FileHandle).readAndVerify(t, 0, 5, []byte("Hello"))
+	assert.Equal(t, 0, len(recorder.Spans))
+}
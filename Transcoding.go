@@ -0,0 +1,129 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// Single-byte charsets which can be transcoded to UTF-8 on read, by name
+var TranscodingCharsets = map[string]func(byte) rune{
+	"iso-8859-1":   decodeLatin1,
+	"latin1":       decodeLatin1,
+	"windows-1252": decodeWindows1252,
+	"cp1252":       decodeWindows1252,
+}
+
+// Transcodes content of the files matching any of the patterns from the single-byte charset to UTF-8 on read
+type Transcoding struct {
+	Charset  string          // Name of the charset the files are encoded in (one of TranscodingCharsets)
+	Patterns []string        // Glob patterns matched against the file name (e.g. "*.csv")
+	decode   func(byte) rune // Maps byte of the charset to Unicode code point
+}
+
+// Creates Transcoding from the charset name and comma-separated list of glob patterns
+func NewTranscoding(charset string, patterns string) (*Transcoding, error) {
+	decode, ok := TranscodingCharsets[strings.ToLower(charset)]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Unsupported charset: %s", charset))
+	}
+	this := &Transcoding{Charset: charset, decode: decode}
+	for _, pattern := range strings.Split(patterns, ",") {
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid transcoding pattern: %s", pattern))
+		}
+		Info.Println("Transcoding [", pattern, "] from", charset, "to UTF-8")
+		this.Patterns = append(this.Patterns, pattern)
+	}
+	return this, nil
+}
+
+// Returns true if the file with the given name is transcoded on read (nil-safe)
+func (this *Transcoding) Match(name string) bool {
+	if this == nil {
+		return false
+	}
+	for _, pattern := range this.Patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Transcodes content to UTF-8
+func (this *Transcoding) Transcode(data []byte) []byte {
+	result := make([]byte, 0, len(data))
+	for _, b := range data {
+		if b < utf8.RuneSelf {
+			result = append(result, b)
+		} else {
+			var buf [utf8.UTFMax]byte
+			result = append(result, buf[:utf8.EncodeRune(buf[:], this.decode(b))]...)
+		}
+	}
+	return result
+}
+
+// Returns true if content of the file is transcoded to UTF-8 on read.
+// Transcoded file is held in memory, so files larger than WholeFileMaxSize are served as stored
+func (this *File) IsTranscoded() bool {
+	return this.FileSystem.Transcoding.Match(this.Attrs.Name) && !this.IsRaw() && this.Attrs.Size <= WholeFileMaxSize
+}
+
+// Transcodes content of the file read from the backend, remembering its transcoded size
+func (this *File) Transcode(data []byte) []byte {
+	transcoded := this.FileSystem.Transcoding.Transcode(data)
+	this.recordTranscodedSize(this.Attrs.ContentVersion(), uint64(len(transcoded)))
+	return transcoded
+}
+
+// Remembers size of the transcoded content of the given version of the file
+func (this *File) recordTranscodedSize(version ContentVersion, size uint64) {
+	this.activeHandlesMutex.Lock()
+	defer this.activeHandlesMutex.Unlock()
+	this.transcodedVersion = version
+	this.transcodedSize = size
+}
+
+// Reports size of the transcoded content of the file (if it is transcoded on read).
+// The size is only known once the file has been opened and transcoded, until then (and once the content changes)
+// size of the content as stored in HDFS is reported. Transcoded files are opened with direct I/O,
+// so the kernel doesn't clamp reads to the size reported before
+func (this *File) ApplyTranscodedSize(a *fuse.Attr) {
+	if !this.IsTranscoded() {
+		return
+	}
+	this.activeHandlesMutex.Lock()
+	defer this.activeHandlesMutex.Unlock()
+	if this.transcodedVersion == this.Attrs.ContentVersion() {
+		a.Size = this.transcodedSize
+	}
+}
+
+// Maps ISO-8859-1 byte to Unicode code point (Latin-1 is the first 256 code points of Unicode)
+func decodeLatin1(b byte) rune {
+	return rune(b)
+}
+
+// Code points of Windows-1252 bytes 0x80-0x9F (the rest of the charset matches ISO-8859-1)
+var windows1252HighControls = [32]rune{
+	0x20AC, 0xFFFD, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021, 0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0xFFFD, 0x017D, 0xFFFD,
+	0xFFFD, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014, 0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0xFFFD, 0x017E, 0x0178,
+}
+
+// Maps Windows-1252 byte to Unicode code point (unassigned bytes map to the replacement character)
+func decodeWindows1252(b byte) rune {
+	if b >= 0x80 && b < 0xA0 {
+		return windows1252HighControls[b-0x80]
+	}
+	return rune(b)
+}
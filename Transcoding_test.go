@@ -0,0 +1,79 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// Latin-1 file matching transcoding pattern is read as UTF-8, and its size is reported accordingly
+func TestTranscodeLatin1(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	var err error
+	fileSystem.Transcoding, err = NewTranscoding("latin1", "*.txt")
+	assert.Nil(t, err)
+	root, _ := fileSystem.Root()
+	latin1 := []byte("caf\xe9 na\xefve \xa31")
+	utf8 := "café naïve £1"
+	expectRead := func() {
+		reader := NewMockReadSeekCloser(mockCtrl)
+		hdfsAccessor.EXPECT().OpenRead("/menu.txt").Return(reader, nil)
+		reader.whenReadReturn(latin1, nil)
+		reader.whenReadReturn(nil, io.EOF)
+		reader.EXPECT().Close().Return(nil)
+	}
+
+	hdfsAccessor.EXPECT().Stat("/menu.txt").Return(Attrs{Name: "menu.txt", Mode: 0644, Size: uint64(len(latin1))}, nil)
+	file, err := root.(*Dir).Lookup(nil, "menu.txt")
+	assert.Nil(t, err)
+
+	// Size stored in HDFS is reported until the file has been transcoded
+	var attr fuse.Attr
+	assert.Nil(t, file.(*File).Attr(nil, &attr))
+	assert.Equal(t, uint64(len(latin1)), attr.Size)
+
+	// Transcoded file is opened with direct I/O, so reads aren't clamped to the size reported before
+	expectRead()
+	resp := &fuse.OpenResponse{}
+	h, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, resp)
+	assert.Nil(t, err)
+	assert.Equal(t, fuse.OpenDirectIO, resp.Flags&fuse.OpenDirectIO)
+	handle := h.(*FileHandle)
+	handle.readAndVerify(t, 0, 100, []byte(utf8))
+	handle.readAndVerify(t, 6, 6, []byte("naïve"))
+	handle.Release(nil, nil)
+
+	// Transcoded size is remembered while the content doesn't change
+	hdfsAccessor.EXPECT().Stat("/menu.txt").Return(Attrs{Name: "menu.txt", Mode: 0644, Size: uint64(len(latin1))}, nil)
+	assert.Nil(t, file.(*File).Attr(nil, &attr))
+	assert.Equal(t, uint64(len(utf8)), attr.Size)
+
+	// Files too large to be held in memory aren't transcoded
+	WholeFileMaxSize = 8
+	defer func() { WholeFileMaxSize = 64 * 1024 * 1024 }()
+	hdfsAccessor.EXPECT().Stat("/large.txt").Return(Attrs{Name: "large.txt", Mode: 0644, Size: uint64(len(latin1))}, nil)
+	large, err := root.(*Dir).Lookup(nil, "large.txt")
+	assert.Nil(t, err)
+	assert.False(t, large.(*File).IsTranscoded())
+
+	// File which has grown beyond the limit since it was stat'ed can't be opened
+	hdfsAccessor.EXPECT().Stat("/grown.txt").Return(Attrs{Name: "grown.txt", Mode: 0644, Size: 4}, nil)
+	grown, err := root.(*Dir).Lookup(nil, "grown.txt")
+	assert.Nil(t, err)
+	reader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/grown.txt").Return(reader, nil)
+	reader.whenReadReturn(latin1, nil)
+	reader.EXPECT().Close().Return(nil)
+	_, err = grown.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EFBIG), err)
+}
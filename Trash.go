@@ -0,0 +1,45 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"path"
+)
+
+// Name of the virtual directory at the root of the mount exposing HDFS trash (see FileSystem.TrashDir)
+const TrashName = ".Trash"
+
+// Exposes HDFS trash directory (e.g. /user/alice/.Trash) as /.Trash at the root of the mount.
+// Trash checkpoints (Current and timestamped ones) are browsable as its subdirectories,
+// renaming an entry out of it restores the entry
+func (this *FileSystem) ExposeTrash(trashDir string) error {
+	if this.PathRewriter == nil {
+		this.PathRewriter = &PathRewriter{}
+	}
+	if err := this.PathRewriter.AddRule("/"+TrashName, trashDir); err != nil {
+		return err
+	}
+	this.TrashDir = path.Clean(trashDir)
+	return nil
+}
+
+// Returns true if HDFS path is located in the exposed trash directory
+func (this *FileSystem) IsTrashPath(p string) bool {
+	return this.TrashDir != "" && isPathUnderPrefix(p, this.TrashDir)
+}
+
+// Returns directory entry of the virtual trash directory, if it exists (the root directory only)
+func (this *Dir) TrashEntry() (fuse.Dirent, bool) {
+	if this.Parent != nil || this.FileSystem.TrashDir == "" {
+		return fuse.Dirent{}, false
+	}
+	var attrs Attrs
+	if err := this.LookupAttrs(TrashName, &attrs); err != nil || !attrs.Mode.IsDir() {
+		// Trash is created by HDFS on the first deletion
+		return fuse.Dirent{}, false
+	}
+	attrs.Name = TrashName
+	this.NodeFromAttrs(attrs)
+	return fuse.Dirent{Inode: attrs.Inode, Name: TrashName, Type: fuse.DT_Dir}, true
+}
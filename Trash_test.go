@@ -0,0 +1,56 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// Trash is listed at the root of the mount, its checkpoints are navigable, and renaming an entry out of it restores the entry
+func TestBrowseAndRestoreFromTrash(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"data"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	assert.Nil(t, fileSystem.ExposeTrash("/user/alice/.Trash"))
+	root, _ := fileSystem.Root()
+
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{
+		{Name: "data", Mode: os.ModeDir | 0755},
+		{Name: "user", Mode: os.ModeDir | 0755}}, nil)
+	hdfsAccessor.EXPECT().Stat("/user/alice/.Trash").Return(Attrs{Name: ".Trash", Mode: os.ModeDir | 0700}, nil)
+	entries, err := root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []fuse.Dirent{
+		{Name: "data", Type: fuse.DT_Dir},
+		{Name: TrashName, Type: fuse.DT_Dir}}, entries)
+
+	// Timestamped checkpoints are listed next to the current trash
+	trash, err := root.(*Dir).Lookup(nil, TrashName)
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().ReadDir("/user/alice/.Trash").Return([]Attrs{
+		{Name: "Current", Mode: os.ModeDir | 0700},
+		{Name: "2610161200", Mode: os.ModeDir | 0700}}, nil)
+	entries, err = trash.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []fuse.Dirent{
+		{Name: "Current", Type: fuse.DT_Dir},
+		{Name: "2610161200", Type: fuse.DT_Dir}}, entries)
+	checkpoint, _ := trash.(*Dir).Lookup(nil, "2610161200")
+	hdfsAccessor.EXPECT().ReadDir("/user/alice/.Trash/2610161200").Return([]Attrs{{Name: "data", Mode: os.ModeDir | 0755}}, nil)
+	entries, err = checkpoint.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []fuse.Dirent{{Name: "data", Type: fuse.DT_Dir}}, entries)
+	trashedData, _ := checkpoint.(*Dir).Lookup(nil, "data")
+
+	// Moving the file out of the trash restores it
+	data, _ := root.(*Dir).Lookup(nil, "data")
+	hdfsAccessor.EXPECT().Rename("/user/alice/.Trash/2610161200/data/report.csv", "/data/report.csv").Return(nil)
+	err = trashedData.(*Dir).Rename(nil, &fuse.RenameRequest{OldName: "report.csv", NewName: "report.csv"}, data)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,41 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Named presets of tuning knobs for typical access patterns (see FileSystem.ApplyWorkload)
+const (
+	WorkloadColumnar = "columnar" // many small random reads within a file, many files scanned (e.g. Parquet, ORC)
+)
+
+// Size of the shared content cache configured by the columnar workload
+var ColumnarCacheSize uint64 = 256 * 1024 * 1024
+
+// Applies named workload preset on top of the current configuration.
+// Knobs which have been configured explicitly (read strategies, content cache) are kept as they are
+func (this *FileSystem) ApplyWorkload(workload string) error {
+	switch workload {
+	case "":
+		return nil
+	case WorkloadColumnar:
+		// Small per-read buffers, since columnar readers jump between column chunks
+		if this.ReadStrategies == nil {
+			this.ReadStrategies = &ReadStrategies{Rules: []ReadStrategyRule{{Pattern: "*", Strategy: ReadStrategyRandom}}}
+		}
+		// Files are scanned one after another, so the next file (and its block locations) is fetched ahead
+		this.SequentialDirPrefetch = true
+		this.PrefetchBlocks = true
+		// Footers and dictionaries are re-read by many readers, keeping them in a larger shared cache
+		if this.DedupCache == nil {
+			this.DedupCache = NewDedupCache(ColumnarCacheSize)
+		}
+	default:
+		return errors.New(fmt.Sprintf("Unknown workload: %s", workload))
+	}
+	Info.Println("Applied workload preset:", workload)
+	return nil
+}
@@ -0,0 +1,60 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// Columnar workload selects small read buffers, prefetching and shared content cache, random reads return correct data
+func TestColumnarWorkload(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSystem, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(&MockClock{}), &MockClock{})
+	assert.Nil(t, fileSystem.ApplyWorkload(WorkloadColumnar))
+	assert.Equal(t, ReadStrategyRandom, fileSystem.ReadStrategies.Match("part-00000.parquet"))
+	assert.True(t, fileSystem.SequentialDirPrefetch)
+	assert.True(t, fileSystem.PrefetchBlocks)
+	assert.NotNil(t, fileSystem.DedupCache)
+	assert.Equal(t, ColumnarCacheSize, fileSystem.DedupCache.MaxSize)
+
+	// Random reads use small backend reads and return correct content
+	fileSize := int64(4 * 1024 * 1024)
+	hdfsReader := &MockReadSeekCloserWithPseudoRandomContent{FileSize: fileSize, ReaderStats: &ReaderStats{}}
+	hdfsAccessor.EXPECT().Stat("/data.parquet").Return(Attrs{Name: "data.parquet", Mode: 0644, Size: uint64(fileSize)}, nil)
+	hdfsAccessor.EXPECT().OpenRead("/data.parquet").Return(hdfsReader, nil)
+	hdfsAccessor.EXPECT().GetBlockLocations("/data.parquet").Return([]BlockLocation{{Offset: 0, Length: uint64(fileSize)}}, nil)
+	root, _ := fileSystem.Root()
+	file, _ := root.(*Dir).Lookup(nil, "data.parquet")
+	h, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+	assert.Nil(t, err)
+	handle := h.(*FileHandle)
+	assert.Equal(t, RandomBlockSize, handle.Reader.BlockSize)
+	assert.Equal(t, 1, len(handle.BlockLocations()))
+	r := rand.New(rand.NewSource(0))
+	for iter := 0; iter < 200; iter++ {
+		offset := r.Int63n(fileSize - 4096)
+		resp := fuse.ReadResponse{Data: make([]byte, 0, 4096)}
+		assert.Nil(t, handle.Read(nil, &fuse.ReadRequest{Offset: offset, Size: 4096}, &resp))
+		assert.Equal(t, 4096, len(resp.Data))
+		for i := range resp.Data {
+			if resp.Data[i] != generateByteAtOffset(offset+int64(i)) {
+				t.Fatal("Invalid byte at offset ", offset+int64(i))
+			}
+		}
+	}
+	handle.Release(nil, nil)
+	assert.True(t, hdfsReader.IsClosed)
+
+	// Explicitly configured read strategies are kept, unknown workloads are rejected
+	fileSystem.ReadStrategies, _ = NewReadStrategies("*.log=sequential")
+	assert.Nil(t, fileSystem.ApplyWorkload(WorkloadColumnar))
+	assert.Equal(t, ReadStrategySequential, fileSystem.ReadStrategies.Match("app.log"))
+	assert.NotNil(t, fileSystem.ApplyWorkload("unknown"))
+}
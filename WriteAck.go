@@ -0,0 +1,27 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+// Replication of the files created via the mount (number of data nodes in the write pipeline)
+var DefaultReplication int = 3
+
+// Number of data nodes which acknowledge the writes before the file is closed and Flush returns
+// (see FileSystem.WriteAckLevel). Data nodes of the write pipeline acknowledge each packet,
+// so the level determines replication of the written file: lower levels trade durability for latency
+const (
+	WriteAckOne      = "one"      // single data node (file has a single replica)
+	WriteAckMajority = "majority" // majority of DefaultReplication data nodes
+	WriteAckAll      = "all"      // all DefaultReplication data nodes (default)
+)
+
+// Returns number of data nodes in the write pipeline for the configured ack level
+func (this *FileSystem) WriteAckReplicas() int {
+	switch this.WriteAckLevel {
+	case WriteAckOne:
+		return 1
+	case WriteAckMajority:
+		return DefaultReplication/2 + 1
+	default:
+		return DefaultReplication
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"syscall"
+	"time"
+)
+
+// Returns age of the locally known attributes of the file (time since they were fetched from HDFS)
+func (this *File) AttrsAge() time.Duration {
+	return this.FileSystem.Clock.Now().Sub(this.Attrs.Expires.Add(-this.FileSystem.MetadataCacheTTL))
+}
+
+// Validates that the file hasn't changed since its content has been read via the handle, before the handle
+// starts writing to it (with WriteFreshness > 0). Attributes older than WriteFreshness are refreshed,
+// write fails with ESTALE if the content of the file has changed in HDFS in the meantime
+func (this *FileHandle) ValidateFreshness() error {
+	freshness := this.File.FileSystem.WriteFreshness
+	if freshness <= 0 || this.File.IsCreateDeferred() || this.File.AttrsAge() <= freshness {
+		return nil
+	}
+	version := this.File.Attrs.ContentVersion()
+	if err := this.File.Parent.LookupAttrs(this.File.Attrs.Name, &this.File.Attrs); err != nil {
+		return err
+	}
+	this.File.CheckContentVersion(version)
+	if version != this.File.Attrs.ContentVersion() {
+		Warning.Println("[", this.File.AbsolutePath(), "] Write rejected: file has changed since it was read")
+		return fuse.Errno(syscall.ESTALE)
+	}
+	return nil
+}
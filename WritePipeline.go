@@ -0,0 +1,52 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io"
+	"strings"
+	"syscall"
+)
+
+// Returns true if the error indicates that the write pipeline has been broken, e.g. by a data node
+// which died while the block was being written
+func IsBrokenPipeError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == syscall.EPIPE || err == syscall.ECONNRESET {
+		return true
+	}
+	message := err.Error()
+	return strings.Contains(message, "broken pipe") || strings.Contains(message, "connection reset by peer")
+}
+
+// Re-establishes write pipeline broken by a failure of a data node (with RecoverWritePipeline enabled):
+// the broken writer is closed, the file is re-opened for append (name node assigns new data nodes)
+// and the staged content is resumed from the length acknowledged by HDFS. Attempts follow the RetryPolicy
+func (this *FileHandleWriter) RecoverPipeline(broken HdfsWriter, cause error) (HdfsWriter, error) {
+	path := this.Handle.File.AbsolutePath()
+	fileSystem := this.Handle.File.FileSystem
+	broken.Close()
+	op := fileSystem.RetryPolicy.StartOperation()
+	for op.ShouldRetry("[%s] Write pipeline broken: %s", path, cause) {
+		attrs, err := fileSystem.HdfsAccessor.Stat(path)
+		if err != nil {
+			cause = err
+			continue
+		}
+		w, err := fileSystem.HdfsAccessor.Append(path)
+		if err != nil {
+			cause = err
+			continue
+		}
+		if _, err = this.stagingFile.Seek(int64(attrs.Size), io.SeekStart); err != nil {
+			w.Close()
+			return nil, err
+		}
+		this.acknowledged = int64(attrs.Size)
+		Info.Println("[", path, "] Write pipeline re-established, resuming at", attrs.Size)
+		return w, nil
+	}
+	return nil, cause
+}
@@ -16,10 +16,21 @@ import (
 	"time"
 )
 
+// Flags which aren't intended for production use and aren't listed in the usage
+var hiddenFlags = map[string]bool{"chaos": true}
+
 var Usage = func() {
 	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "  %s NAMENODE:PORT MOUNTPOINT\n", os.Args[0])
-	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "  %s CLUSTER1=NAMENODE:PORT;CLUSTER2=NAMENODE:PORT MOUNTPOINT\n", os.Args[0])
+	visibleFlags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	visibleFlags.SetOutput(os.Stderr)
+	flag.VisitAll(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			visibleFlags.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	visibleFlags.PrintDefaults()
 }
 
 func main() {
@@ -32,10 +43,130 @@ func main() {
 	flag.IntVar(&retryPolicy.MaxAttempts, "retryMaxAttempts", 99999999, "Maxumum retry attempts for failed operations")
 	flag.DurationVar(&retryPolicy.MinDelay, "retryMinDelay", 1*time.Second, "minimum delay between retries (note, first retry always happens immediatelly)")
 	flag.DurationVar(&retryPolicy.MaxDelay, "retryMaxDelay", 60*time.Second, "maximum delay between retries")
+	flag.DurationVar(&retryPolicy.CongestionMinDelay, "retryCongestionMinDelay", 5*time.Second, "minimum delay before retrying operations rejected by overloaded name node (e.g. RetriableException), 0 to retry them as other failures")
+	flag.DurationVar(&retryPolicy.CongestionMaxDelay, "retryCongestionMaxDelay", 2*time.Minute, "maximum delay before retrying operations rejected by overloaded name node")
 	allowedPrefixesString := flag.String("allowedPrefixes", "*", "Comma-separated list of allowed path prefixes on the remote file system, "+
 		"if specified the mount point will expose access to those prefixes only")
 	expandZips := flag.Bool("expandZips", false, "Enables automatic expansion of ZIP archives")
 	readOnly := flag.Bool("readOnly", false, "Enables mount with readonly")
+	readOnlyWindows := flag.String("readOnlyWindows", "", "Comma-separated list of daily HH:MM-HH:MM time windows (local time) during which the mount is read-only, "+
+		"modifying operations, including uploads of content written before the window, fail with EROFS (e.g. '01:00-03:00' for nightly maintenance)")
+	disableOps := flag.String("disableOps", "", "Comma-separated list of operations failing with EPERM regardless of HDFS permissions: "+strings.Join(DisableableOps, ", "))
+	nonEmpty := flag.Bool("nonempty", false, "Allows mounting over non-empty directory (or existing mount), otherwise mounting fails")
+	clockSkewProbe := flag.String("clockSkewProbe", "", "HDFS path of a temporary file used to measure clock skew between this host and HDFS at startup, made unique by a suffix (with multiple clusters, probed on each of them at this path relative to the cluster) (disabled if empty)")
+	statfsCacheTTL := flag.Duration("statfsCacheTTL", DefaultStatfsCacheTTL, "Time for which HDFS capacity and usage reported by statfs (e.g. df) are cached (0 to query name node on each statfs)")
+	excludeReservedSpace := flag.Bool("excludeReservedSpace", false, "statfs reports capacity excluding space used by non-HDFS data, and available space excluding -reservedSpace, "+
+		"so df shows space genuinely writable to HDFS")
+	reservedSpace := flag.Uint64("reservedSpace", 0, "Space (in bytes) reserved for non-HDFS use on all the data nodes together (dfs.datanode.du.reserved times number of data nodes), see -excludeReservedSpace")
+	smallFileThreshold := flag.Uint64("smallFileThreshold", 0, "Files smaller than this size (in bytes) are read entirely into memory on first access (0 to disable)")
+	pathRewrites := flag.String("pathRewrites", "", "Comma-separated list of VIRTUALPATH=HDFSPATH rules, mapping paths presented via mount point to different HDFS paths")
+	readOnceAction := flag.String("readOnceAction", "", "Action to perform on a file once it was fully read and closed: 'move' (to 'processed' subdirectory) or 'delete'")
+	dataTransferEncryption := flag.Bool("dataTransferEncryption", false, "Requests encryption of block data transferred between the mount and HDFS data nodes")
+	tlsCertFile := flag.String("tlsCertFile", "", "PEM-encoded client certificate for TLS connections to the data nodes")
+	tlsKeyFile := flag.String("tlsKeyFile", "", "PEM-encoded private key for the client certificate")
+	tlsCAFile := flag.String("tlsCAFile", "", "PEM-encoded CA certificates used to verify data nodes (enables TLS for data node connections)")
+	backupNameNode := flag.String("backupNameNode", "", "NAMENODE:PORT of a backup cluster used to serve reads which fail on the primary cluster")
+	backupPathRewrites := flag.String("backupPathRewrites", "", "Comma-separated list of PRIMARYPATH=BACKUPPATH rules, mapping paths on primary cluster to paths on backup cluster")
+	deletedOpenAttrs := flag.Bool("deletedOpenAttrs", true, "Getattr on an opened handle of a file removed while opened returns last known attributes of the file instead of ENOENT")
+	writeFreshness := flag.Duration("writeFreshness", 0, "Maximum age of metadata of a file read before the first write to it, staler metadata is refreshed "+
+		"and the write fails with ESTALE if the file has changed since it was read (0 to disable)")
+	closeToOpen := flag.Bool("closeToOpen", false, "Enforces close-to-open consistency: each open re-stats the file, discarding content cached for its previous version, "+
+		"so it sees complete content as of the last close by any writer (costs a name node call per open)")
+	lazyCloseFlush := flag.Bool("lazyCloseFlush", false, "Flush sent on every close() doesn't upload written data, it is uploaded on explicit fsync or once the file is released "+
+		"(cheaper closes, but upload errors aren't reported to close())")
+	writeAckLevel := flag.String("writeAckLevel", WriteAckAll, "Number of data nodes which acknowledge writes before Flush returns: 'one' (fastest, single replica), 'majority' or 'all' (full replication)")
+	writeConfirmation := flag.String("writeConfirmation", "", "Re-reads written files on close and verifies their 'length' or 'checksum' (disabled by default due to the cost)")
+	openFlagModes := flag.String("openFlagModes", "", "Comma-separated list of FLAG=MODE rules assigning behaviors to handles opened with flags 'sync', 'dsync', 'noatime', modes: "+
+		"'"+OpenModeWriteThrough+"' (each write to new or appended files is flushed to HDFS pipeline, other writes are uploaded on fsync and close), '"+OpenModeNoAtime+"', "+
+		"'"+OpenModeFresh+"' (bypass metadata and content caches, also settable per file with '"+XattrConsistency+"' xattr) (e.g. 'sync=fresh,noatime=noatime')")
+	exposeTrash := flag.String("exposeTrash", "", "HDFS trash directory (e.g. /user/alice/.Trash) exposed as /"+TrashName+" at the root of the mount, renaming entries out of it restores them (disabled if empty)")
+	transcode := flag.String("transcode", "", "Comma-separated list of GLOB patterns selecting files transcoded from -transcodeCharset to UTF-8 on read (e.g. '*.csv,*.txt'), "+
+		"transcoded files are held in memory, so larger ones than 64MB are served as stored")
+	transcodeCharset := flag.String("transcodeCharset", "iso-8859-1", "Charset of the files transcoded on read: 'iso-8859-1' (latin1) or 'windows-1252' (cp1252)")
+	attrOverrides := flag.String("attrOverrides", "", "Comma-separated list of GLOB=MODE:OWNER:GROUP rules overriding attributes reported for matching paths, '**' matches any number of path components, empty parts are kept (e.g. '/public/**=0644:svc:svc')")
+	readStrategies := flag.String("readStrategies", "", "Comma-separated list of GLOB=STRATEGY rules selecting read strategy by file name, strategies: 'whole-file', 'sequential', 'random' (e.g. '*.parquet=random,*.log=sequential')")
+	workload := flag.String("workload", "", "Named preset of tuning knobs for typical access pattern: '"+WorkloadColumnar+"' (small random reads of many files, e.g. Parquet or ORC: small read buffers, "+
+		"prefetch of the next file and block locations, larger shared content cache), explicit -readStrategies and -dedupCacheSize take precedence")
+	exposeConfig := flag.Bool("exposeConfig", false, "Exposes effective configuration and build version of the mount as JSON in virtual '/"+MountInfoDirName+"/"+MountConfigFileName+"' file")
+	exposeQuotaFile := flag.Bool("exposeQuotaFile", false, "Exposes virtual '"+QuotaFileName+"' file in each directory, reporting quota and usage of the directory")
+	exposeSnapshotDiff := flag.Bool("exposeSnapshotDiff", false, "Exposes virtual '"+SnapshotDiffName+"/FROM/TO' files in snapshottable directories, listing paths created (+), modified (M), deleted (-) or renamed (R) between two snapshots")
+	hideTempFiles := flag.Bool("hideTempFiles", true, "Hides transient files written to HDFS by the mount ('"+MountTempPrefix+"*') from listings and lookups")
+	caseCollisions := flag.String("caseCollisions", CaseCollisionsAllow, "Handling of entries whose names differ only in case, colliding on case-insensitive re-export: "+
+		"'allow' (listed as they are), 'hide' (all but the first are hidden), 'suffix' (all but the first are listed as 'name~N.ext') or 'fail' (listing fails with EIO)")
+	exposeBlockLayout := flag.Bool("exposeBlockLayout", false, "Exposes HDFS block layout of each file (offset, length and data nodes of each block) as '"+XattrBlocks+"' extended attribute, "+
+		"for locality-aware tools")
+	exposeModSeq := flag.Bool("exposeModSeq", false, "Exposes monotonic modification sequence of each file (derived from HDFS metadata) as '"+XattrModSeq+"' extended attribute, for change-data-capture tools")
+	renameLocking := flag.Bool("renameLocking", false, "Lookups in directories affected by a rename in progress wait for it, so they see the entry either before or after the rename, never a stale one")
+	createAsCaller := flag.Bool("createAsCaller", false, "New files are owned by the user creating them via the mount: created under a hidden temporary name, "+
+		"chowned and renamed into place, so they never appear with the mount's owner (requires HDFS superuser)")
+	exclusiveCreate := flag.Bool("exclusiveCreate", true, "Honors O_EXCL on create: checks with HDFS whether the file exists (failing with EEXIST), serializing concurrent exclusive creates of the same file")
+	checkNameQuota := flag.Bool("checkNameQuota", false, "Check namespace quotas of the directory and its ancestors before creating a file or directory in it, failing with EDQUOT if any is reached "+
+		"(exceeded quotas are reported as EDQUOT regardless)")
+	nameQuotaCacheTTL := flag.Duration("nameQuotaCacheTTL", DefaultNameQuotaCacheTTL, "Time for which namespace quotas checked with -checkNameQuota are cached "+
+		"(content summary walks the whole directory tree on the name node, usage is adjusted locally in the meantime)")
+	retryInterrupted := flag.Bool("retryInterrupted", false, "Automatically retries interrupted idempotent operations (reads, stats) once instead of returning EINTR (writes always return EINTR)")
+	readaheadBytes := flag.Int("readaheadBytes", DefaultReadaheadBytes, "Size of the window read ahead in background once a file handle is read sequentially, "+
+		"so the subsequent reads are served from memory (0 to disable)")
+	readaheadTriggerCount := flag.Int("readaheadTriggerCount", 0, "Number of consecutive sequential reads from a file handle after which read-ahead becomes aggressive (0 to disable)")
+	enforcePermissions := flag.Bool("enforcePermissions", false, "Checks mode bits and ACL group entries against the identity of the caller when opening files")
+	inheritDefaultAcl := flag.Bool("inheritDefaultAcl", false, "New files created in a directory with default ACL get permissions derived from it (e.g. group-writable in shared directories) instead of the umask")
+	effectiveAccess := flag.Bool("effectiveAccess", false, "Answers access() calls with effective permission of the caller computed from mode bits and ACL group entries")
+	streamingWriteBuffer := flag.Int64("streamingWriteBuffer", 0, "Streams new files directly to HDFS, blocking writes once this number of bytes is buffered (0 to buffer files in the staging directory)")
+	appendMode := flag.String("appendMode", AppendEmulate, "Handling of writes to files opened with O_APPEND: '"+AppendEmulate+"' (rewrite the file with appended content), '"+AppendNative+"' (HDFS append, EOPNOTSUPP if the cluster doesn't support it) or '"+AppendNativeOrEmulate+"' (HDFS append, emulated if the cluster doesn't support it)")
+	deferCreate := flag.Bool("deferCreate", false, "Creates new files in HDFS together with their content on the first flush, instead of creating empty file first (new files are invisible to other HDFS clients until flushed)")
+	onFileClosed := flag.String("onFileClosed", "", "Executable (invoked with path and size as arguments) or http(s) URL (receiving JSON POST) notified in the background whenever a file written via the mount is closed (disabled if empty)")
+	auditLog := flag.String("auditLog", "", "Records create/delete/rename/chmod/chown operations to the given local file or to 'syslog' (disabled if empty)")
+	dirListingOnRead := flag.Bool("dirListingOnRead", false, "Allows reading directories opened as files, returning names of the entries (EISDIR otherwise)")
+	rejectReadOnlyWrites := flag.Bool("rejectReadOnlyWrites", true, "Rejects writes arriving on file handles opened read-only with EBADF, instead of lazily enabling write")
+	honorODirect := flag.Bool("honorODirect", true, "Bypasses read/write buffering for file handles opened with O_DIRECT flag (new files opened with O_DIRECT must be written sequentially)")
+	zeroFillMissingBlocks := flag.Bool("zeroFillMissingBlocks", false, "Reads blocks without any available replica as zeros, so the rest of a damaged file can be recovered (reads fail with EIO otherwise)")
+	followGrowth := flag.Bool("followGrowth", false, "Re-stats the file once reads reach EOF and continues reading if the file has grown since it was opened (e.g. for tailing logs)")
+	escapeNames := flag.Bool("escapeNames", false, "Percent-encodes bytes of HDFS file names which aren't valid UTF-8 (as well as '%' itself), so such files can be accessed")
+	maxPrefetchBytes := flag.Int64("maxPrefetchBytes", 0, "Maximum total size (in bytes) of prefetched and read-ahead data held in memory across all the files, "+
+		"prefetch pauses once it is reached and resumes as the data is consumed (0 for unlimited)")
+	sequentialDirPrefetch := flag.Bool("sequentialDirPrefetch", false, "Prefetches beginning of the next file in the directory listing once a file is opened for reading (e.g. for part-files read in order)")
+	waitSafeMode := flag.Duration("waitSafeMode", 0, "Namespace-modifying operations (mkdir, create, remove, rename, chmod, chown) rejected since name node is in safe mode are retried for up to this long before failing with EROFS (0 to fail immediately)")
+	recoverWritePipeline := flag.Bool("recoverWritePipeline", false, "Re-establishes write pipeline broken by a data node failure (e.g. broken pipe) by re-opening the file for append, "+
+		"resuming upload of the staged content from the length acknowledged by HDFS (requires HDFS append support)")
+	retryClose := flag.Bool("retryClose", false, "Retries failed close of written HDFS files according to the retry policy, and reports the final failure on release instead of only logging it")
+	recoverLease := flag.Bool("recoverLease", false, "Triggers and awaits recovery of the lease held by a stale (crashed) writer if it prevents writing the file")
+	readInProgress := flag.String("readInProgress", ReadInProgressAllow, "Behavior on opening for read a file which is still being written by another client: '"+ReadInProgressAllow+"' (read available data), '"+ReadInProgressDeny+"' (fail with EAGAIN) or '"+ReadInProgressWait+"' (wait until the file is finalized); "+
+		"'"+ReadInProgressDeny+"' and '"+ReadInProgressWait+"' fetch block locations of the file on each open for read")
+	reservedPaths := flag.String("reservedPaths", ReservedPathsAll, "Access to HDFS reserved paths under "+ReservedDir+": '"+ReservedPathsAll+"' (passed to HDFS), '"+ReservedPathsRaw+"' (only "+RawDir+
+		", read-only, e.g. for admin tools reading encrypted files as stored) or '"+ReservedPathsNone+"' (ENOENT), content under "+RawDir+" is never transcoded")
+	maxSymlinkHops := flag.Int("maxSymlinkHops", DefaultMaxSymlinkHops, "Maximum number of symlinks traversed while resolving a path (e.g. target of a new symlink) before failing with ELOOP")
+	allowSymlinks := flag.String("allowSymlinks", SymlinksRead, "Allowed symlink operations: '"+SymlinksCreate+"' (read and create), '"+SymlinksRead+"' (creation fails with EPERM) or '"+SymlinksNone+"' (reading fails with EPERM as well)")
+	checksumSidecar := flag.String("exposeChecksumSidecar", "", "Exposes HDFS checksum (MD5-of-MD5-of-CRC bytes, not Hadoop's local .crc format) of each file 'foo' as virtual '"+ChecksumSidecarVisible+"' ('foo.crc') or '"+ChecksumSidecarHidden+"' ('.foo.crc') file (disabled if empty)")
+	sortListings := flag.String("sortListings", "", "Sorts directory listings by 'name', 'mtime' (oldest first) or 'size' (smallest first), costs extra CPU on huge directories (HDFS order if empty)")
+	staleVanishedDirs := flag.Bool("staleVanishedDirs", true, "Paged directory listing (see -readDirPageSize) fails with ESTALE if the directory is deleted by another client while being listed, instead of returning partial listing")
+	readDirPageSize := flag.Int("readDirPageSize", 0, "List directories in pages of this many entries, retrying failed pages and returning partial listing with a warning if a page keeps failing (0 to list at once)")
+	metadataCacheTTL := flag.Duration("metadataCacheTTL", DefaultMetadataCacheTTL, "Time for which attributes fetched from HDFS are cached: longer TTL reduces name node load, "+
+		"but delays visibility of changes made by other clients")
+	attrCacheEntries := flag.Int("attrCacheEntries", 0, "Maximum number of cached file nodes, least recently used ones are dropped from directory entries unless opened (0 for unlimited)")
+	compressOnWrite := flag.Bool("compressOnWrite", false, "Gzip-compress content written to '*"+GzipExtension+"' files before storing it in HDFS (application writes plain data)")
+	reportUncompressedSize := flag.Bool("reportUncompressedSize", false, "Report size of the files compressed on write as number of uncompressed bytes written by the application (compressed size otherwise)")
+	readerReuseTTL := flag.Duration("readerReuseTTL", 0, "How long backend reader of the closed file is kept open, so quick reopen of the file reuses it instead of reconnecting (0 to disable)")
+	blockReadTimeout := flag.Duration("blockReadTimeout", 0, "Single backend read which doesn't complete in this time (e.g. from a stalled data node) is abandoned and retried with a new reader, independently of the retry time limit; "+
+		"HDFS client might read from the same data node again (0 to wait indefinitely)")
+	parallelBlockReads := flag.Int("parallelBlockReads", 0, "Maximum number of HDFS blocks fetched in parallel (each through its own reader) for a read request spanning several blocks (0 to read them sequentially)")
+	coalesceReadSize := flag.Int("coalesceReadSize", 0, "Reads up to this size from files opened by several handles are coalesced with concurrent reads of nearby offsets "+
+		"into a single backend fetch of the enclosing aligned window (0 to disable)")
+	readMetadataWait := flag.Duration("readMetadataWait", 0, "Reads of opened files needing the name node for additional readers (-parallelBlockReads, -coalesceReadSize, -dedupCacheSize) "+
+		"wait for it at most this long (e.g. while it is paused by checkpointing), falling back to the handle's reader (0 to wait indefinitely)")
+	backendReadSize := flag.Int("backendReadSize", 0, "Maximum number of bytes fetched by a single backend read, larger read requests (and read-ahead) are split into multiple backend reads (0 for unlimited)")
+	maxReadsPerFile := flag.Int("maxReadsPerFile", 0, "Maximum number of concurrent backend reads of a single file, so one hot file can't starve the others; excess reads queue (0 for unlimited)")
+	cachePolicyMarkers := flag.Bool("cachePolicyMarkers", false, "Caching policy of the files is set by '"+CachePolicyMarkerName+"' marker in their directory (or its ancestors): "+
+		"'aggressive' (shared content cache of -dedupCacheSize is used, other files don't use it) or 'none' (one-time-read data, recently read ranges aren't kept)")
+	dedupCacheSize := flag.Uint64("dedupCacheSize", 0, "Size (in bytes) of the content-addressed cache serving identical blocks (by checksum) across files once (0 to disable)")
+	maxNameLength := flag.Int("maxNameLength", DefaultMaxNameLength, "Maximum length (in bytes) of a path component accepted by HDFS, longer names are rejected with ENAMETOOLONG (0 for unlimited)")
+	maxPathLength := flag.Int("maxPathLength", DefaultMaxPathLength, "Maximum length (in bytes) of a path accepted by HDFS, longer paths are rejected with ENAMETOOLONG (0 for unlimited)")
+	maxListingEntries := flag.Int("maxListingEntries", 0, "Maximum number of entries returned when listing a directory, larger listings are truncated with a warning (0 for unlimited)")
+	markTruncatedListing := flag.Bool("markTruncatedListing", false, "Appends '"+ListingTruncatedName+"' entry to truncated directory listings (see -maxListingEntries)")
+	stagingDir := flag.String("stagingDir", DefaultStagingDir, "Local directory used to buffer contents of the files being written")
+	perUserStaging := flag.Bool("perUserStaging", false, "Buffers writes of each user in a separate subdirectory of the staging directory, accessible only by that user")
+	stagingMissing := flag.String("stagingMissing", "create", "Behavior if staging directory is missing or unwritable at startup: 'create', 'fail' or 'memory' (buffer writes in memory)")
+	chaos := flag.Float64("chaos", 0, "Probability (0..1) of injecting I/O errors into reads, writes and stats, for chaos testing only")
+	traceSampleRate := flag.Float64("traceSampleRate", 0, "Fraction (0..1) of FUSE operations traced together with the backend calls they make, spans are written to the info log (0 to disable)")
 	logLevel := flag.Int("logLevel", 0, "logs to be printed. 0: only fatal/err logs; 1: +warning logs; 2: +info logs")
 
 	flag.Usage = Usage
@@ -60,13 +191,71 @@ func main() {
 		InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
 	}
 
-	hdfsAccessor, err := NewHdfsAccessor(flag.Arg(0), WallClock{})
+	tlsConfig, err := LoadTLSConfig(*tlsCertFile, *tlsKeyFile, *tlsCAFile)
 	if err != nil {
-		log.Fatal("Error/NewHdfsAccessor: ", err)
+		log.Fatal("Error/LoadTLSConfig: ", err)
 	}
+	securityOptions := HdfsSecurityOptions{
+		DataTransferEncryption: *dataTransferEncryption,
+		TLSConfig:              tlsConfig}
+
+	var ftHdfsAccessor HdfsAccessor
+	var clusterNames []string
+	if strings.Contains(flag.Arg(0), "=") {
+		// Multiple clusters are mounted as subdirectories of the mount point
+		clusterSpec, err := ParseClusterSpec(flag.Arg(0))
+		if err != nil {
+			log.Fatal("Error/ParseClusterSpec: ", err)
+		}
+		clusters := make(map[string]HdfsAccessor)
+		for name, nameNodeAddresses := range clusterSpec {
+			hdfsAccessor, err := NewHdfsAccessor(nameNodeAddresses, WallClock{}, securityOptions)
+			if err != nil {
+				log.Fatal("Error/NewHdfsAccessor: ", err)
+			}
+			clusterAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, retryPolicy)
+			clusterAccessor.BlockReadTimeout = *blockReadTimeout
+			clusters[name] = clusterAccessor
+		}
+		multiClusterAccessor := NewMultiClusterHdfsAccessor(clusters, WallClock{})
+		clusterNames = multiClusterAccessor.clusterNames()
+		ftHdfsAccessor = multiClusterAccessor
+	} else {
+		hdfsAccessor, err := NewHdfsAccessor(flag.Arg(0), WallClock{}, securityOptions)
+		if err != nil {
+			log.Fatal("Error/NewHdfsAccessor: ", err)
+		}
 
-	// Wrapping with FaultTolerantHdfsAccessor
-	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, retryPolicy)
+		// Wrapping with FaultTolerantHdfsAccessor
+		primaryAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, retryPolicy)
+		primaryAccessor.BlockReadTimeout = *blockReadTimeout
+		ftHdfsAccessor = primaryAccessor
+	}
+
+	if *backupNameNode != "" {
+		backupHdfsAccessor, err := NewHdfsAccessor(*backupNameNode, WallClock{}, securityOptions)
+		if err != nil {
+			log.Fatal("Error/NewHdfsAccessor: ", err)
+		}
+		backupPathRewriter, err := NewPathRewriter(*backupPathRewrites)
+		if err != nil {
+			log.Fatal("Error/NewPathRewriter: ", err)
+		}
+		backupAccessor := NewFaultTolerantHdfsAccessor(backupHdfsAccessor, retryPolicy)
+		backupAccessor.BlockReadTimeout = *blockReadTimeout
+		ftHdfsAccessor = NewBackupReadHdfsAccessor(ftHdfsAccessor, backupAccessor, backupPathRewriter)
+	}
+
+	if *chaos > 0 {
+		log.Print("Chaos mode: injecting I/O errors with probability ", *chaos)
+		ftHdfsAccessor = NewChaosHdfsAccessor(ftHdfsAccessor, *chaos)
+	}
+
+	var tracer *Tracer
+	if *traceSampleRate > 0 {
+		tracer = NewTracer(LogSpanRecorder{}, *traceSampleRate, WallClock{})
+		ftHdfsAccessor = NewTracingHdfsAccessor(ftHdfsAccessor, tracer)
+	}
 
 	if !*lazyMount && ftHdfsAccessor.EnsureConnected() != nil {
 		log.Fatal("Can't establish connection to HDFS, mounting will NOT be performend (this can be suppressed with -lazy)")
@@ -78,6 +267,199 @@ func main() {
 		log.Fatal("Error/NewFileSystem: ", err)
 	}
 
+	fileSystem.AllowNonEmpty = *nonEmpty
+	fileSystem.DisabledOps, err = ParseDisabledOps(*disableOps)
+	if err != nil {
+		log.Fatal("Invalid -disableOps: ", err)
+	}
+	fileSystem.ReadOnlyWindows, err = ParseTimeWindows(*readOnlyWindows)
+	if err != nil {
+		log.Fatal("Invalid -readOnlyWindows: ", err)
+	}
+	fileSystem.Tracer = tracer
+	fileSystem.SmallFileThreshold = *smallFileThreshold
+	fileSystem.StatfsCacheTTL = *statfsCacheTTL
+	fileSystem.ExcludeReservedSpace = *excludeReservedSpace
+	fileSystem.ReservedSpace = *reservedSpace
+	fileSystem.ExposeQuotaFile = *exposeQuotaFile
+	fileSystem.ExposeConfig = *exposeConfig
+	fileSystem.NameNode = flag.Arg(0)
+	fileSystem.Flags = MountFlags{}
+	flag.VisitAll(func(f *flag.Flag) {
+		fileSystem.Flags[f.Name] = f.Value.String()
+	})
+	fileSystem.ExposeSnapshotDiff = *exposeSnapshotDiff
+	fileSystem.ExposeModSeq = *exposeModSeq
+	fileSystem.ExposeBlockLayout = *exposeBlockLayout
+	fileSystem.CheckNameQuota = *checkNameQuota
+	fileSystem.NameQuotaCacheTTL = *nameQuotaCacheTTL
+	fileSystem.ExclusiveCreate = *exclusiveCreate
+	fileSystem.CreateAsCaller = *createAsCaller
+	fileSystem.RenameLocking = *renameLocking
+	fileSystem.HideTempFiles = *hideTempFiles
+	fileSystem.DirListingOnRead = *dirListingOnRead
+	fileSystem.StreamingWriteBuffer = *streamingWriteBuffer
+	fileSystem.DeferCreate = *deferCreate
+	fileSystem.HonorODirect = *honorODirect
+	fileSystem.RejectReadOnlyWrites = *rejectReadOnlyWrites
+	fileSystem.EffectiveAccess = *effectiveAccess
+	fileSystem.InheritDefaultAcl = *inheritDefaultAcl
+	fileSystem.MaxListingEntries = *maxListingEntries
+	fileSystem.MaxNameLength = *maxNameLength
+	fileSystem.MaxPathLength = *maxPathLength
+	fileSystem.FollowGrowth = *followGrowth
+	fileSystem.ZeroFillMissingBlocks = *zeroFillMissingBlocks
+	fileSystem.EscapeNames = *escapeNames
+	fileSystem.SequentialDirPrefetch = *sequentialDirPrefetch
+	if *maxPrefetchBytes > 0 {
+		fileSystem.PrefetchBudget = NewPrefetchBudget(*maxPrefetchBytes)
+	}
+	fileSystem.RecoverLease = *recoverLease
+	fileSystem.RetryClose = *retryClose
+	fileSystem.RecoverWritePipeline = *recoverWritePipeline
+	fileSystem.WaitSafeMode = *waitSafeMode
+	fileSystem.ReadDirPageSize = *readDirPageSize
+	fileSystem.StaleVanishedDirs = *staleVanishedDirs
+	fileSystem.MaxReadsPerFile = *maxReadsPerFile
+	fileSystem.BackendReadSize = *backendReadSize
+	fileSystem.ParallelBlockReads = *parallelBlockReads
+	fileSystem.CoalesceReadSize = *coalesceReadSize
+	fileSystem.ReadMetadataWait = *readMetadataWait
+	fileSystem.ReaderReuseTTL = *readerReuseTTL
+	fileSystem.CompressOnWrite = *compressOnWrite
+	fileSystem.UncompressedSize = *reportUncompressedSize
+	if *dedupCacheSize > 0 {
+		fileSystem.DedupCache = NewDedupCache(*dedupCacheSize)
+	}
+	fileSystem.CachePolicyMarkers = *cachePolicyMarkers
+	fileSystem.MetadataCacheTTL = *metadataCacheTTL
+	if *attrCacheEntries > 0 {
+		fileSystem.AttrCache = NewAttrCache(*attrCacheEntries)
+	}
+	fileSystem.MarkTruncatedListing = *markTruncatedListing
+	fileSystem.EnforcePermissions = *enforcePermissions
+	fileSystem.ReadaheadTriggerCount = *readaheadTriggerCount
+	fileSystem.ReadaheadBytes = *readaheadBytes
+	fileSystem.RetryInterrupted = *retryInterrupted
+	if *readOnceAction != "" && *readOnceAction != "move" && *readOnceAction != "delete" {
+		log.Fatal("Invalid -readOnceAction: ", *readOnceAction)
+	}
+	fileSystem.ReadOnceAction = *readOnceAction
+	if *writeConfirmation != "" && *writeConfirmation != "length" && *writeConfirmation != "checksum" {
+		log.Fatal("Invalid -writeConfirmation: ", *writeConfirmation)
+	}
+	fileSystem.WriteConfirmation = *writeConfirmation
+	if *writeAckLevel != WriteAckOne && *writeAckLevel != WriteAckMajority && *writeAckLevel != WriteAckAll {
+		log.Fatal("Invalid -writeAckLevel: ", *writeAckLevel)
+	}
+	fileSystem.WriteAckLevel = *writeAckLevel
+	fileSystem.LazyCloseFlush = *lazyCloseFlush
+	fileSystem.CloseToOpen = *closeToOpen
+	fileSystem.WriteFreshness = *writeFreshness
+	fileSystem.DeletedOpenAttrs = *deletedOpenAttrs
+	if *checksumSidecar != "" && *checksumSidecar != ChecksumSidecarVisible && *checksumSidecar != ChecksumSidecarHidden {
+		log.Fatal("Invalid -exposeChecksumSidecar: ", *checksumSidecar)
+	}
+	fileSystem.ChecksumSidecar = *checksumSidecar
+	if *readInProgress != ReadInProgressAllow && *readInProgress != ReadInProgressDeny && *readInProgress != ReadInProgressWait {
+		log.Fatal("Invalid -readInProgress: ", *readInProgress)
+	}
+	fileSystem.ReadInProgress = *readInProgress
+	if *allowSymlinks != SymlinksCreate && *allowSymlinks != SymlinksRead && *allowSymlinks != SymlinksNone {
+		log.Fatal("Invalid -allowSymlinks: ", *allowSymlinks)
+	}
+	fileSystem.AllowSymlinks = *allowSymlinks
+	fileSystem.MaxSymlinkHops = *maxSymlinkHops
+	if *reservedPaths != ReservedPathsAll && *reservedPaths != ReservedPathsRaw && *reservedPaths != ReservedPathsNone {
+		log.Fatal("Invalid -reservedPaths: ", *reservedPaths)
+	}
+	fileSystem.ReservedPaths = *reservedPaths
+	if *sortListings != "" && *sortListings != ListingSortName && *sortListings != ListingSortMtime && *sortListings != ListingSortSize {
+		log.Fatal("Invalid -sortListings: ", *sortListings)
+	}
+	fileSystem.SortListings = *sortListings
+	if *caseCollisions != CaseCollisionsAllow && *caseCollisions != CaseCollisionsHide && *caseCollisions != CaseCollisionsSuffix && *caseCollisions != CaseCollisionsFail {
+		log.Fatal("Invalid -caseCollisions: ", *caseCollisions)
+	}
+	fileSystem.CaseCollisions = *caseCollisions
+	if *appendMode != AppendEmulate && *appendMode != AppendNative && *appendMode != AppendNativeOrEmulate {
+		log.Fatal("Invalid -appendMode: ", *appendMode)
+	}
+	fileSystem.AppendMode = *appendMode
+	if *stagingMissing != "create" && *stagingMissing != "fail" && *stagingMissing != "memory" {
+		log.Fatal("Invalid -stagingMissing: ", *stagingMissing)
+	}
+	fileSystem.StagingDir = *stagingDir
+	fileSystem.StagingMissing = *stagingMissing
+	fileSystem.PerUserStaging = *perUserStaging
+	if !*readOnly {
+		if err := fileSystem.PrepareStagingDir(); err != nil {
+			log.Fatal("Staging directory is unavailable: ", err)
+		}
+	}
+	if *openFlagModes != "" {
+		fileSystem.OpenFlagModes, err = NewOpenFlagModes(*openFlagModes)
+		if err != nil {
+			log.Fatal("Error/NewOpenFlagModes: ", err)
+		}
+	}
+	if *attrOverrides != "" {
+		fileSystem.AttrOverrides, err = NewAttrOverrides(*attrOverrides)
+		if err != nil {
+			log.Fatal("Error/NewAttrOverrides: ", err)
+		}
+	}
+	if *readStrategies != "" {
+		fileSystem.ReadStrategies, err = NewReadStrategies(*readStrategies)
+		if err != nil {
+			log.Fatal("Error/NewReadStrategies: ", err)
+		}
+	}
+	if err := fileSystem.ApplyWorkload(*workload); err != nil {
+		log.Fatal("Invalid -workload: ", err)
+	}
+	if *transcode != "" {
+		fileSystem.Transcoding, err = NewTranscoding(*transcodeCharset, *transcode)
+		if err != nil {
+			log.Fatal("Error/NewTranscoding: ", err)
+		}
+	}
+	if *auditLog != "" {
+		fileSystem.AuditLog, err = OpenAuditLog(*auditLog)
+		if err != nil {
+			log.Fatal("Can't open audit log: ", err)
+		}
+		fileSystem.CloseOnUnmount(fileSystem.AuditLog)
+	}
+	if *onFileClosed != "" {
+		fileSystem.CommitHook = NewCommitHook(*onFileClosed)
+	}
+	if *pathRewrites != "" {
+		fileSystem.PathRewriter, err = NewPathRewriter(*pathRewrites)
+		if err != nil {
+			log.Fatal("Error/NewPathRewriter: ", err)
+		}
+	}
+
+	if *exposeTrash != "" {
+		if err := fileSystem.ExposeTrash(*exposeTrash); err != nil {
+			log.Fatal("Can't expose trash: ", err)
+		}
+	}
+
+	if *clockSkewProbe != "" {
+		if clusterNames != nil {
+			// Probe path is relative to each of the clusters
+			fileSystem.ClockSkew, err = MeasureClustersClockSkew(ftHdfsAccessor, WallClock{}, *clockSkewProbe, clusterNames)
+		} else {
+			fileSystem.ClockSkew, err = MeasureClockSkew(ftHdfsAccessor, WallClock{}, *clockSkewProbe)
+		}
+		if err != nil {
+			log.Fatal("Can't measure clock skew: ", err)
+		}
+		log.Print("Clock skew between HDFS and local host: ", fileSystem.ClockSkew)
+	}
+
 	c, err := fileSystem.Mount()
 	if err != nil {
 		log.Fatal(err)